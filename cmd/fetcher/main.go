@@ -9,6 +9,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/blockfile"
 	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/fetcher"
 )
 
@@ -16,7 +17,11 @@ func main() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
 	var (
 		// MockChain RPC base, e.g. http://127.0.0.1:18080
-		rpcBase = flag.String("rpc", "http://127.0.0.1:18080", "mockchain rpc base url")
+		rpcBase   = flag.String("rpc", "http://127.0.0.1:18080", "mockchain rpc base url(s), comma-separated for redundant nodes")
+		rpcMaxLag = flag.Int64("rpc-max-lag", 3, "blocks an endpoint may trail the best head before it counts as out of sync")
+		rpcXCheck = flag.Duration("rpc-cross-check", 10*time.Second, "how often block hashes are compared across endpoints")
+		rps       = flag.Float64("rps", 0, "max requests per second per rpc endpoint (0: unlimited)")
+		rpsBurst  = flag.Int("rps-burst", 0, "token bucket burst for -rps (default: max(1, rps))")
 
 		// Kafka
 		brokers = flag.String("brokers", "127.0.0.1:9092", "kafka brokers, comma-separated")
@@ -25,13 +30,58 @@ func main() {
 		// Backfill window (seconds). -1 disables cold start backfill; will start from checkpoint or head.
 		backfillSec = flag.Int64("backfill-sec", 86400, "cold start backfill window in seconds; -1 disables")
 
+		// Dual cursor: tail head immediately, backfill the window concurrently
+		dualCursor    = flag.Bool("dual-cursor", false, "on cold start tail head at once and backfill history with a second cursor")
+		backfillTopic = flag.String("backfill-topic", "", "topic for the backfill cursor (default: -topic)")
+
+		// Bounded one-shot range: produce [from, to] (e.g. into a repair topic via -topic) and exit; live checkpoint untouched
+		fromHeight = flag.Int64("from-height", 0, "bounded mode: first height (inclusive)")
+		toHeight   = flag.Int64("to-height", 0, "bounded mode: last height (inclusive; default head at start)")
+		fromTs     = flag.Int64("from-ts", 0, "bounded mode: first block timestamp, unix seconds (ignored with -from-height)")
+		toTs       = flag.Int64("to-ts", 0, "bounded mode: last block timestamp, unix seconds (ignored with -to-height)")
+
 		// Pagination and pacing
-		pageSize      = flag.Int("page", 200, "blocks per range request (keep small if block JSON is big)")
+		pageSize      = flag.Int("page", 200, "initial blocks per range request")
+		pageMin       = flag.Int("page-min", 10, "adaptive page size lower bound")
+		pageMax       = flag.Int("page-max", 2000, "adaptive page size upper bound")
+		pageTarget    = flag.Duration("page-target", time.Second, "target latency per range request; page size adapts to it (0: fixed -page)")
+		pageMaxBytes  = flag.Int64("page-max-bytes", 32<<20, "shrink pages whose response body is larger (0: no cap)")
+		parallel      = flag.Int("parallel", 4, "range requests in flight (pages buffered for ordered produce)")
 		pollHeadEvery = flag.Duration("poll-head", 2*time.Second, "how often to refresh head")
 		idleSleep     = flag.Duration("idle-sleep", 300*time.Millisecond, "sleep when caught up")
 
+		// Sink: kafka, or files / stdout to capture a dataset without Kafka
+		sink          = flag.String("sink", "kafka", "block sink: kafka|stdout|file:<dir> (rolling NDJSON, see -sink-gzip)")
+		sinkRollBytes = flag.Int64("sink-roll-bytes", 256<<20, "file sink: start a new file after this many (uncompressed) bytes")
+		sinkRollEvery = flag.Duration("sink-roll-every", time.Hour, "file sink: start a new file after this long")
+		sinkGzip      = flag.Bool("sink-gzip", true, "file sink: gzip files (.ndjson.gz)")
+
+		// Producer: async, batched, compressed
+		compression = flag.String("compression", "lz4", "kafka compression: none|gzip|snappy|lz4|zstd")
+		batchMsgs   = flag.Int("batch-msgs", 500, "max messages per producer batch")
+		linger      = flag.Duration("linger", 20*time.Millisecond, "max time a producer batch waits to fill")
+		maxInflight = flag.Int("max-inflight", 10000, "max records enqueued but not yet acked")
+		chunkBytes  = flag.Int("chunk-bytes", 512<<10, "blocks whose encoding is larger are split into chunk records (keep below message.max.bytes)")
+		codec       = flag.String("codec", "json", "block payload format: json|binary (consumers accept both; switch after they are upgraded)")
+
+		// Fan-out: per-tx topic keyed by address, header-only topic (empty disables)
+		txTopic     = flag.String("tx-topic", "", "topic for one message per tx keyed by address (empty disables)")
+		txKey       = flag.String("tx-key", "from", "tx-topic key: from|to")
+		headerTopic = flag.String("header-topic", "", "topic for header-only messages (empty disables)")
+
+		// Validation
+		quarantineTopic = flag.String("quarantine-topic", "", "topic for blocks failing validation (default: <topic>.quarantine)")
+		quarantineAfter = flag.Int("quarantine-after", 2, "consecutive failed fetches of a block before it is quarantined")
+
 		// Checkpoint
-		ckptPath = flag.String("ckpt", "./data/fetcher.ckpt", "checkpoint file path")
+		ckptPath  = flag.String("ckpt", "./data/fetcher.ckpt", "checkpoint file path, or postgres://... / sqlite:path DSN")
+		pipeID    = flag.String("pipeline-id", "", "checkpoint row id in a database store (default: fetcher/<topic>)")
+		ckptEvery = flag.Duration("ckpt-every", 1*time.Second, "how often the acked watermark is written to the checkpoint")
+
+		// Exactly-once: transactional producer, checkpoint committed with the blocks in a compacted topic
+		exactlyOnce = flag.Bool("exactly-once", false, "transactional produce; checkpoint kept in -ckpt-topic instead of -ckpt")
+		ckptTopic   = flag.String("ckpt-topic", "", "compacted checkpoint topic for -exactly-once (default: <topic>.ckpt)")
+		txnID       = flag.String("txn-id", "", "kafka transactional id for -exactly-once, stable across restarts (default: logpipe-fetcher-<topic>)")
 	)
 	flag.Parse()
 
@@ -40,16 +90,64 @@ func main() {
 
 	cfg := fetcher.Config{
 		RPCBaseURL: *rpcBase,
-		Brokers:    *brokers,
-		Topic:      *topic,
+		RPCPool: fetcher.PoolOptions{
+			MaxLag:          *rpcMaxLag,
+			CrossCheckEvery: *rpcXCheck,
+			RPS:             *rps,
+			Burst:           *rpsBurst,
+		},
+		Brokers: *brokers,
+		Topic:   *topic,
 
 		BackfillSec: *backfillSec,
-		PageSize:    *pageSize,
+
+		DualCursor:    *dualCursor,
+		BackfillTopic: *backfillTopic,
+
+		FromHeight: *fromHeight,
+		ToHeight:   *toHeight,
+		FromTs:     *fromTs,
+		ToTs:       *toTs,
+
+		PageSize:     *pageSize,
+		PageMin:      *pageMin,
+		PageMax:      *pageMax,
+		PageTarget:   *pageTarget,
+		PageMaxBytes: *pageMaxBytes,
+		Parallel:     *parallel,
 
 		PollHeadEvery: *pollHeadEvery,
 		IdleSleep:     *idleSleep,
 
-		CheckpointPath: *ckptPath,
+		CheckpointPath:  *ckptPath,
+		PipelineID:      *pipeID,
+		CheckpointEvery: *ckptEvery,
+
+		Sink: *sink,
+		FileSink: blockfile.Options{
+			RollBytes: *sinkRollBytes,
+			RollEvery: *sinkRollEvery,
+			Gzip:      *sinkGzip,
+		},
+
+		Producer: fetcher.ProducerOptions{
+			Compression:   *compression,
+			BatchMessages: *batchMsgs,
+			Linger:        *linger,
+			MaxInflight:   *maxInflight,
+			ChunkBytes:    *chunkBytes,
+			Codec:         *codec,
+
+			QuarantineTopic: *quarantineTopic,
+			TxTopic:         *txTopic,
+			TxKeyBy:         *txKey,
+			HeaderTopic:     *headerTopic,
+		},
+		QuarantineAfter: *quarantineAfter,
+
+		ExactlyOnce:     *exactlyOnce,
+		CheckpointTopic: *ckptTopic,
+		TxnID:           *txnID,
 	}
 
 	f, err := fetcher.New(cfg)
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/generator"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/miner"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/store"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/store/rocksstore"
+	"github.com/chenzhangda16/web3-logpipe/pkg/rng"
+)
+
+// defaultRouteID is the /chains/{id} name of the legacy single chain (whose ChainID is empty).
+const defaultRouteID = "default"
+
+// chainConfig is one chain of the mockchain process. With -chains it is one entry of the JSON file
+// (a list); unset fields fall back to the command-line flags:
+//
+//	[{"id": "eth", "tick": "12s", "seed": 1, "det": true},
+//	 {"id": "bsc", "tick": "3s", "addrs": 20000, "token": "BNB", "tx_min": 100, "tx_max": 300}]
+type chainConfig struct {
+	ID    string   `json:"id"`
+	DB    string   `json:"db"` // default: {dir of -db}/chains/{id}
+	Tick  duration `json:"tick"`
+	Addrs int      `json:"addrs"`
+	Token string   `json:"token"`
+	TxMin int      `json:"tx_min"`
+	TxMax int      `json:"tx_max"`
+	Det   *bool    `json:"det"`
+	Seed  *int64   `json:"seed"` // default: -seed + index, so chains don't mine identical txs
+
+	BackfillSec  *int64 `json:"backfill_sec"`
+	GapSec       int64  `json:"gap_sec"`
+	RetentionSec *int64 `json:"retention_sec"` // 0 keeps everything, like -retention-sec 0
+	RestoreFrom  string `json:"restore_from"`
+
+	snapshotDir string
+}
+
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (c chainConfig) routeID() string {
+	if c.ID == "" {
+		return defaultRouteID
+	}
+	return c.ID
+}
+
+// loadChainConfigs reads the -chains file and fills every unset field from base (the flag values).
+func loadChainConfigs(path string, base chainConfig, snapshotRoot string) ([]chainConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfgs []chainConfig
+	if err := json.Unmarshal(b, &cfgs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(cfgs) == 0 {
+		return nil, fmt.Errorf("%s: no chains", path)
+	}
+
+	seen := make(map[string]bool)
+	for i := range cfgs {
+		c := &cfgs[i]
+		if !validChainID(c.ID) {
+			return nil, fmt.Errorf("%s: chain[%d]: bad id %q (use [A-Za-z0-9_-])", path, i, c.ID)
+		}
+		if seen[c.ID] {
+			return nil, fmt.Errorf("%s: duplicate chain id %q", path, c.ID)
+		}
+		seen[c.ID] = true
+
+		if c.DB == "" {
+			c.DB = filepath.Join(filepath.Dir(base.DB), "chains", c.ID)
+		}
+		if c.Tick <= 0 {
+			c.Tick = base.Tick
+		}
+		if c.Addrs <= 0 {
+			c.Addrs = base.Addrs
+		}
+		if c.Det == nil {
+			c.Det = base.Det
+		}
+		if c.Seed == nil {
+			seed := *base.Seed + int64(i)
+			c.Seed = &seed
+		}
+		if c.BackfillSec == nil {
+			c.BackfillSec = base.BackfillSec
+		}
+		if c.GapSec <= 0 {
+			c.GapSec = base.GapSec
+		}
+		if c.RetentionSec == nil {
+			c.RetentionSec = base.RetentionSec
+		}
+		if snapshotRoot != "" {
+			c.snapshotDir = filepath.Join(snapshotRoot, c.ID)
+		}
+	}
+	return cfgs, nil
+}
+
+func validChainID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// normalize applies the gap/retention defaults that depend on tick and backfill.
+func (c *chainConfig) normalize() {
+	if c.GapSec <= 0 {
+		// 连续阈值建议绑 tick，别用很大的秒数
+		c.GapSec = 3 * int64(time.Duration(c.Tick)/time.Second)
+	}
+	// retention 不能吃掉 warmup 要保留的窗口，否则每次重启都会 REBUILD
+	if minRet := *c.BackfillSec + c.GapSec; *c.RetentionSec > 0 && *c.RetentionSec < minRet {
+		log.Printf("[mockchain] chain=%s retention=%ds < backfill+gap=%ds, using %ds", c.routeID(), *c.RetentionSec, minRet, minRet)
+		c.RetentionSec = &minRet // base's pointer is shared by every chain
+	}
+}
+
+type chain struct {
+	cfg      chainConfig
+	st       *rocksstore.Store
+	miner    *miner.Miner
+	restored bool // db replaced from cfg.RestoreFrom: no warmup
+}
+
+// openChain restores (if asked), opens the store and builds the generator + miner of one chain.
+func openChain(cfg chainConfig) (*chain, error) {
+	if cfg.RestoreFrom != "" {
+		info, err := store.ReadSnapshotInfo(cfg.RestoreFrom)
+		if err != nil {
+			return nil, err
+		}
+		if info.GapRuleSec != cfg.GapSec {
+			log.Printf("[mockchain] chain=%s snapshot gap=%ds != runtime gap=%ds: gap index will be rebuilt by scan",
+				cfg.routeID(), info.GapRuleSec, cfg.GapSec)
+		}
+		backup, err := store.RestoreSnapshot(cfg.RestoreFrom, cfg.DB)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[mockchain] chain=%s restored snapshot=%s head=%d (previous db moved to %q); warmup will be skipped",
+			cfg.routeID(), info.Name, info.HeadNum, backup)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cfg.DB), 0o755); err != nil {
+		return nil, err
+	}
+	st, err := rocksstore.Open(cfg.DB, cfg.GapSec)
+	if err != nil {
+		return nil, fmt.Errorf("chain %s: %w", cfg.routeID(), err)
+	}
+
+	// RNG factory
+	rf := rng.New(map[bool]rng.Mode{true: rng.Deterministic, false: rng.Real}[*cfg.Det], *cfg.Seed)
+
+	addrs := generator.GenAddrs(cfg.Addrs, rf.R(AddrPool))
+	txgen := generator.NewTxGen(addrs, cfg.Token, rf)
+
+	m := miner.NewMiner(st, txgen, rf, miner.Config{
+		ChainID: cfg.ID,
+		Tick:    time.Duration(cfg.Tick),
+		TxMin:   cfg.TxMin,
+		TxMax:   cfg.TxMax,
+	})
+	return &chain{cfg: cfg, st: st, miner: m, restored: cfg.RestoreFrom != ""}, nil
+}
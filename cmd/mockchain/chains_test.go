@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadChainConfigsRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chains.json")
+	err := os.WriteFile(path, []byte(`[{"id": "a"}, {"id": "b", "retention_sec": 0}, {"id": "c", "retention_sec": 30}]`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	det, seed, backfill, retention := false, int64(1), int64(60), int64(7200)
+	base := chainConfig{DB: "./data/mockchain.db", Tick: duration(time.Second), Addrs: 10,
+		Det: &det, Seed: &seed, BackfillSec: &backfill, RetentionSec: &retention}
+
+	cfgs, err := loadChainConfigs(path, base, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range cfgs {
+		cfgs[i].normalize()
+	}
+	// unset inherits the flag, explicit 0 disables, too small is raised to backfill+gap
+	want := map[string]int64{"a": 7200, "b": 0, "c": 60 + 3}
+	for _, c := range cfgs {
+		if *c.RetentionSec != want[c.ID] {
+			t.Errorf("chain %s: retention=%d want %d", c.ID, *c.RetentionSec, want[c.ID])
+		}
+		if c.snapshotDir != "" {
+			t.Errorf("chain %s: snapshots enabled without -snapshot-dir", c.ID)
+		}
+	}
+	if retention != 7200 {
+		t.Fatalf("normalize changed the flag value: %d", retention)
+	}
+}
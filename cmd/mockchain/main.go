@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -12,17 +13,24 @@ import (
 
 	"golang.org/x/sync/errgroup"
 
-	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/generator"
-	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/miner"
 	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/rpc"
-	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/store"
-	"github.com/chenzhangda16/web3-logpipe/pkg/rng"
 )
 
 const AddrPool = "addr_pool"
 
 func main() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
+
+	// subcommands: mockchain verify|snapshot [flags]
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify":
+			os.Exit(runVerify(os.Args[2:]))
+		case "snapshot":
+			os.Exit(runSnapshot(os.Args[2:]))
+		}
+	}
+
 	var (
 		dbPath    = flag.String("db", "./data/mockchain.db", "rocksdb path")
 		rpcAddr   = flag.String("rpc", ":18080", "rpc listen addr")
@@ -41,39 +49,97 @@ func main() {
 		//   defines contiguity: adjacent blocks with (ts[i+1]-ts[i])<=gap-sec are considered contiguous
 		//   if <=0, defaults to 3*tickSec (min 1)
 		gapSec = flag.Int64("gap-sec", 0, "contiguity gap threshold in seconds; <=0 means default=3*tickSec")
+
+		// retention-sec:
+		//   >0 : prune blocks older than now-retention-sec every prune-every (head is always kept)
+		//   =0 : keep everything
+		retentionSec = flag.Int64("retention-sec", 0, "prune blocks older than this many seconds; 0 disables retention")
+		pruneEvery   = flag.Duration("prune-every", 1*time.Minute, "retention prune interval")
+
+		// snapshots: POST /admin/snapshot cuts a checkpoint into snapshot-dir/{name}. The admin endpoints have
+		// no auth, so they are off unless snapshot-dir is set (e.g. ./data/snapshots).
+		// restore-from replaces -db with a checkpoint before opening it (old db is moved aside); warmup is
+		// skipped for a restored chain so the restored state is served as-is.
+		snapshotDir = flag.String("snapshot-dir", "", "directory for /admin/snapshot checkpoints; empty disables")
+		restoreFrom = flag.String("restore-from", "", "restore -db from this snapshot dir before starting")
+
+		// multi-chain: -chains points at a JSON list of chain configs (see chainConfig); each chain gets
+		// its own db, miner and /chains/{id}/... routes. Without it the flags above describe one chain.
+		chainsPath = flag.String("chains", "", "multi-chain config file (JSON list); empty means a single chain from flags")
+		chainID    = flag.String("chain-id", "", "chain id stamped into block headers in single-chain mode (empty = legacy)")
 	)
 	flag.Parse()
-	log.Printf(
-		"[mockchain] start db=%s rpc=%s addr=%s tick=%s det=%v seed=%d backfill=%ds gap=%ds",
-		*dbPath, *rpcAddr, *addrCount, *tick, *det, *seed, *backfillSec, *gapSec,
-	)
-	if *gapSec <= 0 {
-		// 连续阈值建议绑 tick，别用很大的秒数
-		*gapSec = 3 * int64(*tick/time.Second)
+
+	base := chainConfig{
+		ID:           *chainID,
+		DB:           *dbPath,
+		Tick:         duration(*tick),
+		Addrs:        *addrCount,
+		Det:          det,
+		Seed:         seed,
+		BackfillSec:  backfillSec,
+		GapSec:       *gapSec,
+		RetentionSec: retentionSec,
+		RestoreFrom:  *restoreFrom,
+		snapshotDir:  *snapshotDir,
 	}
-	st, err := store.Open(*dbPath, *gapSec)
-	if err != nil {
-		log.Fatal(err)
+	if *chainID != "" && !validChainID(*chainID) {
+		log.Fatalf("bad -chain-id %q (use [A-Za-z0-9_-])", *chainID)
+	}
+	cfgs := []chainConfig{base}
+	if *chainsPath != "" {
+		if *restoreFrom != "" {
+			log.Fatal("-restore-from is single-chain only; set restore_from per chain in -chains")
+		}
+		var err error
+		if cfgs, err = loadChainConfigs(*chainsPath, base, *snapshotDir); err != nil {
+			log.Fatal(err)
+		}
 	}
-	defer st.Close()
-
-	// RNG factory
-	rf := rng.New(map[bool]rng.Mode{true: rng.Deterministic, false: rng.Real}[*det], *seed)
-
-	addrs := generator.GenAddrs(*addrCount, rf.R(AddrPool))
-	txgen := generator.NewTxGen(addrs, rf)
-
-	m := miner.NewMiner(st, txgen, rf, *tick)
 
-	// --- Warmup / Backfill (sync) ---
-	if *backfillSec > 0 {
-		start := time.Now()
-		if err := m.Warmup(*backfillSec); err != nil {
+	chains := make([]*chain, 0, len(cfgs))
+	defer func() {
+		for _, c := range chains {
+			c.st.Close()
+		}
+	}()
+	for _, cfg := range cfgs {
+		cfg.normalize()
+		log.Printf(
+			"[mockchain] start chain=%s db=%s addr=%d tick=%s det=%v seed=%d backfill=%ds gap=%ds retention=%ds",
+			cfg.routeID(), cfg.DB, cfg.Addrs, time.Duration(cfg.Tick), *cfg.Det, *cfg.Seed, *cfg.BackfillSec, cfg.GapSec, *cfg.RetentionSec,
+		)
+		c, err := openChain(cfg)
+		if err != nil {
 			log.Fatal(err)
 		}
-		log.Printf("warmup done: backfill=%ds gap=%ds tick=%s cost=%s.", *backfillSec, *gapSec, tick.String(), time.Since(start).String())
-	} else {
-		log.Printf("warmup disabled.")
+		chains = append(chains, c)
+	}
+
+	// --- Warmup / Backfill (sync, chains in parallel) ---
+	var wg errgroup.Group
+	for _, c := range chains {
+		wg.Go(func() error {
+			if *c.cfg.BackfillSec <= 0 {
+				log.Printf("[mockchain] chain=%s warmup disabled.", c.cfg.routeID())
+				return nil
+			}
+			if c.restored {
+				// Warmup's TRIM/REBUILD would throw the restored blocks away
+				log.Printf("[mockchain] chain=%s restored from snapshot: warmup skipped.", c.cfg.routeID())
+				return nil
+			}
+			start := time.Now()
+			if err := c.miner.Warmup(*c.cfg.BackfillSec); err != nil {
+				return fmt.Errorf("chain %s warmup: %w", c.cfg.routeID(), err)
+			}
+			log.Printf("[mockchain] chain=%s warmup done: backfill=%ds gap=%ds tick=%s cost=%s.",
+				c.cfg.routeID(), *c.cfg.BackfillSec, c.cfg.GapSec, time.Duration(c.cfg.Tick), time.Since(start))
+			return nil
+		})
+	}
+	if err := wg.Wait(); err != nil {
+		log.Fatal(err)
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
@@ -81,19 +147,23 @@ func main() {
 
 	g, gctx := errgroup.WithContext(ctx)
 
-	// 1) miner
-	g.Go(func() error {
-		err := m.Run(gctx)
-		if err == context.Canceled {
-			return nil
-		}
-		return err
-	})
+	// 1) miners
+	routes := make([]rpc.ChainRoute, 0, len(chains))
+	for _, c := range chains {
+		g.Go(func() error {
+			err := c.miner.Run(gctx)
+			if err == context.Canceled {
+				return nil
+			}
+			return err
+		})
+		routes = append(routes, rpc.ChainRoute{ID: c.cfg.routeID(), Server: rpc.NewServer(c.st, c.cfg.snapshotDir)})
+	}
 
-	// 2) http server
+	// 2) http server: /chains/{id}/... per chain, legacy routes -> first chain
 	srv := &http.Server{
 		Addr:    *rpcAddr,
-		Handler: rpc.NewServer(st).Handler(),
+		Handler: rpc.NewChainsHandler(routes),
 	}
 
 	// ListenAndServe 放进 errgroup
@@ -105,7 +175,21 @@ func main() {
 		return err
 	})
 
-	// 3) shutdown 协程：ctx cancel 后优雅关 server（带超时，防止卡死）
+	// 3) retention
+	for _, c := range chains {
+		if *c.cfg.RetentionSec <= 0 {
+			continue
+		}
+		g.Go(func() error {
+			err := c.st.RunRetention(gctx, *c.cfg.RetentionSec, *pruneEvery)
+			if err == context.Canceled {
+				return nil
+			}
+			return err
+		})
+	}
+
+	// 4) shutdown 协程：ctx cancel 后优雅关 server（带超时，防止卡死）
 	g.Go(func() error {
 		<-gctx.Done()
 
@@ -116,7 +200,7 @@ func main() {
 		return nil
 	})
 
-	log.Printf("mockchain rpc listening on %s, db=%s", *rpcAddr, *dbPath)
+	log.Printf("mockchain rpc listening on %s, chains=%d", *rpcAddr, len(chains))
 
 	if err := g.Wait(); err != nil {
 		log.Printf("exiting with error: %v", err)
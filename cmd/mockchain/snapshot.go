@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// runSnapshot implements `mockchain snapshot [flags]`.
+// The checkpoint has to be cut by the process that holds the DB, so this only asks the running
+// mockchain over its admin API (POST /admin/snapshot, GET /admin/snapshots) and prints the JSON reply;
+// that mockchain must run with -snapshot-dir set.
+// Restore with `mockchain -restore-from <snapshot-dir>/<name>`.
+// Exit code: 0 = ok, 2 = failed.
+func runSnapshot(args []string) int {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	var (
+		rpcAddr = fs.String("rpc", "127.0.0.1:18080", "rpc addr of the running mockchain")
+		name    = fs.String("name", "", "snapshot name ([A-Za-z0-9._-]); empty means current time")
+		list    = fs.Bool("list", false, "list existing snapshots instead of creating one")
+		timeout = fs.Duration("timeout", 5*time.Minute, "request timeout")
+	)
+	_ = fs.Parse(args)
+
+	base := *rpcAddr
+	if !strings.HasPrefix(base, "http://") && !strings.HasPrefix(base, "https://") {
+		base = "http://" + base
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var req *http.Request
+	var err error
+	if *list {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, base+"/admin/snapshots", nil)
+	} else {
+		q := url.Values{}
+		if *name != "" {
+			q.Set("name", *name)
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, base+"/admin/snapshot?"+q.Encode(), nil)
+	}
+	if err != nil {
+		log.Printf("[snapshot] bad request: %v", err)
+		return 2
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("[snapshot] request failed: %v", err)
+		return 2
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("[snapshot] %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		return 2
+	}
+	_, _ = os.Stdout.Write(body)
+	return 0
+}
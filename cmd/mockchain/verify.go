@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/store"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/store/rocksstore"
+)
+
+// runVerify implements `mockchain verify [flags]`.
+// The DB is opened read-only, so it works while a mockchain process holds the store
+// (use /admin/verify instead to verify against the live process' own handle).
+// Exit code: 0 = consistent, 1 = issues found, 2 = could not verify.
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	var (
+		dbPath    = fs.String("db", "./data/mockchain.db", "rocksdb path")
+		tick      = fs.Duration("tick", 1*time.Second, "block interval (only used to derive default gap-sec)")
+		gapSec    = fs.Int64("gap-sec", 0, "gap rule to check the gap index against; <=0 means default=3*tickSec")
+		from      = fs.Int64("from", 0, "first height to verify; <=0 means tail")
+		to        = fs.Int64("to", 0, "last height to verify; <=0 means head")
+		maxIssues = fs.Int("max-issues", 1000, "max issues listed in the report")
+	)
+	_ = fs.Parse(args)
+
+	if *gapSec <= 0 {
+		*gapSec = 3 * int64(*tick/time.Second)
+	}
+	log.Printf("[verify] db=%s gap=%ds from=%d to=%d", *dbPath, *gapSec, *from, *to)
+
+	st, err := rocksstore.OpenReadOnly(*dbPath, *gapSec)
+	if err != nil {
+		log.Printf("[verify] open failed: %v", err)
+		return 2
+	}
+	defer st.Close()
+
+	rep, err := st.Verify(store.VerifyOptions{
+		FromHeight: *from,
+		ToHeight:   *to,
+		MaxIssues:  *maxIssues,
+	})
+	if err != nil {
+		log.Printf("[verify] failed: %v", err)
+		return 2
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(rep)
+
+	if !rep.OK() {
+		log.Printf("[verify] INCONSISTENT: issues=%d (truncated=%v)", rep.IssueCount, rep.Truncated)
+		return 1
+	}
+	if rep.LegacyBlocks > 0 {
+		log.Printf("[verify] note: %d blocks stored without nonces, their hashes were not recomputed", rep.LegacyBlocks)
+	}
+	log.Printf("[verify] OK: checked=%d blocks head=%d", rep.CheckedBlocks, rep.HeadNum)
+	return 0
+}
@@ -19,11 +19,21 @@ func main() {
 		group   = flag.String("group", "logpipe-processor", "kafka consumer group")
 		topic   = flag.String("topic", "mockchain.blocks", "topic to consume blocks")
 
-		spoolPath    = flag.String("spool", "./data/spool.wal", "spool WAL path (ingest barrier)")
+		source   = flag.String("source", "", "replay fetcher file-sink output (dir/glob/file) instead of consuming kafka, then exit")
+		outSpec  = flag.String("out", "kafka", "window output: kafka|stdout|file:<path>")
+		dlqTopic = flag.String("dlq-topic", "", "dead-letter topic for records with unknown/incompatible schema (default: <topic>.dlq)")
+
+		spoolPath    = flag.String("spool", "./data/spool", "spool directory (segmented WAL, ingest barrier)")
+		spoolSegment = flag.Int64("spool-segment-bytes", 128<<20, "spool segment size before rotation")
+		spoolGroupN  = flag.Int("spool-group-records", 256, "spool group commit: fsync once this many records are queued")
+		spoolGroupT  = flag.Duration("spool-group-wait", 200*time.Microsecond, "spool group commit: fsync at most this long after the first queued record")
+		spoolRetain  = flag.Duration("spool-retain", 25*time.Hour, "keep this much event time in the spool (at least the longest window + 1h)")
+		spoolCap     = flag.Int64("spool-retain-bytes", 0, "spool size cap, deleting oldest segments first even inside -spool-retain (0 = none)")
 		decodeWorker = flag.Int("decode-worker", 4, "number of decode workers")
 		decodeQueue  = flag.Int("decode-queue", 8192, "decode queue size")
 
-		ckptPath  = flag.String("ckpt", "./data/processor.ckpt", "processor checkpoint path (reserved)")
+		ckptPath  = flag.String("ckpt", "./data/processor.ckpt", "processor checkpoint: postgres://... / sqlite:path DSN (a file path is reserved)")
+		pipeID    = flag.String("pipeline-id", "", "checkpoint row id (default: processor/<group>)")
 		readyFifo = flag.String("ready-fifo", "./data/ready/processor.ready.fifo", "write one line to FIFO when ready")
 	)
 	flag.Parse()
@@ -37,11 +47,22 @@ func main() {
 		Group:     *group,
 		Topic:     *topic,
 
-		SpoolPath:    *spoolPath,
-		DecodeWorker: *decodeWorker,
-		DecodeQueue:  *decodeQueue,
+		Source: *source,
+		Out:    *outSpec,
+
+		DLQTopic: *dlqTopic,
+
+		SpoolPath:         *spoolPath,
+		SpoolSegmentBytes: *spoolSegment,
+		SpoolGroupRecords: *spoolGroupN,
+		SpoolGroupWait:    *spoolGroupT,
+		SpoolRetain:       *spoolRetain,
+		SpoolRetainBytes:  *spoolCap,
+		DecodeWorker:      *decodeWorker,
+		DecodeQueue:       *decodeQueue,
 
 		CheckpointPath: *ckptPath,
+		PipelineID:     *pipeID,
 	}
 
 	p, err := processor.New(cfg)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -16,13 +17,18 @@ import (
 
 	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/out"
 	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/ready"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/schema"
 	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/writer"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
 )
 
 type Handler struct {
 	readyFifo string
 	readyOnce sync.Once
 	pg        *writer.PGWriter
+
+	catalog *schema.Catalog
+	dlq     *schema.DLQ
 }
 
 func (h *Handler) Setup(sess sarama.ConsumerGroupSession) error {
@@ -37,9 +43,12 @@ func (h *Handler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
 func (h *Handler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	ctx := sess.Context()
 	for msg := range claim.Messages() {
-		var env out.Envelope
-		if err := json.Unmarshal(msg.Value, &env); err != nil {
-			log.Printf("[writer] bad envelope: err=%v", err)
+		env, err := h.decode(msg)
+		if err != nil {
+			// 不认识 / 不兼容 / 解不开：进 DLQ 之后才 mark，绝不静默丢
+			if err := h.dlq.SendRetry(ctx, msg, err); err != nil {
+				return err
+			}
 			sess.MarkMessage(msg, "")
 			continue
 		}
@@ -48,7 +57,9 @@ func (h *Handler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.Co
 		case "win_tick":
 			var t out.WinTick
 			if err := json.Unmarshal(env.Data, &t); err != nil {
-				log.Printf("[writer] bad win_tick: err=%v", err)
+				if err := h.dlq.SendRetry(ctx, msg, fmt.Errorf("bad win_tick: %w", err)); err != nil {
+					return err
+				}
 				sess.MarkMessage(msg, "")
 				continue
 			}
@@ -60,19 +71,45 @@ func (h *Handler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.Co
 			}
 			sess.MarkMessage(msg, "")
 		default:
-			// 未知类型直接 mark 掉（或你想保留重试也行）
+			// in the catalog but no handler here: a deploy mismatch, keep the record
+			if err := h.dlq.SendRetry(ctx, msg, fmt.Errorf("%w: no handler for envelope type %q", schema.ErrUnknownSchema, env.Type)); err != nil {
+				return err
+			}
 			sess.MarkMessage(msg, "")
 		}
 	}
 	return nil
 }
 
+// decode checks the schema headers against the catalog, then decodes the envelope. Records without
+// schema headers predate them: the envelope type names the schema, at v1.
+func (h *Handler) decode(msg *sarama.ConsumerMessage) (out.Envelope, error) {
+	m, ok := schema.Parse(msg.Headers)
+	if ok {
+		if err := h.catalog.Check(m); err != nil {
+			return out.Envelope{}, err
+		}
+	}
+	var env out.Envelope
+	if err := json.Unmarshal(msg.Value, &env); err != nil {
+		return out.Envelope{}, fmt.Errorf("bad envelope: %w", err)
+	}
+	if !ok {
+		return env, h.catalog.Check(schema.Meta{Name: schema.Out(env.Type), Version: schema.OutV1, Codec: model.ContentTypeJSON})
+	}
+	if m.Name != schema.Out(env.Type) {
+		return out.Envelope{}, fmt.Errorf("%w: header %s, envelope type %q", schema.ErrIncompatible, m.Name, env.Type)
+	}
+	return env, nil
+}
+
 func main() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
 	var (
 		brokers   = flag.String("brokers", "127.0.0.1:9092", "kafka brokers, comma separated")
 		topic     = flag.String("topic", "logpipe.out", "out topic")
 		group     = flag.String("group", "logpipe.writer", "consumer group")
+		dlqTopic  = flag.String("dlq-topic", "", "dead-letter topic for unknown/incompatible/undecodable records (default: <topic>.dlq)")
 		readyFifo = flag.String("ready-fifo", "./data/ready/writer.ready.fifo", "write one line to FIFO when ready")
 	)
 	flag.Parse()
@@ -108,12 +145,23 @@ func main() {
 	}
 	defer func() { _ = cg.Close() }()
 
+	if *dlqTopic == "" {
+		*dlqTopic = *topic + ".dlq"
+	}
+	dlq, err := schema.NewDLQ(strings.Split(*brokers, ","), *dlqTopic, "writer/"+*group)
+	if err != nil {
+		log.Fatalf("dlq init failed: %v", err)
+	}
+	defer func() { _ = dlq.Close() }()
+
 	h := &Handler{
 		readyFifo: *readyFifo,
 		pg:        pg,
+		catalog:   schema.OutCatalog(),
+		dlq:       dlq,
 	}
 
-	log.Printf("[writer] start: topic=%s group=%s brokers=%s", *topic, *group, *brokers)
+	log.Printf("[writer] start: topic=%s group=%s brokers=%s dlq=%s", *topic, *group, *brokers, *dlqTopic)
 
 	for ctx.Err() == nil {
 		if err := cg.Consume(ctx, []string{*topic}, h); err != nil {
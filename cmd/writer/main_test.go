@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/out"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/schema"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+)
+
+func outRecord(t *testing.T, offset int64, m *schema.Meta, env any) *sarama.ConsumerMessage {
+	t.Helper()
+	msg := &sarama.ConsumerMessage{Topic: "out", Offset: offset}
+	switch v := env.(type) {
+	case string:
+		msg.Value = []byte(v)
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Value = raw
+	}
+	if m != nil {
+		hs := m.Headers()
+		for i := range hs {
+			msg.Headers = append(msg.Headers, &hs[i])
+		}
+	}
+	return msg
+}
+
+func winTickMeta(version int, codec string) *schema.Meta {
+	return &schema.Meta{Name: schema.Out("win_tick"), Version: version, Codec: codec}
+}
+
+var tick = out.Envelope{Type: "win_tick", TS: 1, Data: json.RawMessage(`{}`)}
+
+func TestHandlerDecode(t *testing.T) {
+	h := &Handler{catalog: schema.OutCatalog()}
+	cases := []struct {
+		name string
+		m    *schema.Meta
+		env  any
+		want error // nil: decoded; errBadJSON: not schema related
+	}{
+		{"current", winTickMeta(schema.OutV1, model.ContentTypeJSON), tick, nil},
+		{"legacy: no schema headers", nil, tick, nil},
+		{"unknown schema", &schema.Meta{Name: schema.Out("win_rank"), Version: 1, Codec: model.ContentTypeJSON}, tick, schema.ErrUnknownSchema},
+		{"legacy unknown type", nil, out.Envelope{Type: "win_rank", Data: json.RawMessage(`{}`)}, schema.ErrUnknownSchema},
+		{"older version", winTickMeta(schema.OutV1-1, model.ContentTypeJSON), tick, schema.ErrIncompatible},
+		{"newer version", winTickMeta(schema.OutV1+1, model.ContentTypeJSON), tick, schema.ErrIncompatible},
+		{"binary codec", winTickMeta(schema.OutV1, model.ContentTypeBinary), tick, schema.ErrIncompatible},
+		{"header and envelope disagree", winTickMeta(schema.OutV1, model.ContentTypeJSON), out.Envelope{Type: "win_rank"}, schema.ErrIncompatible},
+		{"not json", winTickMeta(schema.OutV1, model.ContentTypeJSON), "{", errBadJSON},
+		{"legacy not json", nil, "\x00\x01", errBadJSON},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			env, err := h.decode(outRecord(t, 0, c.m, c.env))
+			var syntax *json.SyntaxError
+			switch {
+			case c.want == nil && (err != nil || env.Type != "win_tick"):
+				t.Fatalf("decoded %+v err=%v", env, err)
+			case c.want == errBadJSON && !errors.As(err, &syntax):
+				t.Fatalf("err=%v want a JSON syntax error", err)
+			case c.want != nil && c.want != errBadJSON && !errors.Is(err, c.want):
+				t.Fatalf("err=%v want %v", err, c.want)
+			}
+		})
+	}
+}
+
+var errBadJSON = errors.New("bad json")
+
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	marked []int64
+}
+
+func (s *fakeSession) Context() context.Context { return s.ctx }
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	ch chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.ch }
+
+// Records the writer can't read go to the DLQ and are marked only once the DLQ has them; a DLQ
+// send that never succeeds stops the claim without marking.
+func TestHandlerUndecodableToDLQ(t *testing.T) {
+	msgs := []*sarama.ConsumerMessage{
+		outRecord(t, 10, &schema.Meta{Name: "logpipe.out.win_rank", Version: 1, Codec: model.ContentTypeJSON}, tick),
+		outRecord(t, 11, winTickMeta(schema.OutV1+1, model.ContentTypeJSON), tick),
+		outRecord(t, 12, winTickMeta(schema.OutV1, model.ContentTypeJSON), "not json"),
+		outRecord(t, 13, winTickMeta(schema.OutV1, model.ContentTypeJSON), out.Envelope{Type: "win_tick", Data: json.RawMessage(`[1]`)}),
+	}
+	p := mocks.NewSyncProducer(t, nil)
+	var reasons []string
+	for range msgs {
+		p.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(pm *sarama.ProducerMessage) error {
+			for _, h := range pm.Headers {
+				if string(h.Key) == schema.HeaderDLQReason {
+					reasons = append(reasons, string(h.Value))
+				}
+			}
+			if pm.Topic != "out.dlq" {
+				return errors.New("topic " + pm.Topic)
+			}
+			return nil
+		})
+	}
+	h := &Handler{catalog: schema.OutCatalog(), dlq: schema.NewDLQProducer(p, "out.dlq", "writer/test")}
+
+	ch := make(chan *sarama.ConsumerMessage, len(msgs))
+	for _, m := range msgs {
+		ch <- m
+	}
+	close(ch)
+	sess := &fakeSession{ctx: context.Background()}
+	if err := h.ConsumeClaim(sess, &fakeClaim{ch: ch}); err != nil {
+		t.Fatal(err)
+	}
+	if len(sess.marked) != len(msgs) || len(reasons) != len(msgs) {
+		t.Fatalf("marked %v, dlq reasons %q", sess.marked, reasons)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// DLQ down: the claim gives up when its session ends, the record stays unmarked
+	p = mocks.NewSyncProducer(t, nil)
+	p.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	h.dlq = schema.NewDLQProducer(p, "out.dlq", "writer/test")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ch = make(chan *sarama.ConsumerMessage, 1)
+	ch <- msgs[0]
+	close(ch)
+	sess = &fakeSession{ctx: ctx}
+	if err := h.ConsumeClaim(sess, &fakeClaim{ch: ch}); !errors.Is(err, context.Canceled) || len(sess.marked) != 0 {
+		t.Fatalf("err=%v marked=%v", err, sess.marked)
+	}
+}
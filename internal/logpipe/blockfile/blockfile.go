@@ -0,0 +1,233 @@
+// Package blockfile is the on-disk form of what the fetcher would produce to Kafka: one JSON record
+// per line (NDJSON), optionally gzip-compressed, rolled into files named by their first height so a
+// lexical sort of a directory is height order. The fetcher writes it (-sink file:<dir>), the
+// processor can replay it instead of consuming Kafka.
+package blockfile
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Record is one Kafka record. Value is the record value verbatim (every fetcher output is JSON).
+type Record struct {
+	Topic   string            `json:"topic"`
+	Key     string            `json:"key,omitempty"`
+	TS      int64             `json:"ts"` // unix seconds (block time)
+	Height  int64             `json:"height"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Value   json.RawMessage   `json:"value"`
+}
+
+const (
+	ext   = ".ndjson"
+	extGz = ".ndjson.gz"
+)
+
+type Options struct {
+	Dir    string
+	Prefix string // file name prefix, usually the topic
+
+	// a file is closed and the next one started after RollBytes (uncompressed) or RollEvery, at the
+	// next block boundary (all records of a height stay in one file). <=0 means 256MiB / 1h.
+	RollBytes int64
+	RollEvery time.Duration
+
+	Gzip bool
+}
+
+// Writer appends records to rolling files. Not safe for concurrent use.
+type Writer struct {
+	opts Options
+
+	f      *os.File
+	gz     *gzip.Writer
+	w      *bufio.Writer
+	n      int64
+	opened time.Time
+	height int64 // height of the last record written
+}
+
+func NewWriter(opts Options) (*Writer, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("blockfile: dir empty")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "blocks"
+	}
+	if opts.RollBytes <= 0 {
+		opts.RollBytes = 256 << 20
+	}
+	if opts.RollEvery <= 0 {
+		opts.RollEvery = time.Hour
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Writer{opts: opts}, nil
+}
+
+func (w *Writer) Write(rec Record) error {
+	if w.f != nil && rec.Height != w.height && (w.n >= w.opts.RollBytes || time.Since(w.opened) >= w.opts.RollEvery) {
+		if err := w.closeFile(); err != nil {
+			return err
+		}
+	}
+	if w.f == nil {
+		if err := w.open(rec.Height); err != nil {
+			return err
+		}
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := w.w.Write(line); err != nil {
+		return err
+	}
+	w.n += int64(len(line))
+	w.height = rec.Height
+	return nil
+}
+
+// open creates <prefix>-<height>[_kkk].ndjson[.gz]; k only appears when a restart re-writes from
+// the same height, and sorts after the earlier file ('_' > '.').
+func (w *Writer) open(height int64) error {
+	e := ext
+	if w.opts.Gzip {
+		e = extGz
+	}
+	base := fmt.Sprintf("%s-%012d", w.opts.Prefix, height)
+	for k := 0; ; k++ {
+		name := base + e
+		if k > 0 {
+			name = fmt.Sprintf("%s_%03d%s", base, k, e)
+		}
+		f, err := os.OpenFile(filepath.Join(w.opts.Dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		w.f, w.n, w.opened = f, 0, time.Now()
+		var dst io.Writer = f
+		if w.opts.Gzip {
+			w.gz = gzip.NewWriter(f)
+			dst = w.gz
+		}
+		w.w = bufio.NewWriterSize(dst, 1<<20)
+		log.Printf("[blockfile] open %s", f.Name())
+		return nil
+	}
+}
+
+// Sync makes everything written so far durable (a gzip sync block is flushed, then fsync).
+func (w *Writer) Sync() error {
+	if w.f == nil {
+		return nil
+	}
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	if w.gz != nil {
+		if err := w.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	return w.f.Sync()
+}
+
+func (w *Writer) closeFile() error {
+	if w.f == nil {
+		return nil
+	}
+	err := w.w.Flush()
+	if w.gz != nil {
+		err = errors.Join(err, w.gz.Close())
+	}
+	err = errors.Join(err, w.f.Sync(), w.f.Close())
+	w.f, w.gz, w.w = nil, nil, nil
+	return err
+}
+
+func (w *Writer) Close() error { return w.closeFile() }
+
+// Files expands path (a directory, a glob or a single file) to blockfiles in height order.
+func Files(path string) ([]string, error) {
+	pattern := path
+	if st, err := os.Stat(path); err == nil && st.IsDir() {
+		pattern = filepath.Join(path, "*")
+	}
+	all, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, p := range all {
+		if strings.HasSuffix(p, ext) || strings.HasSuffix(p, extGz) {
+			out = append(out, p)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("blockfile: no %s / %s files in %s", ext, extGz, path)
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// Read calls fn for every record of the file in order. A torn last line or a truncated gzip
+// stream (the writer crashed) ends the file with a warning instead of an error.
+func Read(path string, fn func(Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var src io.Reader = f
+	if strings.HasSuffix(path, extGz) {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil // created, nothing flushed yet
+			}
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer gz.Close()
+		src = gz
+	}
+
+	br := bufio.NewReaderSize(src, 1<<20)
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if err != nil {
+			if len(line) > 0 || errors.Is(err, io.ErrUnexpectedEOF) {
+				log.Printf("[blockfile][warn] %s: truncated after line %d (writer crash?), rest ignored", path, n-1)
+				return nil
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("%s line %d: %w", path, n, err)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Package chunk splits a block payload that exceeds the Kafka message size limit into ordered chunk
+// records and reassembles them on the consumer side.
+//
+// All chunks of a block share the block's key (so the same partition, in order) and carry headers:
+//
+//	chunk-block  block number (decimal)
+//	chunk-index  0..n-1
+//	chunk-count  n
+//	chunk-hash   block hash (hex), checked by the consumer after decoding
+//
+// A record without chunk headers is a whole payload.
+package chunk
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	HeaderBlock = "chunk-block"
+	HeaderIndex = "chunk-index"
+	HeaderCount = "chunk-count"
+	HeaderHash  = "chunk-hash"
+)
+
+// Meta is the chunk position of one record.
+type Meta struct {
+	Block int64
+	Index int
+	Count int
+	Hash  string
+}
+
+// Split cuts payload into pieces of at most size bytes (sub-slices, no copy).
+func Split(payload []byte, size int) [][]byte {
+	var out [][]byte
+	for len(payload) > size {
+		out = append(out, payload[:size])
+		payload = payload[size:]
+	}
+	return append(out, payload)
+}
+
+func (m Meta) Headers() []sarama.RecordHeader {
+	return []sarama.RecordHeader{
+		{Key: []byte(HeaderBlock), Value: []byte(strconv.FormatInt(m.Block, 10))},
+		{Key: []byte(HeaderIndex), Value: []byte(strconv.Itoa(m.Index))},
+		{Key: []byte(HeaderCount), Value: []byte(strconv.Itoa(m.Count))},
+		{Key: []byte(HeaderHash), Value: []byte(m.Hash)},
+	}
+}
+
+// Parse reads the chunk headers of a record; ok=false means a whole (unchunked) record.
+func Parse(headers []*sarama.RecordHeader) (m Meta, ok bool, err error) {
+	var seen int
+	for _, h := range headers {
+		v := string(h.Value)
+		switch string(h.Key) {
+		case HeaderBlock:
+			m.Block, err = strconv.ParseInt(v, 10, 64)
+		case HeaderIndex:
+			m.Index, err = strconv.Atoi(v)
+		case HeaderCount:
+			m.Count, err = strconv.Atoi(v)
+		case HeaderHash:
+			m.Hash = v
+		default:
+			continue
+		}
+		if err != nil {
+			return Meta{}, false, fmt.Errorf("chunk header %s=%q: %w", h.Key, v, err)
+		}
+		seen++
+	}
+	if seen == 0 {
+		return Meta{}, false, nil
+	}
+	if seen != 4 || m.Count <= 0 || m.Index < 0 || m.Index >= m.Count {
+		return Meta{}, false, fmt.Errorf("bad chunk headers: %+v", m)
+	}
+	return m, true, nil
+}
+
+// ErrBroken: chunks of a block were missing or out of order; the partial block is dropped.
+var ErrBroken = errors.New("chunk sequence broken")
+
+// Assembler rebuilds one partition's chunked payloads. Not safe for concurrent use.
+type Assembler struct {
+	cur   Meta
+	parts [][]byte
+	size  int
+}
+
+// Add feeds one chunk record. It returns the whole payload once chunk n-1 arrives.
+// A chunk of the current block that was already added (a producer retry) is ignored; any other
+// chunk that doesn't continue the current block resets the assembler, and ErrBroken reports
+// the partial block thrown away (the new chunk is kept if it starts a block).
+func (a *Assembler) Add(m Meta, value []byte) (whole []byte, done bool, err error) {
+	if len(a.parts) > 0 && m.Block == a.cur.Block && m.Index < len(a.parts) {
+		return nil, false, nil
+	}
+	if len(a.parts) > 0 && (m.Block != a.cur.Block || m.Index != len(a.parts)) {
+		err = fmt.Errorf("%w: block=%d have=%d/%d got block=%d chunk=%d", ErrBroken, a.cur.Block, len(a.parts), a.cur.Count, m.Block, m.Index)
+		a.Reset()
+	}
+	if len(a.parts) == 0 && m.Index != 0 {
+		// joined mid-block (e.g. resumed from a committed offset): wait for the next block start
+		return nil, false, errors.Join(err, fmt.Errorf("%w: block=%d starts at chunk=%d", ErrBroken, m.Block, m.Index))
+	}
+
+	buf := make([]byte, len(value))
+	copy(buf, value)
+	a.cur = m
+	a.parts = append(a.parts, buf)
+	a.size += len(buf)
+	if len(a.parts) < m.Count {
+		return nil, false, err
+	}
+
+	whole = make([]byte, 0, a.size)
+	for _, p := range a.parts {
+		whole = append(whole, p...)
+	}
+	a.Reset()
+	return whole, true, err
+}
+
+// Pending reports whether a block is half assembled.
+func (a *Assembler) Pending() bool { return len(a.parts) > 0 }
+
+func (a *Assembler) Reset() {
+	a.cur, a.parts, a.size = Meta{}, nil, 0
+}
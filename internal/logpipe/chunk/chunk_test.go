@@ -0,0 +1,159 @@
+package chunk
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+func TestSplit(t *testing.T) {
+	payload := []byte("0123456789")
+	for _, c := range []struct {
+		size int
+		want []string
+	}{
+		{3, []string{"012", "345", "678", "9"}},
+		{5, []string{"01234", "56789"}},
+		{10, []string{"0123456789"}},
+		{64, []string{"0123456789"}},
+	} {
+		parts := Split(payload, c.size)
+		if len(parts) != len(c.want) {
+			t.Fatalf("size=%d: %d parts, want %d", c.size, len(parts), len(c.want))
+		}
+		for i := range parts {
+			if string(parts[i]) != c.want[i] {
+				t.Fatalf("size=%d part %d=%q want %q", c.size, i, parts[i], c.want[i])
+			}
+		}
+	}
+	if parts := Split(nil, 4); len(parts) != 1 || len(parts[0]) != 0 {
+		t.Fatalf("empty payload: %q", parts)
+	}
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	m := Meta{Block: 42, Index: 1, Count: 3, Hash: "0xabc"}
+	hs := m.Headers()
+	ptrs := []*sarama.RecordHeader{{Key: []byte("content-type"), Value: []byte("json")}}
+	for i := range hs {
+		ptrs = append(ptrs, &hs[i])
+	}
+	got, ok, err := Parse(ptrs)
+	if err != nil || !ok || got != m {
+		t.Fatalf("Parse=%+v ok=%v err=%v", got, ok, err)
+	}
+
+	if _, ok, err := Parse(ptrs[:1]); ok || err != nil {
+		t.Fatalf("whole record: ok=%v err=%v", ok, err)
+	}
+	if _, _, err := Parse(ptrs[:3]); err == nil {
+		t.Fatal("missing headers: want error")
+	}
+	bad := Meta{Block: 1, Index: 3, Count: 3}.Headers()
+	if _, _, err := Parse([]*sarama.RecordHeader{&bad[0], &bad[1], &bad[2], &bad[3]}); err == nil {
+		t.Fatal("index >= count: want error")
+	}
+}
+
+// feed is one Add call: chunk index of block.
+type feed struct {
+	block int64
+	index int
+}
+
+func TestAssembler(t *testing.T) {
+	const count = 3
+	payload := func(block int64) []byte { return bytes.Repeat([]byte{byte(block)}, 3*count) }
+	chunkOf := func(f feed) (Meta, []byte) {
+		return Meta{Block: f.block, Index: f.index, Count: count}, Split(payload(f.block), 3)[f.index]
+	}
+
+	cases := []struct {
+		name   string
+		feeds  []feed
+		done   []int64 // blocks completed, in order
+		broken int     // Add calls returning ErrBroken
+	}{
+		{"in order", []feed{{1, 0}, {1, 1}, {1, 2}, {2, 0}, {2, 1}, {2, 2}}, []int64{1, 2}, 0},
+		{"out of order drops the block", []feed{{1, 0}, {1, 2}, {1, 1}}, nil, 2},
+		{"out of order then next block", []feed{{1, 0}, {1, 2}, {2, 0}, {2, 1}, {2, 2}}, []int64{2}, 1},
+		{"duplicate chunk is ignored", []feed{{1, 0}, {1, 1}, {1, 1}, {1, 0}, {1, 2}}, []int64{1}, 0},
+		{"missing chunk", []feed{{1, 0}, {1, 1}, {2, 0}, {2, 1}, {2, 2}}, []int64{2}, 1},
+		{"missing last chunk", []feed{{1, 0}, {1, 1}, {2, 1}, {2, 2}}, nil, 2},
+		{"joined mid-block", []feed{{1, 1}, {1, 2}, {2, 0}, {2, 1}, {2, 2}}, []int64{2}, 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				a      Assembler
+				done   []int64
+				broken int
+			)
+			for _, f := range c.feeds {
+				m, v := chunkOf(f)
+				whole, ok, err := a.Add(m, v)
+				if err != nil {
+					if !errors.Is(err, ErrBroken) {
+						t.Fatalf("%+v: err=%v", f, err)
+					}
+					broken++
+				}
+				if ok {
+					if !bytes.Equal(whole, payload(f.block)) {
+						t.Fatalf("block %d reassembled to %x", f.block, whole)
+					}
+					done = append(done, f.block)
+				}
+			}
+			if len(done) != len(c.done) || broken != c.broken {
+				t.Fatalf("done=%v broken=%d, want done=%v broken=%d", done, broken, c.done, c.broken)
+			}
+			for i := range done {
+				if done[i] != c.done[i] {
+					t.Fatalf("done=%v want %v", done, c.done)
+				}
+			}
+		})
+	}
+}
+
+// A whole record mid-sequence is handled by the caller: Pending says there is a partial block to
+// drop, Reset drops it, and the next block assembles normally.
+func TestAssemblerWholeRecordMidSequence(t *testing.T) {
+	var a Assembler
+	if a.Pending() {
+		t.Fatal("new assembler pending")
+	}
+	if _, done, err := a.Add(Meta{Block: 1, Index: 0, Count: 2}, []byte("ab")); done || err != nil {
+		t.Fatalf("done=%v err=%v", done, err)
+	}
+	if !a.Pending() {
+		t.Fatal("half a block should be pending")
+	}
+	a.Reset()
+	if a.Pending() {
+		t.Fatal("pending after Reset")
+	}
+	if _, _, err := a.Add(Meta{Block: 1, Index: 1, Count: 2}, []byte("cd")); !errors.Is(err, ErrBroken) {
+		t.Fatalf("chunk 1 after Reset: err=%v want ErrBroken", err)
+	}
+	a.Add(Meta{Block: 2, Index: 0, Count: 2}, []byte("ef"))
+	whole, done, err := a.Add(Meta{Block: 2, Index: 1, Count: 2}, []byte("gh"))
+	if !done || err != nil || string(whole) != "efgh" {
+		t.Fatalf("whole=%q done=%v err=%v", whole, done, err)
+	}
+}
+
+func TestAssemblerCopiesValues(t *testing.T) {
+	var a Assembler
+	buf := []byte("ab")
+	a.Add(Meta{Block: 1, Index: 0, Count: 2}, buf)
+	copy(buf, "zz") // the consumer may reuse the message buffer
+	whole, _, _ := a.Add(Meta{Block: 1, Index: 1, Count: 2}, []byte("cd"))
+	if string(whole) != "abcd" {
+		t.Fatalf("whole=%q", whole)
+	}
+}
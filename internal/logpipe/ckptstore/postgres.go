@@ -0,0 +1,29 @@
+package ckptstore
+
+import (
+	"context"
+	"database/sql"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+const pgDDL = `
+CREATE TABLE IF NOT EXISTS ` + table + ` (
+  pipeline_id text        PRIMARY KEY,
+  height      bigint      NOT NULL,
+  hash        text        NOT NULL,
+  version     bigint      NOT NULL,
+  updated_at  timestamptz NOT NULL
+);
+`
+
+// OpenPostgres opens (and creates if needed) the checkpoint table; one row per pipeline id.
+func OpenPostgres(ctx context.Context, dsn string) (Store, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(2)
+	db.SetMaxIdleConns(2)
+	return newSQLStore(ctx, db, pgDDL, dollarPlaceholders)
+}
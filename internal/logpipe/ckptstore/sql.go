@@ -0,0 +1,104 @@
+package ckptstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const table = "logpipe_checkpoints"
+
+// sqlStore is the database/sql implementation shared by Postgres and SQLite.
+// Queries are written with $N placeholders; rebind adapts them to the driver.
+type sqlStore struct {
+	db     *sql.DB
+	rebind func(string) string
+
+	mu       sync.Mutex
+	versions map[string]int64 // id -> version we last loaded or wrote (0: row not seen)
+}
+
+func newSQLStore(ctx context.Context, db *sql.DB, ddl string, rebind func(string) string) (*sqlStore, error) {
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("ckptstore: ensure schema: %w", err)
+	}
+	return &sqlStore{db: db, rebind: rebind, versions: make(map[string]int64)}, nil
+}
+
+func (s *sqlStore) Load(ctx context.Context, id string) (Record, bool, error) {
+	var (
+		rec     Record
+		version int64
+	)
+	err := s.db.QueryRowContext(ctx,
+		s.rebind(`SELECT height, hash, version, updated_at FROM `+table+` WHERE pipeline_id = $1`), id,
+	).Scan(&rec.Height, &rec.Hash, &version, &rec.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.setVersion(id, 0)
+		return Record{}, false, nil
+	}
+	if err != nil {
+		return Record{}, false, err
+	}
+	s.setVersion(id, version)
+	return rec, true, nil
+}
+
+// Save writes rec if the row is still at the version we know; otherwise ErrConflict.
+// A row we have never seen may only be created, not overwritten.
+func (s *sqlStore) Save(ctx context.Context, id string, rec Record) error {
+	if rec.UpdatedAt.IsZero() {
+		rec.UpdatedAt = time.Now()
+	}
+	rec.UpdatedAt = rec.UpdatedAt.UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := s.versions[id]
+
+	var (
+		res sql.Result
+		err error
+	)
+	if v == 0 {
+		res, err = s.db.ExecContext(ctx, s.rebind(`INSERT INTO `+table+` (pipeline_id, height, hash, version, updated_at)
+VALUES ($1, $2, $3, 1, $4) ON CONFLICT (pipeline_id) DO NOTHING`), id, rec.Height, rec.Hash, rec.UpdatedAt)
+	} else {
+		res, err = s.db.ExecContext(ctx, s.rebind(`UPDATE `+table+` SET height = $1, hash = $2, version = version + 1, updated_at = $3
+WHERE pipeline_id = $4 AND version = $5`), rec.Height, rec.Hash, rec.UpdatedAt, id, v)
+	}
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return fmt.Errorf("%w: id=%s expected_version=%d", ErrConflict, id, v)
+	}
+	s.versions[id] = v + 1
+	return nil
+}
+
+func (s *sqlStore) setVersion(id string, v int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versions[id] = v
+}
+
+func (s *sqlStore) Close() error { return s.db.Close() }
+
+func dollarPlaceholders(q string) string { return q }
+
+// questionPlaceholders turns $N into ?N (SQLite numbered parameters).
+func questionPlaceholders(q string) string { return strings.ReplaceAll(q, "$", "?") }
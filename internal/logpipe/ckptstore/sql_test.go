@@ -0,0 +1,112 @@
+package ckptstore
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func openTestSQLite(t *testing.T, path string) Store {
+	t.Helper()
+	st, err := Open(context.Background(), "sqlite:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	return st
+}
+
+func TestSQLiteInsertIfAbsent(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "ckpt.db")
+	a, b := openTestSQLite(t, path), openTestSQLite(t, path)
+
+	if _, ok, err := a.Load(ctx, "p"); err != nil || ok {
+		t.Fatalf("Load on empty table: ok=%v err=%v", ok, err)
+	}
+	if _, _, err := b.Load(ctx, "p"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Save(ctx, "p", Record{Height: 10, Hash: "h10"}); err != nil {
+		t.Fatalf("first insert: %v", err)
+	}
+	// b also saw no row: its insert must not overwrite a's
+	if err := b.Save(ctx, "p", Record{Height: 99, Hash: "h99"}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("second insert: err=%v want ErrConflict", err)
+	}
+	rec, ok, err := b.Load(ctx, "p")
+	if err != nil || !ok || rec.Height != 10 || rec.Hash != "h10" || rec.UpdatedAt.IsZero() {
+		t.Fatalf("Load=%+v ok=%v err=%v", rec, ok, err)
+	}
+
+	// a Save without a Load is an insert too, and other ids are independent
+	if err := b.Save(ctx, "q", Record{Height: 1, Hash: "h1"}); err != nil {
+		t.Fatalf("insert q: %v", err)
+	}
+}
+
+func TestSQLiteCASConflict(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "ckpt.db")
+	a, b := openTestSQLite(t, path), openTestSQLite(t, path)
+
+	if err := a.Save(ctx, "p", Record{Height: 1, Hash: "h1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.Load(ctx, "p"); err != nil {
+		t.Fatal(err)
+	}
+
+	// both at version 1: the first writer wins, the second gets ErrConflict
+	if err := a.Save(ctx, "p", Record{Height: 2, Hash: "h2"}); err != nil {
+		t.Fatalf("a: %v", err)
+	}
+	if err := b.Save(ctx, "p", Record{Height: 3, Hash: "h3"}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("b: err=%v want ErrConflict", err)
+	}
+	// the winner keeps writing from its own version
+	if err := a.Save(ctx, "p", Record{Height: 4, Hash: "h4"}); err != nil {
+		t.Fatalf("a again: %v", err)
+	}
+	if err := b.Save(ctx, "p", Record{Height: 5, Hash: "h5"}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("b again: err=%v want ErrConflict", err)
+	}
+
+	// Load refreshes b's version, after which b can write and a is the stale one
+	rec, _, err := b.Load(ctx, "p")
+	if err != nil || rec.Height != 4 {
+		t.Fatalf("b Load=%+v err=%v", rec, err)
+	}
+	if err := b.Save(ctx, "p", Record{Height: 6, Hash: "h6"}); err != nil {
+		t.Fatalf("b after Load: %v", err)
+	}
+	if err := a.Save(ctx, "p", Record{Height: 7, Hash: "h7"}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("a after b's write: err=%v want ErrConflict", err)
+	}
+	if rec, _, _ := a.Load(ctx, "p"); rec.Height != 6 || rec.Hash != "h6" {
+		t.Fatalf("final=%+v", rec)
+	}
+}
+
+func TestSQLiteReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "sub", "ckpt.db") // parent dir is created
+	st, err := Open(ctx, "sqlite:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Save(ctx, "p", Record{Height: 42, Hash: "h42"}); err != nil {
+		t.Fatal(err)
+	}
+	_ = st.Close()
+
+	st = openTestSQLite(t, path)
+	rec, ok, err := st.Load(ctx, "p")
+	if err != nil || !ok || rec.Height != 42 {
+		t.Fatalf("Load after reopen=%+v ok=%v err=%v", rec, ok, err)
+	}
+	if err := st.Save(ctx, "p", Record{Height: 43, Hash: "h43"}); err != nil {
+		t.Fatalf("Save after reopen+Load: %v", err)
+	}
+}
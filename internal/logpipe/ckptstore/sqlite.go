@@ -0,0 +1,36 @@
+package ckptstore
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteDDL = `
+CREATE TABLE IF NOT EXISTS ` + table + ` (
+  pipeline_id TEXT      PRIMARY KEY,
+  height      INTEGER   NOT NULL,
+  hash        TEXT      NOT NULL,
+  version     INTEGER   NOT NULL,
+  updated_at  TIMESTAMP NOT NULL
+);
+`
+
+// OpenSQLite is the local-run store: same table and CAS semantics as Postgres, in one file.
+func OpenSQLite(ctx context.Context, path string) (Store, error) {
+	if dir := filepath.Dir(path); dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	// WAL + busy timeout: a second process on the same file waits instead of failing with SQLITE_BUSY
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return newSQLStore(ctx, db, sqliteDDL, questionPlaceholders)
+}
@@ -10,6 +10,7 @@ type TxWinMarginInfo struct {
 	TxHead  int64
 	TxTail  int64
 	OpenWin bool
+	Cursor  uint64 // spool seq of the block that moved the window: same in every instance reading the spool
 }
 
 type Dispatcher struct {
@@ -35,12 +36,13 @@ func (d *Dispatcher) Append(ev event.TxEvent, idx int64) {
 	d.log[idx%MaxTxPerWindow] = ev
 }
 
-func (d *Dispatcher) WinMove(txTail []int64, txHead int64, openWin bool) {
+func (d *Dispatcher) WinMove(txTail []int64, txHead int64, openWin bool, cursor uint64) {
 	for i := range d.winMoveRecord {
 		d.winMoveRecord[i] <- TxWinMarginInfo{
 			TxHead:  txHead,
 			TxTail:  txTail[i],
 			OpenWin: openWin,
+			Cursor:  cursor,
 		}
 	}
 }
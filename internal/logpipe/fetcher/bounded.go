@@ -0,0 +1,67 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Bounded reports whether the fetcher runs as a one-shot range job (-from-*/-to-*):
+// produce [from, to] once, exit with a summary, never read or write the live checkpoint.
+func (c Config) Bounded() bool {
+	return c.FromHeight > 0 || c.ToHeight > 0 || c.FromTs > 0 || c.ToTs > 0
+}
+
+// resolveRange turns the height/timestamp bounds into [from, to]. Heights win over timestamps;
+// a missing lower bound is 1 and a missing upper bound is the head at start.
+func (f *Fetcher) resolveRange(ctx context.Context) (int64, int64, error) {
+	head, err := f.rpc.ChainHead(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	if head.Empty || head.HeadNum <= 0 {
+		return 0, 0, errors.New("empty chain")
+	}
+
+	from := int64(1)
+	switch {
+	case f.cfg.FromHeight > 0:
+		from = f.cfg.FromHeight
+	case f.cfg.FromTs > 0:
+		pos, err := f.rpc.BlockAtOrAfter(ctx, f.cfg.FromTs)
+		if err != nil {
+			return 0, 0, fmt.Errorf("from-ts=%d: %w", f.cfg.FromTs, err)
+		}
+		from = pos.BlockNum
+	}
+
+	to := head.HeadNum
+	switch {
+	case f.cfg.ToHeight > 0:
+		to = f.cfg.ToHeight
+	case f.cfg.ToTs > 0:
+		// last block with ts <= to-ts = (first block with ts > to-ts) - 1
+		pos, err := f.rpc.BlockAtOrAfter(ctx, f.cfg.ToTs+1)
+		switch {
+		case err == nil:
+			to = pos.BlockNum - 1
+		case head.HeadTimestamp <= f.cfg.ToTs:
+			to = head.HeadNum // nothing after to-ts yet: everything up to head
+		default:
+			return 0, 0, fmt.Errorf("to-ts=%d: %w", f.cfg.ToTs, err)
+		}
+	}
+
+	if from > to {
+		return 0, 0, fmt.Errorf("empty range: from=%d to=%d", from, to)
+	}
+	log.Printf("[fetcher] bounded range: from=%d to=%d (head=%d) topic=%s", from, to, head.HeadNum, f.cfg.Topic)
+	return from, to, nil
+}
+
+// nopCheckpoint keeps a bounded job away from the live checkpoint.
+type nopCheckpoint struct{}
+
+func (nopCheckpoint) Load() (Ckpt, bool, error) { return Ckpt{}, false, nil }
+func (nopCheckpoint) Save(Ckpt) error           { return nil }
@@ -0,0 +1,154 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/ckptstore"
+)
+
+// Dual-cursor mode (Config.DualCursor): on cold start the live cursor starts at head right away and
+// the history [head-BackfillSec, head-1] is handed to a second, bounded cursor producing into
+// BackfillTopic. Fresh blocks no longer wait behind a day of history.
+//
+// The backfill has its own checkpoints next to the live one (file: <ckpt>.backfill*, database store:
+// <pipeline id>/backfill*):
+//
+//	backfill-plan  LastHeight = last height to backfill (fixed at the cold start)
+//	backfill       progress (acked watermark of the backfill cursor)
+//
+// The backfill is complete when progress >= plan; it then logs BACKFILL COMPLETE and is skipped on
+// later restarts. The backfill cursor is at-least-once even when the live one is exactly-once.
+
+const (
+	sidePlan     = ".backfill-plan"
+	sideProgress = ".backfill"
+)
+
+// sideCheckpoint opens a checkpoint stored next to the live one.
+func (f *Fetcher) sideCheckpoint(suffix string) (Checkpoint, func() error, error) {
+	return openCheckpoint(sidePath(f.cfg.CheckpointPath, suffix), f.cfg.PipelineID+suffix)
+}
+
+// sidePath: a file checkpoint gets a sibling file; a DSN stays the same (the row id differs).
+func sidePath(path, suffix string) string {
+	if ckptstore.IsDSN(path) {
+		return path
+	}
+	return path + suffix
+}
+
+// planBackfill records the backfill range at cold start; the live cursor then starts at head.
+func (f *Fetcher) planBackfill(ctx context.Context, head ChainHeadResp) error {
+	if f.cfg.BackfillSec < 0 || head.HeadNum <= 1 {
+		return nil
+	}
+	targetTs := max(head.HeadTimestamp-f.cfg.BackfillSec, 0)
+	pos, err := f.rpc.BlockAtOrAfter(ctx, targetTs)
+	if err != nil {
+		return err
+	}
+	if pos.BlockNum > head.HeadNum-1 {
+		return nil // nothing older than head inside the window
+	}
+
+	plan, closePlan, err := f.sideCheckpoint(sidePlan)
+	if err != nil {
+		return err
+	}
+	defer closePlan()
+	prog, closeProg, err := f.sideCheckpoint(sideProgress)
+	if err != nil {
+		return err
+	}
+	defer closeProg()
+	// a new cold start supersedes any older plan; loading first also arms the CAS of database stores
+	for _, c := range []Checkpoint{plan, prog} {
+		if _, _, err := c.Load(); err != nil {
+			return err
+		}
+	}
+
+	// progress = from-1: a restart before the first ack resumes the backfill at from
+	start := Ckpt{LastHeight: pos.BlockNum - 1}
+	if start.LastHeight > 0 {
+		blk, err := f.rpc.BlockByNumber(ctx, start.LastHeight)
+		if err != nil {
+			return err
+		}
+		start.LastHash = blk.Hash.Hex()
+	}
+	end, err := f.rpc.BlockByNumber(ctx, head.HeadNum-1)
+	if err != nil {
+		return err
+	}
+	if err := prog.Save(start); err != nil {
+		return err
+	}
+	if err := plan.Save(Ckpt{LastHeight: head.HeadNum - 1, LastHash: end.Hash.Hex()}); err != nil {
+		return err
+	}
+	log.Printf("[fetcher][backfill] planned: from=%d to=%d topic=%s (live starts at head=%d)",
+		pos.BlockNum, head.HeadNum-1, f.cfg.BackfillTopic, head.HeadNum)
+	return nil
+}
+
+// runBackfill drives the backfill cursor until the planned range is produced (or ctx ends).
+func (f *Fetcher) runBackfill(ctx context.Context) error {
+	plan, closePlan, err := f.sideCheckpoint(sidePlan)
+	if err != nil {
+		return err
+	}
+	defer closePlan()
+	to, ok, err := plan.Load()
+	if err != nil || !ok {
+		return err // no plan: nothing to backfill
+	}
+
+	prog, closeProg, err := f.sideCheckpoint(sideProgress)
+	if err != nil {
+		return err
+	}
+	from := int64(1)
+	if p, ok, err := prog.Load(); err != nil {
+		_ = closeProg()
+		return err
+	} else if ok {
+		from = p.LastHeight + 1
+	}
+	if from > to.LastHeight {
+		_ = closeProg()
+		log.Printf("[fetcher][backfill] already complete: to=%d", to.LastHeight)
+		return nil
+	}
+
+	cfg := f.cfg
+	cfg.Topic = f.cfg.BackfillTopic
+	cfg.FromHeight, cfg.ToHeight = from, to.LastHeight
+	cfg.DualCursor, cfg.ExactlyOnce = false, false
+	// resume-able: the bounded cursor checkpoints into the backfill progress instead of nowhere.
+	// One rate-limit budget (and one view of endpoint health) for both cursors: f's pool
+	bf, err := newBounded(cfg, f.rpc, prog)
+	if err != nil {
+		_ = closeProg()
+		return err
+	}
+	defer func() {
+		_ = bf.Close()
+		_ = closeProg()
+	}()
+
+	log.Printf("[fetcher][backfill] start: from=%d to=%d topic=%s", from, to.LastHeight, cfg.Topic)
+	if err := bf.Run(ctx); err != nil {
+		return err
+	}
+	if err := bf.saveCheckpoint(true); err != nil {
+		return err
+	}
+	if p, ok, err := prog.Load(); err != nil || !ok || p.LastHeight < to.LastHeight {
+		return errors.Join(errors.New("backfill finished but progress checkpoint is behind"), err)
+	}
+	log.Printf("[fetcher][backfill] BACKFILL COMPLETE: to=%d topic=%s", to.LastHeight, cfg.Topic)
+	return nil
+}
@@ -0,0 +1,247 @@
+package fetcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/chunk"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/schema"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+)
+
+// TxMessage is one tx on the fan-out topic, keyed by address so consumers can scale by partition
+// instead of reading one totally ordered block stream. Order holds per address, not globally.
+type TxMessage struct {
+	BlockNum  int64       `json:"block_num"`
+	BlockHash hash.Hash32 `json:"block_hash"`
+	BlockTs   int64       `json:"block_ts"`
+	Index     int         `json:"index"` // position in the block
+	Tx        model.Tx    `json:"tx"`
+}
+
+// HeaderMessage is a block without its txs (header-only topic).
+type HeaderMessage struct {
+	Header  model.BlockHeader `json:"header"`
+	Hash    hash.Hash32       `json:"hash"`
+	TxCount int               `json:"tx_count"`
+}
+
+// outputs decides which records one block becomes. Every record of a block carries its height in
+// Metadata, so the ack watermark only passes the block once all of them are acked.
+type outputs struct {
+	topic, qtopic string
+	txTopic       string
+	txKeyBy       string // from|to
+	headerTopic   string
+	chunkBytes    int
+	maxBytes      int    // largest record the sink takes; 0: no limit
+	contentType   string // block payloads; fan-out records are always JSON
+	producerID    string
+}
+
+// newOutputs: records for a Kafka producer, at most maxRecordBytes each.
+func newOutputs(topic string, opts ProducerOptions) (outputs, error) {
+	return newOutputsMax(topic, opts, maxRecordBytes)
+}
+
+// newOutputsMax: maxBytes 0 means records of any size (file sinks).
+func newOutputsMax(topic string, opts ProducerOptions, maxBytes int) (outputs, error) {
+	o := outputs{
+		topic:       topic,
+		qtopic:      opts.QuarantineTopic,
+		txTopic:     opts.TxTopic,
+		txKeyBy:     opts.TxKeyBy,
+		headerTopic: opts.HeaderTopic,
+		chunkBytes:  opts.ChunkBytes,
+		maxBytes:    maxBytes,
+		producerID:  opts.ProducerID,
+	}
+	switch opts.Codec {
+	case "", "json":
+		o.contentType = model.ContentTypeJSON
+	case "binary":
+		o.contentType = model.ContentTypeBinary
+	default:
+		return outputs{}, fmt.Errorf("codec %q: want json|binary", opts.Codec)
+	}
+	if o.chunkBytes <= 0 {
+		o.chunkBytes = defaultChunkBytes
+	}
+	if o.maxBytes > 0 && o.chunkBytes > o.maxBytes-chunkHeadroom {
+		return outputs{}, fmt.Errorf("chunk bytes %d: a chunk record must stay under %d", o.chunkBytes, o.maxBytes-chunkHeadroom)
+	}
+	if o.txKeyBy == "" {
+		o.txKeyBy = "from"
+	}
+	if o.txKeyBy != "from" && o.txKeyBy != "to" {
+		return outputs{}, fmt.Errorf("tx key %q: want from|to", o.txKeyBy)
+	}
+	return o, nil
+}
+
+// messages: a valid block goes to the main topic plus the enabled fan-out topics; a quarantined
+// block only to the quarantine topic. Block records are chunked to fit; a fan-out record too large
+// for the sink is replaced by a note on the quarantine topic (see oversized): sent as is it would
+// fail, be rewound and be produced again forever.
+func (o outputs) messages(rb routedBlock) ([]*sarama.ProducerMessage, error) {
+	b := rb.Block
+	if rb.Reason != "" {
+		msgs, err := o.blockRecords(o.qtopic, b)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			msg.Headers = append(msg.Headers, o.quarantineHeaders(rb.Reason, rb.Detail, o.topic)...)
+		}
+		return msgs, nil
+	}
+
+	msgs, err := o.blockRecords(o.topic, b)
+	if err != nil {
+		return nil, err
+	}
+	ts := time.Unix(b.Header.Timestamp, 0)
+
+	if o.headerTopic != "" {
+		v, err := json.Marshal(HeaderMessage{Header: b.Header, Hash: b.Hash, TxCount: len(b.Txs)})
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, o.fit(&sarama.ProducerMessage{
+			Topic:     o.headerTopic,
+			Key:       sarama.StringEncoder(strconv.FormatInt(b.Header.Number, 10)),
+			Value:     sarama.ByteEncoder(v),
+			Timestamp: ts,
+			Headers:   o.fanoutHeaders(schema.BlockHeader, schema.BlockHeaderV1, b),
+		}, b))
+	}
+
+	if o.txTopic != "" {
+		for i, tx := range b.Txs {
+			v, err := json.Marshal(TxMessage{BlockNum: b.Header.Number, BlockHash: b.Hash, BlockTs: b.Header.Timestamp, Index: i, Tx: tx})
+			if err != nil {
+				return nil, err
+			}
+			key := tx.TxBody.From
+			if o.txKeyBy == "to" {
+				key = tx.TxBody.To
+			}
+			msgs = append(msgs, o.fit(&sarama.ProducerMessage{
+				Topic:     o.txTopic,
+				Key:       sarama.StringEncoder(key),
+				Value:     sarama.ByteEncoder(v),
+				Timestamp: ts,
+				Headers:   o.fanoutHeaders(schema.Tx, schema.TxV1, b),
+			}, b))
+		}
+	}
+	return msgs, nil
+}
+
+func (o outputs) fanoutHeaders(name string, version int, b model.Block) []sarama.RecordHeader {
+	return schema.Meta{
+		Name: name, Version: version, Codec: model.ContentTypeJSON,
+		ProducerID: o.producerID, ChainID: b.Header.ChainID,
+	}.Headers()
+}
+
+// fit returns msg, or the oversized note standing in for it when the sink can't take it.
+func (o outputs) fit(msg *sarama.ProducerMessage, b model.Block) *sarama.ProducerMessage {
+	n := recordBytes(msg)
+	if o.maxBytes <= 0 || n <= o.maxBytes {
+		return msg
+	}
+	log.Printf("[producer][warn] height=%d %s record of %d bytes (max %d): quarantined instead",
+		b.Header.Number, msg.Topic, n, o.maxBytes)
+	return o.oversized(msg, b, n)
+}
+
+// oversized is the quarantine record of a fan-out record that can never be sent: headers only,
+// saying what and how large it was. The data is still on the main topic, in the block. It keeps
+// the block's key and so its place in the ack watermark.
+func (o outputs) oversized(msg *sarama.ProducerMessage, b model.Block, n int) *sarama.ProducerMessage {
+	key, _ := msg.Key.Encode()
+	detail := fmt.Sprintf("bytes=%d max=%d key=%.64s", n, o.maxBytes, key)
+	return &sarama.ProducerMessage{
+		Topic:     o.qtopic,
+		Key:       sarama.StringEncoder(strconv.FormatInt(b.Header.Number, 10)),
+		Timestamp: msg.Timestamp,
+		Headers:   append(msg.Headers, o.quarantineHeaders(ReasonOversized, detail, msg.Topic)...),
+	}
+}
+
+func (o outputs) quarantineHeaders(reason, detail, source string) []sarama.RecordHeader {
+	if len(detail) > maxDetailBytes {
+		detail = detail[:maxDetailBytes] + "..."
+	}
+	return []sarama.RecordHeader{
+		{Key: []byte("reason"), Value: []byte(reason)},
+		{Key: []byte("detail"), Value: []byte(detail)},
+		{Key: []byte("source_topic"), Value: []byte(source)},
+	}
+}
+
+// recordBytes is the size of msg as the producer counts it against MaxMessageBytes, give or take
+// a few bytes of framing.
+func recordBytes(msg *sarama.ProducerMessage) int {
+	n := recordFraming
+	if msg.Key != nil {
+		n += msg.Key.Length()
+	}
+	if msg.Value != nil {
+		n += msg.Value.Length()
+	}
+	for _, h := range msg.Headers {
+		n += len(h.Key) + len(h.Value) + 2*binaryMaxVarint
+	}
+	return n
+}
+
+const (
+	// maxRecordBytes is sarama's default Producer.MaxMessageBytes (and the broker's default
+	// message.max.bytes): a record over it fails every send.
+	maxRecordBytes = 1000000
+
+	// defaultChunkBytes keeps a chunk (uncompressed, plus headers) well under maxRecordBytes.
+	defaultChunkBytes = 512 << 10
+	// chunkHeadroom: room left in a chunk record for its key and headers.
+	chunkHeadroom = 16 << 10
+
+	// maxDetailBytes caps the detail header of a quarantine record.
+	maxDetailBytes = 1 << 10
+
+	recordFraming   = 64 // record length, attributes, deltas, key/value lengths
+	binaryMaxVarint = 5
+)
+
+// blockRecords is the block as one record, or as ordered chunk records when its encoding is larger
+// than chunkBytes (same key, so same partition; see package chunk). The consumer reassembles them.
+func (o outputs) blockRecords(topic string, b model.Block) ([]*sarama.ProducerMessage, error) {
+	msg, err := blockMessage(topic, o.contentType, o.producerID, b)
+	if err != nil {
+		return nil, err
+	}
+	payload := msg.Value.(sarama.ByteEncoder)
+	if len(payload) <= o.chunkBytes {
+		return []*sarama.ProducerMessage{msg}, nil
+	}
+
+	parts := chunk.Split(payload, o.chunkBytes)
+	out := make([]*sarama.ProducerMessage, 0, len(parts))
+	for i, part := range parts {
+		m := chunk.Meta{Block: b.Header.Number, Index: i, Count: len(parts), Hash: b.Hash.Hex()}
+		out = append(out, &sarama.ProducerMessage{
+			Topic:     topic,
+			Key:       msg.Key,
+			Value:     sarama.ByteEncoder(part),
+			Timestamp: msg.Timestamp,
+			Headers:   append(m.Headers(), msg.Headers...),
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,275 @@
+package fetcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/chunk"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/schema"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+)
+
+func fanoutBlock(n int64, froms ...string) model.Block {
+	txs := make([]model.Tx, 0, len(froms))
+	for i, from := range froms {
+		txs = append(txs, model.BuildTx(model.TxBody{From: from, To: "0xb", Token: "MOCK", Amount: 1, Timestamp: 1000 + n, Nonce: uint64(i)}, n))
+	}
+	return model.BuildBlock("mock", n, hash.Hash32{}, txs, 1000+n, 7)
+}
+
+func header(msg *sarama.ProducerMessage, key string) string {
+	for _, h := range msg.Headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func headerPtrs(msg *sarama.ProducerMessage) []*sarama.RecordHeader {
+	hs := make([]*sarama.RecordHeader, len(msg.Headers))
+	for i := range msg.Headers {
+		hs[i] = &msg.Headers[i]
+	}
+	return hs
+}
+
+func testOutputs(t *testing.T, opts ProducerOptions) outputs {
+	t.Helper()
+	opts.QuarantineTopic = "blocks.quarantine"
+	o, err := newOutputs("blocks", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+// A fan-out record over the producer's limit would fail, be rewound and be sent again forever: it
+// goes to the quarantine topic as a headers-only note, the rest of the block is produced as usual.
+func TestOutputsOversizedFanout(t *testing.T) {
+	o := testOutputs(t, ProducerOptions{TxTopic: "txs", HeaderTopic: "headers"})
+	huge := "0x" + strings.Repeat("a", maxRecordBytes)
+	b := fanoutBlock(9, "0xa", huge, "0xc")
+
+	msgs, err := o.messages(routedBlock{Block: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var topics []string
+	for _, m := range msgs {
+		topics = append(topics, m.Topic)
+		if n := recordBytes(m); n > maxRecordBytes {
+			t.Errorf("%s record of %d bytes enqueued", m.Topic, n)
+		}
+	}
+	// the block itself is chunked, so all of it still reaches the main topic
+	want := []string{"blocks", "blocks", "headers", "txs", "blocks.quarantine", "txs"}
+	if strings.Join(topics, ",") != strings.Join(want, ",") {
+		t.Fatalf("topics %v want %v", topics, want)
+	}
+
+	q := msgs[4]
+	if q.Value != nil || header(q, "reason") != ReasonOversized || header(q, "source_topic") != "txs" {
+		t.Fatalf("quarantine note: value=%v reason=%q source=%q", q.Value, header(q, "reason"), header(q, "source_topic"))
+	}
+	if d := header(q, "detail"); !strings.Contains(d, "max=1000000") || len(d) > maxDetailBytes+3 {
+		t.Fatalf("detail %d bytes: %.80s", len(d), d)
+	}
+	if key, _ := q.Key.Encode(); string(key) != "9" {
+		t.Fatalf("quarantine key %q want the height", key)
+	}
+
+	// a file sink has no limit: the record is written as is
+	f, err := newOutputsMax("blocks", ProducerOptions{QuarantineTopic: "q", TxTopic: "txs", ChunkBytes: 1 << 30}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err = f.messages(routedBlock{Block: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 4 || msgs[2].Topic != "txs" || msgs[2].Value.Length() <= maxRecordBytes {
+		t.Fatalf("file sink: %d records", len(msgs))
+	}
+}
+
+// Quarantined blocks are chunked like any other, and their detail header is capped.
+func TestOutputsQuarantineFits(t *testing.T) {
+	o := testOutputs(t, ProducerOptions{ChunkBytes: 64 << 10})
+	b := fanoutBlock(3, "0x"+strings.Repeat("a", 200<<10))
+	msgs, err := o.messages(routedBlock{Block: b, Reason: ReasonTxRoot, Detail: strings.Repeat("x", 10<<10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) < 2 {
+		t.Fatalf("%d records: want the block chunked", len(msgs))
+	}
+	for i, m := range msgs {
+		if m.Topic != "blocks.quarantine" || header(m, "reason") != ReasonTxRoot || len(header(m, "detail")) > maxDetailBytes+3 {
+			t.Fatalf("record %d: topic=%s reason=%s detail=%d bytes", i, m.Topic, header(m, "reason"), len(header(m, "detail")))
+		}
+		if meta, ok, err := chunk.Parse(headerPtrs(m)); err != nil || !ok || meta.Index != i || meta.Count != len(msgs) {
+			t.Fatalf("record %d: chunk %+v ok=%v err=%v", i, meta, ok, err)
+		}
+		if n := recordBytes(m); n > maxRecordBytes {
+			t.Fatalf("record %d: %d bytes", i, n)
+		}
+	}
+
+	if _, err := newOutputs("blocks", ProducerOptions{QuarantineTopic: "q", ChunkBytes: maxRecordBytes}); err == nil {
+		t.Fatal("chunk size at the record limit accepted: its chunks could never be sent")
+	}
+}
+
+// Which records a block becomes, per output mode: topic, key and schema of each, in order.
+func TestOutputsFanoutMapping(t *testing.T) {
+	type rec struct{ topic, key, schema string }
+	b := fanoutBlock(4, "0xa1", "0xa2")
+	b.Txs[1].TxBody.To = "0xb2"
+
+	cases := []struct {
+		name string
+		opts ProducerOptions
+		rb   routedBlock
+		want []rec
+	}{
+		{"blocks only", ProducerOptions{}, routedBlock{Block: b}, []rec{
+			{"blocks", "4", schema.Block},
+		}},
+		{"tx by sender", ProducerOptions{TxTopic: "txs"}, routedBlock{Block: b}, []rec{
+			{"blocks", "4", schema.Block}, {"txs", "0xa1", schema.Tx}, {"txs", "0xa2", schema.Tx},
+		}},
+		{"tx by receiver", ProducerOptions{TxTopic: "txs", TxKeyBy: "to"}, routedBlock{Block: b}, []rec{
+			{"blocks", "4", schema.Block}, {"txs", "0xb", schema.Tx}, {"txs", "0xb2", schema.Tx},
+		}},
+		{"headers and txs", ProducerOptions{TxTopic: "txs", HeaderTopic: "headers"}, routedBlock{Block: b}, []rec{
+			{"blocks", "4", schema.Block}, {"headers", "4", schema.BlockHeader}, {"txs", "0xa1", schema.Tx}, {"txs", "0xa2", schema.Tx},
+		}},
+		{"empty block", ProducerOptions{TxTopic: "txs", HeaderTopic: "headers"}, routedBlock{Block: fanoutBlock(5)}, []rec{
+			{"blocks", "5", schema.Block}, {"headers", "5", schema.BlockHeader},
+		}},
+		{"quarantined: no fan-out", ProducerOptions{TxTopic: "txs", HeaderTopic: "headers"}, routedBlock{Block: b, Reason: ReasonTxRoot}, []rec{
+			{"blocks.quarantine", "4", schema.Block},
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.opts.ProducerID = "fetcher/blocks"
+			msgs, err := testOutputs(t, c.opts).messages(c.rb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []rec
+			for _, m := range msgs {
+				key, _ := m.Key.Encode()
+				meta, _ := schema.Parse(headerPtrs(m))
+				got = append(got, rec{m.Topic, string(key), meta.Name})
+				if meta.ProducerID != "fetcher/blocks" || meta.ChainID != "mock" || m.Timestamp.Unix() != c.rb.Block.Header.Timestamp {
+					t.Fatalf("%s record: %+v ts=%v", m.Topic, meta, m.Timestamp)
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(c.want) {
+				t.Fatalf("records %v\nwant    %v", got, c.want)
+			}
+		})
+	}
+
+	// record contents
+	msgs, err := testOutputs(t, ProducerOptions{TxTopic: "txs", HeaderTopic: "headers"}).messages(routedBlock{Block: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hm HeaderMessage
+	if err := json.Unmarshal(msgs[1].Value.(sarama.ByteEncoder), &hm); err != nil || hm.Hash != b.Hash || hm.TxCount != 2 || hm.Header != b.Header {
+		t.Fatalf("header record %+v err=%v", hm, err)
+	}
+	for i, m := range msgs[2:] {
+		var tm TxMessage
+		if err := json.Unmarshal(m.Value.(sarama.ByteEncoder), &tm); err != nil {
+			t.Fatal(err)
+		}
+		if tm.BlockNum != 4 || tm.BlockHash != b.Hash || tm.BlockTs != b.Header.Timestamp || tm.Index != i || tm.Tx != b.Txs[i] {
+			t.Fatalf("tx record %d: %+v", i, tm)
+		}
+	}
+
+	for _, opts := range []ProducerOptions{{TxKeyBy: "value"}, {Codec: "avro"}} {
+		if _, err := newOutputs("blocks", opts); err == nil {
+			t.Fatalf("%+v accepted", opts)
+		}
+	}
+}
+
+// A block payload up to chunkBytes is one record; one byte more and it is chunked. The chunks
+// reassemble to the exact payload, which decodes to the block.
+func TestOutputsChunkBoundaries(t *testing.T) {
+	b := fanoutBlock(6, "0xa", "0xb", "0xc", "0xd")
+	for _, codec := range []string{"json", "binary"} {
+		o := testOutputs(t, ProducerOptions{Codec: codec})
+		whole, err := model.EncodeBlockAs(o.contentType, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := len(whole)
+
+		for _, c := range []struct {
+			name       string
+			chunkBytes int
+			records    int
+		}{
+			{"exact limit", n, 1},
+			{"limit+1", n - 1, 2},
+			{"two halves", (n + 1) / 2, 2},
+			{"multi-chunk", (n + 2) / 3, 3},
+			{"one byte each", 1, n},
+		} {
+			t.Run(codec+"/"+c.name, func(t *testing.T) {
+				o.chunkBytes = c.chunkBytes
+				msgs, err := o.messages(routedBlock{Block: b})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(msgs) != c.records {
+					t.Fatalf("payload %d bytes, chunks of %d: %d records want %d", n, c.chunkBytes, len(msgs), c.records)
+				}
+				var (
+					a   chunk.Assembler
+					got []byte
+				)
+				for i, m := range msgs {
+					key, _ := m.Key.Encode()
+					if string(key) != "6" || m.Value.Length() > c.chunkBytes {
+						t.Fatalf("record %d: key=%s %d bytes", i, key, m.Value.Length())
+					}
+					meta, ok, err := chunk.Parse(headerPtrs(m))
+					if err != nil || ok != (c.records > 1) {
+						t.Fatalf("record %d: chunked=%v err=%v", i, ok, err)
+					}
+					if !ok {
+						got = m.Value.(sarama.ByteEncoder)
+						continue
+					}
+					if meta.Block != 6 || meta.Index != i || meta.Hash != b.Hash.Hex() {
+						t.Fatalf("record %d: %+v", i, meta)
+					}
+					p, done, err := a.Add(meta, m.Value.(sarama.ByteEncoder))
+					if err != nil || done != (i == len(msgs)-1) {
+						t.Fatalf("record %d: done=%v err=%v", i, done, err)
+					}
+					got = p
+				}
+				if !bytes.Equal(got, whole) {
+					t.Fatalf("reassembled %d bytes, want %d", len(got), n)
+				}
+				if dec, err := model.DecodeBlockAs(o.contentType, got); err != nil || dec.Hash != b.Hash {
+					t.Fatalf("decode: %v", err)
+				}
+			})
+		}
+	}
+}
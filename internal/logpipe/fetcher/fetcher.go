@@ -5,35 +5,96 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"strings"
 	"time"
 
-	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/retry"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/blockfile"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/ckptstore"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
 )
 
 type Config struct {
+	// RPCBaseURL is one or more comma-separated mockchain RPC endpoints (redundant nodes of the same chain).
 	RPCBaseURL string
+	RPCPool    PoolOptions
 
 	Brokers string // comma-separated
 	Topic   string
 
 	BackfillSec int64 // -1 disables
 
-	PageSize int
+	// DualCursor: on cold start tail the head at once and backfill the BackfillSec window
+	// concurrently into BackfillTopic (default Topic) with its own checkpoints; see dual.go.
+	DualCursor    bool
+	BackfillTopic string
+
+	// Bounded one-shot range (see Bounded): heights win over timestamps (unix seconds, inclusive).
+	FromHeight, ToHeight int64
+	FromTs, ToTs         int64
+
+	// PageSize is the initial blocks per BlocksRange call. With PageTarget > 0 it then adapts within
+	// [PageMin, PageMax] so a call takes about PageTarget and its body stays under PageMaxBytes.
+	// PageTarget <=0 keeps PageSize fixed. PageMin <=0 means 10, PageMax <=0 means max(2000, PageSize).
+	PageSize     int
+	PageMin      int
+	PageMax      int
+	PageTarget   time.Duration
+	PageMaxBytes int64 // <=0: no cap
+
+	// Parallel is the number of BlocksRange pages kept in flight (reorder buffer size, in pages);
+	// memory is bounded by Parallel*PageSize (PageMax when adaptive) blocks. <=0 means 4.
+	Parallel int
 
 	PollHeadEvery time.Duration
 	IdleSleep     time.Duration
 
+	// CheckpointPath is a file path, or a postgres:// / sqlite: DSN for a database store
+	// (row keyed by PipelineID, compare-and-swap writes).
 	CheckpointPath string
+	PipelineID     string // default: "fetcher/" + Topic
+
+	// CheckpointEvery throttles checkpoint writes; the checkpoint is the producer's contiguous acked
+	// watermark, never a height that is merely enqueued. <=0 means 1s.
+	CheckpointEvery time.Duration
+
+	// Sink: "kafka" (default), "stdout", or "file:<dir>" for rolling NDJSON files (package blockfile,
+	// FileSink sets rolling/gzip). Non-Kafka sinks need no brokers: topics only label the records.
+	Sink     string
+	FileSink blockfile.Options
+
+	Producer ProducerOptions
+
+	// ExactlyOnce switches to a transactional producer: every run of blocks and its (height, hash)
+	// checkpoint are committed in one Kafka transaction, and the checkpoint lives in the compacted
+	// CheckpointTopic instead of CheckpointPath. Consumers must read with read_committed.
+	ExactlyOnce     bool
+	CheckpointTopic string // default: Topic + ".ckpt"
+	TxnID           string // default: "logpipe-fetcher-" + Topic; keep stable across restarts
+
+	// Every block is validated (hashes, tx root, parent/timestamp continuity) before produce.
+	// A block failing QuarantineAfter fetches in a row goes to Producer.QuarantineTopic
+	// (default Topic + ".quarantine") instead of Topic. <=0 means 2.
+	QuarantineAfter int
 }
 
 type Fetcher struct {
 	cfg Config
 
-	rpc   *RPCClient
-	prod  *Producer
+	rpc   *RPCPool
+	prod  BlockSink    // at-least-once mode
+	txn   *TxnProducer // exactly-once mode
 	ckpt  Checkpoint
+	tail  *TopicCheckpoint // fallback when ckpt is missing or invalid
 	close func() error
+
+	savedHeight int64
+	savedAt     time.Time
+
+	link    blockLink    // parent of the next block to validate
+	suspect suspectBlock // block being refetched after a failed validation
+
+	backfillDone chan struct{} // dual-cursor: closed when the backfill cursor has stopped
 }
 
 func New(cfg Config) (*Fetcher, error) {
@@ -46,57 +107,201 @@ func New(cfg Config) (*Fetcher, error) {
 	if cfg.PageSize <= 0 {
 		cfg.PageSize = 200
 	}
+	if cfg.PageMin <= 0 {
+		cfg.PageMin = 10
+	}
+	if cfg.PageMax <= 0 {
+		cfg.PageMax = max(2000, cfg.PageSize)
+	}
+	if cfg.Parallel <= 0 {
+		cfg.Parallel = 4
+	}
 	if cfg.PollHeadEvery <= 0 {
 		cfg.PollHeadEvery = 2 * time.Second
 	}
 	if cfg.IdleSleep <= 0 {
 		cfg.IdleSleep = 300 * time.Millisecond
 	}
+	if cfg.CheckpointEvery <= 0 {
+		cfg.CheckpointEvery = time.Second
+	}
 	if cfg.CheckpointPath == "" {
 		cfg.CheckpointPath = "./data/fetcher.ckpt"
 	}
 
-	rpc := NewRPCClient(cfg.RPCBaseURL)
+	if cfg.Sink == "" {
+		cfg.Sink = SinkKafka
+	}
+	if cfg.ExactlyOnce && cfg.Sink != SinkKafka {
+		return nil, fmt.Errorf("exactly-once needs the kafka sink, not %q", cfg.Sink)
+	}
+
+	if cfg.QuarantineAfter <= 0 {
+		cfg.QuarantineAfter = 2
+	}
+	if cfg.Producer.QuarantineTopic == "" {
+		cfg.Producer.QuarantineTopic = cfg.Topic + ".quarantine"
+	}
+	if cfg.BackfillTopic == "" {
+		cfg.BackfillTopic = cfg.Topic
+	}
+	if cfg.PipelineID == "" {
+		cfg.PipelineID = "fetcher/" + cfg.Topic
+	}
+	if cfg.Producer.ProducerID == "" {
+		cfg.Producer.ProducerID = cfg.PipelineID
+	}
+	if cfg.CheckpointTopic == "" {
+		cfg.CheckpointTopic = cfg.Topic + ".ckpt"
+	}
+	if cfg.TxnID == "" {
+		cfg.TxnID = "logpipe-fetcher-" + cfg.Topic
+	}
 
-	ckpt, err := NewFileCheckpoint(cfg.CheckpointPath)
+	if cfg.Bounded() && cfg.ExactlyOnce {
+		return nil, errors.New("bounded range mode does not support exactly-once (its checkpoint is the live one)")
+	}
+
+	rpc, err := NewRPCPool(splitCSV(cfg.RPCBaseURL), cfg.RPCPool)
+	if err != nil {
+		return nil, err
+	}
+	f, err := newFetcher(cfg, rpc)
 	if err != nil {
+		_ = rpc.Close()
 		return nil, err
 	}
+	closeRest := f.close
+	f.close = func() error {
+		return errors.Join(closeRest(), rpc.Close())
+	}
+	return f, nil
+}
 
-	prod, err := NewProducer(cfg.Brokers, cfg.Topic)
+// newBounded is a bounded-range fetcher on rpc that checkpoints into ckpt. Close flushes the sink
+// and saves the last checkpoint; rpc and ckpt stay open (the dual-cursor backfill shares them).
+func newBounded(cfg Config, rpc *RPCPool, ckpt Checkpoint) (*Fetcher, error) {
+	prod, err := newSink(cfg, cfg.Topic)
 	if err != nil {
 		return nil, err
 	}
+	f := &Fetcher{cfg: cfg, rpc: rpc, prod: prod, ckpt: ckpt}
+	f.close = func() error {
+		err := prod.Close()
+		_ = f.saveCheckpoint(true)
+		return err
+	}
+	return f, nil
+}
+
+// newFetcher opens the sink and checkpoint of cfg (defaults already applied) around rpc. On error
+// whatever it opened is closed again; rpc is the caller's.
+func newFetcher(cfg Config, rpc *RPCPool) (*Fetcher, error) {
+	if cfg.Bounded() {
+		// no resume point to look for: the range is the job
+		return newBounded(cfg, rpc, nopCheckpoint{})
+	}
+
+	// live: the blocks topic is the resume point of last resort. It holds no connection (each
+	// Load opens and closes its own client), so there is nothing to close on the way out
+	var tail *TopicCheckpoint
+	if cfg.Sink == SinkKafka {
+		var err error
+		if tail, err = NewTopicCheckpoint(cfg.Brokers, cfg.Topic); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.ExactlyOnce {
+		// checkpoint key = blocks topic: several fetchers may share one checkpoint topic
+		kc, err := NewKafkaCheckpoint(cfg.Brokers, cfg.CheckpointTopic, cfg.Topic)
+		if err != nil {
+			return nil, err
+		}
+		txn, err := NewTxnProducer(cfg.Brokers, cfg.Topic, cfg.TxnID, kc, cfg.Producer)
+		if err != nil {
+			return nil, err
+		}
+		return &Fetcher{cfg: cfg, rpc: rpc, txn: txn, ckpt: kc, tail: tail, close: txn.Close}, nil
+	}
+
+	ckpt, closeCkpt, err := openCheckpoint(cfg.CheckpointPath, cfg.PipelineID)
+	if err != nil {
+		return nil, err
+	}
+
+	prod, err := newSink(cfg, cfg.Topic)
+	if err != nil {
+		_ = closeCkpt()
+		return nil, err
+	}
 
 	f := &Fetcher{
 		cfg:  cfg,
 		rpc:  rpc,
 		prod: prod,
 		ckpt: ckpt,
+		tail: tail,
 	}
 	f.close = func() error {
-		_ = prod.Close()
-		return nil
+		// flush + wait for acks, then persist the final watermark
+		err := prod.Close()
+		_ = f.saveCheckpoint(true)
+		return errors.Join(err, closeCkpt())
 	}
 	return f, nil
 }
 
-func (f *Fetcher) Close() error { return f.close() }
+func (f *Fetcher) Close() error {
+	if f.backfillDone != nil {
+		<-f.backfillDone // the backfill cursor flushes and saves its progress on the way out
+	}
+	return f.close()
+}
 
 func (f *Fetcher) Run(ctx context.Context) error {
-	// 1) decide start height
-	start, err := f.decideStartHeight(ctx)
+	// 1) decide start height (and, for a bounded job, where to stop)
+	var (
+		start int64
+		stop  int64 = math.MaxInt64
+		err   error
+	)
+	if f.cfg.Bounded() {
+		start, stop, err = f.resolveRange(ctx)
+	} else {
+		start, err = f.decideStartHeight(ctx)
+	}
 	if err != nil {
 		return err
 	}
 	next := start
 
-	// 2) main loop
+	if f.cfg.DualCursor && !f.cfg.Bounded() {
+		f.backfillDone = make(chan struct{})
+		go func() {
+			defer close(f.backfillDone)
+			if err := f.runBackfill(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("[fetcher][backfill] stopped: %v (resumes on restart)", err)
+			}
+		}()
+	}
+
+	// 2) main loop: up to Parallel pages in flight, produced strictly in height order
 	var headNum int64 = 0
 	nextHeadPoll := time.Now()
 
-	log.Printf("[fetcher] start: next_height=%d topic=%s rpc=%s brokers=%s",
-		next, f.cfg.Topic, f.cfg.RPCBaseURL, f.cfg.Brokers)
+	sizer := newPageSizer(int64(f.cfg.PageSize), int64(f.cfg.PageSize), int64(f.cfg.PageSize), 0, 0)
+	if f.cfg.PageTarget > 0 {
+		sizer = newPageSizer(int64(f.cfg.PageSize), int64(f.cfg.PageMin), int64(f.cfg.PageMax), f.cfg.PageTarget, f.cfg.PageMaxBytes)
+	}
+	pl := newRangePipeline(f.rpc, sizer, f.cfg.Parallel)
+	defer pl.reset()
+	errWait := backoff{base: 200 * time.Millisecond, max: 30 * time.Second}
+
+	st := fetchStats{since: time.Now()}
+
+	log.Printf("[fetcher] start: next_height=%d topic=%s sink=%s rpc=%s brokers=%s page=%d page_target=%s rps=%g parallel=%d exactly_once=%v",
+		next, f.cfg.Topic, f.cfg.Sink, f.cfg.RPCBaseURL, f.cfg.Brokers, f.cfg.PageSize, f.cfg.PageTarget, f.cfg.RPCPool.RPS, f.cfg.Parallel, f.cfg.ExactlyOnce)
 
 	for {
 		select {
@@ -127,75 +332,162 @@ func (f *Fetcher) Run(ctx context.Context) error {
 			headNum = h.HeadNum
 		}
 
-		if next > headNum {
-			time.Sleep(f.cfg.IdleSleep)
-			continue
+		// async produce failure: everything above the watermark is re-produced
+		if f.prod != nil {
+			if perr := f.prod.Err(); perr != nil {
+				pl.reset()
+				if next, err = f.rewind(ctx, start, perr); err != nil {
+					return err
+				}
+				time.Sleep(300 * time.Millisecond)
+				continue
+			}
+		}
+		if err := f.saveCheckpoint(false); err != nil {
+			return err
 		}
 
-		to := next + int64(f.cfg.PageSize) - 1
-		if to > headNum {
-			to = headNum
+		wm, _ := f.durable()
+		st.maybeLog(next, headNum, wm.LastHeight, pl.inflight())
+
+		if next > stop {
+			// bounded job: everything enqueued; done once it is all acked without error
+			if f.prod != nil {
+				if err := f.prod.Drain(ctx); err != nil {
+					return err
+				}
+				if f.prod.Err() != nil {
+					continue // rewind at the top of the loop
+				}
+			}
+			st.summary(start, stop)
+			return nil
 		}
 
-		rangeResp, err := f.rpc.BlocksRange(ctx, next, to)
-		if err != nil {
-			log.Printf("[fetcher] range err: from=%d to=%d err=%v", next, to, err)
-			time.Sleep(500 * time.Millisecond)
+		pl.fill(ctx, next, min(headNum, stop))
+		pg := pl.next(ctx)
+		if pg == nil {
+			// caught up (next > headNum) or ctx done
+			time.Sleep(f.cfg.IdleSleep)
 			continue
 		}
 
+		if pg.err != nil {
+			wait := errWait.next()
+			var rl *RateLimitError
+			if errors.As(pg.err, &rl) {
+				wait = max(wait, rl.RetryAfter)
+			}
+			log.Printf("[fetcher] range err: from=%d to=%d retry_in=%s err=%v", pg.from, pg.to, wait, pg.err)
+			pl.reset()
+			if err := sleepCtx(ctx, wait); err != nil {
+				return err
+			}
+			continue
+		}
+		errWait.reset()
+		rangeResp := pg.resp
+
 		blocks := rangeResp.Blocks
 		if len(blocks) == 0 {
 			// If server says partial but returns nothing, don't advance.
 			// Backoff and retry; also refresh head soon.
 			if rangeResp.Partial {
-				log.Printf("[fetcher] range partial but empty: from=%d to=%d last_ok=%d", next, to, rangeResp.LastOK)
+				log.Printf("[fetcher] range partial but empty: from=%d to=%d last_ok=%d", pg.from, pg.to, rangeResp.LastOK)
 			}
+			pl.reset()
 			time.Sleep(f.cfg.IdleSleep)
 			continue
 		}
 
-		// Produce blocks sequentially (keeps deterministic order)
-		producedAny := false
+		// Keep the contiguous run starting at next.
+		// If there is a gap in returned blocks, stop there and retry from 'next'.
+		// This avoids silently skipping missing heights.
+		run := make([]model.Block, 0, len(blocks))
+		expect := next
 		for _, b := range blocks {
-			if b.Header.Number < next {
+			if b.Header.Number < expect {
 				continue
 			}
-			// If there is a gap in returned blocks, stop and retry from 'next'.
-			// This avoids silently skipping missing heights.
-			if b.Header.Number > next {
+			if b.Header.Number > expect {
 				log.Printf("[fetcher] gap in server response: expected=%d got=%d (from=%d to=%d partial=%v last_ok=%d)",
-					next, b.Header.Number, rangeResp.From, rangeResp.To, rangeResp.Partial, rangeResp.LastOK)
+					expect, b.Header.Number, rangeResp.From, rangeResp.To, rangeResp.Partial, rangeResp.LastOK)
 				break
 			}
+			run = append(run, b)
+			expect++
+		}
 
-			if err := retry.Do(ctx, retry.Policy{
-				MaxAttempts: 5,
-				BaseDelay:   100 * time.Millisecond,
-				MaxDelay:    5 * time.Second,
-				Jitter:      100 * time.Millisecond,
-				OnRetry: func(attempt int, wait time.Duration, err error) {
-					log.Printf("[fetcher] produce retry: attempt=%d wait=%s err=%v", attempt, wait, err)
-				},
-				// Classify: 你后面可以按 sarama 错误类型细分；先 nil 也行（全都重试）
-			}, func(ctx context.Context) error {
-				return f.prod.ProduceBlock(ctx, b)
-			}); err != nil {
-				log.Printf("[fetcher] produce err: height=%d err=%v", b.Header.Number, err)
-				time.Sleep(300 * time.Millisecond)
+		// Validate before anything is produced; stop at a block that needs a refetch.
+		routed := make([]routedBlock, 0, len(run))
+		held := false
+		for _, b := range run {
+			rb, hold := f.route(ctx, b)
+			if hold {
+				held = true
 				break
 			}
+			if rb.Reason != "" {
+				st.quarantined++
+			}
+			routed = append(routed, rb)
+		}
+		run = run[:len(routed)]
+
+		// Enqueue the run in order; acks come back asynchronously and only move the watermark.
+		// Exactly-once: the whole run + its checkpoint is one transaction, all or nothing.
+		enq := 0
+		var perr error
+		if f.txn != nil {
+			if perr = f.txn.Commit(ctx, routed); perr == nil {
+				enq = len(routed)
+			}
+		} else {
+			for _, rb := range routed {
+				if perr = f.prod.Enqueue(ctx, rb); perr != nil {
+					break
+				}
+				enq++
+			}
+		}
 
-			// checkpoint after each successful produce
-			if err := f.ckpt.Save(Ckpt{
-				LastHeight: b.Header.Number,
-				LastHash:   b.Hash.Hex(),
-			}); err != nil {
-				log.Printf("[fetcher] checkpoint save err: %v", err)
+		producedAny := enq > 0
+		if producedAny {
+			next = run[enq-1].Header.Number + 1
+			st.produced += int64(enq)
+		}
+		if perr != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
+			pl.reset()
+			if f.txn != nil {
+				// aborted: nothing of the run is visible, next is unchanged
+				if f.txn.Fatal() {
+					return perr
+				}
+				log.Printf("[fetcher] txn aborted, retry from next=%d: err=%v", next, perr)
+			} else if next, err = f.rewind(ctx, start, perr); err != nil {
+				return err
+			}
+			time.Sleep(300 * time.Millisecond)
+			continue
+		}
+		if err := f.saveCheckpoint(false); err != nil {
+			return err
+		}
 
-			producedAny = true
-			next = b.Header.Number + 1
+		if next > pg.to {
+			continue // page fully consumed; later pages stay in flight
+		}
+
+		// Page not fully consumed: in-flight pages after it would leave a hole, drop them.
+		pl.reset()
+
+		if held {
+			// invalid block: refetch it (the pool may route to another endpoint), never skip it
+			time.Sleep(500 * time.Millisecond)
+			continue
 		}
 
 		// If server marked partial, we should be conservative:
@@ -205,7 +497,6 @@ func (f *Fetcher) Run(ctx context.Context) error {
 			// produced nothing (e.g., decode ok but gap/produce error happened before first block)
 			if !producedAny {
 				if rangeResp.LastOK >= next {
-					// NOTE: next here is still the original 'next' because we didn't advance.
 					// To be safe, only advance if last_ok is at/after expected next.
 					log.Printf("[fetcher] partial advance by last_ok: next=%d last_ok=%d", next, rangeResp.LastOK)
 					next = rangeResp.LastOK + 1
@@ -215,34 +506,105 @@ func (f *Fetcher) Run(ctx context.Context) error {
 				}
 			}
 			// if produced some blocks, next already advanced; just continue
+		} else if !producedAny {
+			time.Sleep(200 * time.Millisecond)
 		}
 	}
 }
 
+// fetchStats logs throughput every few seconds (backfill progress).
+type fetchStats struct {
+	since       time.Time
+	lastLog     time.Time
+	produced    int64
+	quarantined int64
+}
+
+func (s *fetchStats) maybeLog(next, headNum, acked int64, inflight int) {
+	if time.Since(s.lastLog) < 5*time.Second {
+		return
+	}
+	s.lastLog = time.Now()
+	el := time.Since(s.since).Seconds()
+	if el <= 0 || s.produced == 0 {
+		return
+	}
+	log.Printf("[fetcher] progress: next=%d acked=%d head=%d lag=%d produced=%d quarantined=%d rate=%.0f blk/s inflight_pages=%d",
+		next, acked, headNum, max(headNum-next+1, 0), s.produced, s.quarantined, float64(s.produced)/el, inflight)
+}
+
+func (s *fetchStats) summary(from, to int64) {
+	el := time.Since(s.since)
+	log.Printf("[fetcher] bounded range done: from=%d to=%d blocks=%d produced=%d quarantined=%d elapsed=%s rate=%.0f blk/s",
+		from, to, to-from+1, s.produced, s.quarantined, el.Round(time.Millisecond), float64(s.produced)/max(el.Seconds(), 1e-3))
+}
+
+// rewind handles an async produce failure: wait for every in-flight message to resolve, checkpoint
+// the contiguous acked watermark and restart right above it (fallback when nothing was acked yet).
+// Blocks acked above the failed height are produced again: at-least-once, never a hole.
+func (f *Fetcher) rewind(ctx context.Context, fallback int64, cause error) (int64, error) {
+	log.Printf("[fetcher] produce failed, draining in-flight messages: err=%v", cause)
+	if err := f.prod.Drain(ctx); err != nil {
+		return 0, err
+	}
+	if err := f.saveCheckpoint(true); err != nil {
+		return 0, err
+	}
+
+	next := fallback
+	if wm, ok := f.prod.Watermark(); ok {
+		next = wm.LastHeight + 1
+	}
+	f.prod.Rewind(next)
+	log.Printf("[fetcher] rewind: next=%d", next)
+	return next, nil
+}
+
+// durable returns the highest height known to be in Kafka: the acked watermark, or the last committed transaction.
+func (f *Fetcher) durable() (Ckpt, bool) {
+	if f.txn != nil {
+		return f.txn.Committed()
+	}
+	return f.prod.Watermark()
+}
+
+// saveCheckpoint persists the producer watermark when it moved, at most every CheckpointEvery unless forced.
+// No-op in exactly-once mode, where the checkpoint is committed with the blocks.
+// Only a CAS conflict is returned (another fetcher owns the pipeline id: stop); other errors are retried later.
+func (f *Fetcher) saveCheckpoint(force bool) error {
+	if f.prod == nil {
+		return nil
+	}
+	wm, ok := f.prod.Watermark()
+	if !ok || wm.LastHeight == f.savedHeight {
+		return nil
+	}
+	if !force && time.Since(f.savedAt) < f.cfg.CheckpointEvery {
+		return nil
+	}
+	if err := f.ckpt.Save(wm); err != nil {
+		if errors.Is(err, ckptstore.ErrConflict) {
+			return err
+		}
+		log.Printf("[fetcher] checkpoint save err: %v", err)
+		return nil
+	}
+	f.savedHeight, f.savedAt = wm.LastHeight, time.Now()
+	return nil
+}
+
 func (f *Fetcher) decideStartHeight(ctx context.Context) (int64, error) {
 	// A) checkpoint wins, but must be validated against canonical: (height, hash)
-	if ck, ok, err := f.ckpt.Load(); err != nil {
-		return 0, err
-	} else if ok && ck.LastHeight > 0 {
-		if ck.LastHash == "" {
-			// strict: checkpoint without hash is treated as invalid
-			log.Printf("[fetcher] checkpoint missing hash -> cold start: last=%d", ck.LastHeight)
-		} else {
-			blk, err := f.rpc.BlockByNumber(ctx, ck.LastHeight)
-			if err == nil {
-				gotHash := blk.Hash.Hex()
-				if equalHex(gotHash, ck.LastHash) {
-					next := ck.LastHeight + 1
-					log.Printf("[fetcher] resume from checkpoint: last=%d hash=%s next=%d", ck.LastHeight, ck.LastHash, next)
-					return next, nil
-				}
-				log.Printf("[fetcher] checkpoint hash mismatch -> cold start: last=%d ckpt_hash=%s got_hash=%s",
-					ck.LastHeight, ck.LastHash, gotHash)
-			} else {
-				// block not found / rpc error -> cold start
-				log.Printf("[fetcher] checkpoint height not found or rpc error -> cold start: last=%d hash=%s err=%v",
-					ck.LastHeight, ck.LastHash, err)
-			}
+	if next, ok, err := f.resumeFrom(ctx, "checkpoint", f.ckpt); err != nil || ok {
+		return next, err
+	}
+
+	// A') checkpoint lost: the blocks topic is the source of truth, resume from its tail
+	if f.tail != nil {
+		if next, ok, err := f.resumeFrom(ctx, "topic tail", f.tail); err != nil {
+			log.Printf("[fetcher] topic tail unavailable -> cold start: err=%v", err)
+		} else if ok {
+			return next, nil
 		}
 	}
 
@@ -255,6 +617,15 @@ func (f *Fetcher) decideStartHeight(ctx context.Context) (int64, error) {
 		return 1, fmt.Errorf("empty chain")
 	}
 
+	// dual cursor -> live starts at head, the window is backfilled by the second cursor
+	if f.cfg.DualCursor {
+		if err := f.planBackfill(ctx, head); err != nil {
+			return 0, fmt.Errorf("plan backfill: %w", err)
+		}
+		log.Printf("[fetcher] no checkpoint, dual cursor -> live from head=%d", head.HeadNum)
+		return head.HeadNum, nil
+	}
+
 	// backfill disabled -> start at head (only tailing new blocks)
 	if f.cfg.BackfillSec < 0 {
 		log.Printf("[fetcher] no checkpoint, backfill disabled -> start from head=%d", head.HeadNum)
@@ -278,6 +649,40 @@ func (f *Fetcher) decideStartHeight(ctx context.Context) (int64, error) {
 	return pos.BlockNum, nil
 }
 
+// resumeFrom loads ck and validates it against canonical; ok=false means cold start.
+func (f *Fetcher) resumeFrom(ctx context.Context, src string, ck Checkpoint) (int64, bool, error) {
+	c, ok, err := ck.Load()
+	if err != nil {
+		return 0, false, err
+	}
+	if !ok || c.LastHeight <= 0 {
+		return 0, false, nil
+	}
+	if c.LastHash == "" {
+		// strict: checkpoint without hash is treated as invalid
+		log.Printf("[fetcher] %s missing hash -> ignored: last=%d", src, c.LastHeight)
+		return 0, false, nil
+	}
+
+	blk, err := f.rpc.BlockByNumber(ctx, c.LastHeight)
+	if err != nil {
+		// block not found / rpc error -> not usable
+		log.Printf("[fetcher] %s height not found or rpc error -> ignored: last=%d hash=%s err=%v",
+			src, c.LastHeight, c.LastHash, err)
+		return 0, false, nil
+	}
+	gotHash := blk.Hash.Hex()
+	if !equalHex(gotHash, c.LastHash) {
+		log.Printf("[fetcher] %s hash mismatch -> ignored: last=%d ckpt_hash=%s got_hash=%s",
+			src, c.LastHeight, c.LastHash, gotHash)
+		return 0, false, nil
+	}
+
+	next := c.LastHeight + 1
+	log.Printf("[fetcher] resume from %s: last=%d hash=%s next=%d", src, c.LastHeight, c.LastHash, next)
+	return next, true, nil
+}
+
 func equalHex(a, b string) bool {
 	// tolerate "0x" prefix and case differences
 	a = strings.TrimSpace(a)
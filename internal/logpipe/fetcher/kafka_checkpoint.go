@@ -0,0 +1,200 @@
+package fetcher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// KafkaCheckpoint keeps Ckpt as the latest record for key in a single-partition compacted topic.
+// It is written by TxnProducer inside the same transaction as the blocks it covers, so the
+// checkpoint and the blocks topic can never disagree; Save is therefore not supported.
+type KafkaCheckpoint struct {
+	brokers []string
+	topic   string
+	key     string
+}
+
+type kafkaCkptValue struct {
+	LastHeight int64  `json:"last_height"`
+	LastHash   string `json:"last_hash"`
+	UpdatedAt  int64  `json:"updated_at"`
+}
+
+// NewKafkaCheckpoint creates the compacted checkpoint topic if it does not exist yet.
+func NewKafkaCheckpoint(brokersCSV string, topic string, key string) (*KafkaCheckpoint, error) {
+	if topic == "" || key == "" {
+		return nil, errors.New("kafka checkpoint: topic/key empty")
+	}
+	brokers := splitCSV(brokersCSV)
+	if len(brokers) == 0 {
+		return nil, errors.New("no brokers")
+	}
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_1_0_0
+	admin, err := sarama.NewClusterAdmin(brokers, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer admin.Close()
+
+	compact := "compact"
+	err = admin.CreateTopic(topic, &sarama.TopicDetail{
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+		ConfigEntries:     map[string]*string{"cleanup.policy": &compact},
+	}, false)
+	var terr *sarama.TopicError
+	if err != nil && !(errors.As(err, &terr) && terr.Err == sarama.ErrTopicAlreadyExists) {
+		return nil, fmt.Errorf("create checkpoint topic %s: %w", topic, err)
+	}
+
+	// records go to partition 0 through the producer's hash partitioner, which only holds with one partition
+	metas, err := admin.DescribeTopics([]string{topic})
+	if err != nil {
+		return nil, fmt.Errorf("describe checkpoint topic %s: %w", topic, err)
+	}
+	if len(metas) != 1 {
+		return nil, fmt.Errorf("describe checkpoint topic %s: %d results", topic, len(metas))
+	}
+	if !errors.Is(metas[0].Err, sarama.ErrNoError) {
+		return nil, fmt.Errorf("describe checkpoint topic %s: %w", topic, metas[0].Err)
+	}
+	if n := len(metas[0].Partitions); n != 1 {
+		return nil, fmt.Errorf("checkpoint topic %s has %d partitions, want 1", topic, n)
+	}
+
+	return &KafkaCheckpoint{brokers: brokers, topic: topic, key: key}, nil
+}
+
+// Load scans the checkpoint partition with read_committed isolation and returns the last record
+// for key. Records of aborted or still-open transactions are never seen.
+func (c *KafkaCheckpoint) Load() (Ckpt, bool, error) {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_1_0_0
+	cfg.Consumer.IsolationLevel = sarama.ReadCommitted
+	cfg.Consumer.Return.Errors = true
+
+	client, err := sarama.NewClient(c.brokers, cfg)
+	if err != nil {
+		return Ckpt{}, false, err
+	}
+	defer client.Close()
+
+	// high-water mark; the tail of an open transaction past the LSO never arrives, scanSettled stops before it
+	end, err := client.GetOffset(c.topic, 0, sarama.OffsetNewest)
+	if err != nil {
+		return Ckpt{}, false, err
+	}
+	if end <= 0 {
+		return Ckpt{}, false, nil
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return Ckpt{}, false, err
+	}
+	defer consumer.Close()
+
+	var (
+		out   Ckpt
+		found bool
+	)
+	err = scanSettled(c.topic, 0, func(idle time.Duration) (int64, bool, error) {
+		out, found = Ckpt{}, false
+		return scanPartition(consumer, c.topic, 0, sarama.OffsetOldest, end, idle, func(msg *sarama.ConsumerMessage) {
+			if string(msg.Key) != c.key {
+				return
+			}
+			var v kafkaCkptValue
+			if err := json.Unmarshal(msg.Value, &v); err != nil {
+				log.Printf("[ckpt][kafka] bad record offset=%d err=%v (skip)", msg.Offset, err)
+				return
+			}
+			out, found = Ckpt{LastHeight: v.LastHeight, LastHash: v.LastHash}, true
+		})
+	})
+	if err != nil {
+		return Ckpt{}, false, err
+	}
+	return out, found, nil
+}
+
+// scanPartition feeds every record of [from, end) to fn in offset order and returns the offset of
+// the last record it saw (from-1 if none) and whether that was end-1.
+// Consume with read_committed: transaction markers and aborted records are skipped silently, so
+// the last offsets before end may never arrive and the scan stops once the partition is quiet for
+// idle. That alone can't tell a skipped tail from a slow broker; see scanSettled.
+func scanPartition(consumer sarama.Consumer, topic string, partition int32, from, end int64, idle time.Duration, fn func(*sarama.ConsumerMessage)) (int64, bool, error) {
+	pc, err := consumer.ConsumePartition(topic, partition, from)
+	if err != nil {
+		return 0, false, err
+	}
+	defer pc.Close()
+
+	last := from - 1
+	t := time.NewTimer(idle)
+	defer t.Stop()
+	for {
+		select {
+		case msg := <-pc.Messages():
+			fn(msg)
+			last = msg.Offset
+			if msg.Offset >= end-1 {
+				return last, true, nil
+			}
+			t.Reset(idle)
+		case err := <-pc.Errors():
+			return last, false, err
+		case <-t.C:
+			return last, false, nil
+		}
+	}
+}
+
+// scanSettled repeats scan (which must start its result over each time) until it reaches end-1, or
+// until two passes in a row go quiet at the same offset: only then is the rest of the range known
+// to be markers / aborted records rather than records a slow broker hadn't delivered yet.
+// Every pass waits twice as long as the one before.
+func scanSettled(topic string, partition int32, scan func(idle time.Duration) (last int64, complete bool, err error)) error {
+	prev := int64(-2)
+	idle := scanIdle
+	for pass := 1; ; pass++ {
+		last, complete, err := scan(idle)
+		if err != nil || complete || last == prev {
+			return err
+		}
+		if pass == scanPasses {
+			return fmt.Errorf("scan %s/%d: still advancing after %d passes (last offset %d)", topic, partition, pass, last)
+		}
+		log.Printf("[ckpt] scan %s/%d went quiet at offset %d (previous pass %d), rescanning with idle=%s", topic, partition, last, prev, 2*idle)
+		prev, idle = last, 2*idle
+	}
+}
+
+const (
+	scanIdle   = 3 * time.Second
+	scanPasses = 4
+)
+
+func (c *KafkaCheckpoint) Save(Ckpt) error {
+	return errors.New("kafka checkpoint is only written inside a produce transaction")
+}
+
+// message builds the checkpoint record that TxnProducer adds to each transaction.
+func (c *KafkaCheckpoint) message(ck Ckpt) (*sarama.ProducerMessage, error) {
+	v, err := json.Marshal(kafkaCkptValue{LastHeight: ck.LastHeight, LastHash: ck.LastHash, UpdatedAt: time.Now().Unix()})
+	if err != nil {
+		return nil, err
+	}
+	return &sarama.ProducerMessage{
+		Topic: c.topic,
+		Key:   sarama.StringEncoder(c.key),
+		Value: sarama.ByteEncoder(v),
+	}, nil
+}
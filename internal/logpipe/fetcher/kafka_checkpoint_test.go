@@ -0,0 +1,50 @@
+package fetcher
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestScanSettled(t *testing.T) {
+	cases := []struct {
+		name   string
+		passes []int64 // last offset of each pass; -1 ends the scan complete
+		want   int     // passes run
+		err    bool
+	}{
+		{"reaches end", []int64{-1}, 1, false},
+		{"quiet at the same offset twice", []int64{7, 7}, 2, false},
+		{"slow broker: keep going while it advances", []int64{3, 7, 7}, 3, false},
+		{"complete after a short pass", []int64{3, -1}, 2, false},
+		{"never settles", []int64{1, 2, 3, 4}, scanPasses, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				n     int
+				idles []time.Duration
+			)
+			err := scanSettled("ckpt", 0, func(idle time.Duration) (int64, bool, error) {
+				last := c.passes[n]
+				n++
+				idles = append(idles, idle)
+				return last, last == -1, nil
+			})
+			if (err != nil) != c.err || n != c.want {
+				t.Fatalf("passes=%d err=%v, want passes=%d err=%v", n, err, c.want, c.err)
+			}
+			for i := 1; i < len(idles); i++ {
+				if idles[i] != 2*idles[i-1] {
+					t.Fatalf("idle did not double: %v", idles)
+				}
+			}
+		})
+	}
+
+	boom := errors.New("boom")
+	err := scanSettled("ckpt", 0, func(time.Duration) (int64, bool, error) { return 0, false, boom })
+	if !errors.Is(err, boom) {
+		t.Fatalf("err=%v", err)
+	}
+}
@@ -2,29 +2,94 @@ package fetcher
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/IBM/sarama"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/schema"
 	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
 )
 
+type ProducerOptions struct {
+	// Compression: none|gzip|snappy|lz4|zstd. Empty means lz4.
+	Compression string
+
+	// Batching: a batch is sent when it has BatchMessages messages or has waited Linger. <=0 means 500 / 20ms.
+	BatchMessages int
+	Linger        time.Duration
+
+	// MaxInflight bounds records enqueued but not yet acked (and so the ack tracker). <=0 means 10000.
+	MaxInflight int
+
+	// QuarantineTopic receives blocks that failed validation (headers: reason, detail). Required.
+	QuarantineTopic string
+
+	// Optional fan-out of every valid block (see fanout.go); empty topic disables.
+	TxTopic     string // one TxMessage per tx, keyed by address
+	TxKeyBy     string // from|to, default from
+	HeaderTopic string // one HeaderMessage per block, keyed by height
+
+	// Codec of block payloads: json|binary (model.EncodeBlockBinary). Empty means json. Every record
+	// carries a content-type header; consumers accept both formats.
+	Codec string
+
+	// ProducerID goes into the producer-id header of every record (package schema). The fetcher sets
+	// it to its PipelineID when empty.
+	ProducerID string
+
+	// ChunkBytes: a block whose encoding is larger is split into chunk records (package chunk). <=0 means 512KiB.
+	ChunkBytes int
+}
+
+// Producer is an async, batched Kafka producer. Blocks must be enqueued in height order;
+// acks may come back in any order, and Watermark only moves over a contiguous acked prefix,
+// so checkpointing the watermark keeps at-least-once.
 type Producer struct {
-	topic string
-	sp    sarama.SyncProducer
+	out outputs
+	ap  sarama.AsyncProducer
+
+	sem  chan struct{} // one slot per unresolved message
+	wm   *ackWatermark
+	done chan struct{}
+
+	errMu sync.Mutex
+	err   error // first async error since the last Rewind (sticky)
 }
 
-func NewProducer(brokersCSV string, topic string) (*Producer, error) {
-	if topic == "" {
-		return nil, errors.New("topic empty")
+type ackMeta struct {
+	height int64
+	hash   string
+}
+
+func NewProducer(brokersCSV string, topic string, opts ProducerOptions) (*Producer, error) {
+	if topic == "" || opts.QuarantineTopic == "" {
+		return nil, errors.New("topic/quarantine topic empty")
 	}
 	brokers := splitCSV(brokersCSV)
 	if len(brokers) == 0 {
 		return nil, errors.New("no brokers")
 	}
+	out, err := newOutputs(topic, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Compression == "" {
+		opts.Compression = "lz4"
+	}
+	if opts.BatchMessages <= 0 {
+		opts.BatchMessages = 500
+	}
+	if opts.Linger <= 0 {
+		opts.Linger = 20 * time.Millisecond
+	}
+	if opts.MaxInflight <= 0 {
+		opts.MaxInflight = 10000
+	}
 
 	cfg := sarama.NewConfig()
 
@@ -33,67 +98,233 @@ func NewProducer(brokersCSV string, topic string) (*Producer, error) {
 	cfg.Producer.Retry.Max = 10
 	cfg.Producer.Retry.Backoff = 200 * time.Millisecond
 
-	// SyncProducer must have Return.Successes=true
+	// ack tracking needs both channels
 	cfg.Producer.Return.Successes = true
 	cfg.Producer.Return.Errors = true
 
 	cfg.Producer.Idempotent = true
 	cfg.Net.MaxOpenRequests = 1
 
+	// Throughput: batch + compress
+	if err := cfg.Producer.Compression.UnmarshalText([]byte(opts.Compression)); err != nil {
+		return nil, err
+	}
+	cfg.Producer.Flush.Messages = opts.BatchMessages
+	cfg.Producer.Flush.Frequency = opts.Linger
+	cfg.Producer.Flush.Bytes = 1 << 20
+	cfg.ChannelBufferSize = opts.BatchMessages * 2
+
 	cfg.Version = sarama.V2_1_0_0
 
-	sp, err := sarama.NewSyncProducer(brokers, cfg)
+	ap, err := sarama.NewAsyncProducer(brokers, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Producer{
-		topic: topic,
-		sp:    sp,
-	}, nil
+	p := &Producer{
+		out:  out,
+		ap:   ap,
+		sem:  make(chan struct{}, opts.MaxInflight),
+		wm:   newAckWatermark(),
+		done: make(chan struct{}),
+	}
+	go p.drainResults()
+	return p, nil
 }
 
-func (p *Producer) Close() error {
-	if p.sp != nil {
-		return p.sp.Close()
+// drainResults resolves every message exactly once (success or error) until the producer is closed.
+func (p *Producer) drainResults() {
+	defer close(p.done)
+	succ, errs := p.ap.Successes(), p.ap.Errors()
+	for succ != nil || errs != nil {
+		select {
+		case m, ok := <-succ:
+			if !ok {
+				succ = nil
+				continue
+			}
+			meta := m.Metadata.(ackMeta)
+			p.wm.ack(meta.height, meta.hash)
+			<-p.sem
+		case pe, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			meta := pe.Msg.Metadata.(ackMeta)
+			// the failed height never acks, so the watermark stops right below it
+			p.setErr(fmt.Errorf("produce height=%d: %w", meta.height, pe.Err))
+			<-p.sem
+		}
 	}
-	return nil
 }
 
-// ProduceBlock sends the block to Kafka and waits for broker ACK (sync).
-// It is safe to checkpoint after this returns nil.
-func (p *Producer) ProduceBlock(ctx context.Context, b model.Block) error {
-	payload, err := json.Marshal(b)
+func (p *Producer) setErr(err error) {
+	p.errMu.Lock()
+	defer p.errMu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// Err returns the first async produce failure since the last Rewind.
+// After a failure the caller must Drain, checkpoint Watermark and Rewind to Watermark+1.
+func (p *Producer) Err() error {
+	p.errMu.Lock()
+	defer p.errMu.Unlock()
+	return p.err
+}
+
+// Enqueue hands the block's records to the async producer. It blocks while MaxInflight messages are
+// unresolved and returns early with the sticky async error, if any. A quarantined block acks like any
+// other: its height is accounted for, just not on the main topic.
+func (p *Producer) Enqueue(ctx context.Context, rb routedBlock) error {
+	if err := p.Err(); err != nil {
+		return err
+	}
+	b := rb.Block
+	msgs, err := p.out.messages(rb)
 	if err != nil {
 		return err
 	}
 
-	msg := &sarama.ProducerMessage{
-		Topic:     p.topic,
+	p.wm.enqueue(b.Header.Number, len(msgs))
+	for _, msg := range msgs {
+		msg.Metadata = ackMeta{height: b.Header.Number, hash: b.Hash.Hex()}
+		select {
+		case <-ctx.Done():
+			// block only partly handed over: it never completes, the caller rewinds
+			return ctx.Err()
+		case p.sem <- struct{}{}:
+		}
+		select {
+		case <-ctx.Done():
+			<-p.sem
+			return ctx.Err()
+		case p.ap.Input() <- msg:
+		}
+	}
+	return nil
+}
+
+// blockMessage is the blocks-topic record of b: key=height, value=encoded block, timestamp=block time.
+// Headers carry the schema (schema.Block v1, codec, producer, chain).
+func blockMessage(topic string, contentType string, producerID string, b model.Block) (*sarama.ProducerMessage, error) {
+	payload, err := model.EncodeBlockAs(contentType, b)
+	if err != nil {
+		return nil, err
+	}
+	return &sarama.ProducerMessage{
+		Topic:     topic,
 		Key:       sarama.StringEncoder(strconv.FormatInt(b.Header.Number, 10)),
 		Value:     sarama.ByteEncoder(payload),
 		Timestamp: time.Unix(b.Header.Timestamp, 0),
+		Headers: schema.Meta{
+			Name: schema.Block, Version: schema.BlockV1, Codec: contentType,
+			ProducerID: producerID, ChainID: b.Header.ChainID,
+		}.Headers(),
+	}, nil
+}
+
+// Watermark returns the highest height H such that every enqueued height <= H is acked.
+func (p *Producer) Watermark() (Ckpt, bool) { return p.wm.get() }
+
+// Drain waits until every enqueued message is acked or failed.
+func (p *Producer) Drain(ctx context.Context) error {
+	t := time.NewTicker(10 * time.Millisecond)
+	defer t.Stop()
+	for len(p.sem) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil
+}
+
+// Rewind clears the failure state after Drain; the next Enqueue must be height next.
+func (p *Producer) Rewind(next int64) {
+	p.errMu.Lock()
+	p.err = nil
+	p.errMu.Unlock()
+	p.wm.reset(next)
+}
+
+// Close flushes buffered messages, waits for their acks and stops the result loop.
+func (p *Producer) Close() error {
+	if p.ap == nil {
+		return nil
 	}
+	err := p.ap.Close()
+	<-p.done
+	return err
+}
 
-	// sarama SyncProducer doesn't accept context directly; we can only check ctx before/after.
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
+// ackWatermark tracks acks for heights enqueued in order; a height may span several records.
+// base is the lowest height not acked yet; acks above base wait in `acked` until the hole closes.
+type ackWatermark struct {
+	mu      sync.Mutex
+	base    int64
+	inited  bool
+	pending map[int64]int // records of a height still unacked
+	acked   map[int64]string
+
+	last    Ckpt
+	hasLast bool
+}
+
+func newAckWatermark() *ackWatermark {
+	return &ackWatermark{pending: make(map[int64]int), acked: make(map[int64]string)}
+}
+
+func (w *ackWatermark) enqueue(h int64, records int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.inited {
+		w.base, w.inited = h, true
 	}
+	w.pending[h] = records
+}
 
-	_, _, err = p.sp.SendMessage(msg)
-	if err != nil {
-		return err
+func (w *ackWatermark) ack(h int64, hash string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if h < w.base {
+		return // duplicate or pre-rewind ack
+	}
+	if n, ok := w.pending[h]; ok && n > 1 {
+		w.pending[h] = n - 1
+		return
+	}
+	delete(w.pending, h)
+	w.acked[h] = hash
+	for {
+		hh, ok := w.acked[w.base]
+		if !ok {
+			return
+		}
+		delete(w.acked, w.base)
+		w.last, w.hasLast = Ckpt{LastHeight: w.base, LastHash: hh}, true
+		w.base++
 	}
+}
 
-	select {
-	case <-ctx.Done():
-		// message is already acked, but caller wants to stop; return ctx error to exit quickly
-		return ctx.Err()
-	default:
+func (w *ackWatermark) reset(next int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if n := len(w.acked); n > 0 {
+		log.Printf("[producer] rewind to %d drops %d acks above the hole (will be re-produced)", next, n)
 	}
-	return nil
+	w.base, w.inited = next, true
+	clear(w.acked)
+	clear(w.pending)
+}
+
+func (w *ackWatermark) get() (Ckpt, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.last, w.hasLast
 }
 
 func splitCSV(s string) []string {
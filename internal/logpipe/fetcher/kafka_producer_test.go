@@ -0,0 +1,64 @@
+package fetcher
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAckWatermark(t *testing.T) {
+	type op struct {
+		kind string // "enq" (h, n records), "ack" (h), "reset" (h = next)
+		h    int64
+		n    int
+	}
+	enq := func(h int64, n int) op { return op{"enq", h, n} }
+	ack := func(h int64) op { return op{"ack", h, 0} }
+	reset := func(next int64) op { return op{"reset", next, 0} }
+
+	cases := []struct {
+		name string
+		ops  []op
+		want int64 // 0: no watermark yet
+	}{
+		{"in order", []op{enq(10, 1), enq(11, 1), ack(10), ack(11)}, 11},
+		{"nothing acked", []op{enq(10, 1), enq(11, 1)}, 0},
+		{"out of order waits for the hole", []op{enq(10, 1), enq(11, 1), enq(12, 1), ack(12), ack(11)}, 0},
+		{"hole closes", []op{enq(10, 1), enq(11, 1), enq(12, 1), ack(12), ack(11), ack(10)}, 12},
+		{"partial out of order", []op{enq(10, 1), enq(11, 1), enq(12, 1), ack(10), ack(12)}, 10},
+		{"fan-out height needs every record", []op{enq(10, 3), ack(10), ack(10)}, 0},
+		{"fan-out height complete", []op{enq(10, 3), ack(10), ack(10), ack(10)}, 10},
+		{"chunked height behind a later ack", []op{enq(10, 2), enq(11, 1), ack(11), ack(10), ack(10)}, 11},
+		{"duplicate ack below base ignored", []op{enq(10, 1), enq(11, 2), ack(10), ack(10), ack(11)}, 10},
+		// 11 failed: 12 was acked but sits above the hole, so rewind drops it and 11.. are re-produced
+		{"rewind after error", []op{enq(10, 1), enq(11, 1), enq(12, 1), ack(10), ack(12), reset(11), enq(11, 1), enq(12, 1), ack(11)}, 11},
+		{"rewind then complete", []op{enq(10, 1), enq(11, 1), enq(12, 1), ack(10), ack(12), reset(11), enq(11, 1), enq(12, 1), ack(12), ack(11)}, 12},
+		{"pre-rewind ack below next ignored", []op{enq(10, 1), enq(11, 1), ack(10), reset(11), ack(10)}, 10},
+		{"rewind drops partial fan-out", []op{enq(10, 2), ack(10), reset(10), enq(10, 2), ack(10)}, 0},
+		{"first enqueue sets the base", []op{enq(500, 1), ack(500)}, 500},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := newAckWatermark()
+			for _, o := range c.ops {
+				switch o.kind {
+				case "enq":
+					w.enqueue(o.h, o.n)
+				case "ack":
+					w.ack(o.h, fmt.Sprintf("h%d", o.h))
+				case "reset":
+					w.reset(o.h)
+				}
+			}
+			got, ok := w.get()
+			if c.want == 0 {
+				if ok {
+					t.Fatalf("watermark=%+v, want none", got)
+				}
+				return
+			}
+			if !ok || got.LastHeight != c.want || got.LastHash != fmt.Sprintf("h%d", c.want) {
+				t.Fatalf("watermark=%+v ok=%v, want %d", got, ok, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// rangePipeline keeps up to `parallel` BlocksRange requests in flight for consecutive pages.
+// queue is the reorder buffer: pages complete in any order but are handed out strictly by height,
+// and memory stays bounded to parallel*PageMax blocks.
+type rangePipeline struct {
+	rpc      blocksRanger
+	sizer    *pageSizer
+	parallel int
+
+	cursor int64 // first height not dispatched yet
+	queue  []*pageFetch
+}
+
+type pageFetch struct {
+	from, to int64
+
+	done   chan struct{}
+	cancel context.CancelFunc
+
+	resp BlocksRangeResp
+	err  error
+	cost time.Duration
+}
+
+// blocksRanger is the part of RPCPool the pipeline uses.
+type blocksRanger interface {
+	BlocksRange(ctx context.Context, from, to int64) (BlocksRangeResp, error)
+}
+
+func newRangePipeline(rpc blocksRanger, sizer *pageSizer, parallel int) *rangePipeline {
+	if parallel <= 0 {
+		parallel = 1
+	}
+	return &rangePipeline{rpc: rpc, sizer: sizer, parallel: parallel}
+}
+
+// fill dispatches pages up to headNum until the window is full.
+// An empty pipeline restarts from next (the caller's produce cursor).
+func (p *rangePipeline) fill(ctx context.Context, next int64, headNum int64) {
+	if len(p.queue) == 0 {
+		p.cursor = next
+	}
+	for len(p.queue) < p.parallel && p.cursor <= headNum {
+		to := min(p.cursor+p.sizer.size()-1, headNum)
+		p.queue = append(p.queue, p.dispatch(ctx, p.cursor, to))
+		p.cursor = to + 1
+	}
+}
+
+func (p *rangePipeline) dispatch(ctx context.Context, from, to int64) *pageFetch {
+	fctx, cancel := context.WithCancel(ctx)
+	pf := &pageFetch{from: from, to: to, done: make(chan struct{}), cancel: cancel}
+	go func() {
+		defer close(pf.done)
+		start := time.Now()
+		pf.resp, pf.err = p.rpc.BlocksRange(fctx, from, to)
+		pf.cost = time.Since(start)
+	}()
+	return pf
+}
+
+// next waits for the lowest in-flight page. nil means nothing is in flight (or ctx is done).
+func (p *rangePipeline) next(ctx context.Context) *pageFetch {
+	if len(p.queue) == 0 {
+		return nil
+	}
+	pf := p.queue[0]
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-pf.done:
+	}
+	p.queue[0] = nil
+	p.queue = p.queue[1:]
+	pf.cancel()
+	p.sizer.observe(pf)
+	return pf
+}
+
+// reset drops every in-flight page; the next fill starts over from the caller's cursor.
+// Used whenever a page was not consumed completely, since later pages would leave a hole.
+func (p *rangePipeline) reset() {
+	for _, pf := range p.queue {
+		pf.cancel()
+	}
+	p.queue = nil
+}
+
+func (p *rangePipeline) inflight() int { return len(p.queue) }
+
+// pageSizer adapts the page size so one BlocksRange call takes about target (server-side latency
+// of the successful attempt, not time spent waiting for a rate-limit token) and its body stays
+// under maxBytes. target<=0 keeps the size fixed.
+type pageSizer struct {
+	cur, min, max int64
+	target        time.Duration
+	maxBytes      int64 // <=0: no cap
+}
+
+func newPageSizer(start, lo, hi int64, target time.Duration, maxBytes int64) *pageSizer {
+	lo = max(lo, 1)
+	hi = max(hi, lo)
+	return &pageSizer{cur: min(max(start, lo), hi), min: lo, max: hi, target: target, maxBytes: maxBytes}
+}
+
+func (s *pageSizer) size() int64 { return s.cur }
+
+// observe adjusts the size from one completed page (in height order, on the Run goroutine).
+func (s *pageSizer) observe(pf *pageFetch) {
+	if s.target <= 0 {
+		return
+	}
+	old := s.cur
+	lat, bytes := pf.resp.Latency, pf.resp.Bytes
+	var rl *RateLimitError
+	switch {
+	case pf.err != nil && errors.As(pf.err, &rl):
+		// throttling is about request rate, not size: smaller pages would only mean more requests
+		return
+	case pf.err != nil:
+		// timeouts / 5xx on shared providers are often "page too big"
+		s.cur /= 2
+	case s.maxBytes > 0 && bytes > s.maxBytes:
+		s.cur = s.cur * s.maxBytes / bytes
+	case lat > s.target:
+		s.cur = int64(float64(s.cur) * float64(s.target) / float64(lat))
+	case lat < s.target/2 && int64(len(pf.resp.Blocks)) >= pf.to-pf.from+1 && pf.to-pf.from+1 >= s.cur:
+		// only full pages of the current size say anything about headroom (pages at the head are short)
+		s.cur = s.cur*3/2 + 1
+	}
+	s.cur = min(max(s.cur, s.min), s.max)
+	if s.cur != old {
+		log.Printf("[fetcher] page size %d -> %d (latency=%s bytes=%d err=%v)", old, s.cur, lat, bytes, pf.err)
+	}
+}
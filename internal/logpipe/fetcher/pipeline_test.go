@@ -0,0 +1,209 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+)
+
+// fakeRanger answers a page once release(from) is called, or fails it when its ctx is cancelled.
+type fakeRanger struct {
+	mu        sync.Mutex
+	gates     map[int64]chan struct{}
+	cancelled map[int64]bool
+}
+
+func newFakeRanger() *fakeRanger {
+	return &fakeRanger{gates: make(map[int64]chan struct{}), cancelled: make(map[int64]bool)}
+}
+
+func (f *fakeRanger) gate(from int64) chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g, ok := f.gates[from]
+	if !ok {
+		g = make(chan struct{})
+		f.gates[from] = g
+	}
+	return g
+}
+
+func (f *fakeRanger) release(from int64) { close(f.gate(from)) }
+
+func (f *fakeRanger) BlocksRange(ctx context.Context, from, to int64) (BlocksRangeResp, error) {
+	select {
+	case <-f.gate(from):
+	case <-ctx.Done():
+		f.mu.Lock()
+		f.cancelled[from] = true
+		f.mu.Unlock()
+		return BlocksRangeResp{}, ctx.Err()
+	}
+	resp := BlocksRangeResp{From: from, To: to}
+	for n := from; n <= to; n++ {
+		resp.Blocks = append(resp.Blocks, model.Block{Header: model.BlockHeader{Number: n}})
+	}
+	return resp, nil
+}
+
+func TestRangePipelineInOrder(t *testing.T) {
+	ctx := context.Background()
+	fr := newFakeRanger()
+	p := newRangePipeline(fr, newPageSizer(10, 1, 100, 0, 0), 4)
+
+	p.fill(ctx, 1, 35)
+	if p.inflight() != 4 {
+		t.Fatalf("inflight=%d want 4", p.inflight())
+	}
+	// complete back to front: next still hands out 1-10, 11-20, 21-30, 31-35
+	for _, from := range []int64{31, 21, 11, 1} {
+		fr.release(from)
+	}
+	want := [][2]int64{{1, 10}, {11, 20}, {21, 30}, {31, 35}}
+	for _, w := range want {
+		pf := p.next(ctx)
+		if pf == nil || pf.err != nil || pf.from != w[0] || pf.to != w[1] {
+			t.Fatalf("next=%+v want %v", pf, w)
+		}
+		if got := pf.resp.Blocks[0].Header.Number; got != w[0] {
+			t.Fatalf("page %v starts at block %d", w, got)
+		}
+	}
+	if p.next(ctx) != nil {
+		t.Fatal("next on an empty pipeline should be nil")
+	}
+
+	// an empty pipeline restarts from the caller's cursor
+	p.fill(ctx, 36, 40)
+	fr.release(36)
+	if pf := p.next(ctx); pf == nil || pf.from != 36 || pf.to != 40 {
+		t.Fatalf("refill: %+v", pf)
+	}
+}
+
+func TestRangePipelineNextWaitsForLowest(t *testing.T) {
+	fr := newFakeRanger()
+	p := newRangePipeline(fr, newPageSizer(10, 1, 100, 0, 0), 2)
+	p.fill(context.Background(), 1, 100)
+	fr.release(11)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if pf := p.next(ctx); pf != nil {
+		t.Fatalf("next returned page %d-%d before page 1 completed", pf.from, pf.to)
+	}
+	if p.inflight() != 2 {
+		t.Fatalf("a ctx-cancelled next must not drop pages: inflight=%d", p.inflight())
+	}
+	p.reset()
+}
+
+func TestRangePipelineReset(t *testing.T) {
+	ctx := context.Background()
+	fr := newFakeRanger()
+	p := newRangePipeline(fr, newPageSizer(10, 1, 100, 0, 0), 3)
+	p.fill(ctx, 1, 100)
+	queued := append([]*pageFetch(nil), p.queue...)
+
+	p.reset()
+	if p.inflight() != 0 {
+		t.Fatalf("inflight=%d after reset", p.inflight())
+	}
+	for _, pf := range queued {
+		<-pf.done
+		if !errors.Is(pf.err, context.Canceled) {
+			t.Fatalf("page %d-%d: err=%v want canceled", pf.from, pf.to, pf.err)
+		}
+	}
+	fr.mu.Lock()
+	n := len(fr.cancelled)
+	fr.mu.Unlock()
+	if n != 3 {
+		t.Fatalf("cancelled=%d want 3", n)
+	}
+
+	// fill after reset starts from the cursor the caller passes, not where dispatch stopped
+	p.fill(ctx, 5, 100)
+	if p.queue[0].from != 5 {
+		t.Fatalf("refill from=%d want 5", p.queue[0].from)
+	}
+	p.reset()
+}
+
+func TestPageSizerObserve(t *testing.T) {
+	const target = 100 * time.Millisecond
+	page := func(from, to int64, blocks int, lat time.Duration, bytes int64, err error) *pageFetch {
+		pf := &pageFetch{from: from, to: to, err: err}
+		pf.resp.Latency, pf.resp.Bytes = lat, bytes
+		pf.resp.Blocks = make([]model.Block, blocks)
+		return pf
+	}
+
+	cases := []struct {
+		name string
+		cur  int64
+		pf   *pageFetch
+		want int64
+	}{
+		{"fast full page grows", 100, page(1, 100, 100, 10*time.Millisecond, 0, nil), 151},
+		{"fast short page at head stays", 100, page(1, 100, 40, 10*time.Millisecond, 0, nil), 100},
+		{"fast smaller page stays", 100, page(1, 50, 50, 10*time.Millisecond, 0, nil), 100},
+		{"slow page shrinks proportionally", 100, page(1, 100, 100, 400*time.Millisecond, 0, nil), 25},
+		{"within target stays", 100, page(1, 100, 100, 80*time.Millisecond, 0, nil), 100},
+		{"error halves", 100, page(1, 100, 0, 0, 0, errors.New("timeout")), 50},
+		{"rate limit keeps", 100, page(1, 100, 0, 0, 0, &RateLimitError{}), 100},
+		{"too many bytes scales to cap", 100, page(1, 100, 100, 10*time.Millisecond, 4000, nil), 25},
+		{"clamped to min", 12, page(1, 12, 0, 0, 0, errors.New("timeout")), 10},
+		{"clamped to max", 900, page(1, 900, 900, 10*time.Millisecond, 0, nil), 1000},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newPageSizer(c.cur, 10, 1000, target, 1000)
+			s.observe(c.pf)
+			if s.size() != c.want {
+				t.Fatalf("size %d -> %d, want %d", c.cur, s.size(), c.want)
+			}
+		})
+	}
+
+	fixed := newPageSizer(100, 10, 1000, 0, 0)
+	fixed.observe(page(1, 100, 0, 0, 0, errors.New("timeout")))
+	if fixed.size() != 100 {
+		t.Fatalf("target<=0 must keep the size fixed: %d", fixed.size())
+	}
+	if s := newPageSizer(5000, 10, 1000, target, 0); s.size() != 1000 {
+		t.Fatalf("start clamped: %d", s.size())
+	}
+}
+
+// Against a server whose latency grows with the page, the size settles where a page takes between
+// half the target and the target, from either side, and stays there.
+func TestPageSizerConverges(t *testing.T) {
+	const perBlock = 2 * time.Millisecond
+	target := 100 * time.Millisecond
+	for _, start := range []int64{10, 400} {
+		s := newPageSizer(start, 1, 1000, target, 0)
+		var from int64 = 1
+		for range 20 {
+			n := s.size()
+			pf := &pageFetch{from: from, to: from + n - 1}
+			pf.resp.Blocks = make([]model.Block, n)
+			pf.resp.Latency = time.Duration(n) * perBlock
+			s.observe(pf)
+			from += n
+		}
+		if lat := time.Duration(s.size()) * perBlock; lat < target/2 || lat > target {
+			t.Fatalf("start=%d: settled at %d blocks, %s a page", start, s.size(), lat)
+		}
+		settled := s.size()
+		pf := &pageFetch{from: from, to: from + settled - 1, err: &RateLimitError{RetryAfter: time.Second}}
+		s.observe(pf)
+		if s.size() != settled {
+			t.Fatalf("start=%d: 429 moved the size %d -> %d", start, settled, s.size())
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package fetcher
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	if newTokenBucket(0, 5) != nil {
+		t.Fatal("rps 0 must mean unlimited")
+	}
+	b := newTokenBucket(50, 3)
+	ctx := context.Background()
+	start := time.Now()
+	for range 3 {
+		if err := b.wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d > 30*time.Millisecond {
+		t.Fatalf("burst of 3 took %s", d)
+	}
+	// the next ones come at 50/s
+	start = time.Now()
+	for range 5 {
+		if err := b.wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 80*time.Millisecond || d > 300*time.Millisecond {
+		t.Fatalf("5 tokens at 50/s took %s", d)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	slow := newTokenBucket(0.1, 1)
+	_ = slow.wait(ctx)
+	if err := slow.wait(cctx); err == nil {
+		t.Fatal("wait ignored its context")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := backoff{base: 100 * time.Millisecond, max: time.Second}
+	var got []time.Duration
+	for range 6 {
+		got = append(got, b.next())
+	}
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i := range want {
+		if got[i] != want[i]*time.Millisecond {
+			t.Fatalf("backoff %v", got)
+		}
+	}
+	b.reset()
+	if d := b.next(); d != b.base {
+		t.Fatalf("after reset: %s", d)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	for _, c := range []struct {
+		v      string
+		lo, hi time.Duration
+	}{
+		{"", 0, 0},
+		{"3", 3 * time.Second, 3 * time.Second},
+		{" 0 ", 0, 0},
+		{"-1", 0, 0},
+		{"soon", 0, 0},
+		{time.Now().Add(5 * time.Second).UTC().Format(http.TimeFormat), 3 * time.Second, 5 * time.Second},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+	} {
+		if d := parseRetryAfter(c.v); d < c.lo || d > c.hi {
+			t.Fatalf("%q: %s want %s..%s", c.v, d, c.lo, c.hi)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -24,6 +25,50 @@ type BlocksRangeResp struct {
 	Blocks  []model.Block `json:"blocks"`
 	Partial bool          `json:"partial"`
 	LastOK  int64         `json:"last_ok"`
+
+	// measured by the client, for adaptive page sizing
+	Bytes   int64         `json:"-"`
+	Latency time.Duration `json:"-"`
+}
+
+// RateLimitError is an HTTP 429 from the RPC. RetryAfter is 0 when the server didn't say.
+type RateLimitError struct {
+	Path       string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rpc %s rate limited (retry_after=%s)", e.Path, e.RetryAfter)
+}
+
+// parseRetryAfter accepts delay-seconds or an HTTP-date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Close drops the client's idle keep-alive connections.
+func (c *RPCClient) Close() {
+	c.hc.CloseIdleConnections()
 }
 
 func NewRPCClient(base string) *RPCClient {
@@ -59,25 +104,31 @@ type AtOrAfterResp struct {
 	// block field exists too but we don't need it for positioning
 }
 
-func (c *RPCClient) getJSON(ctx context.Context, path string, out any) error {
+// getJSON decodes the response into out and returns the body size.
+func (c *RPCClient) getJSON(ctx context.Context, path string, out any) (int64, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	resp, err := c.hc.Do(req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return 0, &RateLimitError{Path: path, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("rpc %s status=%d", path, resp.StatusCode)
+		return 0, fmt.Errorf("rpc %s status=%d", path, resp.StatusCode)
 	}
-	return json.NewDecoder(resp.Body).Decode(out)
+	cr := &countingReader{r: resp.Body}
+	err = json.NewDecoder(cr).Decode(out)
+	return cr.n, err
 }
 
 func (c *RPCClient) ChainHead(ctx context.Context) (ChainHeadResp, error) {
 	var out ChainHeadResp
-	err := c.getJSON(ctx, "/chain/head", &out)
+	_, err := c.getJSON(ctx, "/chain/head", &out)
 	return out, err
 }
 
@@ -85,7 +136,7 @@ func (c *RPCClient) BlockAtOrAfter(ctx context.Context, ts int64) (AtOrAfterResp
 	var out AtOrAfterResp
 	q := url.Values{}
 	q.Set("ts", strconv.FormatInt(ts, 10))
-	err := c.getJSON(ctx, "/block/at-or-after?"+q.Encode(), &out)
+	_, err := c.getJSON(ctx, "/block/at-or-after?"+q.Encode(), &out)
 	return out, err
 }
 
@@ -96,12 +147,14 @@ func (c *RPCClient) BlocksRange(ctx context.Context, from, to int64) (BlocksRang
 	q.Set("from", strconv.FormatInt(from, 10))
 	q.Set("to", strconv.FormatInt(to, 10))
 
-	err := c.getJSON(ctx, "/blocks/range?"+q.Encode(), &out)
+	start := time.Now()
+	n, err := c.getJSON(ctx, "/blocks/range?"+q.Encode(), &out)
+	out.Bytes, out.Latency = n, time.Since(start)
 	return out, err
 }
 
 func (c *RPCClient) BlockByNumber(ctx context.Context, n int64) (model.Block, error) {
 	var blk model.Block
-	err := c.getJSON(ctx, "/block/by-number/"+strconv.FormatInt(n, 10), &blk)
+	_, err := c.getJSON(ctx, "/block/by-number/"+strconv.FormatInt(n, 10), &blk)
 	return blk, err
 }
@@ -0,0 +1,400 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+)
+
+type PoolOptions struct {
+	// MaxLag: an endpoint whose head is more than MaxLag blocks behind the best head is out of sync
+	// and only gets requests it can answer (to <= its head). <=0 means 3.
+	MaxLag int64
+
+	// CrossCheckEvery: how often the block hash at a common height is compared across endpoints. <=0 means 10s.
+	CrossCheckEvery time.Duration
+
+	// RPS caps requests per second per endpoint (token bucket, bursts up to Burst). <=0 means unlimited.
+	RPS   float64
+	Burst int // <=0 means max(1, RPS)
+}
+
+// RPCPool spreads requests over redundant mockchain RPC endpoints.
+// Each endpoint keeps latency / error-rate EWMAs and its last head; requests go to the best-scored
+// healthy endpoint that has the requested heights, and fail over to the next one on error.
+// Periodically the hash at a common height is compared across endpoints: the minority is marked
+// forked and gets no traffic until it agrees again.
+type RPCPool struct {
+	eps  []*endpoint
+	opts PoolOptions
+
+	mu        sync.Mutex
+	nextCheck time.Time
+}
+
+type endpoint struct {
+	url string
+	c   *RPCClient
+
+	bucket *tokenBucket // nil: unlimited
+
+	mu             sync.Mutex
+	lat            time.Duration // EWMA
+	errRate        float64       // EWMA of 0/1
+	consecErr      int
+	downUntil      time.Time
+	throttledUntil time.Time // 429 / Retry-After
+	head           int64
+	forked         bool
+}
+
+const (
+	ewmaAlpha    = 0.2
+	downAfterErr = 3
+	downBase     = 2 * time.Second
+	downMax      = time.Minute
+
+	// throttle used when a 429 carries no Retry-After
+	throttleDefault = time.Second
+)
+
+func NewRPCPool(urls []string, opts PoolOptions) (*RPCPool, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("no rpc endpoints")
+	}
+	if opts.MaxLag <= 0 {
+		opts.MaxLag = 3
+	}
+	if opts.CrossCheckEvery <= 0 {
+		opts.CrossCheckEvery = 10 * time.Second
+	}
+	if opts.Burst <= 0 {
+		opts.Burst = max(1, int(opts.RPS))
+	}
+	p := &RPCPool{opts: opts}
+	for _, u := range urls {
+		p.eps = append(p.eps, &endpoint{url: u, c: NewRPCClient(u), bucket: newTokenBucket(opts.RPS, opts.Burst)})
+	}
+	return p, nil
+}
+
+// Close releases the endpoints' connections. Requests after it still work, on new connections.
+func (p *RPCPool) Close() error {
+	for _, ep := range p.eps {
+		ep.c.Close()
+	}
+	return nil
+}
+
+// do runs fn on candidates in score order until one succeeds. need is the highest height the
+// request touches (0: any); endpoints known to be below it are tried last.
+func (p *RPCPool) do(ctx context.Context, need int64, fn func(*RPCClient) error) error {
+	var errs []error
+	for _, ep := range p.ranked(need) {
+		if err := ep.acquire(ctx); err != nil {
+			return err
+		}
+		start := time.Now()
+		err := fn(ep.c)
+		if ctx.Err() != nil {
+			return ctx.Err() // cancelled by us (pipeline reset / shutdown): not the endpoint's fault
+		}
+		ep.observe(time.Since(start), err)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", ep.url, err))
+	}
+	if len(errs) == 0 {
+		return errors.New("no usable rpc endpoint")
+	}
+	return errors.Join(errs...)
+}
+
+// ranked orders endpoints: usable and in sync for need first (by score), then lagging / down /
+// throttled ones as a last resort. Forked endpoints are never used: their blocks would be wrong, not just late.
+func (p *RPCPool) ranked(need int64) []*endpoint {
+	now := time.Now()
+	type cand struct {
+		ep    *endpoint
+		tier  int
+		score float64
+	}
+	cs := make([]cand, 0, len(p.eps))
+	for _, ep := range p.eps {
+		ep.mu.Lock()
+		if ep.forked {
+			ep.mu.Unlock()
+			continue
+		}
+		tier := 0
+		switch {
+		case now.Before(ep.throttledUntil):
+			tier = 3 // usable, but only after waiting out Retry-After
+		case now.Before(ep.downUntil):
+			tier = 2
+		case need > 0 && ep.head > 0 && ep.head < need:
+			tier = 1
+		}
+		// lower is better: latency inflated by error rate
+		score := float64(max(ep.lat, time.Millisecond)) * (1 + 10*ep.errRate)
+		ep.mu.Unlock()
+		cs = append(cs, cand{ep, tier, score})
+	}
+	slices.SortStableFunc(cs, func(a, b cand) int {
+		if a.tier != b.tier {
+			return a.tier - b.tier
+		}
+		switch {
+		case a.score < b.score:
+			return -1
+		case a.score > b.score:
+			return 1
+		}
+		return 0
+	})
+	out := make([]*endpoint, len(cs))
+	for i, c := range cs {
+		out[i] = c.ep
+	}
+	return out
+}
+
+// acquire waits out a server-requested throttle, then for a token of the endpoint's own rate limit.
+func (ep *endpoint) acquire(ctx context.Context) error {
+	ep.mu.Lock()
+	until := ep.throttledUntil
+	ep.mu.Unlock()
+	if err := sleepCtx(ctx, time.Until(until)); err != nil {
+		return err
+	}
+	return ep.bucket.wait(ctx)
+}
+
+func (ep *endpoint) throttled() bool {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return time.Now().Before(ep.throttledUntil)
+}
+
+func (ep *endpoint) observe(d time.Duration, err error) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	// 429 is the server pacing us, not the endpoint failing: no error-rate / down penalty
+	var rl *RateLimitError
+	if errors.As(err, &rl) {
+		wait := rl.RetryAfter
+		if wait <= 0 {
+			wait = throttleDefault
+		}
+		ep.throttledUntil = time.Now().Add(wait)
+		log.Printf("[rpc] endpoint %s throttled for %s", ep.url, wait)
+		return
+	}
+	if ep.lat == 0 {
+		ep.lat = d
+	} else {
+		ep.lat = time.Duration(ewmaAlpha*float64(d) + (1-ewmaAlpha)*float64(ep.lat))
+	}
+	bad := 0.0
+	if err != nil {
+		bad = 1
+	}
+	ep.errRate = ewmaAlpha*bad + (1-ewmaAlpha)*ep.errRate
+
+	if err == nil {
+		if ep.consecErr >= downAfterErr {
+			log.Printf("[rpc] endpoint %s recovered", ep.url)
+		}
+		ep.consecErr = 0
+		return
+	}
+	ep.consecErr++
+	if ep.consecErr >= downAfterErr {
+		backoff := min(downBase<<min(ep.consecErr-downAfterErr, 5), downMax)
+		ep.downUntil = time.Now().Add(backoff)
+		log.Printf("[rpc] endpoint %s down for %s after %d errors: %v", ep.url, backoff, ep.consecErr, err)
+	}
+}
+
+// ChainHead polls every endpoint, refreshes their heads and returns the highest head among
+// healthy, non-forked endpoints. It also runs the hash cross-check when due.
+func (p *RPCPool) ChainHead(ctx context.Context) (ChainHeadResp, error) {
+	heads := make([]ChainHeadResp, len(p.eps))
+	errs := make([]error, len(p.eps))
+	var wg sync.WaitGroup
+	for i, ep := range p.eps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ep.throttled() {
+				errs[i] = fmt.Errorf("%s throttled", ep.url) // keep its last head, don't make it worse
+				return
+			}
+			if errs[i] = ep.bucket.wait(ctx); errs[i] != nil {
+				return
+			}
+			start := time.Now()
+			heads[i], errs[i] = ep.c.ChainHead(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			ep.observe(time.Since(start), errs[i])
+			if errs[i] == nil {
+				ep.mu.Lock()
+				ep.head = heads[i].HeadNum
+				ep.mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return ChainHeadResp{}, err
+	}
+
+	best, found := ChainHeadResp{}, false
+	for i, ep := range p.eps {
+		if errs[i] != nil {
+			continue
+		}
+		ep.mu.Lock()
+		forked := ep.forked
+		ep.mu.Unlock()
+		if forked {
+			continue
+		}
+		if !found || heads[i].HeadNum > best.HeadNum {
+			best, found = heads[i], true
+		}
+	}
+	if !found {
+		return ChainHeadResp{}, fmt.Errorf("no healthy rpc endpoint: %w", errors.Join(errs...))
+	}
+
+	p.maybeCrossCheck(ctx, best.HeadNum)
+	return best, nil
+}
+
+// maybeCrossCheck compares the block hash at the lowest head among in-sync endpoints.
+// The majority hash wins; endpoints that disagree are marked forked until a later check agrees.
+func (p *RPCPool) maybeCrossCheck(ctx context.Context, bestHead int64) {
+	if len(p.eps) < 2 {
+		return
+	}
+	p.mu.Lock()
+	if time.Now().Before(p.nextCheck) {
+		p.mu.Unlock()
+		return
+	}
+	p.nextCheck = time.Now().Add(p.opts.CrossCheckEvery)
+	p.mu.Unlock()
+
+	var (
+		eps []*endpoint
+		h   int64
+	)
+	for _, ep := range p.eps {
+		ep.mu.Lock()
+		head := ep.head
+		ep.mu.Unlock()
+		if head <= 0 {
+			continue
+		}
+		if bestHead-head > p.opts.MaxLag {
+			log.Printf("[rpc] endpoint %s lagging: head=%d best=%d", ep.url, head, bestHead)
+			continue
+		}
+		if h == 0 || head < h {
+			h = head
+		}
+		eps = append(eps, ep)
+	}
+	if len(eps) < 2 {
+		return
+	}
+
+	hashes := make([]string, len(eps))
+	var wg sync.WaitGroup
+	for i, ep := range eps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ep.throttled() || ep.bucket.wait(ctx) != nil {
+				return
+			}
+			if blk, err := ep.c.BlockByNumber(ctx, h); err == nil {
+				hashes[i] = blk.Hash.Hex()
+			}
+		}()
+	}
+	wg.Wait()
+
+	votes := make(map[string]int)
+	for _, hh := range hashes {
+		if hh != "" {
+			votes[hh]++
+		}
+	}
+	var major string
+	for hh, n := range votes {
+		if n > votes[major] {
+			major = hh
+		}
+	}
+	if votes[major]*2 <= len(eps) && len(votes) > 1 {
+		// no strict majority (e.g. 1 vs 1): can't tell who is right, keep routing by health only
+		log.Printf("[rpc] cross-check height=%d: no majority among %d endpoints %v", h, len(eps), hashes)
+		return
+	}
+
+	for i, ep := range eps {
+		if hashes[i] == "" {
+			continue
+		}
+		forked := hashes[i] != major
+		ep.mu.Lock()
+		if forked != ep.forked {
+			if forked {
+				log.Printf("[rpc] endpoint %s forked at height=%d: hash=%s majority=%s", ep.url, h, hashes[i], major)
+			} else {
+				log.Printf("[rpc] endpoint %s back on majority chain at height=%d", ep.url, h)
+			}
+		}
+		ep.forked = forked
+		ep.mu.Unlock()
+	}
+}
+
+func (p *RPCPool) BlockAtOrAfter(ctx context.Context, ts int64) (AtOrAfterResp, error) {
+	var out AtOrAfterResp
+	err := p.do(ctx, 0, func(c *RPCClient) (err error) {
+		out, err = c.BlockAtOrAfter(ctx, ts)
+		return err
+	})
+	return out, err
+}
+
+func (p *RPCPool) BlocksRange(ctx context.Context, from, to int64) (BlocksRangeResp, error) {
+	var out BlocksRangeResp
+	err := p.do(ctx, to, func(c *RPCClient) (err error) {
+		out, err = c.BlocksRange(ctx, from, to)
+		return err
+	})
+	return out, err
+}
+
+func (p *RPCPool) BlockByNumber(ctx context.Context, n int64) (model.Block, error) {
+	var out model.Block
+	err := p.do(ctx, n, func(c *RPCClient) (err error) {
+		out, err = c.BlockByNumber(ctx, n)
+		return err
+	})
+	return out, err
+}
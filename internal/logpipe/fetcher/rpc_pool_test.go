@@ -0,0 +1,326 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+)
+
+// fakeNode is a mockchain RPC endpoint whose health the test switches.
+type fakeNode struct {
+	mu         sync.Mutex
+	head       int64
+	fork       bool          // serves another chain: same heights, other hashes
+	status     int           // non-zero: every request answers it
+	retryAfter string        // with status 429
+	delay      time.Duration // before answering
+	hits       int
+	srv        *httptest.Server
+}
+
+func newFakeNode(t *testing.T, head int64) *fakeNode {
+	n := &fakeNode{head: head}
+	n.srv = httptest.NewServer(http.HandlerFunc(n.serve))
+	t.Cleanup(n.srv.Close)
+	return n
+}
+
+func (n *fakeNode) set(fn func(n *fakeNode)) {
+	n.mu.Lock()
+	fn(n)
+	n.mu.Unlock()
+}
+
+func (n *fakeNode) hitCount() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.hits
+}
+
+func (n *fakeNode) block(num int64) model.Block {
+	nonce := uint64(7)
+	if n.fork {
+		nonce = 8
+	}
+	return model.BuildBlock("", num, hash.Hash32{}, nil, 1000+num, nonce)
+}
+
+func (n *fakeNode) serve(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	n.hits++
+	status, retryAfter, delay, head := n.status, n.retryAfter, n.delay, n.head
+	n.mu.Unlock()
+	time.Sleep(delay)
+	if status != 0 {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+		return
+	}
+	var v any
+	switch {
+	case r.URL.Path == "/chain/head":
+		v = ChainHeadResp{HeadNum: head}
+	case strings.HasPrefix(r.URL.Path, "/block/by-number/"):
+		num, _ := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/block/by-number/"), 10, 64)
+		n.mu.Lock()
+		v = n.block(num)
+		n.mu.Unlock()
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func newTestPool(t *testing.T, opts PoolOptions, nodes ...*fakeNode) *RPCPool {
+	t.Helper()
+	urls := make([]string, len(nodes))
+	for i, n := range nodes {
+		urls[i] = n.srv.URL
+	}
+	p, err := NewRPCPool(urls, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+// order is the node index of every endpoint ranked for need, best first.
+func order(p *RPCPool, nodes []*fakeNode, need int64) []int {
+	var idx []int
+	for _, ep := range p.ranked(need) {
+		for i, n := range nodes {
+			if n.srv.URL == ep.url {
+				idx = append(idx, i)
+			}
+		}
+	}
+	return idx
+}
+
+func sameInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Requests go to the best-scored endpoint that can serve them: slow, failing, down, throttled or
+// lagging endpoints fall behind (in that order of preference), and failed requests fail over.
+func TestRPCPoolRanking(t *testing.T) {
+	cases := []struct {
+		name  string
+		setup func(nodes []*fakeNode)
+		calls int
+		need  int64 // BlockByNumber height
+		want  []int // ranking afterwards
+	}{
+		{
+			name:  "slow falls behind",
+			setup: func(ns []*fakeNode) { ns[0].set(func(n *fakeNode) { n.delay = 30 * time.Millisecond }) },
+			calls: 4,
+			want:  []int{1, 0},
+		},
+		{
+			name:  "failing is ejected",
+			setup: func(ns []*fakeNode) { ns[0].set(func(n *fakeNode) { n.status = http.StatusInternalServerError }) },
+			calls: downAfterErr,
+			want:  []int{1, 0},
+		},
+		{
+			name: "throttled waits behind failing",
+			setup: func(ns []*fakeNode) {
+				ns[0].set(func(n *fakeNode) { n.status, n.retryAfter = http.StatusTooManyRequests, "60" })
+				ns[1].set(func(n *fakeNode) { n.status = http.StatusInternalServerError })
+			},
+			calls: downAfterErr,
+			want:  []int{2, 1, 0},
+		},
+		{
+			name:  "lagging only for heights above its head",
+			setup: func(ns []*fakeNode) { ns[0].set(func(n *fakeNode) { n.head = 50 }) },
+			need:  80,
+			want:  []int{1, 0},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			nodes := []*fakeNode{newFakeNode(t, 100), newFakeNode(t, 100), newFakeNode(t, 100)}
+			if len(c.want) == 2 {
+				nodes = nodes[:2]
+			}
+			p := newTestPool(t, PoolOptions{CrossCheckEvery: time.Hour}, nodes...)
+			c.setup(nodes)
+			ctx := context.Background()
+			if _, err := p.ChainHead(ctx); err != nil {
+				t.Fatal(err)
+			}
+			for range c.calls {
+				// fails over: the caller never sees the bad endpoint
+				if _, err := p.BlockByNumber(ctx, 10); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := order(p, nodes, max(c.need, 1)); !sameInts(got, c.want) {
+				t.Fatalf("ranking %v want %v", got, c.want)
+			}
+		})
+	}
+}
+
+// An endpoint down after downAfterErr errors in a row gets no traffic while another one works;
+// once its down time is over and a request succeeds on it, it is healthy again.
+func TestRPCPoolEjectReadmit(t *testing.T) {
+	// b lags: a stays first for height 10 however slow or erratic, until it is down
+	a, b := newFakeNode(t, 100), newFakeNode(t, 5)
+	p := newTestPool(t, PoolOptions{CrossCheckEvery: time.Hour}, a, b)
+	ctx := context.Background()
+	if _, err := p.ChainHead(ctx); err != nil {
+		t.Fatal(err)
+	}
+	epA := p.eps[0]
+
+	a.set(func(n *fakeNode) { n.status = http.StatusServiceUnavailable })
+	before := a.hitCount()
+	for range downAfterErr + 5 {
+		if _, err := p.BlockByNumber(ctx, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits := a.hitCount() - before; hits != downAfterErr {
+		t.Fatalf("down endpoint got %d requests, want %d", hits, downAfterErr)
+	}
+	epA.mu.Lock()
+	down := time.Until(epA.downUntil)
+	epA.mu.Unlock()
+	if down <= 0 || down > downBase {
+		t.Fatalf("down for %s want up to %s", down, downBase)
+	}
+
+	// both failing: the error names each endpoint
+	b.set(func(n *fakeNode) { n.status = http.StatusInternalServerError })
+	_, err := p.BlockByNumber(ctx, 10)
+	if err == nil || !strings.Contains(err.Error(), a.srv.URL) || !strings.Contains(err.Error(), b.srv.URL) {
+		t.Fatalf("err=%v: want both endpoints' errors", err)
+	}
+
+	// a healed, its down time over: it serves again and its error streak is gone
+	a.set(func(n *fakeNode) { n.status = 0 })
+	epA.mu.Lock()
+	epA.downUntil = time.Now().Add(-time.Millisecond)
+	epA.mu.Unlock()
+	if _, err := p.BlockByNumber(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	epA.mu.Lock()
+	streak := epA.consecErr
+	epA.mu.Unlock()
+	if got := order(p, []*fakeNode{a, b}, 10); streak != 0 || !sameInts(got, []int{0, 1}) {
+		t.Fatalf("after recovery: streak=%d ranking=%v", streak, got)
+	}
+}
+
+// A 429 paces the endpoint without counting as an error.
+func TestRPCPoolRetryAfter(t *testing.T) {
+	a, b := newFakeNode(t, 100), newFakeNode(t, 100)
+	p := newTestPool(t, PoolOptions{CrossCheckEvery: time.Hour}, a, b)
+	a.set(func(n *fakeNode) { n.status, n.retryAfter = http.StatusTooManyRequests, "2" })
+	if _, err := p.BlockByNumber(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	ep := p.eps[0]
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	if wait := time.Until(ep.throttledUntil); wait <= time.Second || wait > 2*time.Second {
+		t.Fatalf("throttled for %s want ~2s", wait)
+	}
+	if ep.errRate != 0 || ep.consecErr != 0 {
+		t.Fatalf("429 counted as an error: rate=%v streak=%d", ep.errRate, ep.consecErr)
+	}
+}
+
+// The hash cross-check marks the minority forked: it gets no requests and its head is ignored,
+// until a later check finds it back on the majority chain. Without a majority nobody is marked.
+func TestRPCPoolCrossCheck(t *testing.T) {
+	t.Run("minority forked", func(t *testing.T) {
+		nodes := []*fakeNode{newFakeNode(t, 100), newFakeNode(t, 100), newFakeNode(t, 100)}
+		p := newTestPool(t, PoolOptions{CrossCheckEvery: time.Hour}, nodes...)
+		nodes[2].set(func(n *fakeNode) { n.fork, n.head = true, 101 })
+		ctx := context.Background()
+
+		head, err := p.ChainHead(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := order(p, nodes, 1); len(got) != 2 || got[0] == 2 || got[1] == 2 {
+			t.Fatalf("ranking %v: forked endpoint still used", got)
+		}
+		// the next ChainHead no longer trusts its head
+		if head, err = p.ChainHead(ctx); err != nil || head.HeadNum != 100 {
+			t.Fatalf("head=%d err=%v want 100 from the majority", head.HeadNum, err)
+		}
+		before := nodes[2].hitCount()
+		for range 5 {
+			if blk, err := p.BlockByNumber(ctx, 10); err != nil || blk.Hash != nodes[0].block(10).Hash {
+				t.Fatalf("block from the wrong chain: %v", err)
+			}
+		}
+		if nodes[2].hitCount() != before {
+			t.Fatal("forked endpoint served blocks")
+		}
+
+		// back on the majority chain at the next check
+		nodes[2].set(func(n *fakeNode) { n.fork = false })
+		p.mu.Lock()
+		p.nextCheck = time.Time{}
+		p.mu.Unlock()
+		if _, err := p.ChainHead(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := order(p, nodes, 1); len(got) != 3 {
+			t.Fatalf("ranking %v: endpoint not re-admitted", got)
+		}
+	})
+
+	t.Run("no majority", func(t *testing.T) {
+		nodes := []*fakeNode{newFakeNode(t, 100), newFakeNode(t, 100)}
+		p := newTestPool(t, PoolOptions{CrossCheckEvery: time.Hour}, nodes...)
+		nodes[1].set(func(n *fakeNode) { n.fork = true })
+		if _, err := p.ChainHead(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got := order(p, nodes, 1); len(got) != 2 {
+			t.Fatalf("ranking %v: 1 vs 1 marked one forked", got)
+		}
+	})
+
+	t.Run("lagging left out", func(t *testing.T) {
+		nodes := []*fakeNode{newFakeNode(t, 100), newFakeNode(t, 100), newFakeNode(t, 90)}
+		p := newTestPool(t, PoolOptions{CrossCheckEvery: time.Hour, MaxLag: 3}, nodes...)
+		nodes[2].set(func(n *fakeNode) { n.fork = true })
+		if _, err := p.ChainHead(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		// too far behind to compare at height 100: not checked, not forked
+		if got := order(p, nodes, 1); len(got) != 3 {
+			t.Fatalf("ranking %v", got)
+		}
+	})
+}
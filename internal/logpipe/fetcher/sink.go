@@ -0,0 +1,192 @@
+package fetcher
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/blockfile"
+)
+
+// BlockSink is where the fetcher's blocks go. Blocks are enqueued in height order; Watermark is
+// the highest height all of whose records are durable, and is what gets checkpointed.
+// After Err != nil the caller must Drain, checkpoint Watermark and Rewind to Watermark+1.
+type BlockSink interface {
+	Enqueue(ctx context.Context, rb routedBlock) error
+	Err() error
+	Watermark() (Ckpt, bool)
+	Drain(ctx context.Context) error
+	Rewind(next int64)
+	Close() error
+}
+
+var _ BlockSink = (*Producer)(nil)
+
+// Sink kinds (Config.Sink).
+const (
+	SinkKafka  = "kafka"
+	SinkStdout = "stdout"
+	sinkFile   = "file:" // file:<dir>
+)
+
+// newSink builds the sink named by cfg.Sink for topic.
+func newSink(cfg Config, topic string) (BlockSink, error) {
+	switch {
+	case cfg.Sink == SinkKafka:
+		return NewProducer(cfg.Brokers, topic, cfg.Producer)
+	case cfg.Sink == SinkStdout:
+		return newRecordSink(topic, cfg.Producer, &streamWriter{w: bufio.NewWriterSize(os.Stdout, 1<<20)}, 0)
+	case strings.HasPrefix(cfg.Sink, sinkFile):
+		opts := cfg.FileSink
+		opts.Dir = strings.TrimPrefix(cfg.Sink, sinkFile)
+		opts.Prefix = topic
+		w, err := blockfile.NewWriter(opts)
+		if err != nil {
+			return nil, err
+		}
+		return newRecordSink(topic, cfg.Producer, w, cfg.CheckpointEvery)
+	}
+	return nil, fmt.Errorf("sink %q: want kafka|stdout|file:<dir>", cfg.Sink)
+}
+
+type recordWriter interface {
+	Write(blockfile.Record) error
+	Sync() error
+	Close() error
+}
+
+// recordSink writes the same records the Kafka producer would send (fan-out and quarantine
+// included, never chunked) as blockfile records. Writes are synchronous; the watermark moves
+// when the writer is synced, every syncEvery and on Drain/Close.
+type recordSink struct {
+	out       outputs
+	w         recordWriter
+	syncEvery time.Duration
+
+	lastSync time.Time
+	written  Ckpt // last block handed to w
+	hasWrite bool
+	wm       Ckpt // last block known durable
+	hasWm    bool
+	err      error
+}
+
+func newRecordSink(topic string, opts ProducerOptions, w recordWriter, syncEvery time.Duration) (*recordSink, error) {
+	opts.ChunkBytes = math.MaxInt // no message size limit on a file
+	opts.Codec = "json"           // records are NDJSON lines, the value must be JSON
+	out, err := newOutputsMax(topic, opts, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &recordSink{out: out, w: w, syncEvery: syncEvery, lastSync: time.Now()}, nil
+}
+
+func (s *recordSink) Enqueue(ctx context.Context, rb routedBlock) error {
+	if s.err != nil {
+		return s.err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	msgs, err := s.out.messages(rb)
+	if err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		rec, err := toRecord(msg, rb.Block.Header.Number)
+		if err != nil {
+			return err
+		}
+		if err := s.w.Write(rec); err != nil {
+			s.err = fmt.Errorf("write height=%d: %w", rb.Block.Header.Number, err)
+			return s.err
+		}
+	}
+	s.written, s.hasWrite = Ckpt{LastHeight: rb.Block.Header.Number, LastHash: rb.Block.Hash.Hex()}, true
+	if time.Since(s.lastSync) >= s.syncEvery {
+		s.sync()
+	}
+	return s.err
+}
+
+func (s *recordSink) sync() {
+	if err := s.w.Sync(); err != nil {
+		if s.err == nil {
+			s.err = fmt.Errorf("sync: %w", err)
+		}
+		return
+	}
+	s.lastSync = time.Now()
+	if s.hasWrite {
+		s.wm, s.hasWm = s.written, true
+	}
+}
+
+func toRecord(msg *sarama.ProducerMessage, height int64) (blockfile.Record, error) {
+	rec := blockfile.Record{Topic: msg.Topic, TS: msg.Timestamp.Unix(), Height: height}
+	if msg.Key != nil {
+		k, err := msg.Key.Encode()
+		if err != nil {
+			return rec, err
+		}
+		rec.Key = string(k)
+	}
+	v, err := msg.Value.Encode()
+	if err != nil {
+		return rec, err
+	}
+	rec.Value = json.RawMessage(v)
+	if len(msg.Headers) > 0 {
+		rec.Headers = make(map[string]string, len(msg.Headers))
+		for _, h := range msg.Headers {
+			rec.Headers[string(h.Key)] = string(h.Value)
+		}
+	}
+	return rec, nil
+}
+
+func (s *recordSink) Err() error { return s.err }
+
+func (s *recordSink) Watermark() (Ckpt, bool) { return s.wm, s.hasWm }
+
+func (s *recordSink) Drain(context.Context) error {
+	s.sync()
+	return nil
+}
+
+// Rewind: the watermark stays at the last synced block; what was written after it may be in the
+// file twice once the caller re-enqueues from next (readers dedup by height).
+func (s *recordSink) Rewind(next int64) {
+	s.err = nil
+	s.written, s.hasWrite = s.wm, s.hasWm
+}
+
+func (s *recordSink) Close() error {
+	s.sync()
+	return errors.Join(s.err, s.w.Close())
+}
+
+// streamWriter writes blockfile records to a stream (stdout); Sync only flushes.
+type streamWriter struct {
+	w *bufio.Writer
+}
+
+func (s *streamWriter) Write(rec blockfile.Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+func (s *streamWriter) Sync() error { return s.w.Flush() }
+
+func (s *streamWriter) Close() error { return s.w.Flush() }
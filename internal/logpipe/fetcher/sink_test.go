@@ -0,0 +1,185 @@
+package fetcher
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/blockfile"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/schema"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+)
+
+func enqueue(t *testing.T, s BlockSink, rbs ...routedBlock) {
+	t.Helper()
+	for _, rb := range rbs {
+		if err := s.Enqueue(context.Background(), rb); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func sinkBlocks(from, to int64) []routedBlock {
+	var out []routedBlock
+	for n := from; n <= to; n++ {
+		out = append(out, routedBlock{Block: fanoutBlock(n, "0xa", "0xc")})
+	}
+	return out
+}
+
+// readBack reads every blockfile of dir in file order, with the file each record is in.
+func readBack(t *testing.T, dir string) (recs []blockfile.Record, files []string) {
+	t.Helper()
+	paths, err := blockfile.Files(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range paths {
+		err := blockfile.Read(p, func(rec blockfile.Record) error {
+			recs = append(recs, rec)
+			files = append(files, filepath.Base(p))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return recs, files
+}
+
+// The file sink rolls at block boundaries and its watermark only covers synced blocks; after a
+// rewind or a restart blocks are written again, to later files. Read back in file order and
+// deduplicated by height (last wins), the files hold exactly what the producer would have sent.
+func TestFileSinkRollReadBack(t *testing.T) {
+	for _, gz := range []bool{false, true} {
+		t.Run(fmt.Sprintf("gzip=%v", gz), func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := Config{
+				Sink:            sinkFile + dir,
+				FileSink:        blockfile.Options{RollBytes: 1, Gzip: gz},
+				Producer:        ProducerOptions{QuarantineTopic: "blocks.quarantine", TxTopic: "txs"},
+				CheckpointEvery: time.Hour,
+			}
+			open := func() BlockSink {
+				s, err := newSink(cfg, "blocks")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s
+			}
+
+			s := open()
+			blocks := sinkBlocks(1, 7)
+			blocks[2].Reason, blocks[2].Detail = ReasonParentHash, "parent_hash=0x00"
+			enqueue(t, s, blocks[:5]...)
+			if _, ok := s.Watermark(); ok {
+				t.Fatal("watermark before any sync")
+			}
+			if err := s.Drain(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if wm, ok := s.Watermark(); !ok || wm.LastHeight != 5 || wm.LastHash != blocks[4].Block.Hash.Hex() {
+				t.Fatalf("watermark %+v ok=%v after Drain, want 5", wm, ok)
+			}
+
+			// 6 and 7 written but not synced: a rewind keeps the watermark and writes them again
+			enqueue(t, s, blocks[5:]...)
+			s.Rewind(6)
+			if wm, _ := s.Watermark(); wm.LastHeight != 5 {
+				t.Fatalf("watermark %d after rewind", wm.LastHeight)
+			}
+			enqueue(t, s, blocks[5:]...)
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			// restarted from a checkpoint at 6
+			s = open()
+			enqueue(t, s, blocks[6])
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			recs, files := readBack(t, dir)
+			fileHeight := make(map[string]int64)
+			var heights []int64
+			last := make(map[int64][]blockfile.Record)
+			for i, rec := range recs {
+				if h, ok := fileHeight[files[i]]; ok && h != rec.Height {
+					t.Fatalf("%s holds heights %d and %d", files[i], h, rec.Height)
+				}
+				fileHeight[files[i]] = rec.Height
+				if i == 0 || files[i] != files[i-1] {
+					heights = append(heights, rec.Height)
+					last[rec.Height] = nil
+				}
+				last[rec.Height] = append(last[rec.Height], rec)
+			}
+			if got := fmt.Sprint(heights); got != "[1 2 3 4 5 6 6 7 7 7]" {
+				t.Fatalf("file heights in order %s", got)
+			}
+
+			for _, rb := range blocks {
+				h := rb.Block.Header.Number
+				rs := last[h]
+				want := []string{"blocks", "txs", "txs"}
+				if rb.Reason != "" {
+					want = []string{"blocks.quarantine"}
+				}
+				var topics []string
+				for _, r := range rs {
+					topics = append(topics, r.Topic)
+				}
+				if fmt.Sprint(topics) != fmt.Sprint(want) {
+					t.Fatalf("height %d: topics %v want %v", h, topics, want)
+				}
+				if meta, ok := schema.ParseMap(rs[0].Headers); !ok || meta.Name != schema.Block || rs[0].Headers["reason"] != rb.Reason {
+					t.Fatalf("height %d: headers %v", h, rs[0].Headers)
+				}
+				b, err := model.DecodeBlock(rs[0].Value)
+				if err != nil || b.Hash != rb.Block.Hash || rs[0].Key != fmt.Sprint(h) || rs[0].TS != rb.Block.Header.Timestamp {
+					t.Fatalf("height %d: block record key=%s ts=%d err=%v", h, rs[0].Key, rs[0].TS, err)
+				}
+				for i, r := range rs[1:] {
+					var tm TxMessage
+					if err := json.Unmarshal(r.Value, &tm); err != nil || tm.Tx != rb.Block.Txs[i] || r.Key != tm.Tx.TxBody.From {
+						t.Fatalf("height %d tx %d: key=%s err=%v", h, i, r.Key, err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// The stdout sink writes the same records as NDJSON lines, flushed at every sync.
+func TestStreamSink(t *testing.T) {
+	var buf bytes.Buffer
+	s, err := newRecordSink("blocks", ProducerOptions{HeaderTopic: "headers"}, &streamWriter{w: bufio.NewWriter(&buf)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enqueue(t, s, sinkBlocks(1, 2)...)
+	if wm, ok := s.Watermark(); !ok || wm.LastHeight != 2 {
+		t.Fatalf("watermark %+v ok=%v", wm, ok)
+	}
+	var got []string
+	sc := bufio.NewScanner(&buf)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var rec blockfile.Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s/%d", rec.Topic, rec.Height))
+	}
+	if fmt.Sprint(got) != "[blocks/1 headers/1 blocks/2 headers/2]" {
+		t.Fatalf("lines %v", got)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,55 @@
+package fetcher
+
+import (
+	"context"
+	"time"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/ckptstore"
+)
+
+const storeTimeout = 10 * time.Second
+
+// StoreCheckpoint adapts a database checkpoint store (Postgres/SQLite) to Checkpoint, one row per
+// pipeline id. Save fails with ckptstore.ErrConflict if another fetcher wrote the same row.
+type StoreCheckpoint struct {
+	st ckptstore.Store
+	id string
+}
+
+func NewStoreCheckpoint(st ckptstore.Store, pipelineID string) *StoreCheckpoint {
+	return &StoreCheckpoint{st: st, id: pipelineID}
+}
+
+func (c *StoreCheckpoint) Load() (Ckpt, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	rec, ok, err := c.st.Load(ctx, c.id)
+	if err != nil || !ok {
+		return Ckpt{}, false, err
+	}
+	return Ckpt{LastHeight: rec.Height, LastHash: rec.Hash}, true, nil
+}
+
+func (c *StoreCheckpoint) Save(ck Ckpt) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	return c.st.Save(ctx, c.id, ckptstore.Record{Height: ck.LastHeight, Hash: ck.LastHash})
+}
+
+func (c *StoreCheckpoint) Close() error { return c.st.Close() }
+
+// openCheckpoint picks the checkpoint backend from path: a postgres:// or sqlite: DSN, else a file.
+func openCheckpoint(path string, pipelineID string) (Checkpoint, func() error, error) {
+	if !ckptstore.IsDSN(path) {
+		c, err := NewFileCheckpoint(path)
+		return c, func() error { return nil }, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	st, err := ckptstore.Open(ctx, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := NewStoreCheckpoint(st, pipelineID)
+	return c, c.Close, nil
+}
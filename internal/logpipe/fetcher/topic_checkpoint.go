@@ -0,0 +1,141 @@
+package fetcher
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/chunk"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+)
+
+// topicTailLookback is how many records before the high-water mark are read per partition:
+// the very last offsets may be transaction markers or aborted records, which are never delivered.
+const topicTailLookback = 64
+
+// TopicCheckpoint derives the resume point from the blocks topic itself: the last block of every
+// partition. It is the fallback when the checkpoint file is lost; Save is a no-op since the topic
+// already records what was produced. The result must still be validated against the chain.
+type TopicCheckpoint struct {
+	brokers []string
+	topic   string
+}
+
+func NewTopicCheckpoint(brokersCSV string, topic string) (*TopicCheckpoint, error) {
+	if topic == "" {
+		return nil, errors.New("topic empty")
+	}
+	brokers := splitCSV(brokersCSV)
+	if len(brokers) == 0 {
+		return nil, errors.New("no brokers")
+	}
+	return &TopicCheckpoint{brokers: brokers, topic: topic}, nil
+}
+
+// Load returns the lowest of the per-partition tail blocks. Heights are spread over partitions by
+// key and partitions fail independently, so a hole can only sit above the lowest tail: resuming
+// there re-produces the blocks between the lowest and highest tail (at-least-once), never skips one.
+func (c *TopicCheckpoint) Load() (Ckpt, bool, error) {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_1_0_0
+	cfg.Consumer.IsolationLevel = sarama.ReadCommitted
+	cfg.Consumer.Return.Errors = true
+
+	client, err := sarama.NewClient(c.brokers, cfg)
+	if err != nil {
+		return Ckpt{}, false, err
+	}
+	defer client.Close()
+
+	parts, err := client.Partitions(c.topic)
+	if err != nil {
+		if errors.Is(err, sarama.ErrUnknownTopicOrPartition) {
+			return Ckpt{}, false, nil
+		}
+		return Ckpt{}, false, err
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return Ckpt{}, false, err
+	}
+	defer consumer.Close()
+
+	var (
+		out   Ckpt
+		found bool
+	)
+	for _, p := range parts {
+		ck, ok, err := c.partitionTail(client, consumer, p)
+		if err != nil {
+			return Ckpt{}, false, err
+		}
+		if !ok {
+			continue // empty (or fully aborted) partition: says nothing about progress
+		}
+		log.Printf("[ckpt][topic] partition=%d tail_height=%d", p, ck.LastHeight)
+		if !found || ck.LastHeight < out.LastHeight {
+			out, found = ck, true
+		}
+	}
+	return out, found, nil
+}
+
+// partitionTail returns the highest block among the last topicTailLookback records of partition p.
+// A chunked block counts once its last chunk is there; its height and hash come from the chunk headers.
+func (c *TopicCheckpoint) partitionTail(client sarama.Client, consumer sarama.Consumer, p int32) (Ckpt, bool, error) {
+	oldest, err := client.GetOffset(c.topic, p, sarama.OffsetOldest)
+	if err != nil {
+		return Ckpt{}, false, err
+	}
+	end, err := client.GetOffset(c.topic, p, sarama.OffsetNewest)
+	if err != nil {
+		return Ckpt{}, false, err
+	}
+	if end <= oldest {
+		return Ckpt{}, false, nil
+	}
+
+	var (
+		tail  Ckpt
+		found bool
+	)
+	err = scanSettled(c.topic, p, func(idle time.Duration) (int64, bool, error) {
+		tail, found = Ckpt{}, false
+		return scanPartition(consumer, c.topic, p, max(oldest, end-topicTailLookback), end, idle, func(msg *sarama.ConsumerMessage) {
+			var ck Ckpt
+			if m, ok, err := chunk.Parse(msg.Headers); err != nil {
+				log.Printf("[ckpt][topic] partition=%d offset=%d %v (skip)", p, msg.Offset, err)
+				return
+			} else if ok {
+				if m.Index != m.Count-1 {
+					return
+				}
+				ck = Ckpt{LastHeight: m.Block, LastHash: m.Hash}
+			} else {
+				num, h, err := model.BlockID(headerValue(msg.Headers, model.HeaderContentType), msg.Value)
+				if err != nil {
+					log.Printf("[ckpt][topic] partition=%d offset=%d decode err=%v (skip)", p, msg.Offset, err)
+					return
+				}
+				ck = Ckpt{LastHeight: num, LastHash: h.Hex()}
+			}
+			if !found || ck.LastHeight > tail.LastHeight {
+				tail, found = ck, true
+			}
+		})
+	})
+	return tail, found, err
+}
+
+func (c *TopicCheckpoint) Save(Ckpt) error { return nil }
+
+func headerValue(hs []*sarama.RecordHeader, key string) string {
+	for _, h := range hs {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// TxnProducer writes each run of blocks plus the checkpoint record covering it in one Kafka
+// transaction. Either both become visible to read_committed consumers or neither does, so a crash
+// anywhere re-produces nothing that was already committed: exactly-once on the blocks topic.
+type TxnProducer struct {
+	out  outputs
+	sp   sarama.SyncProducer
+	ckpt *KafkaCheckpoint
+
+	mu        sync.Mutex
+	committed Ckpt
+	hasCommit bool
+}
+
+// NewTxnProducer starts a transactional producer. txnID must be stable across restarts of the same
+// fetcher: initializing it fences any zombie instance and aborts its unfinished transaction.
+func NewTxnProducer(brokersCSV string, topic string, txnID string, ckpt *KafkaCheckpoint, opts ProducerOptions) (*TxnProducer, error) {
+	if topic == "" || opts.QuarantineTopic == "" {
+		return nil, errors.New("topic/quarantine topic empty")
+	}
+	if txnID == "" {
+		return nil, errors.New("transactional id empty")
+	}
+	brokers := splitCSV(brokersCSV)
+	if len(brokers) == 0 {
+		return nil, errors.New("no brokers")
+	}
+	out, err := newOutputs(topic, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Compression == "" {
+		opts.Compression = "lz4"
+	}
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_1_0_0
+
+	// transactions require idempotence + acks=all + one in-flight request per broker
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Idempotent = true
+	cfg.Net.MaxOpenRequests = 1
+	cfg.Producer.Transaction.ID = txnID
+	cfg.Producer.Transaction.Timeout = time.Minute
+	cfg.Producer.Retry.Max = 10
+	cfg.Producer.Retry.Backoff = 200 * time.Millisecond
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Return.Errors = true
+
+	if err := cfg.Producer.Compression.UnmarshalText([]byte(opts.Compression)); err != nil {
+		return nil, err
+	}
+
+	sp, err := sarama.NewSyncProducer(brokers, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &TxnProducer{out: out, sp: sp, ckpt: ckpt}, nil
+}
+
+// Commit produces blocks (consecutive, ascending; quarantined ones to the quarantine topic) and the
+// checkpoint of the last one atomically. On error the transaction is aborted and nothing of it is
+// visible; retry from the same height.
+func (p *TxnProducer) Commit(ctx context.Context, blocks []routedBlock) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	last := blocks[len(blocks)-1].Block
+	ck := Ckpt{LastHeight: last.Header.Number, LastHash: last.Hash.Hex()}
+
+	msgs := make([]*sarama.ProducerMessage, 0, len(blocks)+1)
+	for _, rb := range blocks {
+		bm, err := p.out.messages(rb)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, bm...)
+	}
+	cm, err := p.ckpt.message(ck)
+	if err != nil {
+		return err
+	}
+	msgs = append(msgs, cm)
+
+	if err := p.sp.BeginTxn(); err != nil {
+		return fmt.Errorf("begin txn: %w", err)
+	}
+	if err := p.sp.SendMessages(msgs); err != nil {
+		return p.abort(fmt.Errorf("produce heights=%d..%d: %w", blocks[0].Block.Header.Number, ck.LastHeight, err))
+	}
+	if err := p.sp.CommitTxn(); err != nil {
+		return p.abort(fmt.Errorf("commit txn height=%d: %w", ck.LastHeight, err))
+	}
+
+	p.mu.Lock()
+	p.committed, p.hasCommit = ck, true
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *TxnProducer) abort(cause error) error {
+	if p.Fatal() {
+		return cause
+	}
+	if err := p.sp.AbortTxn(); err != nil {
+		log.Printf("[producer][txn] abort err: %v", err)
+		return errors.Join(cause, err)
+	}
+	return cause
+}
+
+// Fatal reports that the producer can no longer be used (e.g. fenced by a newer instance with the
+// same transactional id); the fetcher must exit instead of retrying.
+func (p *TxnProducer) Fatal() bool {
+	return p.sp.TxnStatus()&sarama.ProducerTxnFlagFatalError != 0
+}
+
+// Committed returns the checkpoint of the last transaction committed by this process.
+func (p *TxnProducer) Committed() (Ckpt, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.committed, p.hasCommit
+}
+
+func (p *TxnProducer) Close() error {
+	if p.sp == nil {
+		return nil
+	}
+	return p.sp.Close()
+}
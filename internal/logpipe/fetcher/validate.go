@@ -0,0 +1,124 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+)
+
+// Quarantine reason codes (Kafka header "reason" on the quarantine topic).
+const (
+	ReasonHeaderHash  = "bad_header_hash"   // Hash != HashHeader(Header)
+	ReasonTxHash      = "bad_tx_hash"       // some tx Hash != HashTxCanonical(TxBody)
+	ReasonTxBlockNum  = "bad_tx_block_num"  // some tx BlockNum != Header.Number
+	ReasonTxRoot      = "bad_tx_root"       // Header.TxRoot != TxRoot(tx hashes)
+	ReasonParentHash  = "parent_mismatch"   // Header.ParentHash != hash of block Number-1
+	ReasonTimestampDn = "timestamp_regress" // Header.Timestamp < timestamp of block Number-1
+
+	// not a bad block: a fan-out record of it too large to produce (headers only, see outputs.oversized)
+	ReasonOversized = "oversized_record"
+)
+
+// routedBlock is a block on its way out: Reason empty means the main topic, else the quarantine topic.
+type routedBlock struct {
+	Block  model.Block
+	Reason string
+	Detail string
+}
+
+// validateBlock recomputes everything a block commits to. It does not look at neighbours.
+func validateBlock(b model.Block) (reason, detail string) {
+	hashes := make([]hash.Hash32, 0, len(b.Txs))
+	for i, tx := range b.Txs {
+		if got := model.HashTxCanonical(tx.TxBody); got != tx.Hash {
+			return ReasonTxHash, fmt.Sprintf("tx[%d] hash=%s recomputed=%s", i, tx.Hash.Hex(), got.Hex())
+		}
+		if tx.BlockNum != b.Header.Number {
+			return ReasonTxBlockNum, fmt.Sprintf("tx[%d] block_num=%d header=%d", i, tx.BlockNum, b.Header.Number)
+		}
+		hashes = append(hashes, tx.Hash)
+	}
+	if got := model.TxRoot(hashes); got != b.Header.TxRoot {
+		return ReasonTxRoot, fmt.Sprintf("tx_root=%s recomputed=%s txs=%d", b.Header.TxRoot.Hex(), got.Hex(), len(b.Txs))
+	}
+	if got := model.HashHeader(b.Header); got != b.Hash {
+		return ReasonHeaderHash, fmt.Sprintf("hash=%s recomputed=%s", b.Hash.Hex(), got.Hex())
+	}
+	return "", ""
+}
+
+// blockLink is the last block accepted for height num: the parent of num+1.
+type blockLink struct {
+	num  int64
+	hash hash.Hash32
+	ts   int64
+	ok   bool
+}
+
+// checkLink verifies b against its parent: ParentHash continuity and non-decreasing timestamps.
+// The parent normally is the previous block of the run; after a rewind/restart it is fetched once.
+func (f *Fetcher) checkLink(ctx context.Context, b model.Block) (reason, detail string) {
+	h := b.Header.Number
+	if !f.link.ok || f.link.num != h-1 {
+		f.link = blockLink{}
+		if h > 1 {
+			parent, err := f.rpc.BlockByNumber(ctx, h-1)
+			if err != nil {
+				log.Printf("[fetcher] parent of %d unavailable, continuity not checked: %v", h, err)
+				return "", ""
+			}
+			f.link = blockLink{num: h - 1, hash: parent.Hash, ts: parent.Header.Timestamp, ok: true}
+		}
+	}
+	if !f.link.ok {
+		return "", ""
+	}
+	if b.Header.ParentHash != f.link.hash {
+		return ReasonParentHash, fmt.Sprintf("parent_hash=%s block[%d].hash=%s", b.Header.ParentHash.Hex(), f.link.num, f.link.hash.Hex())
+	}
+	if b.Header.Timestamp < f.link.ts {
+		return ReasonTimestampDn, fmt.Sprintf("timestamp=%d block[%d].timestamp=%d", b.Header.Timestamp, f.link.num, f.link.ts)
+	}
+	return "", ""
+}
+
+// route validates b and decides where it goes. held=true means "don't produce yet, refetch":
+// a block is only quarantined after failing the same way QuarantineAfter fetches in a row, so a
+// transient bad response (or a bad endpoint in the pool) doesn't cost us a block on the main topic.
+func (f *Fetcher) route(ctx context.Context, b model.Block) (rb routedBlock, held bool) {
+	reason, detail := validateBlock(b)
+	if reason == "" {
+		reason, detail = f.checkLink(ctx, b)
+	}
+	h := b.Header.Number
+
+	if reason == "" {
+		f.suspect = suspectBlock{}
+		f.link = blockLink{num: h, hash: b.Hash, ts: b.Header.Timestamp, ok: true}
+		return routedBlock{Block: b}, false
+	}
+
+	if f.suspect.num != h || f.suspect.reason != reason {
+		f.suspect = suspectBlock{num: h, reason: reason}
+	}
+	f.suspect.seen++
+	if f.suspect.seen < f.cfg.QuarantineAfter {
+		log.Printf("[fetcher] invalid block, refetching: height=%d reason=%s %s (seen=%d)", h, reason, detail, f.suspect.seen)
+		return routedBlock{}, true
+	}
+
+	log.Printf("[fetcher] QUARANTINE height=%d reason=%s %s", h, reason, detail)
+	f.suspect = suspectBlock{}
+	// the child links to what the header commits to, even if the block's Hash field is the broken part
+	f.link = blockLink{num: h, hash: model.HashHeader(b.Header), ts: b.Header.Timestamp, ok: true}
+	return routedBlock{Block: b, Reason: reason, Detail: detail}, false
+}
+
+type suspectBlock struct {
+	num    int64
+	reason string
+	seen   int
+}
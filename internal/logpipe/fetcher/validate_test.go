@@ -0,0 +1,108 @@
+package fetcher
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+)
+
+func TestValidateBlock(t *testing.T) {
+	cases := []struct {
+		name   string
+		mutate func(b *model.Block)
+		want   string
+	}{
+		{"valid", func(b *model.Block) {}, ""},
+		{"tx body", func(b *model.Block) { b.Txs[1].TxBody.Amount++ }, ReasonTxHash},
+		{"tx hash", func(b *model.Block) { b.Txs[0].Hash[0] ^= 1 }, ReasonTxHash},
+		{"tx block num", func(b *model.Block) { b.Txs[1].BlockNum = 4 }, ReasonTxBlockNum},
+		{"tx dropped", func(b *model.Block) { b.Txs = b.Txs[:1] }, ReasonTxRoot},
+		{"tx root", func(b *model.Block) { b.Header.TxRoot[0] ^= 1 }, ReasonTxRoot},
+		{"header field", func(b *model.Block) { b.Header.Timestamp++ }, ReasonHeaderHash},
+		{"block hash", func(b *model.Block) { b.Hash[31] ^= 1 }, ReasonHeaderHash},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b := fanoutBlock(5, "0xa", "0xc")
+			c.mutate(&b)
+			if reason, detail := validateBlock(b); reason != c.want {
+				t.Fatalf("reason=%q (%s) want %q", reason, detail, c.want)
+			}
+		})
+	}
+}
+
+// A block failing validation or its parent link is refetched, then quarantined if it fails the
+// same way again; its child links to it all the same. Quarantined blocks go to the quarantine
+// topic with the reason in the headers.
+func TestRouteQuarantine(t *testing.T) {
+	node := newFakeNode(t, 100)
+	f := &Fetcher{cfg: Config{QuarantineAfter: 2}, rpc: newTestPool(t, PoolOptions{}, node)}
+	o := testOutputs(t, ProducerOptions{})
+	ctx := context.Background()
+
+	child := func(parent model.Block, ts int64) model.Block {
+		return model.BuildBlock("", parent.Header.Number+1, model.HashHeader(parent.Header), nil, ts, 7)
+	}
+	// route b until it is not held; wantHeld is how many fetches it takes
+	route := func(b model.Block, wantHeld int) routedBlock {
+		t.Helper()
+		for i := 0; ; i++ {
+			rb, held := f.route(ctx, b)
+			if !held {
+				if i != wantHeld {
+					t.Fatalf("height %d: held %d times want %d", b.Header.Number, i, wantHeld)
+				}
+				return rb
+			}
+		}
+	}
+	deliver := func(rb routedBlock, topic, reason, detail string) {
+		t.Helper()
+		msgs, err := o.messages(rb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range msgs {
+			if m.Topic != topic || header(m, "reason") != reason || !strings.Contains(header(m, "detail"), detail) {
+				t.Fatalf("height %d: topic=%s reason=%q detail=%q, want %s %q %q",
+					rb.Block.Header.Number, m.Topic, header(m, "reason"), header(m, "detail"), topic, reason, detail)
+			}
+		}
+	}
+
+	// first block of the run: its parent comes from the RPC
+	b11 := child(node.block(10), 1011)
+	deliver(route(b11, 0), "blocks", "", "")
+
+	b12 := model.BuildBlock("", 12, hash.Hash32{1}, nil, 1012, 7)
+	deliver(route(b12, 1), "blocks.quarantine", ReasonParentHash, "block[11]")
+
+	b13 := child(b12, 1013)
+	deliver(route(b13, 0), "blocks", "", "")
+
+	b14 := child(b13, 1000)
+	deliver(route(b14, 1), "blocks.quarantine", ReasonTimestampDn, "timestamp=1000")
+
+	// broken tx root: the child still links to the hash the header commits to
+	b15 := child(b14, 1015)
+	b15.Header.TxRoot[0] ^= 1
+	deliver(route(b15, 1), "blocks.quarantine", ReasonTxRoot, "tx_root=")
+	b16 := child(b15, 1016)
+	deliver(route(b16, 0), "blocks", "", "")
+
+	// bad once, then good: a transient bad response costs a refetch, not the block
+	b17 := child(b16, 1017)
+	bad := b17
+	bad.Hash = hash.Hash32{}
+	if _, held := f.route(ctx, bad); !held {
+		t.Fatal("bad block not held for a refetch")
+	}
+	deliver(route(b17, 0), "blocks", "", "")
+	if f.suspect != (suspectBlock{}) {
+		t.Fatalf("suspect %+v left after a good fetch", f.suspect)
+	}
+}
@@ -0,0 +1,93 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Anchor is where the compute stage (Tail) starts in the spool, and the ring numbering it starts
+// with there. Every processor on a spool starts from the same anchor file, so the windows count
+// the same txs from the same base and a standby's win_tick Head/Tail are the leader's.
+//
+// The leader moves it along with the longest window's tail (Ingestor.Anchor): a standby started
+// later then rebuilds exactly the longest window, numbered as the leader numbers it.
+type Anchor struct {
+	Cursor uint64 `json:"cursor"`  // spool seq of the first block read
+	Seq    int64  `json:"seq"`     // its ring seq (RawMsg.Seq)
+	TxBase int64  `json:"tx_base"` // tx index of its first tx (rbTxSum before it)
+	Open   bool   `json:"open"`    // the longest window was already full from this block on
+}
+
+// LoadAnchor reads the anchor file; ok=false if there is none.
+func LoadAnchor(path string) (Anchor, bool, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Anchor{}, false, nil
+	}
+	if err != nil {
+		return Anchor{}, false, err
+	}
+	var a Anchor
+	if err := json.Unmarshal(b, &a); err != nil {
+		return Anchor{}, false, fmt.Errorf("anchor %s: %w", path, err)
+	}
+	return a, true, nil
+}
+
+// InitAnchor returns the anchor at path, creating it as a if there is none yet. Of processors
+// starting together exactly one creates it; the others get its anchor.
+func InitAnchor(path string, a Anchor) (Anchor, error) {
+	if cur, ok, err := LoadAnchor(path); err != nil || ok {
+		return cur, err
+	}
+	tmp, err := writeAnchorTemp(path, a)
+	if err != nil {
+		return Anchor{}, err
+	}
+	defer os.Remove(tmp)
+	// link, unlike rename, fails when path exists: the first one wins, whole
+	if err := os.Link(tmp, path); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			return Anchor{}, err
+		}
+		cur, ok, err := LoadAnchor(path)
+		if err == nil && !ok {
+			err = fmt.Errorf("anchor %s: gone while created", path)
+		}
+		return cur, err
+	}
+	return a, syncDir(filepath.Dir(path))
+}
+
+// SaveAnchor replaces the anchor file: a crash leaves the old anchor or the new one.
+func SaveAnchor(path string, a Anchor) error {
+	tmp, err := writeAnchorTemp(path, a)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func writeAnchorTemp(path string, a Anchor) (string, error) {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(b)
+	if err = errors.Join(err, f.Sync(), f.Close()); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
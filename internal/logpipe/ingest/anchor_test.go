@@ -0,0 +1,136 @@
+package ingest
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/dispatcher"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/ids"
+	mc "github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+)
+
+func TestAnchorFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "compute.anchor")
+	if _, ok, err := LoadAnchor(path); ok || err != nil {
+		t.Fatalf("ok=%v err=%v on a new spool", ok, err)
+	}
+
+	// processors starting together: one creates it, all start from it
+	var wg sync.WaitGroup
+	got := make([]Anchor, 8)
+	for i := range got {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a, err := InitAnchor(path, Anchor{Cursor: uint64(100 + i)})
+			if err != nil {
+				t.Error(err)
+			}
+			got[i] = a
+		}()
+	}
+	wg.Wait()
+	for _, a := range got {
+		if a != got[0] {
+			t.Fatalf("processors start from different anchors: %v", got)
+		}
+	}
+
+	want := Anchor{Cursor: 7, Seq: 3, TxBase: 12, Open: true}
+	if err := SaveAnchor(path, want); err != nil {
+		t.Fatal(err)
+	}
+	if a, err := InitAnchor(path, Anchor{Cursor: 1}); err != nil || a != want {
+		t.Fatalf("InitAnchor=%+v, %v: want the saved %+v", a, err, want)
+	}
+	if left, _ := filepath.Glob(path + ".*.tmp"); len(left) != 0 {
+		t.Fatalf("temp files left: %v", left)
+	}
+}
+
+// anchorSpool appends blocks from..to-1, 30000s apart (about 3 per longest window) with 1-3 txs each.
+func anchorSpool(t *testing.T, dir string, from, to int64) {
+	t.Helper()
+	s := openTestSpool(t, dir, SpoolOptions{})
+	defer s.Close()
+	for i := from; i < to; i++ {
+		var txs []mc.Tx
+		for j := range i%3 + 1 {
+			txs = append(txs, mc.BuildTx(mc.TxBody{From: "0xa", To: "0xb", Token: "MOCK", Amount: j + 1, Timestamp: 30000 * i, Nonce: uint64(j)}, i))
+		}
+		raw, err := mc.EncodeBlock(mc.BuildBlock("", i, hash.Hash32{}, txs, 30000*i, 7))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Append(SpoolRecord{Partition: 0, Offset: i, Timestamp: 30000 * i * 1000, Value: raw}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+type winMoves map[uint64][4]dispatcher.TxWinMarginInfo
+
+// tailMoves tails dir from at with a real decoder; the returned func waits for the window moves of
+// n more blocks and records them by spool cursor. disp is shared between calls: its tx log is the
+// size of the longest window.
+func tailMoves(t *testing.T, disp *dispatcher.Dispatcher, dir string, at Anchor) (*Ingestor, winMoves, func(n int)) {
+	t.Helper()
+	adapter := NewMockChainAdapter(ids.NewAddressID(4, 16), ids.NewTokenID(4, 16))
+	ig := NewIngestor("", disp, nil, 2, 4, adapter, nil, "blocks", nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		_ = ig.Close()
+	})
+	go func() { _ = ig.Tail(ctx, dir, at) }()
+
+	moves := make(winMoves)
+	return ig, moves, func(n int) {
+		t.Helper()
+		for range n {
+			var mv [4]dispatcher.TxWinMarginInfo
+			for w := range mv {
+				select {
+				case mv[w] = <-disp.WinMoveCh(w):
+				case <-time.After(5 * time.Second):
+					t.Fatalf("stuck after %d moves", len(moves))
+				}
+			}
+			moves[mv[0].Cursor] = mv
+		}
+	}
+}
+
+// A processor started later, from the anchor the first one saved, computes the same tx indexes
+// and window bounds as the first one for every block from there on: they emit the same ticks.
+func TestTailFromAnchor(t *testing.T) {
+	dir := t.TempDir()
+	disp := dispatcher.NewDispatcher(4)
+	anchorSpool(t, dir, 0, 20)
+
+	ig, first, next := tailMoves(t, disp, dir, Anchor{})
+	next(20)
+	at, ok := ig.Anchor()
+	if !ok || at.Cursor == 0 || !at.Open {
+		t.Fatalf("anchor=%+v ok=%v: want the longest window's tail, window open", at, ok)
+	}
+	if last := first[19][3]; at.Seq != int64(at.Cursor) || at.TxBase != last.TxTail {
+		t.Fatalf("anchor=%+v, last move of the longest window: %+v", at, last)
+	}
+	anchorSpool(t, dir, 20, 30)
+	next(10)
+
+	_, later, laterNext := tailMoves(t, disp, dir, at)
+	laterNext(30 - int(at.Cursor))
+	// before block 19 the first processor's longest window reached back past the anchor; it has
+	// emitted those ticks already
+	for cur := uint64(19); cur < 30; cur++ {
+		if later[cur] != first[cur] {
+			t.Fatalf("cursor=%d: later %+v, first %+v", cur, later[cur], first[cur])
+		}
+	}
+}
@@ -1,16 +1,19 @@
 package ingest
 
 import (
-	"encoding/json"
+	"fmt"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/IBM/sarama"
 
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/chunk"
 	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/dispatcher"
 	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/event"
 	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/ready"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/schema"
 	mc "github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
 )
 
@@ -18,13 +21,20 @@ const MaxGroutines = 20
 
 type RawMsg struct {
 	Partition int32
-	Offset    int64
+	Offset    int64  // last record of the block (chunked blocks span several offsets)
+	Seq       int64  // contiguous block sequence within the partition since Tail started; drives the ring
+	Cursor    uint64 // spool seq (record index for file replay); tags the outputs, see package leader
 	Value     []byte
+
+	// ContentType of Value (model.ContentTypeJSON / ContentTypeBinary); empty means sniff the
+	// format (spool records, records from before the header existed).
+	ContentType string
 }
 
 type BlockWinMarginInfo struct {
 	blockTs     int64
 	relativeIdx int64
+	cursor      uint64 // spool seq (Anchor)
 }
 
 type Ingestor struct {
@@ -37,6 +47,11 @@ type Ingestor struct {
 	rawCh chan RawMsg
 	wg    sync.WaitGroup
 
+	// compute stage (Tail): woken by ConsumeClaim after each append, stopped by Close
+	wake   chan struct{}
+	stop   chan struct{}
+	tailWG sync.WaitGroup
+
 	maxWindowBlocks uint32
 	blockTail       []uint32
 	winTs           []int64
@@ -48,17 +63,35 @@ type Ingestor struct {
 	rbBlockInfo  *[dispatcher.MaxBlocksPerWindow]BlockWinMarginInfo
 	rbTxSum      int64
 
+	// start: where Tail began (ring seq, tx base); anchor: the longest window's tail block, set in
+	// the rbInCh critical section like setLast
+	start    Anchor
+	anchorMu sync.Mutex
+	anchor   Anchor
+	anchored bool
+
 	// --- offsets / cold-start observability ---
 	offMu             sync.RWMutex
 	firstOffsetByPart map[int32]int64
 	firstSeenByPart   map[int32]bool
+	seqByPart         map[int32]int64
 
 	setupAt time.Time // set in Setup()
 
+	// last block handed to the dispatcher (processor checkpoint)
+	lastMu   sync.Mutex
+	lastNum  int64
+	lastHash string
+
 	client sarama.Client
 	topic  string
 
 	adapter *MockChainAdapter
+
+	// records whose schema headers the catalog rejects, and chunked blocks that reassemble into
+	// something other than the block their headers name, go to dlq (nil: logged and dropped)
+	catalog *schema.Catalog
+	dlq     *schema.DLQ
 }
 
 func NewIngestor(
@@ -70,6 +103,8 @@ func NewIngestor(
 	adapter *MockChainAdapter,
 	client sarama.Client,
 	topic string,
+	catalog *schema.Catalog,
+	dlq *schema.DLQ,
 ) *Ingestor {
 	if workerN <= 0 {
 		workerN = MaxGroutines
@@ -83,12 +118,17 @@ func NewIngestor(
 		disp:      disp,
 		spool:     spool,
 		rawCh:     make(chan RawMsg, chSize),
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
 		adapter:   adapter,
 		client:    client,
 		topic:     topic,
+		catalog:   catalog,
+		dlq:       dlq,
 
 		firstOffsetByPart: make(map[int32]int64),
 		firstSeenByPart:   make(map[int32]bool),
+		seqByPart:         make(map[int32]int64),
 
 		// 需求 1：blockTail 赋值为 4 个 0
 		blockTail: make([]uint32, 4),
@@ -119,37 +159,184 @@ func NewIngestor(
 }
 
 func (ig *Ingestor) Close() error {
+	close(ig.stop)
+	ig.tailWG.Wait() // Tail may still be sending on rawCh
 	close(ig.rawCh)
 	ig.wg.Wait()
+	if ig.spool == nil {
+		return nil
+	}
 	return ig.spool.Close()
 }
 
-// ConsumeClaim：只做 barrier + commit + 投递，不做任何窗口/图计算
+// SetSpool gives the Kafka reader (ConsumeClaim) its spool: a standby processor has none until it
+// leads. Call before consuming.
+func (ig *Ingestor) SetSpool(sp Spool) {
+	ig.spool = sp
+}
+
+// ConsumeClaim 是 reader stage：Kafka -> spool，只做 schema 检查 + 拼 chunk + 落盘 + commit。
+// 计算侧只认 spool（见 Tail），所以 rebalance 不会碰到窗口状态。
+// 一个 block 整体落盘之后才 mark 它的最后一条 record：chunk 拼到一半崩了，重启会从头重读这个 block。
+// 落盘是 group commit（FileSpool）：append 不等 fsync，mark 在 durable 回调里做，顺序不变。
 func (ig *Ingestor) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	var (
+		asm     chunk.Assembler
+		failMu  sync.Mutex
+		failErr error
+	)
+	failed := func() error {
+		failMu.Lock()
+		defer failMu.Unlock()
+		return failErr
+	}
 	for msg := range claim.Messages() {
-		if err := ig.spool.Append(msg.Partition, msg.Offset, msg.Value); err != nil {
-			log.Printf("[ingest] spool append failed: p=%d off=%d err=%v", msg.Partition, msg.Offset, err)
+		if err := failed(); err != nil {
+			// 不能跳过：后面的 mark 会把它一起 commit 掉。结束 session，从 spool 的尾巴重读
+			return err
+		}
+		if err := ig.checkSchema(schema.Parse(msg.Headers)); err != nil {
+			if err := ig.reject(sess, msg, err); err != nil {
+				return err
+			}
 			continue
 		}
 
-		sess.MarkMessage(msg, "")
-
-		// 推荐：拷贝 Value，避免生命周期/复用风险
-		val := make([]byte, len(msg.Value))
-		copy(val, msg.Value)
+		ct := headerValue(msg.Headers, mc.HeaderContentType)
+		val, ok, bad := ig.reassemble(&asm, msg, ct)
+		if bad != nil {
+			// 拼出来的不是 header 说的那个 block：整块进 DLQ，和 schema reject 一样
+			if err := ig.reject(sess, deadBlock(msg, val), bad); err != nil {
+				return err
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
 
-		rm := RawMsg{
+		// spool 里存整块 payload：JSON / binary 都能靠内容自识别（model.DecodeBlock），不需要 header
+		ig.spool.AppendAsync(SpoolRecord{
 			Partition: msg.Partition,
 			Offset:    msg.Offset,
+			Timestamp: msg.Timestamp.UnixMilli(),
 			Value:     val,
-		}
+		}, func(err error) {
+			if err != nil {
+				failMu.Lock()
+				if failErr == nil {
+					failErr = fmt.Errorf("spool append p=%d off=%d: %w", msg.Partition, msg.Offset, err)
+				}
+				failMu.Unlock()
+				return
+			}
+			sess.MarkMessage(msg, "")
+			ig.wakeTail()
+		})
+	}
+	return failed()
+}
 
-		// 背压：直接阻塞写最清晰
-		ig.rawCh <- rm
+// reject sends msg to the DLQ (or drops it when there is none) and marks it.
+func (ig *Ingestor) reject(sess sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage, reason error) error {
+	if ig.dlq == nil {
+		log.Printf("[ingest][warn] p=%d off=%d %v: dropped (no dlq)", msg.Partition, msg.Offset, reason)
+	} else if err := ig.dlq.SendRetry(sess.Context(), msg, reason); err != nil {
+		return err // session over; not marked, so it is redelivered
 	}
+	// the mark covers every earlier offset: those must be in the spool first
+	if err := ig.spool.WaitDurable(); err != nil {
+		return err
+	}
+	sess.MarkMessage(msg, "")
 	return nil
 }
 
+// deadBlock is the DLQ record for a reassembled block: the whole payload at the offset of its last
+// chunk, without the chunk headers (the reason names what they claimed).
+func deadBlock(last *sarama.ConsumerMessage, whole []byte) *sarama.ConsumerMessage {
+	dead := *last
+	dead.Value = whole
+	dead.Headers = make([]*sarama.RecordHeader, 0, len(last.Headers))
+	for _, h := range last.Headers {
+		if h == nil {
+			continue
+		}
+		switch string(h.Key) {
+		case chunk.HeaderBlock, chunk.HeaderIndex, chunk.HeaderCount, chunk.HeaderHash:
+			continue
+		}
+		dead.Headers = append(dead.Headers, h)
+	}
+	return &dead
+}
+
+// reassemble returns a whole block payload (a private copy), or ok=false while chunks are pending
+// or when a broken chunk sequence was dropped. Seq is only assigned to whole blocks, so the ring
+// never waits on a block that won't come.
+// bad!=nil: the chunks reassembled into val, but it is not the block the headers name.
+func (ig *Ingestor) reassemble(asm *chunk.Assembler, msg *sarama.ConsumerMessage, contentType string) (val []byte, ok bool, bad error) {
+	m, chunked, err := chunk.Parse(msg.Headers)
+	if err != nil {
+		log.Printf("[ingest] p=%d off=%d %v (skip)", msg.Partition, msg.Offset, err)
+		return nil, false, nil
+	}
+	if !chunked {
+		if asm.Pending() {
+			log.Printf("[ingest][warn] p=%d off=%d whole record inside a chunked block: partial block dropped", msg.Partition, msg.Offset)
+			asm.Reset()
+		}
+		// 推荐：拷贝 Value，避免生命周期/复用风险
+		val := make([]byte, len(msg.Value))
+		copy(val, msg.Value)
+		return val, true, nil
+	}
+
+	whole, done, err := asm.Add(m, msg.Value)
+	if err != nil {
+		log.Printf("[ingest][warn] p=%d off=%d %v", msg.Partition, msg.Offset, err)
+	}
+	if !done {
+		return nil, false, nil
+	}
+
+	// the chunk headers name the block: check before it enters the ring
+	num, h, err := mc.BlockID(contentType, whole)
+	if err != nil {
+		return whole, false, fmt.Errorf("reassembled block=%d (%d chunks) does not decode: %w", m.Block, m.Count, err)
+	}
+	if num != m.Block || !strings.EqualFold(h.Hex(), m.Hash) {
+		return whole, false, fmt.Errorf("reassembled block=%d hash=%s (%d chunks) is number=%d hash=%s", m.Block, m.Hash, m.Count, num, h.Hex())
+	}
+	return whole, true, nil
+}
+
+// checkSchema: nil when the record can be read. The blocks topic has one schema, so records without
+// schema headers (written before they existed) are accepted as legacy.
+func (ig *Ingestor) checkSchema(m schema.Meta, ok bool) error {
+	if !ok || ig.catalog == nil {
+		return nil
+	}
+	return ig.catalog.Check(m)
+}
+
+func headerValue(hs []*sarama.RecordHeader, key string) string {
+	for _, h := range hs {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (ig *Ingestor) nextSeq(part int32) int64 {
+	ig.offMu.Lock()
+	defer ig.offMu.Unlock()
+	seq := ig.seqByPart[part]
+	ig.seqByPart[part] = seq + 1
+	return seq
+}
+
 func (ig *Ingestor) getFirstOffset(part int32) (int64, bool) {
 	ig.offMu.RLock()
 	defer ig.offMu.RUnlock()
@@ -175,7 +362,7 @@ func (ig *Ingestor) decodeLoop() {
 		// 0) base offset (per partition)
 		base, ok := ig.getFirstOffset(rawMsg.Partition)
 		if !ok {
-			// fallback: should not happen if Setup ran and ResetOffset succeeded.
+			// fallback: should not happen, Tail / ReplayFiles set it before the first message.
 			// keep non-fatal: use current msg offset as base to avoid negative reOffset.
 			base = rawMsg.Offset
 			ig.offMu.Lock()
@@ -187,21 +374,16 @@ func (ig *Ingestor) decodeLoop() {
 
 		ig.markFirstSeen(rawMsg.Partition, rawMsg.Offset, base)
 
-		var blk mc.Block
-		if err := json.Unmarshal(rawMsg.Value, &blk); err != nil {
+		blk, err := mc.DecodeBlockAs(rawMsg.ContentType, rawMsg.Value)
+		if err != nil {
 			log.Printf("[ingest] decode block failed: p=%d off=%d err=%v", rawMsg.Partition, rawMsg.Offset, err)
 			continue
 		}
 		ig.testLog.Do(func() {
 			log.Printf("[ingest] blk head first %d.", blk.Header.Number)
 		})
-		reOffset := rawMsg.Offset - base
-		if reOffset < 0 {
-			// should not happen; indicates base is wrong or offset rewind
-			log.Printf("[ingest][warn] reOffset<0: topic=%s p=%d off=%d base=%d re=%d",
-				ig.topic, rawMsg.Partition, rawMsg.Offset, base, reOffset)
-			continue
-		}
+		// 环形缓冲按连续的 block 序号推进：chunk / 事务 marker 会让 offset 不连续
+		reOffset := rawMsg.Seq
 
 		reIdx := reOffset % dispatcher.MaxBlocksPerWindow
 
@@ -209,10 +391,12 @@ func (ig *Ingestor) decodeLoop() {
 
 		curRbTxSum := ig.rbTxSum
 		ig.rbTxSum += int64(len(blk.Txs))
+		ig.setLast(blk.Header.Number, blk.Hash.Hex())
 
 		ig.rbBlockInfo[reIdx] = BlockWinMarginInfo{
 			blockTs:     blk.Header.Timestamp,
 			relativeIdx: curRbTxSum,
+			cursor:      rawMsg.Cursor,
 		}
 
 		openWin := false
@@ -222,7 +406,10 @@ func (ig *Ingestor) decodeLoop() {
 			}
 			ig.blockTail[idx] = tail
 			if idx == len(ig.blockTail)-1 {
-				openWin = tail != 0
+				// the tail left the first block: a full window behind us (or the anchor had one)
+				openWin = ig.start.Open || tail != uint32(ig.start.Seq)
+				info := ig.rbBlockInfo[tail%dispatcher.MaxBlocksPerWindow]
+				ig.setAnchor(Anchor{Cursor: info.cursor, Seq: int64(tail), TxBase: info.relativeIdx, Open: openWin})
 			}
 		}
 
@@ -247,7 +434,7 @@ func (ig *Ingestor) decodeLoop() {
 
 		<-ig.rbOutCh[reOffset%MaxGroutines]
 
-		ig.disp.WinMove(curTxTail, curTxHead, openWin)
+		ig.disp.WinMove(curTxTail, curTxHead, openWin, rawMsg.Cursor)
 
 		if reOffset%100 == 0 {
 			log.Printf("[ingest] p=%d off=%d base=%d re=%d blk=%d tx=%d rawCh=%d",
@@ -258,9 +445,39 @@ func (ig *Ingestor) decodeLoop() {
 	}
 }
 
+// setLast 在 rbInCh 临界区内调用，天然按 offset 顺序
+func (ig *Ingestor) setLast(num int64, hash string) {
+	ig.lastMu.Lock()
+	ig.lastNum, ig.lastHash = num, hash
+	ig.lastMu.Unlock()
+}
+
+func (ig *Ingestor) setAnchor(a Anchor) {
+	ig.anchorMu.Lock()
+	ig.anchor, ig.anchored = a, true
+	ig.anchorMu.Unlock()
+}
+
+// Anchor returns the block at the tail of the longest window, as a start for Tail; ok=false before
+// the first block.
+func (ig *Ingestor) Anchor() (Anchor, bool) {
+	ig.anchorMu.Lock()
+	defer ig.anchorMu.Unlock()
+	return ig.anchor, ig.anchored
+}
+
+// LastBlock returns the last block handed to the dispatcher; ok=false before the first one.
+func (ig *Ingestor) LastBlock() (num int64, hash string, ok bool) {
+	ig.lastMu.Lock()
+	defer ig.lastMu.Unlock()
+	return ig.lastNum, ig.lastHash, ig.lastHash != ""
+}
+
 // 你如果需要 ctx cancel，可把 rawCh 改成带 ctx 的 select（略）
 var _ sarama.ConsumerGroupHandler = (*Ingestor)(nil)
 
+// Setup 决定 reader 从哪读：spool 里已有这个 partition 就接着 spool 的尾巴读（重启 / rebalance 不回头），
+// 只有 spool 里没有它（冷启动）才往回 24 小时去 Kafka 里找。
 func (ig *Ingestor) Setup(sess sarama.ConsumerGroupSession) error {
 	ig.setupAt = time.Now()
 
@@ -278,6 +495,12 @@ func (ig *Ingestor) Setup(sess sarama.ConsumerGroupSession) error {
 	}
 
 	for _, p := range parts {
+		if last, ok := ig.spool.LastOffset(p); ok {
+			log.Printf("[ingest][setup] resume from spool: topic=%s p=%d off=%d", ig.topic, p, last+1)
+			sess.ResetOffset(ig.topic, p, last+1, "")
+			continue
+		}
+
 		t0 := time.Now()
 		off, err := ig.client.GetOffset(ig.topic, p, targetMs)
 		cost := time.Since(t0)
@@ -287,12 +510,8 @@ func (ig *Ingestor) Setup(sess sarama.ConsumerGroupSession) error {
 			continue
 		}
 
-		log.Printf("[ingest][setup] reset offset: topic=%s p=%d off=%d (t=%dms) cost=%s",
+		log.Printf("[ingest][setup] cold start, reset offset: topic=%s p=%d off=%d (t=%dms) cost=%s",
 			ig.topic, p, off, targetMs, cost)
-		ig.offMu.Lock()
-		ig.firstOffsetByPart[p] = max(off, 0)
-		ig.firstSeenByPart[p] = false
-		ig.offMu.Unlock()
 
 		// 注意：如果 retention 不够，off 会退化成当前最早可用的 offset
 		sess.ResetOffset(ig.topic, p, off, "")
@@ -0,0 +1,119 @@
+package ingest
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/chunk"
+	mc "github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+)
+
+// chunkMsgs splits blk's JSON into n-byte chunk records whose headers claim block num / hash h.
+func chunkMsgs(t *testing.T, blk mc.Block, size int, num int64, h string) ([]*sarama.ConsumerMessage, []byte) {
+	t.Helper()
+	raw, err := mc.EncodeBlock(blk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := chunk.Split(raw, size)
+	out := make([]*sarama.ConsumerMessage, 0, len(parts))
+	for i, p := range parts {
+		hs := []*sarama.RecordHeader{{Key: []byte(mc.HeaderContentType), Value: []byte(mc.ContentTypeJSON)}}
+		for _, ch := range (chunk.Meta{Block: num, Index: i, Count: len(parts), Hash: h}).Headers() {
+			hs = append(hs, &ch)
+		}
+		out = append(out, &sarama.ConsumerMessage{Topic: "blocks", Partition: 2, Offset: int64(100 + i), Headers: hs, Value: p})
+	}
+	return out, raw
+}
+
+func testBlock(n int64) mc.Block {
+	txs := []mc.Tx{mc.BuildTx(mc.TxBody{From: "0xa", To: "0xb", Token: "MOCK", Amount: 1, Timestamp: 1000 + n, Nonce: 1}, n)}
+	return mc.BuildBlock("", n, hash.Hash32{}, txs, 1000+n, 7)
+}
+
+func TestReassemble(t *testing.T) {
+	ig := &Ingestor{}
+	blk := testBlock(5)
+
+	t.Run("match", func(t *testing.T) {
+		var asm chunk.Assembler
+		msgs, raw := chunkMsgs(t, blk, 64, 5, blk.Hash.Hex())
+		for i, m := range msgs {
+			val, ok, bad := ig.reassemble(&asm, m, mc.ContentTypeJSON)
+			if bad != nil {
+				t.Fatal(bad)
+			}
+			if ok != (i == len(msgs)-1) {
+				t.Fatalf("chunk %d: ok=%v", i, ok)
+			}
+			if ok && !bytes.Equal(val, raw) {
+				t.Fatal("reassembled payload differs")
+			}
+		}
+	})
+
+	for name, claim := range map[string]struct {
+		num int64
+		h   string
+	}{
+		"wrong number": {6, blk.Hash.Hex()},
+		"wrong hash":   {5, testBlock(6).Hash.Hex()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var asm chunk.Assembler
+			msgs, raw := chunkMsgs(t, blk, 64, claim.num, claim.h)
+			var (
+				val []byte
+				ok  bool
+				bad error
+			)
+			for _, m := range msgs {
+				val, ok, bad = ig.reassemble(&asm, m, mc.ContentTypeJSON)
+			}
+			if ok || bad == nil || !bytes.Equal(val, raw) {
+				t.Fatalf("ok=%v bad=%v len=%d: want the whole payload rejected", ok, bad, len(val))
+			}
+
+			dead := deadBlock(msgs[len(msgs)-1], val)
+			if dead.Partition != 2 || dead.Offset != int64(100+len(msgs)-1) || !bytes.Equal(dead.Value, raw) {
+				t.Fatalf("dead=%+v", dead)
+			}
+			if _, chunked, _ := chunk.Parse(dead.Headers); chunked {
+				t.Fatal("dead record still carries chunk headers")
+			}
+			if headerValue(dead.Headers, mc.HeaderContentType) != mc.ContentTypeJSON {
+				t.Fatal("dead record lost its content type")
+			}
+		})
+	}
+
+	t.Run("garbage", func(t *testing.T) {
+		var asm chunk.Assembler
+		hs := chunk.Meta{Block: 5, Index: 0, Count: 1, Hash: blk.Hash.Hex()}.Headers()
+		msg := &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{&hs[0], &hs[1], &hs[2], &hs[3]}, Value: []byte("{nope")}
+		if _, ok, bad := ig.reassemble(&asm, msg, mc.ContentTypeJSON); ok || bad == nil || !strings.Contains(bad.Error(), "does not decode") {
+			t.Fatalf("ok=%v bad=%v", ok, bad)
+		}
+	})
+
+	t.Run("whole record", func(t *testing.T) {
+		var asm chunk.Assembler
+		msgs, _ := chunkMsgs(t, blk, 64, 5, blk.Hash.Hex())
+		ig.reassemble(&asm, msgs[0], mc.ContentTypeJSON)
+
+		raw, _ := mc.EncodeBlock(testBlock(9))
+		whole := &sarama.ConsumerMessage{Value: raw}
+		val, ok, bad := ig.reassemble(&asm, whole, mc.ContentTypeJSON)
+		if !ok || bad != nil || !bytes.Equal(val, raw) || asm.Pending() {
+			t.Fatalf("ok=%v bad=%v pending=%v", ok, bad, asm.Pending())
+		}
+		raw[0] = 'x'
+		if val[0] == 'x' {
+			t.Fatal("whole record value not copied")
+		}
+	})
+}
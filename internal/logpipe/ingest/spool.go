@@ -3,56 +3,743 @@ package ingest
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"maps"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
 type Spool interface {
-	Append(partition int32, offset int64, raw []byte) error
+	// Append returns once rec is durable.
+	Append(rec SpoolRecord) error
+	// AppendAsync queues rec and returns at once (or when the queue is full). done runs once rec is
+	// durable, or with the error that broke the spool; calls come in append order, from one goroutine.
+	// Once the spool is broken or closed, done runs at once on the caller's goroutine.
+	AppendAsync(rec SpoolRecord, done func(error))
+	// WaitDurable returns once everything appended before the call is durable.
+	WaitDurable() error
+	// LastOffset is the offset of the newest durable record of partition in the spool; the Kafka
+	// reader resumes after it.
+	LastOffset(partition int32) (int64, bool)
 	Close() error
 }
 
+// SpoolRecord is one Kafka record as kept in the spool. Seq is assigned by the spool: dense,
+// starting at 0, it is the position readers resume from.
+type SpoolRecord struct {
+	Seq       uint64
+	Partition int32
+	Offset    int64
+	Timestamp int64 // unix ms, Kafka record timestamp (the fetcher sets it to block time)
+	Value     []byte
+}
+
+type SpoolOptions struct {
+	// SegmentBytes: the active segment is closed and a new one started once it is this large. <=0 means 128MiB.
+	SegmentBytes int64
+
+	// Group commit: the flusher writes and fsyncs what is queued once GroupRecords records are
+	// waiting or GroupWait after the first one, whichever comes first. <=0 means 256 / 200µs.
+	GroupRecords int
+	GroupWait    time.Duration
+	// QueueBytes: AppendAsync blocks while this much is queued and not yet written. <=0 means 64MiB.
+	QueueBytes int
+
+	// Retention, checked whenever a segment is sealed; only whole sealed segments are deleted,
+	// oldest first. RetainFor: keep every record within this much event time (Timestamp) of the
+	// newest one. RetainBytes: cap on the spool's size; it wins over RetainFor (logged), and may
+	// delete records a reader hasn't reached yet (Tail skips past them, logged). <=0: no limit.
+	RetainFor   time.Duration
+	RetainBytes int64
+}
+
+// FileSpool is a segmented append-only log in a directory:
+//
+//	<base seq>.seg  header "LPSPOOL1", then records (see encodeSpoolRecord)
+//	<base seq>.idx  one entry per record of the segment: [pos u64][partition i32][offset i64]
+//
+// Every record carries a CRC32C. On open the tail of the last segment is checked and a torn record
+// (crash mid-write) is truncated; its index is rebuilt from the segment. Readers (SpoolReader) work
+// on the directory alone, so another process can tail the same spool.
+//
+// Appends are group-committed: appenders encode into a queue, one flusher goroutine owns the files
+// and writes + fsyncs the queue as a batch, then reports durability (Append returns, AppendAsync's
+// done runs). One fsync covers many records instead of one each.
+//
+// Appends are idempotent per (partition, offset): a record at or below the partition's last offset
+// is already in the spool (a rebalance re-delivered it) and is acknowledged without being written.
 type FileSpool struct {
-	mu sync.Mutex
-	f  *os.File
-	w  *bufio.Writer
+	dir  string
+	opts SpoolOptions
+
+	mu      sync.Mutex
+	cond    *sync.Cond // durable / err / queue space changed
+	next    uint64     // seq of the next append
+	durable uint64     // records with seq < durable are on disk
+	queue   []byte     // encoded records not handed to the flusher yet
+	pending []spoolPending
+	lastOff map[int32]int64 // per partition, durable records over all segments
+	lastQ   map[int32]int64 // per partition, queued or durable: the dedup bound
+	dups    int64           // duplicates skipped in the current run of them
+	err     error           // sticky: the flusher failed, the spool takes no more appends
+	closed  bool
+
+	kick    chan struct{} // cap 1: records queued
+	stopped chan struct{} // flusher exited
+
+	// owned by the flusher (by the opener before it starts)
+	base    uint64 // first seq of the active segment
+	f       *os.File
+	w       *bufio.Writer
+	size    int64 // bytes in the active segment (flushed or not)
+	idx     *os.File
+	idxW    *bufio.Writer
+	written uint64 // seq of the next record written to the segment
+	newest  int64  // max Timestamp written: retention is in event time
+}
+
+// spoolPending is a queued record: n bytes of the queue, done when durable. dup: nothing written,
+// done still waits for the records queued before it.
+type spoolPending struct {
+	partition int32
+	offset    int64
+	ts        int64
+	n         int
+	dup       bool
+	done      func(error)
 }
 
-func NewFileSpool(path string) (*FileSpool, error) {
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+const (
+	spoolMagic     = "LPSPOOL1"
+	spoolHdrLen    = 8  // [len u32][crc u32]
+	spoolFixedBody = 28 // [seq u64][p i32][off i64][ts i64]
+	spoolIdxEntry  = 20
+	spoolMaxValue  = 64 << 20 // sanity bound when scanning: a larger length means garbage
+)
+
+var (
+	spoolCRC = crc32.MakeTable(crc32.Castagnoli)
+
+	ErrSpoolCorrupt = errors.New("spool: corrupt record")
+	ErrSpoolGone    = errors.New("spool: position no longer in the spool")
+	ErrSpoolClosed  = errors.New("spool: closed")
+)
+
+func NewFileSpool(dir string, opts SpoolOptions) (*FileSpool, error) {
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = 128 << 20
+	}
+	if opts.GroupRecords <= 0 {
+		opts.GroupRecords = 256
+	}
+	if opts.GroupWait <= 0 {
+		opts.GroupWait = 200 * time.Microsecond
+	}
+	if opts.QueueBytes <= 0 {
+		opts.QueueBytes = 64 << 20
+	}
+	if st, err := os.Stat(dir); err == nil && !st.IsDir() {
+		return nil, fmt.Errorf("spool %s is a file (old single-file spool without checksums): move it away, the spool is a directory now", dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &FileSpool{
+		dir:     dir,
+		opts:    opts,
+		lastOff: make(map[int32]int64),
+		lastQ:   make(map[int32]int64),
+		kick:    make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	segs, err := listSegments(dir)
 	if err != nil {
 		return nil, err
 	}
-	return &FileSpool{f: f, w: bufio.NewWriterSize(f, 1<<20)}, nil
+	if len(segs) == 0 {
+		if err := s.openSegment(0); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := s.recover(segs[len(segs)-1]); err != nil {
+			return nil, err
+		}
+		// indexes are small (20 bytes a record): read them all once, oldest first, newest wins
+		for _, base := range segs {
+			b, err := os.ReadFile(idxPath(dir, base))
+			if err != nil {
+				_ = s.closeSegment()
+				return nil, err
+			}
+			for j := 0; j+spoolIdxEntry <= len(b); j += spoolIdxEntry {
+				s.lastOff[int32(binary.BigEndian.Uint32(b[j+8:j+12]))] = int64(binary.BigEndian.Uint64(b[j+12 : j+20]))
+			}
+		}
+	}
+	s.next, s.durable = s.written, s.written
+	maps.Copy(s.lastQ, s.lastOff)
+	if err := s.retain(); err != nil {
+		log.Printf("[spool][retain] %v", err)
+	}
+	go s.flushLoop()
+	return s, nil
+}
+
+// recover validates the last segment record by record, truncates whatever follows the last good
+// one, rewrites its index and reopens it for appending.
+func (s *FileSpool) recover(base uint64) error {
+	path := segPath(s.dir, base)
+	f, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	var entries []byte
+	pos, seq := int64(len(spoolMagic)), base
+	if err := checkSegmentHeader(f); err != nil {
+		// header itself torn (crash right after create): start the segment over
+		log.Printf("[spool][recover] %s: %v, rewriting header", path, err)
+		if err := f.Truncate(0); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if _, err := f.WriteAt([]byte(spoolMagic), 0); err != nil {
+			_ = f.Close()
+			return err
+		}
+	} else {
+		for {
+			rec, n, err := readSpoolRecordAt(f, pos)
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					log.Printf("[spool][recover] %s: stop at pos=%d seq=%d: %v", path, pos, seq, err)
+				}
+				break
+			}
+			if rec.Seq != seq {
+				log.Printf("[spool][recover] %s: seq=%d at pos=%d, want %d", path, rec.Seq, pos, seq)
+				break
+			}
+			entries = appendIdxEntry(entries, pos, rec.Partition, rec.Offset)
+			pos += n
+			seq++
+			s.newest = max(s.newest, rec.Timestamp)
+		}
+	}
+	if pos < st.Size() {
+		log.Printf("[spool][recover] %s: truncating torn tail: %d bytes after seq=%d", path, st.Size()-pos, seq)
+		if err := f.Truncate(pos); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := f.Seek(pos, io.SeekStart); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	// the index of the active segment is never trusted: rebuilt from what the segment holds
+	if err := os.WriteFile(idxPath(s.dir, base), entries, 0o644); err != nil {
+		_ = f.Close()
+		return err
+	}
+	idx, err := os.OpenFile(idxPath(s.dir, base), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	s.base, s.written, s.size = base, seq, pos
+	s.f, s.w = f, bufio.NewWriterSize(f, 1<<20)
+	s.idx, s.idxW = idx, bufio.NewWriterSize(idx, 64<<10)
+	log.Printf("[spool] open %s: segment=%d next_seq=%d", s.dir, base, seq)
+	return nil
+}
+
+func (s *FileSpool) openSegment(base uint64) error {
+	f, err := os.OpenFile(segPath(s.dir, base), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	idx, err := os.OpenFile(idxPath(s.dir, base), os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	s.base, s.written = base, base
+	s.f, s.w = f, bufio.NewWriterSize(f, 1<<20)
+	s.idx, s.idxW = idx, bufio.NewWriterSize(idx, 64<<10)
+	if _, err := s.w.WriteString(spoolMagic); err != nil {
+		return err
+	}
+	s.size = int64(len(spoolMagic))
+	if err := s.sync(); err != nil {
+		return err
+	}
+	// the new file name must survive a crash too
+	return syncDir(s.dir)
+}
+
+// Append writes rec (its Seq is assigned here) and returns once it is on disk.
+func (s *FileSpool) Append(rec SpoolRecord) error {
+	errc := make(chan error, 1)
+	s.AppendAsync(rec, func(err error) { errc <- err })
+	return <-errc
+}
+
+func (s *FileSpool) AppendAsync(rec SpoolRecord, done func(error)) {
+	s.mu.Lock()
+	for s.err == nil && !s.closed && len(s.queue) >= s.opts.QueueBytes {
+		s.cond.Wait()
+	}
+	if err := s.brokenLocked(); err != nil {
+		s.mu.Unlock()
+		done(err)
+		return
+	}
+	if last, ok := s.lastQ[rec.Partition]; ok && rec.Offset <= last {
+		if s.dups == 0 {
+			log.Printf("[spool] p=%d off=%d already spooled (last=%d): skipping re-delivered records", rec.Partition, rec.Offset, last)
+		}
+		s.dups++
+		s.pending = append(s.pending, spoolPending{partition: rec.Partition, offset: rec.Offset, dup: true, done: done})
+	} else {
+		if s.dups > 0 {
+			log.Printf("[spool] skipped %d re-delivered records, p=%d resumes at off=%d", s.dups, rec.Partition, rec.Offset)
+			s.dups = 0
+		}
+		s.lastQ[rec.Partition] = rec.Offset
+		rec.Seq = s.next
+		s.next++
+		n := len(s.queue)
+		s.queue = encodeSpoolRecord(s.queue, rec)
+		s.pending = append(s.pending, spoolPending{partition: rec.Partition, offset: rec.Offset, ts: rec.Timestamp, n: len(s.queue) - n, done: done})
+	}
+	kick := len(s.pending) == 1 || len(s.pending) >= s.opts.GroupRecords
+	s.mu.Unlock()
+
+	if kick {
+		select {
+		case s.kick <- struct{}{}:
+		default:
+		}
+	}
 }
 
-func (s *FileSpool) Append(partition int32, offset int64, raw []byte) error {
+func (s *FileSpool) WaitDurable() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	target := s.next
+	for s.durable < target && s.err == nil {
+		s.cond.Wait()
+	}
+	if s.durable >= target {
+		return nil
+	}
+	return s.err
+}
+
+func (s *FileSpool) brokenLocked() error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.closed {
+		return ErrSpoolClosed
+	}
+	return nil
+}
 
-	// record = [p:int32][off:int64][n:uint32][raw:n]
-	var hdr [4 + 8 + 4]byte
-	binary.BigEndian.PutUint32(hdr[0:4], uint32(partition))
-	binary.BigEndian.PutUint64(hdr[4:12], uint64(offset))
-	binary.BigEndian.PutUint32(hdr[12:16], uint32(len(raw)))
+// flushLoop is the group committer: wait for the first queued record, give the batch GroupWait to
+// fill up (or GroupRecords), then write it, fsync once and report.
+func (s *FileSpool) flushLoop() {
+	defer close(s.stopped)
+	timer := time.NewTimer(s.opts.GroupWait)
+	defer timer.Stop()
+	for {
+		<-s.kick
 
-	if _, err := s.w.Write(hdr[:]); err != nil {
-		return err
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(s.opts.GroupWait)
+	fill:
+		for {
+			s.mu.Lock()
+			full := len(s.pending) >= s.opts.GroupRecords || s.closed
+			s.mu.Unlock()
+			if full {
+				break
+			}
+			select {
+			case <-timer.C:
+				break fill
+			case <-s.kick:
+			}
+		}
+
+		s.mu.Lock()
+		buf, batch := s.queue, s.pending
+		s.queue, s.pending = nil, nil
+		closed := s.closed
+		s.cond.Broadcast() // queue space
+		s.mu.Unlock()
+
+		err := s.writeBatch(buf, batch)
+
+		s.mu.Lock()
+		if err != nil {
+			if s.err == nil {
+				log.Printf("[spool] write failed, spool stops taking appends: %v", err)
+				s.err = err
+			}
+		} else {
+			for _, p := range batch {
+				if !p.dup {
+					s.durable++
+					s.lastOff[p.partition] = p.offset
+				}
+			}
+		}
+		err = s.err
+		s.cond.Broadcast()
+		// records queued after a failure never reach the flusher: fail them here
+		var failed []spoolPending
+		if err != nil {
+			failed, s.pending, s.queue = s.pending, nil, nil
+		}
+		s.mu.Unlock()
+
+		for _, p := range batch {
+			p.done(err)
+		}
+		for _, p := range failed {
+			p.done(err)
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// writeBatch appends the encoded records in buf (described by batch) to the segment, rolling it
+// when full, then flushes and fsyncs.
+func (s *FileSpool) writeBatch(buf []byte, batch []spoolPending) error {
+	if s.f == nil {
+		if len(batch) == 0 {
+			return nil
+		}
+		return ErrSpoolClosed
+	}
+	wrote := false
+	for _, p := range batch {
+		if p.dup {
+			continue
+		}
+		if s.size >= s.opts.SegmentBytes {
+			if err := s.rotate(); err != nil {
+				return err
+			}
+			if err := s.retain(); err != nil {
+				log.Printf("[spool][retain] %v", err) // the spool is fine, only bigger than wanted
+			}
+		}
+		if _, err := s.w.Write(buf[:p.n]); err != nil {
+			return err
+		}
+		if _, err := s.idxW.Write(appendIdxEntry(nil, s.size, p.partition, p.offset)); err != nil {
+			return err
+		}
+		buf = buf[p.n:]
+		s.size += int64(p.n)
+		s.written++
+		s.newest = max(s.newest, p.ts)
+		wrote = true
+	}
+	if !wrote {
+		return nil
+	}
+	return s.sync()
+}
+
+// retain deletes the oldest sealed segments that RetainFor / RetainBytes no longer keep. A segment
+// is old once the next one starts before the cutoff: timestamps (block times) only go up, so all
+// of it is older than that.
+func (s *FileSpool) retain() error {
+	if s.opts.RetainFor <= 0 && s.opts.RetainBytes <= 0 {
+		return nil
 	}
-	if _, err := s.w.Write(raw); err != nil {
+	segs, err := listSegments(s.dir)
+	if err != nil {
 		return err
 	}
+	if len(segs) < 2 {
+		return nil
+	}
+	sealed := segs[:len(segs)-1] // the last one is active
+
+	drop := 0
+	if s.opts.RetainFor > 0 {
+		cutoff := s.newest - s.opts.RetainFor.Milliseconds()
+		for drop < len(sealed) {
+			ts, ok, err := firstSpoolTimestamp(s.dir, segs[drop+1])
+			if err != nil {
+				return err
+			}
+			if !ok || ts > cutoff {
+				break
+			}
+			drop++
+		}
+	}
+	if s.opts.RetainBytes > 0 {
+		sizes := make([]int64, len(segs))
+		var total int64
+		for i, base := range segs {
+			for _, p := range []string{segPath(s.dir, base), idxPath(s.dir, base)} {
+				if st, err := os.Stat(p); err == nil {
+					sizes[i] += st.Size()
+				}
+			}
+			total += sizes[i]
+		}
+		for i := 0; i < drop; i++ {
+			total -= sizes[i]
+		}
+		if total > s.opts.RetainBytes && drop < len(sealed) && s.opts.RetainFor > 0 {
+			log.Printf("[spool][retain][warn] %d bytes over retain-bytes=%d: deleting segments still within retain-for=%s",
+				total-s.opts.RetainBytes, s.opts.RetainBytes, s.opts.RetainFor)
+		}
+		for total > s.opts.RetainBytes && drop < len(sealed) {
+			total -= sizes[drop]
+			drop++
+		}
+	}
+
+	for _, base := range segs[:drop] {
+		// .seg first: readers find segments by it; an .idx without one is ignored
+		if err := os.Remove(segPath(s.dir, base)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Remove(idxPath(s.dir, base)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if drop > 0 {
+		log.Printf("[spool][retain] deleted %d segments [%d, %d), oldest kept=%d", drop, segs[0], segs[drop], segs[drop])
+		return syncDir(s.dir)
+	}
+	return nil
+}
 
-	// mock/先求正确：每条都 flush+fsync（慢，但语义最稳）
+// sync: index first, so a flushed record always has its entry (the active index is rebuilt on
+// recovery anyway; this only keeps readers' lookups cheap).
+func (s *FileSpool) sync() error {
+	if err := s.idxW.Flush(); err != nil {
+		return err
+	}
 	if err := s.w.Flush(); err != nil {
 		return err
 	}
 	return s.f.Sync()
 }
 
+func (s *FileSpool) rotate() error {
+	if err := s.closeSegment(); err != nil {
+		return err
+	}
+	log.Printf("[spool] rotate: segment=%d records=%d -> segment=%d", s.base, s.written-s.base, s.written)
+	return s.openSegment(s.written)
+}
+
+func (s *FileSpool) closeSegment() error {
+	err := s.sync()
+	err = errors.Join(err, s.idx.Sync(), s.idx.Close(), s.f.Close())
+	s.f, s.w, s.idx, s.idxW = nil, nil, nil, nil
+	return err
+}
+
+// NextSeq is the seq the next Append will get (= records ever appended, retention aside).
+func (s *FileSpool) NextSeq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.next
+}
+
+func (s *FileSpool) Dir() string { return s.dir }
+
+func (s *FileSpool) LastOffset(partition int32) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	off, ok := s.lastOff[partition]
+	return off, ok
+}
+
+// Lookup finds the seq of the durable record (partition, offset), newest first. ok=false when it
+// is not (or no longer) in the spool.
+func (s *FileSpool) Lookup(partition int32, offset int64) (uint64, bool, error) {
+	return lookupSpool(s.dir, partition, offset)
+}
+
+// Close writes and syncs what is queued, then closes the files. Appends after Close fail.
 func (s *FileSpool) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		<-s.stopped
+		return nil
+	}
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+	<-s.stopped
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	_ = s.w.Flush()
-	return s.f.Close()
+	if s.f == nil {
+		return s.err
+	}
+	return errors.Join(s.err, s.closeSegment())
+}
+
+// encodeSpoolRecord: [len u32][crc32c u32] then the body [seq u64][p i32][off i64][ts i64][value];
+// len is the body length, crc covers the body.
+func encodeSpoolRecord(buf []byte, rec SpoolRecord) []byte {
+	bodyLen := spoolFixedBody + len(rec.Value)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(bodyLen))
+	buf = binary.BigEndian.AppendUint32(buf, 0) // crc, filled below
+	start := len(buf)
+	buf = binary.BigEndian.AppendUint64(buf, rec.Seq)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(rec.Partition))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(rec.Offset))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(rec.Timestamp))
+	buf = append(buf, rec.Value...)
+	binary.BigEndian.PutUint32(buf[start-4:start], crc32.Checksum(buf[start:], spoolCRC))
+	return buf
+}
+
+// readSpoolRecordAt decodes the record at pos and returns its total size. io.EOF: nothing (or only
+// part of a record) there yet; ErrSpoolCorrupt: a complete record that fails its checksum.
+func readSpoolRecordAt(f io.ReaderAt, pos int64) (SpoolRecord, int64, error) {
+	var hdr [spoolHdrLen]byte
+	if _, err := f.ReadAt(hdr[:], pos); err != nil {
+		return SpoolRecord{}, 0, io.EOF
+	}
+	bodyLen := int64(binary.BigEndian.Uint32(hdr[0:4]))
+	if bodyLen < spoolFixedBody || bodyLen > spoolFixedBody+spoolMaxValue {
+		return SpoolRecord{}, 0, fmt.Errorf("%w: length %d", ErrSpoolCorrupt, bodyLen)
+	}
+	body := make([]byte, bodyLen)
+	if _, err := f.ReadAt(body, pos+spoolHdrLen); err != nil {
+		return SpoolRecord{}, 0, io.EOF
+	}
+	if crc32.Checksum(body, spoolCRC) != binary.BigEndian.Uint32(hdr[4:8]) {
+		return SpoolRecord{}, 0, fmt.Errorf("%w: checksum mismatch at pos=%d", ErrSpoolCorrupt, pos)
+	}
+	return SpoolRecord{
+		Seq:       binary.BigEndian.Uint64(body[0:8]),
+		Partition: int32(binary.BigEndian.Uint32(body[8:12])),
+		Offset:    int64(binary.BigEndian.Uint64(body[12:20])),
+		Timestamp: int64(binary.BigEndian.Uint64(body[20:28])),
+		Value:     body[spoolFixedBody:],
+	}, spoolHdrLen + bodyLen, nil
+}
+
+func checkSegmentHeader(f io.ReaderAt) error {
+	var m [len(spoolMagic)]byte
+	if _, err := f.ReadAt(m[:], 0); err != nil {
+		return fmt.Errorf("short segment header: %w", err)
+	}
+	if string(m[:]) != spoolMagic {
+		return fmt.Errorf("bad segment magic %q", m[:])
+	}
+	return nil
+}
+
+func appendIdxEntry(buf []byte, pos int64, partition int32, offset int64) []byte {
+	buf = binary.BigEndian.AppendUint64(buf, uint64(pos))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(partition))
+	return binary.BigEndian.AppendUint64(buf, uint64(offset))
+}
+
+func segPath(dir string, base uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d.seg", base))
+}
+
+func idxPath(dir string, base uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d.idx", base))
+}
+
+// listSegments returns the base seqs of the segments in dir, ascending.
+func listSegments(dir string) ([]uint64, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]uint64, 0, len(names))
+	for _, n := range names {
+		var base uint64
+		if _, err := fmt.Sscanf(filepath.Base(n), "%020d.seg", &base); err != nil {
+			continue
+		}
+		out = append(out, base)
+	}
+	// zero-padded names: Glob's lexical order is numeric order
+	return out, nil
+}
+
+func lookupSpool(dir string, partition int32, offset int64) (uint64, bool, error) {
+	segs, err := listSegments(dir)
+	if err != nil {
+		return 0, false, err
+	}
+	for i := len(segs) - 1; i >= 0; i-- {
+		b, err := os.ReadFile(idxPath(dir, segs[i]))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return 0, false, err
+		}
+		for j := len(b)/spoolIdxEntry - 1; j >= 0; j-- {
+			e := b[j*spoolIdxEntry:]
+			if int32(binary.BigEndian.Uint32(e[8:12])) == partition && int64(binary.BigEndian.Uint64(e[12:20])) == offset {
+				return segs[i] + uint64(j), true, nil
+			}
+		}
+	}
+	return 0, false, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
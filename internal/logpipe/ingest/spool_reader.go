@@ -0,0 +1,217 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// SpoolReader iterates a spool directory from a seq on. It only needs the files, so it works in
+// another process than the writer, and follows segment rotation. At the tail Next returns io.EOF;
+// call it again later to pick up new records.
+type SpoolReader struct {
+	dir string
+
+	seq  uint64 // next record to return
+	base uint64 // segment f belongs to
+	f    *os.File
+	pos  int64
+}
+
+func OpenSpoolReader(dir string, from uint64) (*SpoolReader, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	return &SpoolReader{dir: dir, seq: from}, nil
+}
+
+// Seq is the seq of the record the next Next returns.
+func (r *SpoolReader) Seq() uint64 { return r.seq }
+
+// Next returns the next record. io.EOF: nothing more yet. ErrSpoolGone: the position was deleted
+// (retention) before it was read. A record failing its checksum in a sealed segment is
+// ErrSpoolCorrupt; in the active segment it may still be being written and reads as io.EOF.
+func (r *SpoolReader) Next() (SpoolRecord, error) {
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return SpoolRecord{}, err
+		}
+	}
+	rec, n, err := readSpoolRecordAt(r.f, r.pos)
+	if err != nil {
+		// end of this segment? the writer may have moved on to the next one
+		nextBase, ok, lerr := r.nextSegment()
+		if lerr != nil {
+			return SpoolRecord{}, lerr
+		}
+		if !ok {
+			if errors.Is(err, ErrSpoolCorrupt) {
+				return SpoolRecord{}, io.EOF
+			}
+			return SpoolRecord{}, err
+		}
+		// sealed segment: a bad record here is real corruption, not a write in progress
+		if errors.Is(err, ErrSpoolCorrupt) {
+			return SpoolRecord{}, fmt.Errorf("%s: %w", segPath(r.dir, r.base), err)
+		}
+		if nextBase != r.seq {
+			if _, err := os.Stat(segPath(r.dir, r.base)); errors.Is(err, os.ErrNotExist) {
+				// retention deleted this segment and the ones after it under us
+				return SpoolRecord{}, fmt.Errorf("%w: seq %d, segment %d deleted, next segment starts at %d",
+					ErrSpoolGone, r.seq, r.base, nextBase)
+			}
+			return SpoolRecord{}, fmt.Errorf("%w: segment %d ends before seq %d, next segment starts at %d",
+				ErrSpoolCorrupt, r.base, r.seq, nextBase)
+		}
+		_ = r.f.Close()
+		r.f = nil
+		return r.Next()
+	}
+	if rec.Seq != r.seq {
+		return SpoolRecord{}, fmt.Errorf("%w: seq %d at pos=%d of segment %d, want %d", ErrSpoolCorrupt, rec.Seq, r.pos, r.base, r.seq)
+	}
+	r.pos += n
+	r.seq++
+	return rec, nil
+}
+
+// open finds the segment holding r.seq and its position (from the index, else by scanning).
+func (r *SpoolReader) open() error {
+	segs, err := listSegments(r.dir)
+	if err != nil {
+		return err
+	}
+	if len(segs) == 0 {
+		return io.EOF
+	}
+	i, found := slices.BinarySearch(segs, r.seq)
+	if !found {
+		i--
+	}
+	if i < 0 {
+		return fmt.Errorf("%w: seq %d, oldest segment starts at %d", ErrSpoolGone, r.seq, segs[0])
+	}
+	base := segs[i]
+	f, err := os.Open(segPath(r.dir, base))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: segment %d deleted", ErrSpoolGone, base)
+		}
+		return err
+	}
+	if err := checkSegmentHeader(f); err != nil {
+		_ = f.Close()
+		return io.EOF // just created, header not flushed yet
+	}
+
+	pos := int64(len(spoolMagic))
+	if k := r.seq - base; k > 0 {
+		var e [spoolIdxEntry]byte
+		if idx, err := os.Open(idxPath(r.dir, base)); err == nil {
+			_, err = idx.ReadAt(e[:], int64(k)*spoolIdxEntry)
+			_ = idx.Close()
+			if err == nil {
+				pos = int64(binary.BigEndian.Uint64(e[0:8]))
+				k = 0
+			}
+		}
+		for ; k > 0; k-- { // no index entry (yet): walk the segment
+			_, n, err := readSpoolRecordAt(f, pos)
+			if err != nil {
+				_ = f.Close()
+				if errors.Is(err, io.EOF) {
+					return io.EOF
+				}
+				return err
+			}
+			pos += n
+		}
+	}
+	r.f, r.base, r.pos = f, base, pos
+	return nil
+}
+
+// nextSegment returns the base of the segment after r.base, if there is one.
+func (r *SpoolReader) nextSegment() (uint64, bool, error) {
+	segs, err := listSegments(r.dir)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, b := range segs {
+		if b > r.base {
+			return b, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+func (r *SpoolReader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// SpoolSeekTime returns the seq of the first record with Timestamp >= tsMs (unix ms), or the tail
+// seq when every record is older. Timestamps are block times, increasing but not strictly, so the
+// segment is picked by its first record and then scanned.
+func SpoolSeekTime(dir string, tsMs int64) (uint64, error) {
+	segs, err := listSegments(dir)
+	if err != nil || len(segs) == 0 {
+		return 0, err
+	}
+	from := segs[0]
+	for _, base := range segs[1:] {
+		ts, ok, err := firstSpoolTimestamp(dir, base)
+		if err != nil {
+			return 0, err
+		}
+		if !ok || ts >= tsMs {
+			break
+		}
+		from = base
+	}
+
+	r, err := OpenSpoolReader(dir, from)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	for {
+		seq := r.Seq()
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return seq, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if rec.Timestamp >= tsMs {
+			return seq, nil
+		}
+	}
+}
+
+func firstSpoolTimestamp(dir string, base uint64) (int64, bool, error) {
+	f, err := os.Open(segPath(dir, base))
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+	if err := checkSegmentHeader(f); err != nil {
+		return 0, false, nil
+	}
+	rec, _, err := readSpoolRecordAt(f, int64(len(spoolMagic)))
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, ErrSpoolCorrupt) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return rec.Timestamp, true, nil
+}
@@ -0,0 +1,131 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"time"
+)
+
+// tailPoll: how long the compute stage sleeps at the spool tail when nobody wakes it (the reader
+// of this process wakes it on every append; a reader in another process doesn't).
+const tailPoll = 50 * time.Millisecond
+
+// Tail is the compute stage: it reads the spool in dir from at.Cursor on and feeds the decoder,
+// until ctx ends or the spool can't be read. The ring and the tx indexes go on from at's, see
+// Anchor. It never touches Kafka, so a rebalance of the reader (ConsumeClaim) doesn't disturb the
+// windows, and a restart rebuilds them from the local spool. Call it once, before anything else
+// feeds the decoder.
+func (ig *Ingestor) Tail(ctx context.Context, dir string, at Anchor) error {
+	ig.tailWG.Add(1)
+	defer ig.tailWG.Done()
+
+	r, err := OpenSpoolReader(dir, at.Cursor)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }() // r is replaced on a re-seek
+
+	// the decoders read these after the first rawCh send
+	ig.start = at
+	ig.rbTxSum = at.TxBase
+	for i := range ig.blockTail {
+		ig.blockTail[i] = uint32(at.Seq)
+	}
+	if lane := at.Seq % MaxGroutines; lane != 0 {
+		// the ring's turn starts at lane 0 (NewIngestor): hand it to the first block's lane
+		ig.rbInCh[lane] <- <-ig.rbInCh[0]
+		ig.rbOutCh[lane] <- <-ig.rbOutCh[0]
+	}
+
+	log.Printf("[ingest][tail] spool=%s from seq=%d ring=%d tx=%d", dir, at.Cursor, at.Seq, at.TxBase)
+	timer := time.NewTimer(tailPoll)
+	defer timer.Stop()
+
+	lastOff := make(map[int32]int64)
+	var lastTs int64 // newest Timestamp handed on: where to resume if retention overtakes us
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, ErrSpoolGone) {
+			// retention (RetainBytes) deleted segments we hadn't read yet: skip to the oldest
+			// record still there at or after lastTs; lastOff drops what we already handed on
+			seq, serr := SpoolSeekTime(dir, lastTs)
+			if serr != nil {
+				return serr
+			}
+			log.Printf("[ingest][tail][warn] seq=%d deleted before it was read (%v): resuming at seq=%d ts>=%d",
+				r.Seq(), err, seq, lastTs)
+			_ = r.Close()
+			if r, err = OpenSpoolReader(dir, seq); err != nil {
+				return err
+			}
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(tailPoll)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ig.stop:
+				return nil
+			case <-ig.wake:
+			case <-timer.C:
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// the reader resumes after the spool's last offset, so a record is normally there once;
+		// a rebalance between two readers can still write one twice
+		if last, ok := lastOff[rec.Partition]; ok && rec.Offset <= last {
+			continue
+		}
+		if _, ok := lastOff[rec.Partition]; !ok {
+			ig.resetPart(rec.Partition, rec.Offset)
+		}
+		lastOff[rec.Partition] = rec.Offset
+		lastTs = max(lastTs, rec.Timestamp)
+
+		rm := RawMsg{
+			Partition: rec.Partition,
+			Offset:    rec.Offset,
+			Seq:       ig.nextSeq(rec.Partition),
+			Cursor:    rec.Seq,
+			Value:     rec.Value,
+		}
+		// 背压：rawCh 满了就停在这里，reader 照样往 spool 里写
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ig.stop:
+			return nil
+		case ig.rawCh <- rm:
+		}
+	}
+}
+
+// wakeTail tells Tail a record was appended; never blocks.
+func (ig *Ingestor) wakeTail() {
+	select {
+	case ig.wake <- struct{}{}:
+	default:
+	}
+}
+
+// resetPart starts partition part over: base offset for the logs, the start seq for the ring.
+func (ig *Ingestor) resetPart(part int32, base int64) {
+	ig.offMu.Lock()
+	ig.firstOffsetByPart[part] = base
+	ig.firstSeenByPart[part] = false
+	ig.seqByPart[part] = ig.start.Seq
+	ig.offMu.Unlock()
+}
@@ -0,0 +1,879 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func spoolRec(p int32, off, ts int64) SpoolRecord {
+	return SpoolRecord{Partition: p, Offset: off, Timestamp: ts, Value: []byte(fmt.Sprintf("p%d-o%d", p, off))}
+}
+
+func openTestSpool(t *testing.T, dir string, opts SpoolOptions) *FileSpool {
+	t.Helper()
+	s, err := NewFileSpool(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// appendOffsets appends partition 0, offsets [from, to), timestamp = offset.
+func appendOffsets(t *testing.T, s *FileSpool, from, to int64) {
+	t.Helper()
+	for off := from; off < to; off++ {
+		if err := s.Append(spoolRec(0, off, off)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readSpool reads dir from seq from to the tail.
+func readSpool(t *testing.T, dir string, from uint64) []SpoolRecord {
+	t.Helper()
+	r, err := OpenSpoolReader(dir, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var out []SpoolRecord
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, rec)
+	}
+}
+
+// checkOffsets: recs are seqs from.., each holding partition 0 offset == seq (appendOffsets from 0).
+func checkOffsets(t *testing.T, recs []SpoolRecord, from uint64, n int) {
+	t.Helper()
+	if len(recs) != n {
+		t.Fatalf("got %d records, want %d", len(recs), n)
+	}
+	for i, rec := range recs {
+		seq := from + uint64(i)
+		if rec.Seq != seq || rec.Offset != int64(seq) || !bytes.Equal(rec.Value, spoolRec(0, int64(seq), 0).Value) {
+			t.Fatalf("record %d: %+v", i, rec)
+		}
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st.Size()
+}
+
+// recordPos is the position of record k (0-based) of a segment whose records are all the size of
+// spoolRec(0, off, off) with a one-digit offset.
+func recordPos(k int) int64 {
+	return int64(len(spoolMagic)) + int64(k)*int64(len(encodeSpoolRecord(nil, spoolRec(0, 0, 0))))
+}
+
+func TestSpoolRecover(t *testing.T) {
+	for name, c := range map[string]struct {
+		damage func(t *testing.T, seg string)
+		keep   int // records of the 5 surviving the reopen
+	}{
+		"torn tail record": {func(t *testing.T, seg string) {
+			torn := encodeSpoolRecord(nil, spoolRec(0, 5, 5))
+			appendFile(t, seg, torn[:len(torn)-3])
+		}, 5},
+		"torn record header": {func(t *testing.T, seg string) {
+			appendFile(t, seg, encodeSpoolRecord(nil, spoolRec(0, 5, 5))[:spoolHdrLen-2])
+		}, 5},
+		"checksum flip in the last record": {func(t *testing.T, seg string) {
+			flipByte(t, seg, recordPos(4)+spoolHdrLen+spoolFixedBody)
+		}, 4},
+		// everything after a bad record goes with it: it can't be trusted to be in order
+		"checksum flip mid segment": {func(t *testing.T, seg string) {
+			flipByte(t, seg, recordPos(2)+spoolHdrLen+1)
+		}, 2},
+		"garbage length": {func(t *testing.T, seg string) {
+			appendFile(t, seg, []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 1, 2, 3})
+		}, 5},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := openTestSpool(t, dir, SpoolOptions{})
+			appendOffsets(t, s, 0, 5)
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			seg := segPath(dir, 0)
+			c.damage(t, seg)
+
+			s = openTestSpool(t, dir, SpoolOptions{})
+			defer s.Close()
+			if got := s.NextSeq(); got != uint64(c.keep) {
+				t.Fatalf("NextSeq=%d want %d", got, c.keep)
+			}
+			if got := fileSize(t, seg); got != recordPos(c.keep) {
+				t.Fatalf("segment size=%d, want truncated to %d", got, recordPos(c.keep))
+			}
+			if off, ok := s.LastOffset(0); !ok || off != int64(c.keep-1) {
+				t.Fatalf("LastOffset=%d,%v want %d", off, ok, c.keep-1)
+			}
+			// appends carry on right after the last good record
+			appendOffsets(t, s, int64(c.keep), 7)
+			checkOffsets(t, readSpool(t, dir, 0), 0, 7)
+		})
+	}
+}
+
+// A crash right after a segment was created can leave its header torn: the segment starts over,
+// the sealed ones before it stay.
+func TestSpoolRecoverTornSegmentHeader(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestSpool(t, dir, SpoolOptions{})
+	appendOffsets(t, s, 0, 3)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(segPath(dir, 3), []byte(spoolMagic[:3]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestSpool(t, dir, SpoolOptions{})
+	defer s.Close()
+	if got := s.NextSeq(); got != 3 {
+		t.Fatalf("NextSeq=%d want 3", got)
+	}
+	if got := fileSize(t, segPath(dir, 3)); got != int64(len(spoolMagic)) {
+		t.Fatalf("segment 3 size=%d, want a bare header", got)
+	}
+	if off, ok := s.LastOffset(0); !ok || off != 2 {
+		t.Fatalf("LastOffset=%d,%v want 2 from the sealed segment", off, ok)
+	}
+	appendOffsets(t, s, 3, 5)
+	checkOffsets(t, readSpool(t, dir, 0), 0, 5)
+}
+
+func TestSpoolRebuildsActiveIndex(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestSpool(t, dir, SpoolOptions{})
+	appendOffsets(t, s, 0, 6)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(idxPath(dir, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != 6*spoolIdxEntry {
+		t.Fatalf("index has %d bytes, want %d", len(want), 6*spoolIdxEntry)
+	}
+
+	// lost entries and junk: both replaced by what the segment holds
+	for name, idx := range map[string][]byte{
+		"short":   want[:2*spoolIdxEntry+7],
+		"garbage": bytes.Repeat([]byte{0xee}, 9*spoolIdxEntry),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := os.WriteFile(idxPath(dir, 0), idx, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			s := openTestSpool(t, dir, SpoolOptions{})
+			defer s.Close()
+			got, err := os.ReadFile(idxPath(dir, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("index not rebuilt:\n got  %x\n want %x", got, want)
+			}
+			if seq, ok, err := s.Lookup(0, 4); err != nil || !ok || seq != 4 {
+				t.Fatalf("Lookup(0,4)=%d,%v,%v", seq, ok, err)
+			}
+			checkOffsets(t, readSpool(t, dir, 3), 3, 3)
+		})
+	}
+}
+
+// The reader follows the writer across segments while both run.
+func TestSpoolReaderFollowsRotation(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestSpool(t, dir, SpoolOptions{SegmentBytes: 200})
+	defer s.Close()
+
+	const n = 60
+	errc := make(chan error, 1)
+	go func() {
+		for off := int64(0); off < n; off++ {
+			if err := s.Append(spoolRec(0, off, off)); err != nil {
+				errc <- err
+				return
+			}
+		}
+		errc <- nil
+	}()
+
+	r, err := OpenSpoolReader(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	deadline := time.Now().Add(10 * time.Second)
+	for seq := uint64(0); seq < n; {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			if time.Now().After(deadline) {
+				t.Fatalf("stuck at seq=%d", seq)
+			}
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Seq != seq || rec.Offset != int64(seq) {
+			t.Fatalf("got seq=%d off=%d, want %d", rec.Seq, rec.Offset, seq)
+		}
+		seq++
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if segs, _ := listSegments(dir); len(segs) < 5 {
+		t.Fatalf("only %d segments: nothing rotated", len(segs))
+	}
+	// a reader opened mid-spool finds its segment through the indexes
+	checkOffsets(t, readSpool(t, dir, 37), 37, n-37)
+}
+
+func TestSpoolReaderGone(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestSpool(t, dir, SpoolOptions{SegmentBytes: 200})
+	appendOffsets(t, s, 0, 30)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	segs, err := listSegments(dir)
+	if err != nil || len(segs) < 3 {
+		t.Fatalf("segments=%v err=%v", segs, err)
+	}
+
+	r, err := OpenSpoolReader(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	// retention deletes the first two segments under the reader: it finishes the open one (the
+	// file stays readable), then the next is gone from under it
+	for _, base := range segs[:2] {
+		_ = os.Remove(segPath(dir, base))
+		_ = os.Remove(idxPath(dir, base))
+	}
+	for r.Seq() < segs[1] {
+		if _, err := r.Next(); err != nil {
+			t.Fatalf("seq=%d: %v", r.Seq(), err)
+		}
+	}
+	if _, err := r.Next(); !errors.Is(err, ErrSpoolGone) {
+		t.Fatalf("seq=%d: err=%v want ErrSpoolGone", r.Seq(), err)
+	}
+
+	// a reader opened below the oldest segment
+	r2, err := OpenSpoolReader(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+	if _, err := r2.Next(); !errors.Is(err, ErrSpoolGone) {
+		t.Fatalf("err=%v want ErrSpoolGone", err)
+	}
+	checkOffsets(t, readSpool(t, dir, segs[2]), segs[2], 30-int(segs[2]))
+}
+
+func TestSpoolSeekTime(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestSpool(t, dir, SpoolOptions{SegmentBytes: 200})
+	// timestamps 100,100,100,110,110,110,... : equal runs cross segment boundaries
+	for off := int64(0); off < 30; off++ {
+		if err := s.Append(spoolRec(0, off, 100+10*(off/3))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if segs, _ := listSegments(dir); len(segs) < 3 {
+		t.Fatalf("segments=%v: want several", segs)
+	}
+
+	for _, c := range []struct {
+		ts   int64
+		want uint64
+	}{
+		{0, 0},
+		{100, 0},
+		{101, 3},
+		{110, 3},
+		{150, 15},
+		{155, 18},
+		{190, 27},
+		{191, 30}, // newer than everything: the tail
+	} {
+		got, err := SpoolSeekTime(dir, c.ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Fatalf("SpoolSeekTime(%d)=%d want %d", c.ts, got, c.want)
+		}
+	}
+
+	if got, err := SpoolSeekTime(t.TempDir(), 100); err != nil || got != 0 {
+		t.Fatalf("empty spool: %d, %v", got, err)
+	}
+}
+
+// A record half written to the active segment (the writer is mid-flush, or another process
+// reads) is "nothing yet", not corruption; in a sealed segment it is corruption.
+func TestSpoolReaderPartialRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestSpool(t, dir, SpoolOptions{})
+	appendOffsets(t, s, 0, 2)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	seg := segPath(dir, 0)
+
+	r, err := OpenSpoolReader(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for range 2 {
+		if _, err := r.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := encodeSpoolRecord(nil, SpoolRecord{Seq: 2, Partition: 0, Offset: 2, Timestamp: 2, Value: spoolRec(0, 2, 2).Value})
+	for _, cut := range []int{3, spoolHdrLen, spoolHdrLen + 5, len(rec) - 1} {
+		if err := os.Truncate(seg, recordPos(2)); err != nil {
+			t.Fatal(err)
+		}
+		appendFile(t, seg, rec[:cut])
+		if _, err := r.Next(); !errors.Is(err, io.EOF) {
+			t.Fatalf("cut=%d: err=%v want io.EOF", cut, err)
+		}
+	}
+	// the whole record, but its checksum not matching (yet): still the writer's business
+	if err := os.Truncate(seg, recordPos(2)); err != nil {
+		t.Fatal(err)
+	}
+	bad := bytes.Clone(rec)
+	bad[len(bad)-1] ^= 1
+	appendFile(t, seg, bad)
+	if _, err := r.Next(); !errors.Is(err, io.EOF) {
+		t.Fatalf("bad crc in the active segment: err=%v want io.EOF", err)
+	}
+	// once a newer segment exists the bad record is sealed in: corruption
+	if err := os.WriteFile(segPath(dir, 3), []byte(spoolMagic), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); !errors.Is(err, ErrSpoolCorrupt) {
+		t.Fatalf("bad crc in a sealed segment: err=%v want ErrSpoolCorrupt", err)
+	}
+	_ = os.Remove(segPath(dir, 3))
+
+	// completed: the reader picks it up where it stopped
+	if err := os.Truncate(seg, recordPos(2)); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, seg, rec)
+	got, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOffsets(t, []SpoolRecord{got}, 2, 1)
+}
+
+func appendFile(t *testing.T, path string, b []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func flipByte(t *testing.T, path string, pos int64) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[pos] ^= 0x5a
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// done runs in append order, duplicates (acknowledged without a write) included.
+func TestSpoolDoneOrder(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestSpool(t, dir, SpoolOptions{GroupRecords: 7, GroupWait: time.Millisecond})
+	defer s.Close()
+
+	var (
+		order []int
+		errs  []error
+		done  = make(chan struct{})
+	)
+	const n = 500
+	for i := range n {
+		off := int64(i)
+		if i%10 == 9 {
+			off = int64(i - 5) // re-delivered
+		}
+		s.AppendAsync(spoolRec(int32(i%3), off, off), func(err error) {
+			order = append(order, i) // one goroutine: no lock needed
+			errs = append(errs, err)
+			if len(order) == n {
+				close(done)
+			}
+		})
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("%d of %d done", len(order), n)
+	}
+	for i, got := range order {
+		if got != i || errs[i] != nil {
+			t.Fatalf("done #%d was append %d, err=%v", i, got, errs[i])
+		}
+	}
+	if got, want := len(readSpool(t, dir, 0)), n-n/10; got != want {
+		t.Fatalf("spooled %d records, want %d (duplicates skipped)", got, want)
+	}
+}
+
+func TestSpoolWaitDurable(t *testing.T) {
+	dir := t.TempDir()
+	// only WaitDurable's own wait can see these through: the batch isn't full and GroupWait is long
+	s := openTestSpool(t, dir, SpoolOptions{GroupRecords: 1000, GroupWait: 50 * time.Millisecond})
+	defer s.Close()
+
+	for off := range int64(20) {
+		s.AppendAsync(spoolRec(0, off, off), func(error) {})
+	}
+	if err := s.WaitDurable(); err != nil {
+		t.Fatal(err)
+	}
+	checkOffsets(t, readSpool(t, dir, 0), 0, 20)
+	if off, ok := s.LastOffset(0); !ok || off != 19 {
+		t.Fatalf("LastOffset=%d,%v want 19", off, ok)
+	}
+	// nothing queued: returns at once
+	start := time.Now()
+	if err := s.WaitDurable(); err != nil || time.Since(start) > 40*time.Millisecond {
+		t.Fatalf("idle WaitDurable: err=%v after %s", err, time.Since(start))
+	}
+}
+
+// After a failed write the spool is broken for good: the batch and everything queued or appended
+// later fail with the same error.
+func TestSpoolStickyError(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestSpool(t, dir, SpoolOptions{GroupRecords: 1000, GroupWait: 20 * time.Millisecond})
+	appendOffsets(t, s, 0, 2)
+
+	_ = s.f.Close() // the flusher is idle; its next write fails
+
+	errc := make(chan error, 3)
+	for off := int64(2); off < 5; off++ {
+		s.AppendAsync(spoolRec(0, off, off), func(err error) { errc <- err })
+	}
+	var first error
+	for range 3 {
+		err := <-errc
+		if err == nil {
+			t.Fatal("queued append succeeded on a closed file")
+		}
+		if first == nil {
+			first = err
+		} else if err != first {
+			t.Fatalf("errors differ: %v / %v", first, err)
+		}
+	}
+	if err := s.Append(spoolRec(0, 5, 5)); err != first {
+		t.Fatalf("later Append: err=%v want %v", err, first)
+	}
+	if err := s.WaitDurable(); err != first {
+		t.Fatalf("WaitDurable: err=%v want %v", err, first)
+	}
+	if off, _ := s.LastOffset(0); off != 1 {
+		t.Fatalf("LastOffset=%d: failed records counted", off)
+	}
+	if err := s.Close(); err == nil {
+		t.Fatal("Close after a failed write returned nil")
+	}
+}
+
+func TestSpoolCloseFlushes(t *testing.T) {
+	dir := t.TempDir()
+	// without Close nothing would be written for an hour
+	s := openTestSpool(t, dir, SpoolOptions{GroupRecords: 1000, GroupWait: time.Hour})
+
+	errc := make(chan error, 10)
+	for off := range int64(10) {
+		s.AppendAsync(spoolRec(0, off, off), func(err error) { errc <- err })
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range 10 {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Append(spoolRec(0, 10, 10)); !errors.Is(err, ErrSpoolClosed) {
+		t.Fatalf("Append after Close: err=%v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	checkOffsets(t, readSpool(t, dir, 0), 0, 10)
+}
+
+// With GroupWait out of the picture a batch is only ever written because an append kicked the
+// flusher: G appenders each waiting on its record fill a batch of G exactly, so a kick lost
+// anywhere (taken while the flusher writes, dropped on a full channel) stalls them all.
+func TestSpoolKickNoLostWakeup(t *testing.T) {
+	for _, g := range []int{1, 2, 8} {
+		t.Run(fmt.Sprint(g), func(t *testing.T) {
+			s := openTestSpool(t, t.TempDir(), SpoolOptions{GroupRecords: g, GroupWait: time.Hour})
+			defer s.Close()
+
+			const per = 300
+			errc := make(chan error, g)
+			for p := range int32(g) {
+				go func() {
+					for off := range int64(per) {
+						if err := s.Append(spoolRec(p, off, off)); err != nil {
+							errc <- err
+							return
+						}
+					}
+					errc <- nil
+				}()
+			}
+			timeout := time.After(20 * time.Second)
+			for range g {
+				select {
+				case err := <-errc:
+					if err != nil {
+						t.Fatal(err)
+					}
+				case <-timeout:
+					t.Fatalf("appenders stuck at seq=%d", s.NextSeq())
+				}
+			}
+			if got := s.NextSeq(); got != uint64(g*per) {
+				t.Fatalf("NextSeq=%d want %d", got, g*per)
+			}
+		})
+	}
+}
+
+// spoolWithSegments writes n records (partition 0, offset = seq, ts = offset*1000) in ~5-record
+// segments and closes the spool.
+func spoolWithSegments(t *testing.T, dir string, n int64) []uint64 {
+	t.Helper()
+	s := openTestSpool(t, dir, SpoolOptions{SegmentBytes: 200})
+	for off := range n {
+		if err := s.Append(spoolRec(0, off, off*1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	segs, err := listSegments(dir)
+	if err != nil || len(segs) < 4 {
+		t.Fatalf("segments=%v err=%v", segs, err)
+	}
+	return segs
+}
+
+func spoolBytes(t *testing.T, dir string) (total int64) {
+	t.Helper()
+	segs, err := listSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, base := range segs {
+		total += fileSize(t, segPath(dir, base)) + fileSize(t, idxPath(dir, base))
+	}
+	return total
+}
+
+func TestSpoolRetainFor(t *testing.T) {
+	dir := t.TempDir()
+	spoolWithSegments(t, dir, 40)
+
+	// newest ts is 39s: keep everything from 29s on, and no whole segment more
+	s := openTestSpool(t, dir, SpoolOptions{SegmentBytes: 200, RetainFor: 10 * time.Second})
+	defer s.Close()
+	segs, _ := listSegments(dir)
+	recs := readSpool(t, dir, segs[0])
+	if recs[0].Timestamp > 29000 {
+		t.Fatalf("oldest kept ts=%d: records within retain-for deleted", recs[0].Timestamp)
+	}
+	if ts, _, _ := firstSpoolTimestamp(dir, segs[1]); ts <= 29000 {
+		t.Fatalf("segment %d kept though segment %d starts at ts=%d, before the cutoff", segs[0], segs[1], ts)
+	}
+	checkOffsets(t, recs, segs[0], 40-int(segs[0]))
+}
+
+func TestSpoolRetainBytesWins(t *testing.T) {
+	dir := t.TempDir()
+	all := spoolWithSegments(t, dir, 40)
+	size := spoolBytes(t, dir)
+	sizes := make(map[uint64]int64)
+	for _, base := range all {
+		sizes[base] = fileSize(t, segPath(dir, base)) + fileSize(t, idxPath(dir, base))
+	}
+
+	// retain-for alone keeps everything
+	s := openTestSpool(t, dir, SpoolOptions{SegmentBytes: 200, RetainFor: time.Hour})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if segs, _ := listSegments(dir); len(segs) != len(all) {
+		t.Fatalf("retain-for=1h deleted segments: %v -> %v", all, segs)
+	}
+
+	limit := size / 2
+	s = openTestSpool(t, dir, SpoolOptions{SegmentBytes: 200, RetainFor: time.Hour, RetainBytes: limit})
+	defer s.Close()
+	segs, _ := listSegments(dir)
+	if got := spoolBytes(t, dir); got > limit {
+		t.Fatalf("spool is %d bytes, over retain-bytes=%d", got, limit)
+	}
+	if segs[len(segs)-1] != all[len(all)-1] || len(segs) >= len(all) {
+		t.Fatalf("segments %v -> %v: want the oldest deleted, the active kept", all, segs)
+	}
+	// oldest first, and no more than needed: the segment before the oldest kept would not fit
+	if prev := sizes[all[len(all)-len(segs)-1]]; spoolBytes(t, dir)+prev <= limit {
+		t.Fatalf("deleted one segment too many: %d + %d bytes fit in %d", spoolBytes(t, dir), prev, limit)
+	}
+	checkOffsets(t, readSpool(t, dir, segs[0]), segs[0], 40-int(segs[0]))
+}
+
+// A spool reopened resumes dedup where it stopped: the per-partition last offsets come from the
+// indexes of every segment, sealed ones included.
+func TestSpoolDedupAcrossReopen(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestSpool(t, dir, SpoolOptions{SegmentBytes: 200})
+	for off := range int64(10) {
+		if err := s.Append(spoolRec(1, off, off)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for off := range int64(20) { // partition 1 now only lives in sealed segments
+		if err := s.Append(spoolRec(0, off, off)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestSpool(t, dir, SpoolOptions{SegmentBytes: 200})
+	defer s.Close()
+	for p, want := range map[int32]int64{0: 19, 1: 9} {
+		if off, ok := s.LastOffset(p); !ok || off != want {
+			t.Fatalf("LastOffset(%d)=%d,%v want %d", p, off, ok, want)
+		}
+	}
+	if _, ok := s.LastOffset(2); ok {
+		t.Fatal("LastOffset of an unknown partition")
+	}
+
+	// a rebalance re-delivers from older offsets: only the new ones are written
+	for off := int64(15); off < 25; off++ {
+		if err := s.Append(spoolRec(0, off, off)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for off := int64(5); off < 12; off++ {
+		if err := s.Append(spoolRec(1, off, off)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := s.NextSeq(); got != 30+5+2 {
+		t.Fatalf("NextSeq=%d want 37", got)
+	}
+	recs := readSpool(t, dir, 30)
+	want := []struct {
+		p   int32
+		off int64
+	}{{0, 20}, {0, 21}, {0, 22}, {0, 23}, {0, 24}, {1, 10}, {1, 11}}
+	for i, rec := range recs {
+		if rec.Partition != want[i].p || rec.Offset != want[i].off {
+			t.Fatalf("record %d: p=%d off=%d, want %+v", i, rec.Partition, rec.Offset, want[i])
+		}
+	}
+}
+
+// bareTail runs Tail on dir without decoders: recv takes what it hands on.
+func bareTail(t *testing.T, dir string, at Anchor) (*Ingestor, func() RawMsg, chan error) {
+	ig := &Ingestor{
+		rawCh:             make(chan RawMsg),
+		wake:              make(chan struct{}, 1),
+		stop:              make(chan struct{}),
+		firstOffsetByPart: make(map[int32]int64),
+		firstSeenByPart:   make(map[int32]bool),
+		seqByPart:         make(map[int32]int64),
+	}
+	tailErr := make(chan error, 1)
+	go func() { tailErr <- ig.Tail(context.Background(), dir, at) }()
+
+	recv := func() RawMsg {
+		t.Helper()
+		select {
+		case m := <-ig.rawCh:
+			return m
+		case err := <-tailErr:
+			t.Fatalf("Tail returned: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Tail stuck")
+		}
+		return RawMsg{}
+	}
+	return ig, recv, tailErr
+}
+
+// Retention may delete segments Tail hasn't read yet (retain-bytes wins over retain-for): Tail
+// skips to what is left instead of failing.
+func TestTailSurvivesRetention(t *testing.T) {
+	dir := t.TempDir()
+	segs := spoolWithSegments(t, dir, 40)
+
+	ig, recv, tailErr := bareTail(t, dir, Anchor{})
+	for off := range int64(2) {
+		if m := recv(); m.Offset != off {
+			t.Fatalf("got off=%d want %d", m.Offset, off)
+		}
+	}
+
+	// the writer reopens with a size cap: every sealed segment goes
+	s := openTestSpool(t, dir, SpoolOptions{SegmentBytes: 200, RetainBytes: 1})
+	defer s.Close()
+	last := segs[len(segs)-1]
+	if left, _ := listSegments(dir); len(left) != 1 || left[0] != last {
+		t.Fatalf("segments left: %v", left)
+	}
+
+	// Tail already holds segment 0 open and finishes it, then jumps to the oldest segment left
+	next := int64(2)
+	for next < int64(segs[1]) {
+		if m := recv(); m.Offset != next {
+			t.Fatalf("got off=%d want %d", m.Offset, next)
+		}
+		next++
+	}
+	for next = int64(last); next < 40; next++ {
+		if m := recv(); m.Offset != next || m.Cursor != uint64(next) {
+			t.Fatalf("got off=%d cursor=%d want %d", m.Offset, m.Cursor, next)
+		}
+	}
+
+	// and keeps following the writer
+	if err := s.Append(spoolRec(0, 40, 40000)); err != nil {
+		t.Fatal(err)
+	}
+	ig.wakeTail()
+	if m := recv(); m.Offset != 40 {
+		t.Fatalf("got off=%d want 40", m.Offset)
+	}
+	close(ig.stop)
+	if err := <-tailErr; err != nil {
+		t.Fatalf("Tail: %v", err)
+	}
+}
+
+// Tail resumes mid-segment from a seq. Deleting the segment it is reading doesn't disturb it (the
+// open file stays readable); when the next one is gone too it skips to the oldest one left. Either
+// way records come in spool order, none twice, and the ring seq has no gaps.
+func TestTailResumeDeleteUnder(t *testing.T) {
+	dir := t.TempDir()
+	segs := spoolWithSegments(t, dir, 40)
+	if len(segs) < 6 || segs[4]-segs[3] < 4 {
+		t.Fatalf("segments %v: want 6+ of 4+ records", segs)
+	}
+	from := segs[2] + 1
+	ig, recv, tailErr := bareTail(t, dir, Anchor{Cursor: from, Seq: MaxGroutines})
+
+	var got []uint64
+	ring := int64(MaxGroutines)
+	take := func() {
+		t.Helper()
+		m := recv()
+		if m.Cursor != uint64(m.Offset) || m.Seq != ring {
+			t.Fatalf("cursor=%d off=%d seq=%d, want seq %d", m.Cursor, m.Offset, m.Seq, ring)
+		}
+		got = append(got, m.Cursor)
+		ring++
+	}
+	// oldest first, as retention does
+	remove := func(bases ...uint64) {
+		t.Helper()
+		for _, base := range bases {
+			if err := os.Remove(segPath(dir, base)); err != nil {
+				t.Fatal(err)
+			}
+			_ = os.Remove(idxPath(dir, base))
+		}
+	}
+
+	take()
+	remove(segs[:3]...) // up to the one under the reader
+	for got[len(got)-1] < segs[3]+1 {
+		take()
+	}
+	remove(segs[3], segs[4]) // the one under it and the next
+	for got[len(got)-1] < 39 {
+		take()
+	}
+
+	var want []uint64
+	for seq := from; seq < 40; seq++ {
+		if seq < segs[4] || seq >= segs[5] {
+			want = append(want, seq)
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("read %v\nwant %v", got, want)
+	}
+	close(ig.stop)
+	if err := <-tailErr; err != nil {
+		t.Fatalf("Tail: %v", err)
+	}
+}
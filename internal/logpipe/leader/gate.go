@@ -0,0 +1,309 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/out"
+)
+
+// Gate is the out.Sink of a processor that may be standby. While this instance holds the lease
+// outputs go to the sink and the cursor (per stream, see out.Positioned) is saved. While standby
+// they are kept in a pending list, trimmed by the cursor the leader saved (re-read every
+// ReloadEvery); Promote re-reads it, emits what the old leader had not, then goes live.
+//
+// Each Positioned output reaches the sink once, across failovers and restarts:
+//   - an out.Resumable sink (file, Kafka) is its own cursor: Promote asks it for the last position
+//     it holds, so an output that got out after the dead leader's last cursor save is not sent
+//     again. The cursor file is saved in batches after the outputs and only trims the standby;
+//   - any other sink (stdout): the cursor is saved before each output is released. A leader killed
+//     between the save and the sink write loses that one output instead of doubling it.
+//
+// A full standby pending list blocks Emit (and so the compute stage) until the leader's cursor
+// frees room: dropping would lose outputs the leader may not have emitted yet. Outputs that aren't
+// Positioned have no cursor and are only emitted by the leader, never replayed.
+type Gate struct {
+	sink       out.Sink
+	resume     out.Resumable // sink, if it is one
+	lease      Lease
+	cursorPath string
+	opts       GateOptions
+
+	mu       sync.Mutex
+	leader   bool
+	cursor   map[string]uint64
+	pending  []pendingOut
+	unsaved  int       // leader: outputs since the last cursor save
+	savedAt  time.Time // leader: last cursor save
+	loadedAt time.Time // standby: last cursor read
+}
+
+type GateOptions struct {
+	// MaxPending: standby outputs kept for a takeover; Emit blocks beyond it. <=0 means 65536.
+	MaxPending int
+	// Resumable sinks: the leader saves the cursor (write, fsync, rename, fsync dir) once SaveEvery
+	// outputs are unsaved or on the first output SaveInterval after the last save, and on Close.
+	// <=0 means 64 / 1s. Other sinks save before every output.
+	SaveEvery    int
+	SaveInterval time.Duration
+	// ReloadEvery: how often a standby re-reads the cursor to trim pending. <=0 means 1s.
+	ReloadEvery time.Duration
+}
+
+type pendingOut struct {
+	typ    string
+	v      any
+	stream string
+	seq    uint64
+}
+
+// NewGate: cursorPath is shared by all instances (next to the spool).
+func NewGate(sink out.Sink, lease Lease, cursorPath string, opts GateOptions) *Gate {
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = 1 << 16
+	}
+	if opts.SaveEvery <= 0 {
+		opts.SaveEvery = 64
+	}
+	if opts.SaveInterval <= 0 {
+		opts.SaveInterval = time.Second
+	}
+	if opts.ReloadEvery <= 0 {
+		opts.ReloadEvery = time.Second
+	}
+	resume, _ := sink.(out.Resumable)
+	return &Gate{sink: sink, resume: resume, lease: lease, cursorPath: cursorPath, opts: opts, cursor: make(map[string]uint64)}
+}
+
+func (g *Gate) Emit(ctx context.Context, typ string, v any) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.leader && !g.lease.Held() {
+		log.Printf("[leader] lease lost, standing by")
+		g.leader = false
+	}
+	stream, seq, positioned := position(v)
+	if !g.leader {
+		if !positioned {
+			return nil
+		}
+		if time.Since(g.loadedAt) >= g.opts.ReloadEvery {
+			g.loadCursor()
+			g.trim()
+		}
+		if err := g.waitRoom(ctx); err != nil {
+			return err
+		}
+	}
+	if !g.leader {
+		g.pending = append(g.pending, pendingOut{typ: typ, v: v, stream: stream, seq: seq})
+		return nil
+	}
+	// leader, or promoted while waiting for room (pending is replayed, this one comes next)
+	return g.emit(ctx, typ, v, stream, seq, positioned)
+}
+
+// waitRoom blocks a standby while pending is full, re-reading the cursor every ReloadEvery. It
+// returns early if this instance is promoted meanwhile.
+func (g *Gate) waitRoom(ctx context.Context) error {
+	warned := false
+	for !g.leader && len(g.pending) >= g.opts.MaxPending {
+		if !warned {
+			log.Printf("[leader][warn] standby pending full (%d, oldest %s seq=%d): holding compute until the leader's cursor passes it",
+				len(g.pending), g.pending[0].stream, g.pending[0].seq)
+			warned = true
+		}
+		g.mu.Unlock()
+		t := time.NewTimer(g.opts.ReloadEvery)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			g.mu.Lock()
+			return ctx.Err()
+		case <-t.C:
+		}
+		g.mu.Lock()
+		if !g.leader {
+			g.loadCursor()
+			g.trim()
+		}
+	}
+	return nil
+}
+
+// Promote is called once the lease is held: outputs after the last leader's cursor (and after what
+// a resumable sink already holds) are emitted, from then on Emit goes to the sink.
+func (g *Gate) Promote(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.loadCursor()
+	if g.resume != nil {
+		pos, err := g.resume.Positions(ctx)
+		if err != nil {
+			// without it the outputs after the saved cursor may be sent twice
+			return fmt.Errorf("read back sink positions: %w", err)
+		}
+		for k, v := range pos {
+			if cur, ok := g.cursor[k]; !ok || v > cur {
+				g.cursor[k] = v
+			}
+		}
+	}
+	g.trim()
+	n := len(g.pending)
+	for len(g.pending) > 0 {
+		p := g.pending[0]
+		if err := g.emit(ctx, p.typ, p.v, p.stream, p.seq, true); err != nil {
+			return err
+		}
+		g.pending = g.pending[1:]
+	}
+	g.pending = nil
+	g.leader = true
+	g.unsaved++ // the sink's positions may be ahead of the file
+	if err := g.flushCursor(); err != nil {
+		log.Printf("[leader][warn] save cursor %s: %v", g.cursorPath, err)
+	}
+	log.Printf("[leader] promoted: replayed %d pending outputs, cursor=%v", n, g.cursor)
+	return nil
+}
+
+func (g *Gate) emit(ctx context.Context, typ string, v any, stream string, seq uint64, positioned bool) error {
+	if !positioned {
+		return g.sink.Emit(ctx, typ, v)
+	}
+	if last, ok := g.cursor[stream]; ok && seq <= last {
+		return nil // the previous leader (or this instance before a restart) emitted it
+	}
+	if g.resume == nil {
+		// nothing to ask after a crash: the cursor goes first
+		g.cursor[stream] = seq
+		g.unsaved++
+		if err := g.flushCursor(); err != nil {
+			// holding the output back would lose it for sure; only a crash now doubles it
+			log.Printf("[leader][warn] save cursor %s: %v", g.cursorPath, err)
+		}
+		return g.sink.Emit(ctx, typ, v)
+	}
+
+	if err := g.sink.Emit(ctx, typ, v); err != nil {
+		return err
+	}
+	g.cursor[stream] = seq
+	g.unsaved++
+	if g.unsaved >= g.opts.SaveEvery || time.Since(g.savedAt) >= g.opts.SaveInterval {
+		if err := g.flushCursor(); err != nil {
+			// the output is out and the sink knows it: the file only trims standbys
+			log.Printf("[leader][warn] save cursor %s: %v", g.cursorPath, err)
+		}
+	}
+	return nil
+}
+
+// flushCursor saves the cursor if outputs were emitted since the last save.
+func (g *Gate) flushCursor() error {
+	if g.unsaved == 0 {
+		return nil
+	}
+	if err := g.saveCursor(); err != nil {
+		return err
+	}
+	g.unsaved, g.savedAt = 0, time.Now()
+	return nil
+}
+
+// trim drops pending outputs the leader has already emitted.
+func (g *Gate) trim() {
+	i := 0
+	for _, p := range g.pending {
+		if last, ok := g.cursor[p.stream]; ok && p.seq <= last {
+			continue
+		}
+		g.pending[i] = p
+		i++
+	}
+	clear(g.pending[i:])
+	g.pending = g.pending[:i]
+}
+
+func (g *Gate) loadCursor() {
+	g.loadedAt = time.Now()
+	b, err := os.ReadFile(g.cursorPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[leader][warn] read cursor %s: %v", g.cursorPath, err)
+		}
+		return
+	}
+	var c map[string]uint64
+	if err := json.Unmarshal(b, &c); err != nil {
+		log.Printf("[leader][warn] read cursor %s: %v", g.cursorPath, err)
+		return
+	}
+	for k, v := range c {
+		if cur, ok := g.cursor[k]; !ok || v > cur {
+			g.cursor[k] = v
+		}
+	}
+}
+
+// saveCursor replaces the cursor file: a crash leaves the old one or the new one, never a torn or
+// empty file, and the rename itself is durable before the outputs it covers are forgotten.
+func (g *Gate) saveCursor() error {
+	b, err := json.Marshal(g.cursor)
+	if err != nil {
+		return err
+	}
+	tmp := g.cursorPath + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := errors.Join(f.Sync(), f.Close()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, g.cursorPath); err != nil {
+		return err
+	}
+	d, err := os.Open(filepath.Dir(g.cursorPath))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Close saves the cursor (leader) and closes the sink.
+func (g *Gate) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var err error
+	if g.leader && g.lease.Held() {
+		err = g.flushCursor()
+	}
+	return errors.Join(err, g.sink.Close())
+}
+
+func position(v any) (string, uint64, bool) {
+	p, ok := v.(out.Positioned)
+	if !ok {
+		return "", 0, false
+	}
+	stream, seq := p.Position()
+	return stream, seq, true
+}
+
+var _ out.Sink = (*Gate)(nil)
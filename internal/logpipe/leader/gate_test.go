@@ -0,0 +1,433 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/out"
+)
+
+type fakeLease struct {
+	mu   sync.Mutex
+	held bool
+}
+
+func (l *fakeLease) TryAcquire() (bool, error) { return l.Held(), nil }
+func (l *fakeLease) Release() error            { l.set(false); return nil }
+
+func (l *fakeLease) Held() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.held
+}
+
+func (l *fakeLease) set(held bool) {
+	l.mu.Lock()
+	l.held = held
+	l.mu.Unlock()
+}
+
+type recSink struct {
+	got    []string
+	pos    map[string]uint64
+	closed bool
+}
+
+func (s *recSink) Emit(_ context.Context, typ string, v any) error {
+	if p, ok := v.(posOut); ok {
+		s.got = append(s.got, p.String())
+		if s.pos == nil {
+			s.pos = make(map[string]uint64)
+		}
+		s.pos[p.stream] = max(s.pos[p.stream], p.seq)
+	} else {
+		s.got = append(s.got, typ)
+	}
+	return nil
+}
+
+func (s *recSink) Close() error { s.closed = true; return nil }
+
+// resSink is a recSink that can tell what it holds (out.Resumable).
+type resSink struct{ *recSink }
+
+func (s resSink) Positions(context.Context) (map[string]uint64, error) {
+	return maps.Clone(s.pos), nil
+}
+
+// posOut is a Positioned output.
+type posOut struct {
+	stream string
+	seq    uint64
+}
+
+func (o posOut) Position() (string, uint64) { return o.stream, o.seq }
+func (o posOut) String() string             { return fmt.Sprintf("%s:%02d", o.stream, o.seq) }
+
+func newTestGate(t *testing.T, path string, held bool, opts GateOptions) (*Gate, *fakeLease, *recSink) {
+	t.Helper()
+	s := &recSink{}
+	g, l := newGateOn(t, s, path, held, opts)
+	return g, l, s
+}
+
+func newGateOn(t *testing.T, s out.Sink, path string, held bool, opts GateOptions) (*Gate, *fakeLease) {
+	t.Helper()
+	l := &fakeLease{held: held}
+	g := NewGate(s, l, path, opts)
+	if held {
+		if err := g.Promote(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return g, l
+}
+
+func emitSeqs(t *testing.T, g *Gate, stream string, from, to uint64) {
+	t.Helper()
+	for seq := from; seq <= to; seq++ {
+		if err := g.Emit(context.Background(), "tick", posOut{stream, seq}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readCursor(t *testing.T, path string) map[string]uint64 {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c map[string]uint64
+	if err := json.Unmarshal(b, &c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func pendingSeqs(g *Gate) []uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var seqs []uint64
+	for _, p := range g.pending {
+		seqs = append(seqs, p.seq)
+	}
+	return seqs
+}
+
+func TestGateStandbyTrimsByCursor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.cursor")
+	lead, _, _ := newTestGate(t, path, true, GateOptions{SaveEvery: 1})
+
+	// a standby re-reading the cursor on every output, and one that practically never does
+	eager, _, _ := newTestGate(t, path, false, GateOptions{ReloadEvery: time.Nanosecond})
+	lazy, _, lazySink := newTestGate(t, path, false, GateOptions{ReloadEvery: time.Hour})
+
+	emitSeqs(t, eager, "a", 1, 10)
+	emitSeqs(t, lazy, "a", 1, 10)
+	emitSeqs(t, lead, "a", 1, 6)
+
+	emitSeqs(t, eager, "a", 11, 11)
+	emitSeqs(t, lazy, "a", 11, 11)
+	if got, want := pendingSeqs(eager), []uint64{7, 8, 9, 10, 11}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("eager standby pending=%v want %v", got, want)
+	}
+	if got := pendingSeqs(lazy); len(got) != 11 {
+		t.Fatalf("lazy standby pending=%v: reloaded the cursor before ReloadEvery", got)
+	}
+	if len(lazySink.got) != 0 {
+		t.Fatalf("standby emitted %v", lazySink.got)
+	}
+}
+
+// A full standby blocks until the leader's cursor frees room, or until it is promoted itself;
+// nothing is dropped.
+func TestGateMaxPending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.cursor")
+	g, lease, sink := newTestGate(t, path, false, GateOptions{MaxPending: 3, ReloadEvery: 5 * time.Millisecond})
+	emitSeqs(t, g, "a", 1, 3)
+
+	emitAsync := func(seq uint64) chan error {
+		done := make(chan error, 1)
+		go func() { done <- g.Emit(context.Background(), "tick", posOut{"a", seq}) }()
+		return done
+	}
+	blocked := func(done chan error) bool {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			return false
+		case <-time.After(50 * time.Millisecond):
+			return true
+		}
+	}
+	wait := func(done chan error) {
+		t.Helper()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Emit still blocked")
+		}
+	}
+
+	done := emitAsync(4)
+	if !blocked(done) {
+		t.Fatalf("Emit returned with pending full: %v", pendingSeqs(g))
+	}
+	lead, _, _ := newTestGate(t, path, true, GateOptions{})
+	emitSeqs(t, lead, "a", 2, 2) // one cursor save covering 1 and 2
+	wait(done)
+	if got, want := pendingSeqs(g), []uint64{3, 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("pending=%v want %v", got, want)
+	}
+
+	// full again, and this time the standby takes over
+	emitSeqs(t, g, "a", 5, 5)
+	done = emitAsync(6)
+	if !blocked(done) {
+		t.Fatalf("Emit returned with pending full: %v", pendingSeqs(g))
+	}
+	lead.lease.(*fakeLease).set(false)
+	lease.set(true)
+	if err := g.Promote(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wait(done)
+	if want := []string{"a:03", "a:04", "a:05", "a:06"}; !reflect.DeepEqual(sink.got, want) {
+		t.Fatalf("emitted %v want %v", sink.got, want)
+	}
+
+	// a blocked Emit gives up with its context
+	g2, _, _ := newTestGate(t, filepath.Join(t.TempDir(), "leader.cursor"), false, GateOptions{MaxPending: 1, ReloadEvery: time.Millisecond})
+	emitSeqs(t, g2, "a", 1, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := g2.Emit(ctx, "tick", posOut{"a", 2}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Emit err=%v want deadline", err)
+	}
+}
+
+// Promote emits exactly what the old leader's cursor doesn't cover, per stream and in order, then
+// the gate is live.
+func TestGatePromoteReplaysAfterCursor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.cursor")
+	old, oldLease, _ := newTestGate(t, path, true, GateOptions{SaveEvery: 1})
+	g, lease, sink := newTestGate(t, path, false, GateOptions{ReloadEvery: time.Hour})
+
+	emitSeqs(t, g, "a", 1, 10)
+	emitSeqs(t, g, "b", 1, 5)
+	if err := g.Emit(context.Background(), "plain", "not positioned"); err != nil {
+		t.Fatal(err)
+	}
+	emitSeqs(t, old, "a", 1, 6)
+	emitSeqs(t, old, "b", 1, 5)
+
+	// the old leader dies, this one takes the lease
+	oldLease.set(false)
+	lease.set(true)
+	if err := g.Promote(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a:07", "a:08", "a:09", "a:10"}; !reflect.DeepEqual(sink.got, want) {
+		t.Fatalf("replayed %v want %v", sink.got, want)
+	}
+	if len(pendingSeqs(g)) != 0 {
+		t.Fatal("pending kept after Promote")
+	}
+	if c := readCursor(t, path); c["a"] != 10 || c["b"] != 5 {
+		t.Fatalf("cursor after Promote=%v", c)
+	}
+
+	sink.got = nil
+	emitSeqs(t, g, "a", 9, 11) // 9, 10 already out
+	emitSeqs(t, g, "c", 1, 1)
+	if err := g.Emit(context.Background(), "plain", "not positioned"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a:11", "c:01", "plain"}; !reflect.DeepEqual(sink.got, want) {
+		t.Fatalf("live emits %v want %v", sink.got, want)
+	}
+
+	// and once the lease is gone it stands by again
+	lease.set(false)
+	sink.got = nil
+	emitSeqs(t, g, "a", 12, 12)
+	if len(sink.got) != 0 || !reflect.DeepEqual(pendingSeqs(g), []uint64{12}) {
+		t.Fatalf("after losing the lease: emitted %v, pending %v", sink.got, pendingSeqs(g))
+	}
+}
+
+// With a resumable sink the cursor only trims standbys, so it is saved in batches.
+func TestGateCursorBatching(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.cursor")
+	sink := &recSink{}
+	g, _ := newGateOn(t, resSink{sink}, path, true, GateOptions{SaveEvery: 3, SaveInterval: time.Hour})
+
+	emitSeqs(t, g, "a", 1, 2)
+	if c := readCursor(t, path); len(c) != 0 {
+		t.Fatalf("cursor saved after 2 of 3 outputs: %v", c)
+	}
+	emitSeqs(t, g, "a", 3, 4)
+	if c := readCursor(t, path); c["a"] != 3 {
+		t.Fatalf("cursor=%v want a=3", c)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temp file left: %v", err)
+	}
+
+	// Close saves the rest while the lease is held
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c := readCursor(t, path); c["a"] != 4 || !sink.closed {
+		t.Fatalf("after Close: cursor=%v sink closed=%v", c, sink.closed)
+	}
+
+	// a gate without the lease leaves the cursor to whoever has it
+	g2, _ := newGateOn(t, resSink{&recSink{}}, path, true, GateOptions{SaveEvery: 10, SaveInterval: time.Hour})
+	emitSeqs(t, g2, "a", 5, 6)
+	g2.lease.(*fakeLease).set(false)
+	if err := g2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c := readCursor(t, path); c["a"] != 4 {
+		t.Fatalf("cursor=%v: saved without the lease", c)
+	}
+}
+
+// cursorSink checks that the gate saved the cursor before handing it an output.
+type cursorSink struct {
+	t    *testing.T
+	path string
+	n    int
+}
+
+func (s *cursorSink) Emit(_ context.Context, _ string, v any) error {
+	p := v.(posOut)
+	if c := readCursor(s.t, s.path); c[p.stream] < p.seq {
+		s.t.Errorf("%s emitted before the cursor covered it: %v", p, c)
+	}
+	s.n++
+	return nil
+}
+
+func (s *cursorSink) Close() error { return nil }
+
+// A sink that can't be read back gets the cursor saved ahead of every output: a leader killed in
+// between loses that output, it is never emitted twice.
+func TestGateSavesCursorFirst(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.cursor")
+	sink := &cursorSink{t: t, path: path}
+	g, lease := newGateOn(t, sink, path, true, GateOptions{SaveEvery: 100, SaveInterval: time.Hour})
+	emitSeqs(t, g, "a", 1, 5)
+	if sink.n != 5 {
+		t.Fatalf("emitted %d want 5", sink.n)
+	}
+
+	// killed after saving a:6 but before the write: the next leader goes on with a:7
+	g.cursor["a"] = 6
+	if err := g.saveCursor(); err != nil {
+		t.Fatal(err)
+	}
+	lease.set(false)
+	next, _, nextSink := newTestGate(t, path, false, GateOptions{})
+	emitSeqs(t, next, "a", 1, 7)
+	next.lease.(*fakeLease).set(true)
+	if err := next.Promote(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a:07"}; !reflect.DeepEqual(nextSink.got, want) {
+		t.Fatalf("next leader emitted %v want %v", nextSink.got, want)
+	}
+}
+
+// The leader is killed after outputs reached the file but long before its cursor save, mid-way
+// through writing a line. The new leader resumes from what the file holds: every output is in the
+// file exactly once and the torn line is gone.
+func TestGateKilledBeforeSave(t *testing.T) {
+	dir := t.TempDir()
+	path, outPath := filepath.Join(dir, "leader.cursor"), filepath.Join(dir, "out.jsonl")
+	opts := GateOptions{SaveEvery: 1000, SaveInterval: time.Hour, ReloadEvery: time.Hour}
+
+	oldSink, err := out.NewFileSink(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, oldLease := newGateOn(t, oldSink, path, true, opts)
+	newSink, err := out.NewFileSink(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, lease := newGateOn(t, newSink, path, false, opts)
+	defer g.Close()
+
+	emitSeqs(t, g, "a", 1, 8)
+	emitSeqs(t, g, "b", 1, 3)
+	emitSeqs(t, old, "a", 1, 5)
+	emitSeqs(t, old, "b", 1, 3)
+	if c := readCursor(t, path); c["a"] != 0 || c["b"] != 0 {
+		t.Fatalf("cursor=%v: the test wants the kill before a save", c)
+	}
+	f, err := os.OpenFile(outPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"type":"tick","ts":1,"data":{},"pos":{"stream":"a","se`); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	// killed: no Close, the lease goes to the standby
+	oldLease.set(false)
+	lease.set(true)
+	if err := g.Promote(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	emitSeqs(t, g, "a", 9, 9)
+
+	b, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]int)
+	var order []uint64
+	for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+		var env out.Envelope
+		if err := json.Unmarshal([]byte(line), &env); err != nil || env.Pos == nil {
+			t.Fatalf("bad line %q: %v", line, err)
+		}
+		seen[posOut{env.Pos.Stream, env.Pos.Seq}.String()]++
+		if env.Pos.Stream == "a" {
+			order = append(order, env.Pos.Seq)
+		}
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Errorf("%s in the output %d times", k, n)
+		}
+	}
+	if want := []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("stream a=%v want %v", order, want)
+	}
+	if len(seen) != 12 {
+		t.Fatalf("%d distinct outputs want 12: %v", len(seen), seen)
+	}
+}
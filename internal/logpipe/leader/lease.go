@@ -0,0 +1,103 @@
+// Package leader lets several processors tail one spool while only one of them writes it and
+// emits: the holder of a Lease. The others keep identical window state and take over from the
+// output cursor the leader recorded (Gate).
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Lease is the leader lock. Only flock for now; a backend with expiry (etcd, a DB row) reports the
+// loss through Held, the gate checks it before every output.
+type Lease interface {
+	// TryAcquire takes the lease if it is free; ok=false when another instance holds it.
+	TryAcquire() (ok bool, err error)
+	// Held reports whether this instance (still) holds the lease.
+	Held() bool
+	Release() error
+}
+
+// Acquire polls l every poll until it is held or ctx ends. <=0 poll means 100ms.
+func Acquire(ctx context.Context, l Lease, poll time.Duration) error {
+	if poll <= 0 {
+		poll = 100 * time.Millisecond
+	}
+	t := time.NewTicker(poll)
+	defer t.Stop()
+	for {
+		ok, err := l.TryAcquire()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// FlockLease is an exclusive flock(2) on a file (the spool directory's leader.lock). The kernel
+// drops it when the holder dies, however it dies, so the next TryAcquire of a standby succeeds.
+// Only good for instances on one host (or a filesystem with working flock).
+type FlockLease struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func NewFlockLease(path string) *FlockLease {
+	return &FlockLease{path: path}
+}
+
+func (l *FlockLease) TryAcquire() (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		return true, nil
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return false, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		}
+		return false, fmt.Errorf("flock %s: %w", l.path, err)
+	}
+	// for operators only: who holds it
+	host, _ := os.Hostname()
+	_ = f.Truncate(0)
+	_, _ = f.WriteAt([]byte(fmt.Sprintf("pid=%d host=%s since=%s\n", os.Getpid(), host, time.Now().Format(time.RFC3339))), 0)
+	l.f = f
+	return true, nil
+}
+
+func (l *FlockLease) Held() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f != nil
+}
+
+func (l *FlockLease) Release() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := errors.Join(syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN), l.f.Close())
+	l.f = nil
+	return err
+}
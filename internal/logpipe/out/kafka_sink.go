@@ -3,10 +3,13 @@ package out
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"time"
 
 	"github.com/IBM/sarama"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/schema"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
 )
 
 type Sink interface {
@@ -14,45 +17,54 @@ type Sink interface {
 	Close() error
 }
 
+// KafkaSink sends envelopes to topic. Positioned outputs are keyed by stream, so each stream stays
+// in order on one partition, and the sink is Resumable: Positions reads the partitions' tails.
 type KafkaSink struct {
-	topic string
-	p     sarama.SyncProducer
+	topic      string
+	producerID string
+	client     sarama.Client
+	p          sarama.SyncProducer
 }
 
-func NewKafkaSink(brokers []string, topic string, cfg *sarama.Config) (*KafkaSink, error) {
+// resumeScan: records read back per partition by Positions. The gate saves its cursor at least every
+// few dozen outputs, and only outputs newer than that need finding.
+const resumeScan = 4096
+
+// NewKafkaSink: producerID goes into the producer-id header (package schema) of every envelope.
+func NewKafkaSink(brokers []string, topic string, producerID string, cfg *sarama.Config) (*KafkaSink, error) {
 	if cfg == nil {
 		cfg = sarama.NewConfig()
 	}
 	cfg.Producer.Return.Successes = true
 	cfg.Producer.Return.Errors = true
+	// a retried send must not land twice: Positions would hide it, but readers would see both
+	cfg.Producer.Idempotent = true
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Net.MaxOpenRequests = 1
 
-	p, err := sarama.NewSyncProducer(brokers, cfg)
+	client, err := sarama.NewClient(brokers, cfg)
+	if err != nil {
+		return nil, err
+	}
+	p, err := sarama.NewSyncProducerFromClient(client)
 	if err != nil {
+		_ = client.Close()
 		return nil, err
 	}
-	return &KafkaSink{topic: topic, p: p}, nil
+	return &KafkaSink{topic: topic, producerID: producerID, client: client, p: p}, nil
 }
 
 func (s *KafkaSink) Close() error {
-	if s.p != nil {
-		return s.p.Close()
+	if s.p == nil {
+		return nil
 	}
-	return nil
+	return errors.Join(s.p.Close(), s.client.Close())
 }
 
 func (s *KafkaSink) Emit(ctx context.Context, typ string, v any) error {
 	_ = ctx // SyncProducer 不吃 ctx，先留签名方便未来升级
 
-	data, err := json.Marshal(v)
-	if err != nil {
-		return err
-	}
-	env := Envelope{
-		Type: typ,
-		TS:   time.Now().UnixMilli(),
-		Data: data,
-	}
-	b, err := json.Marshal(env)
+	b, pos, err := encodeEnvelope(typ, v)
 	if err != nil {
 		return err
 	}
@@ -60,6 +72,13 @@ func (s *KafkaSink) Emit(ctx context.Context, typ string, v any) error {
 	msg := &sarama.ProducerMessage{
 		Topic: s.topic,
 		Value: sarama.ByteEncoder(b),
+		// the envelope type is the schema: the writer checks it against its catalog before decoding
+		Headers: schema.Meta{
+			Name: schema.Out(typ), Version: schema.OutV1, Codec: model.ContentTypeJSON, ProducerID: s.producerID,
+		}.Headers(),
+	}
+	if pos != nil {
+		msg.Key = sarama.StringEncoder(pos.Stream)
 	}
 	_, _, err = s.p.SendMessage(msg)
 	if err != nil {
@@ -67,3 +86,62 @@ func (s *KafkaSink) Emit(ctx context.Context, typ string, v any) error {
 	}
 	return nil
 }
+
+// Positions reads the last resumeScan records of every partition of the topic.
+func (s *KafkaSink) Positions(ctx context.Context) (map[string]uint64, error) {
+	parts, err := s.client.Partitions(s.topic)
+	if err != nil {
+		return nil, err
+	}
+	cons, err := sarama.NewConsumerFromClient(s.client)
+	if err != nil {
+		return nil, err
+	}
+	defer cons.Close()
+
+	pos := make(map[string]uint64)
+	for _, part := range parts {
+		if err := s.scanTail(ctx, cons, part, pos); err != nil {
+			return nil, fmt.Errorf("read back %s/%d: %w", s.topic, part, err)
+		}
+	}
+	return pos, nil
+}
+
+func (s *KafkaSink) scanTail(ctx context.Context, cons sarama.Consumer, part int32, pos map[string]uint64) error {
+	end, err := s.client.GetOffset(s.topic, part, sarama.OffsetNewest)
+	if err != nil {
+		return err
+	}
+	from, err := s.client.GetOffset(s.topic, part, sarama.OffsetOldest)
+	if err != nil {
+		return err
+	}
+	from = max(from, end-resumeScan)
+	if from >= end {
+		return nil
+	}
+	pc, err := cons.ConsumePartition(s.topic, part, from)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-pc.Errors():
+			return err
+		case m := <-pc.Messages():
+			var env Envelope
+			if json.Unmarshal(m.Value, &env) == nil {
+				notePosition(pos, env.Pos)
+			}
+			if m.Offset >= end-1 {
+				return nil
+			}
+		}
+	}
+}
+
+var _ Resumable = (*KafkaSink)(nil)
@@ -1,18 +1,72 @@
 package out
 
 import (
+	"context"
 	"encoding/json"
+	"strconv"
+	"time"
 )
 
 type Envelope struct {
 	Type string          `json:"type"` // e.g. "win_tick"
 	TS   int64           `json:"ts"`   // unix milli
 	Data json.RawMessage `json:"data"`
+
+	// Pos of a Positioned output: resumable sinks find the last one they hold in their own output.
+	Pos *Position `json:"pos,omitempty"`
+}
+
+type Position struct {
+	Stream string `json:"stream"`
+	Seq    uint64 `json:"seq"`
 }
 
 type WinTick struct {
-	WinIdx  int   `json:"win_idx"`
-	Head    int64 `json:"head"`
-	Tail    int64 `json:"tail"`
-	OpenWin bool  `json:"open_win"`
+	WinIdx  int    `json:"win_idx"`
+	Head    int64  `json:"head"`
+	Tail    int64  `json:"tail"`
+	OpenWin bool   `json:"open_win"`
+	Cursor  uint64 `json:"cursor"` // spool seq of the block the tick was computed at
+}
+
+// Positioned outputs know where in the input they were computed: seq increases within stream.
+// The leader gate (package leader) uses it so a new leader resumes exactly after the old one.
+type Positioned interface {
+	Position() (stream string, seq uint64)
+}
+
+// Resumable sinks can read their own output back: Positions returns, per stream, the highest seq
+// they hold. A new leader (package leader) resumes after it, so an output that got out just before
+// the old leader died is not sent again, whatever its cursor file says.
+type Resumable interface {
+	Positions(ctx context.Context) (map[string]uint64, error)
+}
+
+// encodeEnvelope wraps v in an Envelope, with its position if it has one.
+func encodeEnvelope(typ string, v any) ([]byte, *Position, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	env := Envelope{Type: typ, TS: time.Now().UnixMilli(), Data: data}
+	if p, ok := v.(Positioned); ok {
+		stream, seq := p.Position()
+		env.Pos = &Position{Stream: stream, Seq: seq}
+	}
+	b, err := json.Marshal(env)
+	return b, env.Pos, err
+}
+
+// notePosition raises pos[p.Stream] to p.Seq.
+func notePosition(pos map[string]uint64, p *Position) {
+	if p == nil {
+		return
+	}
+	if cur, ok := pos[p.Stream]; !ok || p.Seq > cur {
+		pos[p.Stream] = p.Seq
+	}
+}
+
+func (t WinTick) Position() (string, uint64) {
+	return "win_tick/" + strconv.Itoa(t.WinIdx), t.Cursor
 }
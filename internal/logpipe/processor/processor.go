@@ -2,12 +2,20 @@ package processor
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/IBM/sarama"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/blockfile"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/ckptstore"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/leader"
 	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/out"
+	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/schema"
 	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/window"
 
 	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/dispatcher"
@@ -21,14 +29,41 @@ type Config struct {
 	Group     string
 	Topic     string
 
-	SpoolPath    string
-	DecodeWorker int
-	DecodeQueue  int
+	// Source: empty reads Topic from Kafka; otherwise a directory / glob / file of fetcher file-sink
+	// output (package blockfile) replayed once, without Kafka. Run returns when it is consumed.
+	Source string
 
-	CheckpointPath string // 暂时还能留着（以后迁移）
+	// Out: "kafka" (default, logpipe.out), "stdout" or "file:<path>" (NDJSON envelopes).
+	Out string
+
+	// DLQTopic receives blocks-topic records whose schema headers this build can't read
+	// (schema.BlockCatalog). Default: Topic + ".dlq".
+	DLQTopic string
+
+	SpoolPath         string        // directory of spool segments
+	SpoolSegmentBytes int64         // <=0 means 128MiB
+	SpoolGroupRecords int           // group commit: fsync every N records... <=0 means 256
+	SpoolGroupWait    time.Duration // ...or this long after the first one. <=0 means 200µs
+	SpoolRetain       time.Duration // event time kept in the spool; raised to the longest window + 1h. <=0 means that
+	SpoolRetainBytes  int64         // spool size cap, wins over SpoolRetain; <=0 means none
+	DecodeWorker      int
+	DecodeQueue       int
+
+	// Lease: leader lock among processors sharing SpoolPath. Only the holder reads Kafka into the
+	// spool and emits; the others tail the spool as hot standby. nil: flock on <SpoolPath>/leader.lock.
+	Lease leader.Lease
+
+	// CheckpointPath: postgres:// / sqlite: DSN of the shared checkpoint store (ckptstore), row PipelineID.
+	// A plain file path is still reserved (ignored).
+	CheckpointPath  string
+	PipelineID      string        // default: "processor/" + Group
+	CheckpointEvery time.Duration // <=0 means 2s
 	// WindowSec/GapSec 先留着，后面下游再用
 }
 
+// longestWindow: the 86400s window of the ingestor (ingest.Ingestor winTs).
+const longestWindow = 24 * time.Hour
+
 type Processor struct {
 	cfg Config
 
@@ -38,50 +73,143 @@ type Processor struct {
 	disp     *dispatcher.Dispatcher
 	ingestor *ingest.Ingestor
 	wins     []*window.Runner
+	ckpt     ckptstore.Store // nil: no checkpoint
+	sink     out.Sink
+	gate     *leader.Gate // wraps sink in Kafka mode; nil in file-source mode
+	lease    leader.Lease
+	dlq      *schema.DLQ // nil in file-source mode
+	savedNum int64
+
+	savedAnchor ingest.Anchor // last one written to <SpoolPath>/compute.anchor (leader)
 }
 
 func New(cfg Config) (*Processor, error) {
-	sp, err := ingest.NewFileSpool(cfg.SpoolPath)
-
 	disp := dispatcher.NewDispatcher(16)
 	addrs := ids.NewAddressID(64, 1<<12)
 	tokens := ids.NewTokenID(32, 1<<10)
 	adapter := ingest.NewMockChainAdapter(addrs, tokens)
 
-	client, err := sarama.NewClient(strings.Split(cfg.Brokers, ","), sarama.NewConfig())
-	if err != nil {
-		return nil, err
+	if cfg.DLQTopic == "" {
+		cfg.DLQTopic = cfg.Topic + ".dlq"
 	}
+	if cfg.PipelineID == "" {
+		cfg.PipelineID = "processor/" + cfg.Group
+	}
+
+	var (
+		client sarama.Client
+		cons   *Consumer
+		dlq    *schema.DLQ
+		err    error
+	)
+	if cfg.Source == "" {
+		ccfg := sarama.NewConfig()
+		// fetcher -exactly-once writes the blocks topic transactionally: skip aborted / open txns
+		ccfg.Consumer.IsolationLevel = sarama.ReadCommitted
+		client, err = sarama.NewClient(strings.Split(cfg.Brokers, ","), ccfg)
+		if err != nil {
+			return nil, err
+		}
 
-	cons, err := NewConsumerWithClient(client, cfg.Group)
+		cons, err = NewConsumerWithClient(client, cfg.Group)
+		if err != nil {
+			_ = client.Close()
+			return nil, err
+		}
+
+		dlq, err = schema.NewDLQ(strings.Split(cfg.Brokers, ","), cfg.DLQTopic, cfg.PipelineID)
+		if err != nil {
+			_ = cons.Close()
+			_ = client.Close()
+			return nil, err
+		}
+	}
+	closeKafka := func() {
+		if cons != nil {
+			_ = dlq.Close()
+			_ = cons.Close()
+			_ = client.Close()
+		}
+	}
+	if cfg.CheckpointEvery <= 0 {
+		cfg.CheckpointEvery = 2 * time.Second
+	}
+	// compute replays the longest window from the spool on restart / takeover: never keep less
+	if minRetain := longestWindow + time.Hour; cfg.SpoolRetain < minRetain {
+		if cfg.SpoolRetain > 0 {
+			log.Printf("[processor][warn] spool retain %s is shorter than the longest window, using %s", cfg.SpoolRetain, minRetain)
+		}
+		cfg.SpoolRetain = minRetain
+	}
+	var ckpt ckptstore.Store
+	if ckptstore.IsDSN(cfg.CheckpointPath) {
+		octx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ckpt, err = ckptstore.Open(octx, cfg.CheckpointPath)
+		cancel()
+		if err != nil {
+			closeKafka()
+			return nil, err
+		}
+	}
+
+	// the spool is opened for writing once this instance is leader (Run)
+	ig := ingest.NewIngestor(cfg.ReadyFifo, disp, nil, cfg.DecodeWorker, cfg.DecodeQueue, adapter, client, cfg.Topic, schema.BlockCatalog(), dlq)
+
+	sink, err := newOutSink(cfg.Out, cfg.PipelineID)
 	if err != nil {
-		_ = client.Close()
+		closeKafka()
 		return nil, err
 	}
 
-	ig := ingest.NewIngestor(cfg.ReadyFifo, disp, sp, cfg.DecodeWorker, cfg.DecodeQueue, adapter, client, cfg.Topic)
-
-	sink, _ := out.NewKafkaSink([]string{"127.0.0.1:9092"}, "logpipe.out", sarama.NewConfig())
+	var (
+		gate  *leader.Gate
+		lease leader.Lease
+		emit  = sink
+	)
+	if cfg.Source == "" {
+		lease = cfg.Lease
+		if lease == nil {
+			lease = leader.NewFlockLease(filepath.Join(cfg.SpoolPath, "leader.lock"))
+		}
+		gate = leader.NewGate(sink, lease, filepath.Join(cfg.SpoolPath, "leader.cursor"), leader.GateOptions{})
+		emit = gate
+	}
 
 	allOpen := false
 
 	wins := []*window.Runner{
-		window.NewRunner(0, disp, sink, &allOpen, false, &window.EmitTick{Every: 50}),
-		window.NewRunner(1, disp, sink, &allOpen, false, &window.EmitTick{Every: 200}),
-		window.NewRunner(2, disp, sink, &allOpen, false, &window.EmitTick{Every: 1000}),
-		window.NewRunner(3, disp, sink, &allOpen, true, &window.EmitTick{Every: 5000}),
+		window.NewRunner(0, disp, emit, &allOpen, false, &window.EmitTick{Every: 50}),
+		window.NewRunner(1, disp, emit, &allOpen, false, &window.EmitTick{Every: 200}),
+		window.NewRunner(2, disp, emit, &allOpen, false, &window.EmitTick{Every: 1000}),
+		window.NewRunner(3, disp, emit, &allOpen, true, &window.EmitTick{Every: 5000}),
 	}
 
 	return &Processor{
 		cfg:      cfg,
 		cons:     cons,
-		spool:    sp,
 		disp:     disp,
 		ingestor: ig,
 		wins:     wins,
+		ckpt:     ckpt,
+		sink:     sink,
+		gate:     gate,
+		lease:    lease,
+		dlq:      dlq,
 	}, nil
 }
 
+func newOutSink(spec string, producerID string) (out.Sink, error) {
+	switch {
+	case spec == "" || spec == "kafka":
+		return out.NewKafkaSink([]string{"127.0.0.1:9092"}, "logpipe.out", producerID, sarama.NewConfig())
+	case spec == "stdout":
+		return out.NewStdoutSink(), nil
+	case strings.HasPrefix(spec, "file:"):
+		return out.NewFileSink(strings.TrimPrefix(spec, "file:"))
+	}
+	return nil, fmt.Errorf("out %q: want kafka|stdout|file:<path>", spec)
+}
+
 func (p *Processor) Close() error {
 	if p.ingestor != nil {
 		_ = p.ingestor.Close()
@@ -89,12 +217,35 @@ func (p *Processor) Close() error {
 	if p.cons != nil {
 		_ = p.cons.Close()
 	}
+	if p.dlq != nil {
+		_ = p.dlq.Close()
+	}
 	if p.client != nil {
 		_ = p.client.Close()
 	}
 	if p.spool != nil {
 		_ = p.spool.Close()
 	}
+	if p.spool != nil && p.lease.Held() {
+		p.saveAnchor()
+	}
+	if p.ckpt != nil {
+		// only the leader owns the row, and only while it still holds the lease: a standby's save
+		// would conflict, or overwrite the new leader's after we let go
+		if p.lease == nil || p.lease.Held() {
+			p.saveCheckpoint(context.Background())
+		}
+		_ = p.ckpt.Close()
+	}
+	// the gate saves its output cursor while still leader: before the lease goes
+	if p.gate != nil {
+		_ = p.gate.Close() // closes sink
+	} else if p.sink != nil {
+		_ = p.sink.Close()
+	}
+	if p.lease != nil {
+		_ = p.lease.Release()
+	}
 	return nil
 }
 
@@ -109,14 +260,182 @@ func (p *Processor) Run(ctx context.Context) error {
 		}()
 	}
 
-	// 2) 启动 Kafka consume 主循环
+	// 2) checkpoint: last block handed to the windows
+	if p.ckpt != nil {
+		if err := p.loadCheckpoint(ctx); err != nil {
+			return err
+		}
+	}
+
+	// 3) file source: replay once and stop
+	if p.cfg.Source != "" {
+		if p.ckpt != nil {
+			go p.checkpointLoop(ctx)
+		}
+		files, err := blockfile.Files(p.cfg.Source)
+		if err != nil {
+			return err
+		}
+		return p.ingestor.ReplayFiles(ctx, files)
+	}
+
+	// 4) compute: tail the spool from the shared anchor (the longest window's tail, as the leader
+	// last saved it; the first block of the last 24h on a new spool). The windows are rebuilt from
+	// local disk; Kafka is only read past what the spool already has (Ingestor.Setup). Leader or
+	// standby alike: both number the txs from the same anchor, so their ticks are the same.
+	if err := os.MkdirAll(p.cfg.SpoolPath, 0o755); err != nil {
+		return err
+	}
+	start, err := p.initAnchor()
+	if err != nil {
+		return err
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	tailErr := make(chan error, 1)
+	go func() {
+		err := p.ingestor.Tail(cctx, p.cfg.SpoolPath, start)
+		if err != nil && cctx.Err() == nil {
+			log.Printf("[processor] spool tail stopped: %v", err)
+		}
+		tailErr <- err
+		cancel()
+	}()
+
+	// 5) leader only: write the spool, emit, checkpoint
+	if ok, err := p.lease.TryAcquire(); err != nil {
+		return err
+	} else if !ok {
+		log.Printf("[processor] standby: another processor leads spool=%s, tailing it", p.cfg.SpoolPath)
+		if err := leader.Acquire(cctx, p.lease, 100*time.Millisecond); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return <-tailErr
+		}
+	}
+	sp, err := ingest.NewFileSpool(p.cfg.SpoolPath, ingest.SpoolOptions{
+		SegmentBytes: p.cfg.SpoolSegmentBytes,
+		GroupRecords: p.cfg.SpoolGroupRecords,
+		GroupWait:    p.cfg.SpoolGroupWait,
+		RetainFor:    p.cfg.SpoolRetain,
+		RetainBytes:  p.cfg.SpoolRetainBytes,
+	})
+	if err != nil {
+		return err
+	}
+	p.spool = sp
+	p.ingestor.SetSpool(sp)
+	if err := p.gate.Promote(cctx); err != nil {
+		return err
+	}
+	log.Printf("[processor] leader of spool=%s", p.cfg.SpoolPath)
+	if p.ckpt != nil {
+		// the row moved on while we stood by (the old leader kept saving): load again, or the first
+		// Save is a CAS against a stale version, conflicts and stops the loop
+		if err := p.loadCheckpoint(cctx); err != nil {
+			return err
+		}
+		go p.checkpointLoop(cctx)
+	}
+	go p.anchorLoop(cctx)
+
+	// 6) 启动 Kafka consume 主循环：Kafka -> spool
 	for {
-		if err := p.cons.group.Consume(ctx, []string{p.cfg.Topic}, p.ingestor); err != nil {
+		if err := p.cons.group.Consume(cctx, []string{p.cfg.Topic}, p.ingestor); err != nil {
 			log.Printf("[processor] consume err: %v", err)
 			time.Sleep(300 * time.Millisecond)
 		}
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
+		if cctx.Err() != nil {
+			return <-tailErr
+		}
+	}
+}
+
+func (p *Processor) anchorPath() string {
+	return filepath.Join(p.cfg.SpoolPath, "compute.anchor")
+}
+
+// initAnchor returns the anchor every processor on the spool starts from, creating it on a new
+// spool at the first block of the longest window.
+func (p *Processor) initAnchor() (ingest.Anchor, error) {
+	seq, err := ingest.SpoolSeekTime(p.cfg.SpoolPath, time.Now().Add(-longestWindow).UnixMilli())
+	if err != nil {
+		return ingest.Anchor{}, err
+	}
+	// an anchor retention has since deleted is skipped forward by Tail, numbering kept
+	return ingest.InitAnchor(p.anchorPath(), ingest.Anchor{Cursor: seq})
+}
+
+// anchorLoop moves the anchor along with the longest window while this processor leads.
+func (p *Processor) anchorLoop(ctx context.Context) {
+	t := time.NewTicker(p.cfg.CheckpointEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		p.saveAnchor()
+	}
+}
+
+func (p *Processor) saveAnchor() {
+	a, ok := p.ingestor.Anchor()
+	if !ok || a == p.savedAnchor {
+		return
+	}
+	if err := ingest.SaveAnchor(p.anchorPath(), a); err != nil {
+		log.Printf("[processor] anchor save err: %v", err)
+		return
+	}
+	p.savedAnchor = a
+}
+
+// loadCheckpoint reads the checkpoint row; the store keeps its version for the next Save.
+func (p *Processor) loadCheckpoint(ctx context.Context) error {
+	rec, ok, err := p.ckpt.Load(ctx, p.cfg.PipelineID)
+	if err != nil {
+		return err
+	}
+	if ok {
+		log.Printf("[processor] checkpoint id=%s last=%d hash=%s updated_at=%s",
+			p.cfg.PipelineID, rec.Height, rec.Hash, rec.UpdatedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (p *Processor) checkpointLoop(ctx context.Context) {
+	t := time.NewTicker(p.cfg.CheckpointEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if !p.saveCheckpoint(ctx) {
+			return
+		}
+	}
+}
+
+// saveCheckpoint writes the ingestor's last block; false means stop (another processor owns the row).
+func (p *Processor) saveCheckpoint(ctx context.Context) bool {
+	num, hash, ok := p.ingestor.LastBlock()
+	if !ok || num == p.savedNum {
+		return true
+	}
+	sctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := p.ckpt.Save(sctx, p.cfg.PipelineID, ckptstore.Record{Height: num, Hash: hash}); err != nil {
+		log.Printf("[processor] checkpoint save err: %v", err)
+		return !errors.Is(err, ckptstore.ErrConflict)
 	}
+	p.savedNum = num
+	return true
 }
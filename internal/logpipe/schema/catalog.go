@@ -0,0 +1,76 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+)
+
+var (
+	ErrUnknownSchema = errors.New("unknown schema")
+	ErrIncompatible  = errors.New("incompatible schema")
+)
+
+// Entry is what a consumer can read of one schema: versions MinVersion..MaxVersion in one of Codecs.
+type Entry struct {
+	Name       string
+	MinVersion int
+	MaxVersion int
+	Codecs     []string
+}
+
+// Catalog is the local list of schemas a consumer understands. Safe for concurrent use.
+type Catalog struct {
+	mu sync.RWMutex
+	m  map[string]Entry
+}
+
+func NewCatalog(entries ...Entry) *Catalog {
+	c := &Catalog{m: make(map[string]Entry)}
+	for _, e := range entries {
+		c.Register(e)
+	}
+	return c
+}
+
+func (c *Catalog) Register(e Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m[e.Name] = e
+}
+
+// Check returns nil when m can be read, else an error wrapping ErrUnknownSchema or ErrIncompatible.
+func (c *Catalog) Check(m Meta) error {
+	c.mu.RLock()
+	e, ok := c.m[m.Name]
+	c.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownSchema, m.Name)
+	}
+	if m.Version < e.MinVersion || m.Version > e.MaxVersion {
+		return fmt.Errorf("%w: %s v%d (readable v%d..v%d)", ErrIncompatible, m.Name, m.Version, e.MinVersion, e.MaxVersion)
+	}
+	if len(e.Codecs) > 0 && !slices.Contains(e.Codecs, m.Codec) {
+		return fmt.Errorf("%w: %s v%d codec %q", ErrIncompatible, m.Name, m.Version, m.Codec)
+	}
+	return nil
+}
+
+// BlockCatalog is what the ingestor reads from the blocks topic.
+func BlockCatalog() *Catalog {
+	return NewCatalog(Entry{
+		Name: Block, MinVersion: BlockV1, MaxVersion: BlockV1,
+		Codecs: []string{model.ContentTypeJSON, model.ContentTypeBinary},
+	})
+}
+
+// OutCatalog is what the writer reads from the out topic: one entry per envelope type it stores.
+func OutCatalog() *Catalog {
+	return NewCatalog(Entry{
+		Name: Out("win_tick"), MinVersion: OutV1, MaxVersion: OutV1,
+		Codecs: []string{model.ContentTypeJSON},
+	})
+}
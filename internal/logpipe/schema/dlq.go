@@ -0,0 +1,110 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// DLQ header keys, added to the original record's key, value and headers.
+const (
+	HeaderDLQReason    = "dlq-reason"
+	HeaderDLQSource    = "dlq-source-topic"
+	HeaderDLQPartition = "dlq-source-partition"
+	HeaderDLQOffset    = "dlq-source-offset"
+	HeaderDLQConsumer  = "dlq-consumer"
+)
+
+// DLQ sends records a consumer can't handle to a dead-letter topic, so they are kept for
+// inspection / replay instead of being marked and lost.
+type DLQ struct {
+	topic    string
+	consumer string
+	p        sarama.SyncProducer
+}
+
+// NewDLQ: consumer names who rejected the record (header dlq-consumer).
+func NewDLQ(brokers []string, topic string, consumer string) (*DLQ, error) {
+	if topic == "" {
+		return nil, errors.New("dlq topic empty")
+	}
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_1_0_0
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Return.Errors = true
+	cfg.Producer.Retry.Max = 10
+	cfg.Producer.Retry.Backoff = 200 * time.Millisecond
+	p, err := sarama.NewSyncProducer(brokers, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewDLQProducer(p, topic, consumer), nil
+}
+
+// NewDLQProducer sends through p, which Close closes.
+func NewDLQProducer(p sarama.SyncProducer, topic string, consumer string) *DLQ {
+	return &DLQ{topic: topic, consumer: consumer, p: p}
+}
+
+func (d *DLQ) Topic() string { return d.topic }
+
+// Send copies msg to the DLQ with the reason. Returns only once the DLQ acked.
+func (d *DLQ) Send(msg *sarama.ConsumerMessage, reason error) error {
+	hs := make([]sarama.RecordHeader, 0, len(msg.Headers)+5)
+	for _, h := range msg.Headers {
+		if h != nil {
+			hs = append(hs, *h)
+		}
+	}
+	hs = append(hs,
+		sarama.RecordHeader{Key: []byte(HeaderDLQReason), Value: []byte(reason.Error())},
+		sarama.RecordHeader{Key: []byte(HeaderDLQSource), Value: []byte(msg.Topic)},
+		sarama.RecordHeader{Key: []byte(HeaderDLQPartition), Value: []byte(strconv.FormatInt(int64(msg.Partition), 10))},
+		sarama.RecordHeader{Key: []byte(HeaderDLQOffset), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		sarama.RecordHeader{Key: []byte(HeaderDLQConsumer), Value: []byte(d.consumer)},
+	)
+	pm := &sarama.ProducerMessage{
+		Topic:     d.topic,
+		Value:     sarama.ByteEncoder(msg.Value),
+		Headers:   hs,
+		Timestamp: msg.Timestamp,
+	}
+	if msg.Key != nil {
+		pm.Key = sarama.ByteEncoder(msg.Key)
+	}
+	if _, _, err := d.p.SendMessage(pm); err != nil {
+		return fmt.Errorf("dlq %s: %w", d.topic, err)
+	}
+	return nil
+}
+
+// SendRetry retries Send until it succeeds or ctx is done. The caller must not mark msg before
+// this returns nil: marking a later offset would commit past the record.
+func (d *DLQ) SendRetry(ctx context.Context, msg *sarama.ConsumerMessage, reason error) error {
+	log.Printf("[dlq] %s p=%d off=%d -> %s: %v", msg.Topic, msg.Partition, msg.Offset, d.topic, reason)
+	for {
+		err := d.Send(msg, reason)
+		if err == nil {
+			return nil
+		}
+		log.Printf("[dlq] send failed, retrying: %v", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (d *DLQ) Close() error {
+	if d.p == nil {
+		return nil
+	}
+	return d.p.Close()
+}
@@ -0,0 +1,102 @@
+// Package schema describes what a Kafka record contains, in record headers:
+//
+//	schema-name     e.g. logpipe.block, logpipe.out.win_tick
+//	schema-version  integer, bumped on incompatible changes
+//	content-type    codec of the value (model.ContentTypeJSON / ContentTypeBinary)
+//	producer-id     pipeline id of the writer (fetcher/<topic>, processor/<group>)
+//	chain-id        chain of the payload; absent for the legacy single chain / unknown
+//
+// Consumers check the headers against a local Catalog on receipt and send what they can't read to
+// a dead-letter topic (DLQ) instead of dropping it. Records without schema headers predate them and
+// are accepted as legacy by consumers that know the topic's only schema.
+package schema
+
+import (
+	"strconv"
+
+	"github.com/IBM/sarama"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+)
+
+const (
+	HeaderName     = "schema-name"
+	HeaderVersion  = "schema-version"
+	HeaderCodec    = model.HeaderContentType
+	HeaderProducer = "producer-id"
+	HeaderChain    = "chain-id"
+)
+
+// Schema names and the versions this build writes.
+const (
+	Block       = "logpipe.block" // blocks + quarantine topics (whole or chunked)
+	BlockHeader = "logpipe.header"
+	Tx          = "logpipe.tx"
+	OutPrefix   = "logpipe.out." // + envelope type, e.g. logpipe.out.win_tick
+
+	BlockV1       = 1
+	BlockHeaderV1 = 1
+	TxV1          = 1
+	OutV1         = 1
+)
+
+// Out is the schema name of an out.Envelope of type typ.
+func Out(typ string) string { return OutPrefix + typ }
+
+// Meta is the schema description of one record.
+type Meta struct {
+	Name       string
+	Version    int
+	Codec      string
+	ProducerID string
+	ChainID    string
+}
+
+func (m Meta) Headers() []sarama.RecordHeader {
+	hs := []sarama.RecordHeader{
+		{Key: []byte(HeaderName), Value: []byte(m.Name)},
+		{Key: []byte(HeaderVersion), Value: []byte(strconv.Itoa(m.Version))},
+		{Key: []byte(HeaderCodec), Value: []byte(m.Codec)},
+	}
+	if m.ProducerID != "" {
+		hs = append(hs, sarama.RecordHeader{Key: []byte(HeaderProducer), Value: []byte(m.ProducerID)})
+	}
+	if m.ChainID != "" {
+		hs = append(hs, sarama.RecordHeader{Key: []byte(HeaderChain), Value: []byte(m.ChainID)})
+	}
+	return hs
+}
+
+// Parse reads the schema headers; ok=false means the record has no schema-name (legacy).
+// A malformed version parses as 0, which no catalog entry accepts.
+func Parse(headers []*sarama.RecordHeader) (m Meta, ok bool) {
+	for _, h := range headers {
+		if h == nil {
+			continue
+		}
+		v := string(h.Value)
+		switch string(h.Key) {
+		case HeaderName:
+			m.Name, ok = v, true
+		case HeaderVersion:
+			m.Version, _ = strconv.Atoi(v)
+		case HeaderCodec:
+			m.Codec = v
+		case HeaderProducer:
+			m.ProducerID = v
+		case HeaderChain:
+			m.ChainID = v
+		}
+	}
+	return m, ok
+}
+
+// ParseMap is Parse for headers kept as a map (blockfile records).
+func ParseMap(headers map[string]string) (m Meta, ok bool) {
+	m.Name, ok = headers[HeaderName]
+	m.Version, _ = strconv.Atoi(headers[HeaderVersion])
+	m.Codec = headers[HeaderCodec]
+	m.ProducerID = headers[HeaderProducer]
+	m.ChainID = headers[HeaderChain]
+	return m, ok
+}
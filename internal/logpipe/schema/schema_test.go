@@ -0,0 +1,148 @@
+package schema
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+)
+
+func ptrs(hs []sarama.RecordHeader) []*sarama.RecordHeader {
+	out := make([]*sarama.RecordHeader, len(hs))
+	for i := range hs {
+		out[i] = &hs[i]
+	}
+	return out
+}
+
+func TestCatalogCheck(t *testing.T) {
+	c := NewCatalog(
+		Entry{Name: "t.a", MinVersion: 2, MaxVersion: 3, Codecs: []string{model.ContentTypeJSON}},
+		Entry{Name: "t.any", MinVersion: 1, MaxVersion: 1},
+	)
+	json := model.ContentTypeJSON
+	cases := []struct {
+		name string
+		m    Meta
+		want error
+	}{
+		{"oldest readable", Meta{Name: "t.a", Version: 2, Codec: json}, nil},
+		{"newest readable", Meta{Name: "t.a", Version: 3, Codec: json}, nil},
+		{"unknown", Meta{Name: "t.b", Version: 1, Codec: json}, ErrUnknownSchema},
+		{"no name", Meta{Version: 1, Codec: json}, ErrUnknownSchema},
+		{"too old", Meta{Name: "t.a", Version: 1, Codec: json}, ErrIncompatible},
+		{"too new", Meta{Name: "t.a", Version: 4, Codec: json}, ErrIncompatible},
+		{"malformed version", Meta{Name: "t.a", Codec: json}, ErrIncompatible},
+		{"codec", Meta{Name: "t.a", Version: 2, Codec: model.ContentTypeBinary}, ErrIncompatible},
+		{"no codec list", Meta{Name: "t.any", Version: 1, Codec: "x"}, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := c.Check(tc.m)
+			if (tc.want == nil) != (err == nil) || (tc.want != nil && !errors.Is(err, tc.want)) {
+				t.Fatalf("Check(%+v)=%v want %v", tc.m, err, tc.want)
+			}
+		})
+	}
+
+	// a newer build registers v4: records of the new version become readable
+	c.Register(Entry{Name: "t.a", MinVersion: 2, MaxVersion: 4})
+	if err := c.Check(Meta{Name: "t.a", Version: 4, Codec: json}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range []Meta{
+		{Name: Block, Version: BlockV1, Codec: model.ContentTypeJSON},
+		{Name: Block, Version: BlockV1, Codec: model.ContentTypeBinary},
+	} {
+		if err := BlockCatalog().Check(m); err != nil {
+			t.Fatalf("block catalog: %v", err)
+		}
+	}
+	if err := OutCatalog().Check(Meta{Name: Out("win_tick"), Version: OutV1, Codec: model.ContentTypeJSON}); err != nil {
+		t.Fatalf("out catalog: %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	full := Meta{Name: Block, Version: 2, Codec: model.ContentTypeBinary, ProducerID: "fetcher/blocks", ChainID: "mock"}
+	cases := []struct {
+		name    string
+		headers []sarama.RecordHeader
+		want    Meta
+		ok      bool
+	}{
+		{"all headers", full.Headers(), full, true},
+		{"optional ones absent", Meta{Name: Tx, Version: 1, Codec: model.ContentTypeJSON}.Headers(), Meta{Name: Tx, Version: 1, Codec: model.ContentTypeJSON}, true},
+		{"legacy: none", nil, Meta{}, false},
+		{"legacy: content-type only", []sarama.RecordHeader{{Key: []byte(HeaderCodec), Value: []byte(model.ContentTypeJSON)}}, Meta{Codec: model.ContentTypeJSON}, false},
+		{"malformed version", []sarama.RecordHeader{
+			{Key: []byte(HeaderName), Value: []byte(Block)},
+			{Key: []byte(HeaderVersion), Value: []byte("v1")},
+		}, Meta{Name: Block}, true},
+		{"other headers ignored", append([]sarama.RecordHeader{{Key: []byte("chunk-index"), Value: []byte("0")}}, full.Headers()...), full, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, ok := Parse(append(ptrs(tc.headers), nil))
+			if m != tc.want || ok != tc.ok {
+				t.Fatalf("Parse=%+v ok=%v want %+v ok=%v", m, ok, tc.want, tc.ok)
+			}
+			hm := make(map[string]string)
+			for _, h := range tc.headers {
+				hm[string(h.Key)] = string(h.Value)
+			}
+			if m, ok := ParseMap(hm); m != tc.want || ok != tc.ok {
+				t.Fatalf("ParseMap=%+v ok=%v want %+v ok=%v", m, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+// The DLQ record is the original key, value and headers plus where it came from and why.
+func TestDLQSend(t *testing.T) {
+	p := mocks.NewSyncProducer(t, nil)
+	d := NewDLQProducer(p, "out.dlq", "writer/g")
+	msg := &sarama.ConsumerMessage{
+		Topic: "out", Partition: 3, Offset: 42, Key: []byte("k"), Value: []byte("{"),
+		Headers: append(ptrs(Meta{Name: Out("win_tick"), Version: 9, Codec: model.ContentTypeJSON}.Headers()), nil),
+	}
+	reason := errors.New("incompatible schema: v9")
+	p.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(pm *sarama.ProducerMessage) error {
+		got := make(map[string]string)
+		for _, h := range pm.Headers {
+			got[string(h.Key)] = string(h.Value)
+		}
+		want := map[string]string{
+			HeaderName: Out("win_tick"), HeaderVersion: "9", HeaderCodec: model.ContentTypeJSON,
+			HeaderDLQReason: reason.Error(), HeaderDLQSource: "out", HeaderDLQConsumer: "writer/g",
+			HeaderDLQPartition: "3", HeaderDLQOffset: strconv.Itoa(42),
+		}
+		key, _ := pm.Key.Encode()
+		val, _ := pm.Value.Encode()
+		if pm.Topic != "out.dlq" || string(key) != "k" || string(val) != "{" || len(got) != len(want) {
+			return errors.New("not the original record")
+		}
+		for k, v := range want {
+			if got[k] != v {
+				return errors.New("header " + k + "=" + got[k] + " want " + v)
+			}
+		}
+		return nil
+	})
+	if err := d.Send(msg, reason); err != nil {
+		t.Fatal(err)
+	}
+
+	p.ExpectSendMessageAndFail(sarama.ErrNotLeaderForPartition)
+	if err := d.Send(msg, reason); !errors.Is(err, sarama.ErrNotLeaderForPartition) {
+		t.Fatalf("err=%v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -13,8 +13,9 @@ type Runner struct {
 	strategies []Strategy
 	sink       out.Sink
 
-	head int64
-	tail int64
+	head    int64
+	tail    int64
+	started bool // head/tail set from the first move: tx indexes start at the anchor's (ingest.Anchor)
 
 	adj          map[uint32]map[uint32]struct{}
 	rev          map[uint32]map[uint32]struct{}
@@ -61,6 +62,9 @@ func (r *Runner) Run(ctx context.Context) error {
 }
 
 func (r *Runner) handleMove(ctx context.Context, mv dispatcher.TxWinMarginInfo) error {
+	if !r.started {
+		r.head, r.tail, r.started = mv.TxTail, mv.TxTail, true
+	}
 	// 1) 永远维护窗口语义（短窗不偷跑 ≠ 不维护）
 	r.addEdges(mv.TxHead)
 	r.delEdges(mv.TxTail)
@@ -7,17 +7,17 @@ import (
 	"github.com/chenzhangda16/web3-logpipe/internal/logpipe/out"
 )
 
+// EmitTick emits a win_tick every Every blocks. It counts by spool cursor, not by moves seen, so a
+// standby that started elsewhere in the spool ticks at the same blocks as the active.
 type EmitTick struct {
 	Every int64
-	n     int64
 }
 
 func (s *EmitTick) OnMove(ctx context.Context, r *Runner, mv dispatcher.TxWinMarginInfo, sink out.Sink) error {
 	if s.Every <= 0 {
 		s.Every = 200
 	}
-	s.n++
-	if s.n%s.Every != 0 {
+	if (mv.Cursor+1)%uint64(s.Every) != 0 {
 		return nil
 	}
 	return sink.Emit(ctx, "win_tick", out.WinTick{
@@ -25,5 +25,6 @@ func (s *EmitTick) OnMove(ctx context.Context, r *Runner, mv dispatcher.TxWinMar
 		Head:    mv.TxHead,
 		Tail:    mv.TxTail,
 		OpenWin: mv.OpenWin,
+		Cursor:  mv.Cursor,
 	})
 }
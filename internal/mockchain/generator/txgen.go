@@ -14,8 +14,12 @@ const (
 	Nonce    = "nonce"
 )
 
+// DefaultToken is the token symbol when a chain does not configure one.
+const DefaultToken = "MOCK"
+
 type TxGen struct {
 	addrs []string
+	token string
 
 	rFrom  *rand.Rand
 	rTo    *rand.Rand
@@ -23,9 +27,13 @@ type TxGen struct {
 	rNonce *rand.Rand
 }
 
-func NewTxGen(addrs []string, rf *rng.Factory) *TxGen {
+func NewTxGen(addrs []string, token string, rf *rng.Factory) *TxGen {
+	if token == "" {
+		token = DefaultToken
+	}
 	return &TxGen{
 		addrs:  addrs,
+		token:  token,
 		rFrom:  rf.R(FromPick),
 		rTo:    rf.R(ToPick),
 		rAmt:   rf.R(Amount),
@@ -46,7 +54,7 @@ func (g *TxGen) RandomTx(blockNum, ts int64) model.Tx {
 		model.TxBody{
 			From:      g.addrs[fromIdx],
 			To:        g.addrs[toIdx],
-			Token:     "MOCK",
+			Token:     g.token,
 			Amount:    amt,
 			Timestamp: ts,
 			Nonce:     nonce,
@@ -64,7 +72,7 @@ func (g *TxGen) SelfLoopTx(blockNum, ts int64) model.Tx {
 		model.TxBody{
 			From:      a,
 			To:        a,
-			Token:     "MOCK",
+			Token:     g.token,
 			Amount:    amt,
 			Timestamp: ts,
 			Nonce:     nonce,
@@ -18,19 +18,51 @@ const (
 	BlockNonce = "block_nonce"
 )
 
+// Config is the per-chain mining setup.
+type Config struct {
+	ChainID string // stamped into every BlockHeader; empty for the legacy single chain
+	Tick    time.Duration
+
+	// TxMin/TxMax: txs per block are drawn from [TxMin, TxMax); <=0 means 50 / 100.
+	TxMin int
+	TxMax int
+}
+
 type Miner struct {
-	store *store.RocksStore
+	store store.ChainStore
 	txgen *generator.TxGen
 	rf    *rng.Factory
 	tick  time.Duration
+
+	chainID string
+	txMin   int
+	txSpan  int
+	log     *log.Logger
 }
 
-func NewMiner(st *store.RocksStore, txgen *generator.TxGen, rf *rng.Factory, tick time.Duration) *Miner {
+func NewMiner(st store.ChainStore, txgen *generator.TxGen, rf *rng.Factory, cfg Config) *Miner {
+	txMin, txMax := cfg.TxMin, cfg.TxMax
+	if txMin <= 0 {
+		txMin = 50
+	}
+	if txMax <= txMin {
+		txMax = max(txMin+1, 100)
+	}
+
+	// 多链时日志按链区分
+	logger := log.Default()
+	if cfg.ChainID != "" {
+		logger = log.New(log.Writer(), "[chain="+cfg.ChainID+"] ", log.Flags()|log.Lmsgprefix)
+	}
 	return &Miner{
-		store: st,
-		txgen: txgen,
-		rf:    rf,
-		tick:  tick,
+		store:   st,
+		txgen:   txgen,
+		rf:      rf,
+		tick:    cfg.Tick,
+		chainID: cfg.ChainID,
+		txMin:   txMin,
+		txSpan:  txMax - txMin,
+		log:     logger,
 	}
 }
 
@@ -38,7 +70,7 @@ func (m *Miner) Warmup(backfillSec int64) error {
 	start := time.Now()
 	gapSec := m.store.GapRuleSec()
 	if backfillSec <= 0 {
-		log.Printf("[warmup] skip: backfillSec=%d gapSec=%d tick=%s", backfillSec, m.store.GapRuleSec(), m.tick)
+		m.log.Printf("[warmup] skip: backfillSec=%d gapSec=%d tick=%s", backfillSec, m.store.GapRuleSec(), m.tick)
 		return nil
 	}
 
@@ -47,40 +79,40 @@ func (m *Miner) Warmup(backfillSec int64) error {
 		step = 1
 	}
 
-	log.Printf("[warmup] begin: backfillSec=%d gapSec=%d tick=%s step=%ds", backfillSec, gapSec, m.tick, step)
+	m.log.Printf("[warmup] begin: backfillSec=%d gapSec=%d tick=%s step=%ds", backfillSec, gapSec, m.tick, step)
 
 	// 1) 决策：REBUILD / TRIM / KEEP_ALL
 	curTs := time.Now().Unix()
 	action, keep, err := m.store.DecideTailAction(curTs, backfillSec)
 	if err != nil {
-		log.Printf("[warmup] decide_tail_action failed: curTs=%d backfillSec=%d gapSec=%d err=%v", curTs, backfillSec, gapSec, err)
+		m.log.Printf("[warmup] decide_tail_action failed: curTs=%d backfillSec=%d gapSec=%d err=%v", curTs, backfillSec, gapSec, err)
 		return err
 	}
-	log.Printf("[warmup] tail_action: action=%s keepHeight=%d curTs=%d targetTs=%d",
+	m.log.Printf("[warmup] tail_action: action=%s keepHeight=%d curTs=%d targetTs=%d",
 		action.String(), keep, curTs, curTs-backfillSec)
 
 	switch action {
 	case store.TailRebuild:
-		log.Printf("[warmup] tail_action=REBUILD: delete canonical after 0")
+		m.log.Printf("[warmup] tail_action=REBUILD: delete canonical after 0")
 		if err := m.store.DeleteCanonicalAfter(0); err != nil {
-			log.Printf("[warmup] delete_canonical_after failed: keepHeight=0 err=%v", err)
+			m.log.Printf("[warmup] delete_canonical_after failed: keepHeight=0 err=%v", err)
 			return err
 		}
 
 	case store.TailTrimAfterKeep:
-		log.Printf("[warmup] tail_action=TRIM_AFTER_KEEP: delete canonical after keep=%d", keep)
+		m.log.Printf("[warmup] tail_action=TRIM_AFTER_KEEP: delete canonical after keep=%d", keep)
 		if err := m.store.DeleteCanonicalAfter(keep); err != nil {
-			log.Printf("[warmup] delete_canonical_after failed: keepHeight=%d err=%v", keep, err)
+			m.log.Printf("[warmup] delete_canonical_after failed: keepHeight=%d err=%v", keep, err)
 			return err
 		}
 
 	case store.TailKeepAllCatchUp:
-		log.Printf("[warmup] tail_action=KEEP_ALL_CATCH_UP: no deletion")
+		m.log.Printf("[warmup] tail_action=KEEP_ALL_CATCH_UP: no deletion")
 	default:
 		// 容错：当作 rebuild
-		log.Printf("[warmup] tail_action=UNKNOWN: treat as REBUILD, delete canonical after 0")
+		m.log.Printf("[warmup] tail_action=UNKNOWN: treat as REBUILD, delete canonical after 0")
 		if err := m.store.DeleteCanonicalAfter(0); err != nil {
-			log.Printf("[warmup] delete_canonical_after failed: keepHeight=0 err=%v", err)
+			m.log.Printf("[warmup] delete_canonical_after failed: keepHeight=0 err=%v", err)
 			return err
 		}
 	}
@@ -88,10 +120,10 @@ func (m *Miner) Warmup(backfillSec int64) error {
 	// 2) 重新加载 head（因为可能删过）
 	parentHash, nextNum, lastTs, hasHead, err := m.loadHead()
 	if err != nil {
-		log.Printf("[warmup] load_head failed: err=%v", err)
+		m.log.Printf("[warmup] load_head failed: err=%v", err)
 		return err
 	}
-	log.Printf("[warmup] head_loaded: hasHead=%v nextNum=%d lastTs=%d parent=%s", hasHead, nextNum, lastTs, parentHash.Hex())
+	m.log.Printf("[warmup] head_loaded: hasHead=%v nextNum=%d lastTs=%d parent=%s", hasHead, nextNum, lastTs, parentHash.Hex())
 
 	// 3) decide warmup start timestamp
 	now := time.Now().Unix()
@@ -104,14 +136,14 @@ func (m *Miner) Warmup(backfillSec int64) error {
 	if !hasHead {
 		// empty or rebuilt: always start from backfill window
 		ts = minTs
-		log.Printf("[warmup] start_from_empty: ts=%d (now-backfillSec)", ts)
+		m.log.Printf("[warmup] start_from_empty: ts=%d (now-backfillSec)", ts)
 	} else {
 		// KEEP_ALL_CATCH_UP or after trim: don't go earlier than backfill window
 		ts = lastTs + step
 		if ts < minTs {
 			ts = minTs
 		}
-		log.Printf("[warmup] start_from_last: ts=%d (max(lastTs+step, now-backfillSec))", ts)
+		m.log.Printf("[warmup] start_from_last: ts=%d (max(lastTs+step, now-backfillSec))", ts)
 	}
 
 	// 4) 动态追时间墙：只要 ts+step < dynamicNow，就继续“加速挖”
@@ -124,7 +156,7 @@ func (m *Miner) Warmup(backfillSec int64) error {
 		}
 
 		if err := m.mineOne(nextNum, &parentHash, ts); err != nil {
-			log.Printf("[warmup] mine_one failed: bn=%d ts=%d err=%v (mined=%d cost=%s)",
+			m.log.Printf("[warmup] mine_one failed: bn=%d ts=%d err=%v (mined=%d cost=%s)",
 				nextNum, ts, err, mined, time.Since(start))
 			return err
 		}
@@ -135,18 +167,18 @@ func (m *Miner) Warmup(backfillSec int64) error {
 		// 节流：最多每 1s 打一条进度（避免 backfill 很大时刷屏）
 		if time.Since(lastLog) >= 1*time.Second {
 			lag := dynamicNow - ts
-			log.Printf("[warmup] progress: mined=%d nextNum=%d ts=%d dynamicNow=%d lag=%ds cost=%s",
+			m.log.Printf("[warmup] progress: mined=%d nextNum=%d ts=%d dynamicNow=%d lag=%ds cost=%s",
 				mined, nextNum, ts, dynamicNow, lag, time.Since(start))
 			lastLog = time.Now()
 		}
 	}
 
-	log.Printf("[warmup] done: mined=%d nextNum=%d endTs=%d cost=%s", mined, nextNum, ts, time.Since(start))
+	m.log.Printf("[warmup] done: mined=%d nextNum=%d endTs=%d cost=%s", mined, nextNum, ts, time.Since(start))
 	return nil
 }
 
 func (m *Miner) mineOne(bn int64, parentHash *hash.Hash32, ts int64) error {
-	nTx := 50 + m.rf.R(TxCount).Intn(50)
+	nTx := m.txMin + m.rf.R(TxCount).Intn(m.txSpan)
 	txs := make([]model.Tx, 0, nTx)
 	for i := 0; i < nTx; i++ {
 		p := m.rf.R(Choose).Float64()
@@ -158,7 +190,7 @@ func (m *Miner) mineOne(bn int64, parentHash *hash.Hash32, ts int64) error {
 	}
 	nonce := m.rf.R(BlockNonce).Uint64()
 
-	blk := model.BuildBlock(bn, *parentHash, txs, ts, nonce)
+	blk := model.BuildBlock(m.chainID, bn, *parentHash, txs, ts, nonce)
 	raw, err := model.EncodeBlock(blk)
 	if err != nil {
 		return err
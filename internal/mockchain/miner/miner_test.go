@@ -0,0 +1,132 @@
+package miner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/generator"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/store"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/store/storetest"
+	"github.com/chenzhangda16/web3-logpipe/pkg/rng"
+)
+
+const gapSec = 3
+
+func newTestMiner(st store.ChainStore, tick time.Duration) *Miner {
+	rf := rng.New(rng.Deterministic, 42)
+	txgen := generator.NewTxGen(generator.GenAddrs(16, rf.R("addrs")), "MOCK", rf)
+	return NewMiner(st, txgen, rf, Config{Tick: tick, TxMin: 1, TxMax: 4})
+}
+
+func mustVerify(t *testing.T, st store.ChainStore) *store.VerifyReport {
+	t.Helper()
+	rep, err := st.Verify(store.VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.OK() {
+		t.Fatalf("verify: %+v", rep.Issues)
+	}
+	return rep
+}
+
+func headTs(t *testing.T, st store.ChainStore) (int64, int64) {
+	t.Helper()
+	n, ok, err := st.HeadNum()
+	if err != nil || !ok {
+		t.Fatalf("HeadNum: ok=%v err=%v", ok, err)
+	}
+	ts, _, err := st.GetCanonicalTimestamp(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n, ts
+}
+
+func TestWarmupFromEmpty(t *testing.T) {
+	st := store.NewMemStore(gapSec)
+	m := newTestMiner(st, time.Second)
+	before := time.Now().Unix()
+	if err := m.Warmup(60); err != nil {
+		t.Fatal(err)
+	}
+
+	rep := mustVerify(t, st)
+	if rep.CheckedBlocks < 55 || rep.CheckedBlocks > 61 {
+		t.Fatalf("mined %d blocks for a 60s backfill at 1s tick", rep.CheckedBlocks)
+	}
+	if ts, _, _ := st.GetCanonicalTimestamp(1); ts < before-60 || ts > before-58 {
+		t.Fatalf("first ts=%d want ~now-60=%d", ts, before-60)
+	}
+	if _, ts := headTs(t, st); ts < before-3 {
+		t.Fatalf("head ts=%d did not catch up to now=%d", ts, before)
+	}
+}
+
+// A chain that covers the start of the window without gaps is extended in place, not rebuilt.
+func TestWarmupKeepsContiguousChain(t *testing.T) {
+	st := store.NewMemStore(gapSec)
+	now := time.Now().Unix()
+	blocks := storetest.AppendChain(t, st, 40, now-70, 1)
+
+	if err := newTestMiner(st, time.Second).Warmup(60); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range blocks {
+		if h, ok, _ := st.GetCanonicalHash(b.Header.Number); !ok || h != b.Hash {
+			t.Fatalf("block %d replaced by warmup", b.Header.Number)
+		}
+	}
+	n, ts := headTs(t, st)
+	if n <= 40 || ts < now-3 {
+		t.Fatalf("head=%d ts=%d: did not catch up", n, ts)
+	}
+	mustVerify(t, st)
+}
+
+// A gap inside the window is trimmed away and the chain is re-mined from the block before it.
+func TestWarmupTrimsAtGap(t *testing.T) {
+	st := store.NewMemStore(gapSec)
+	now := time.Now().Unix()
+	kept := storetest.AppendChain(t, st, 20, now-70, 1)
+	storetest.AppendChain(t, st, 5, now-20, 1) // 31s gap after height 20
+
+	if err := newTestMiner(st, time.Second).Warmup(60); err != nil {
+		t.Fatal(err)
+	}
+	if h, _, _ := st.GetCanonicalHash(20); h != kept[19].Hash {
+		t.Fatalf("block 20 should be kept")
+	}
+	ts21, _, _ := st.GetCanonicalTimestamp(21)
+	if ts21 != kept[19].Header.Timestamp+1 {
+		t.Fatalf("height 21 ts=%d, want re-mined at %d", ts21, kept[19].Header.Timestamp+1)
+	}
+	mustVerify(t, st)
+}
+
+func TestRunMinesMonotonicTimestamps(t *testing.T) {
+	st := store.NewMemStore(gapSec)
+	m := newTestMiner(st, 5*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := m.Run(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Run: %v", err)
+	}
+
+	// many blocks per wall second: ts is forced strictly increasing, which Verify checks
+	rep := mustVerify(t, st)
+	if rep.CheckedBlocks < 5 {
+		t.Fatalf("mined %d blocks", rep.CheckedBlocks)
+	}
+	raw, err := st.GetCanonicalBlockRaw(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := model.DecodeBlock(raw)
+	if err != nil || len(blk.Txs) < 1 || len(blk.Txs) >= 4 {
+		t.Fatalf("block 1: txs=%d err=%v", len(blk.Txs), err)
+	}
+}
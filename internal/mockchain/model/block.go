@@ -5,11 +5,16 @@ import (
 )
 
 type BlockHeader struct {
+	// ChainID names the chain in a multi-chain mockchain; empty for the legacy single chain
+	// (and then left out of HashHeader, so old blocks keep their hashes).
+	ChainID    string      `json:"chain_id,omitempty"`
 	Number     int64       `json:"number"`
 	ParentHash hash.Hash32 `json:"parent_hash"`
 	Timestamp  int64       `json:"timestamp"`
 	TxRoot     hash.Hash32 `json:"tx_root"`
-	Nonce      uint64      `json:"-"`
+	// Nonce is part of HashHeader. It used to be left out of the JSON (json:"-"), so blocks encoded
+	// before that changed decode with nonce 0 and their hash can't be recomputed: see HasNonces.
+	Nonce uint64 `json:"nonce"`
 }
 
 type Block struct {
@@ -30,5 +35,5 @@ type TxBody struct {
 	Token     string `json:"token"`
 	Amount    int64  `json:"amount"`
 	Timestamp int64  `json:"timestamp"`
-	Nonce     uint64 `json:"-"`
+	Nonce     uint64 `json:"nonce"` // part of HashTxCanonical; see BlockHeader.Nonce
 }
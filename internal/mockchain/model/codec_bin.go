@@ -0,0 +1,250 @@
+package model
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+)
+
+// Binary block wire format, version 1. All integers are varints (signed: zigzag) except nonces,
+// which are random 64-bit values and stored as 8 bytes big endian. Strings are uvarint length +
+// bytes; hashes are 32 raw bytes.
+//
+//	magic "LPB" | version u8
+//	header: chain_id str | number varint | parent_hash [32] | timestamp varint | tx_root [32] | nonce u64
+//	hash [32] | tx_count uvarint
+//	tx: hash [32] | block_num-number varint | from addr | to addr | token str | amount varint |
+//	    timestamp-header.timestamp varint | nonce u64
+//	addr: 0x00 [20] for canonical "0x"+40 lowercase hex, else 0x01 str (verbatim, so tx hashes
+//	      computed over the string still match)
+//
+// A new layout gets a new version byte; decoders reject versions they don't know.
+const (
+	binMagic     = "LPB"
+	BinVersion1  = 1
+	binHeaderLen = len(binMagic) + 1
+
+	addrRaw  = 0x00
+	addrText = 0x01
+)
+
+// Content types of a block payload (Kafka header "content-type").
+const (
+	ContentTypeJSON   = "application/json"
+	ContentTypeBinary = "application/x-logpipe-block;v=1"
+)
+
+var (
+	ErrBinTruncated = errors.New("binary block truncated")
+	ErrBinVersion   = errors.New("binary block: unknown version")
+)
+
+// IsBinaryBlock reports whether raw starts with the binary block magic (JSON starts with '{').
+func IsBinaryBlock(raw []byte) bool {
+	return len(raw) >= binHeaderLen && string(raw[:len(binMagic)]) == binMagic
+}
+
+func EncodeBlockBinary(b Block) []byte {
+	// ~100 bytes per tx with raw addresses; grows if not
+	buf := make([]byte, 0, 128+len(b.Txs)*112)
+	buf = append(buf, binMagic...)
+	buf = append(buf, BinVersion1)
+
+	h := b.Header
+	buf = appendStr(buf, h.ChainID)
+	buf = binary.AppendVarint(buf, h.Number)
+	buf = append(buf, h.ParentHash[:]...)
+	buf = binary.AppendVarint(buf, h.Timestamp)
+	buf = append(buf, h.TxRoot[:]...)
+	buf = binary.BigEndian.AppendUint64(buf, h.Nonce)
+	buf = append(buf, b.Hash[:]...)
+
+	buf = binary.AppendUvarint(buf, uint64(len(b.Txs)))
+	for _, tx := range b.Txs {
+		buf = append(buf, tx.Hash[:]...)
+		buf = binary.AppendVarint(buf, tx.BlockNum-h.Number)
+		buf = appendAddr(buf, tx.TxBody.From)
+		buf = appendAddr(buf, tx.TxBody.To)
+		buf = appendStr(buf, tx.TxBody.Token)
+		buf = binary.AppendVarint(buf, tx.TxBody.Amount)
+		buf = binary.AppendVarint(buf, tx.TxBody.Timestamp-h.Timestamp)
+		buf = binary.BigEndian.AppendUint64(buf, tx.TxBody.Nonce)
+	}
+	return buf
+}
+
+func appendStr(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendAddr(buf []byte, a string) []byte {
+	if isCanonicalAddr(a) {
+		buf = append(buf, addrRaw)
+		var raw [20]byte
+		_, _ = hex.Decode(raw[:], []byte(a[2:]))
+		return append(buf, raw[:]...)
+	}
+	buf = append(buf, addrText)
+	return appendStr(buf, a)
+}
+
+// isCanonicalAddr: exactly what hex.EncodeToString produces after "0x", so decode restores a.
+func isCanonicalAddr(a string) bool {
+	if len(a) != 42 || a[0] != '0' || a[1] != 'x' {
+		return false
+	}
+	for i := 2; i < len(a); i++ {
+		c := a[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func DecodeBlockBinary(raw []byte) (Block, error) {
+	d, err := newBinDecoder(raw)
+	if err != nil {
+		return Block{}, err
+	}
+	var b Block
+	d.header(&b)
+
+	n := d.uvarint()
+	if d.err == nil && n > uint64(len(d.buf)) {
+		d.err = ErrBinTruncated // each tx is far more than a byte: don't allocate for garbage counts
+	}
+	if d.err != nil {
+		return Block{}, d.err
+	}
+	b.Txs = make([]Tx, n)
+	for i := range b.Txs {
+		tx := &b.Txs[i]
+		d.hash(&tx.Hash)
+		tx.BlockNum = b.Header.Number + d.varint()
+		tx.TxBody.From = d.addr()
+		tx.TxBody.To = d.addr()
+		tx.TxBody.Token = d.str()
+		tx.TxBody.Amount = d.varint()
+		tx.TxBody.Timestamp = b.Header.Timestamp + d.varint()
+		tx.TxBody.Nonce = d.u64()
+		if d.err != nil {
+			return Block{}, fmt.Errorf("tx[%d]: %w", i, d.err)
+		}
+	}
+	if len(d.buf) != 0 {
+		return Block{}, fmt.Errorf("binary block: %d trailing bytes", len(d.buf))
+	}
+	return b, nil
+}
+
+// binDecoder consumes buf front to back; the first error sticks and zero values are returned after it.
+type binDecoder struct {
+	buf []byte
+	err error
+}
+
+func newBinDecoder(raw []byte) (*binDecoder, error) {
+	if !IsBinaryBlock(raw) {
+		return nil, errors.New("binary block: bad magic")
+	}
+	if v := raw[len(binMagic)]; v != BinVersion1 {
+		return nil, fmt.Errorf("%w %d", ErrBinVersion, v)
+	}
+	return &binDecoder{buf: raw[binHeaderLen:]}, nil
+}
+
+func (d *binDecoder) header(b *Block) {
+	b.Header.ChainID = d.str()
+	b.Header.Number = d.varint()
+	d.hash(&b.Header.ParentHash)
+	b.Header.Timestamp = d.varint()
+	d.hash(&b.Header.TxRoot)
+	b.Header.Nonce = d.u64()
+	d.hash(&b.Hash)
+}
+
+func (d *binDecoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.buf) {
+		d.err = ErrBinTruncated
+		return nil
+	}
+	out := d.buf[:n]
+	d.buf = d.buf[n:]
+	return out
+}
+
+func (d *binDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = ErrBinTruncated
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *binDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrBinTruncated
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *binDecoder) u64() uint64 {
+	if b := d.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *binDecoder) hash(h *hash.Hash32) {
+	if b := d.take(32); b != nil {
+		copy(h[:], b)
+	}
+}
+
+func (d *binDecoder) str() string {
+	n := d.uvarint()
+	if n > uint64(len(d.buf)) {
+		if d.err == nil {
+			d.err = ErrBinTruncated
+		}
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+func (d *binDecoder) addr() string {
+	tag := d.take(1)
+	if tag == nil {
+		return ""
+	}
+	switch tag[0] {
+	case addrRaw:
+		if b := d.take(20); b != nil {
+			return "0x" + hex.EncodeToString(b)
+		}
+		return ""
+	case addrText:
+		return d.str()
+	}
+	d.err = fmt.Errorf("binary block: bad address tag %#x", tag[0])
+	return ""
+}
@@ -0,0 +1,154 @@
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+)
+
+func binTestBlocks() map[string]Block {
+	tx := func(from, to string, amount, ts int64, blockNum int64) Tx {
+		return BuildTx(TxBody{From: from, To: to, Token: "MOCK", Amount: amount, Timestamp: ts, Nonce: math.MaxUint64 - uint64(amount)}, blockNum)
+	}
+	const a, b = "0x00112233445566778899aabbccddeeff00112233", "0xffeeddccbbaa99887766554433221100ffeeddcc"
+	return map[string]Block{
+		"canonical addrs": BuildBlock("eth", 100, hash.Hash32{1}, []Tx{tx(a, b, 5, 1700000000, 100), tx(b, a, 6, 1700000000, 100)}, 1700000000, 42),
+		// anything isCanonicalAddr rejects is stored verbatim (addrText)
+		"non-canonical addrs": BuildBlock("", 7, hash.Hash32{2}, []Tx{
+			tx("0x00112233445566778899AABBCCDDEEFF00112233", b, 1, 50, 7), // upper case
+			tx("00112233445566778899aabbccddeeff0011223344", a, 2, 50, 7), // no 0x
+			tx("0x0011", "", 3, 50, 7),                                    // short, empty
+			tx("0xzz112233445566778899aabbccddeeff00112233", "bob", 4, 50, 7),
+		}, 50, 1),
+		// tx block_num / timestamp below the header's: negative deltas
+		"negative deltas": BuildBlock("", 1000, hash.Hash32{}, []Tx{tx(a, b, -9, 10, 3), tx(a, b, 9, 2000, 1001)}, 1500, 0),
+		"negative header": BuildBlock("x", -1, hash.Hash32{}, []Tx{tx(a, b, math.MinInt64, -5, -1)}, -100, 0),
+		"empty tx list":   BuildBlock("", 1, hash.Hash32{}, []Tx{}, 1, math.MaxUint64),
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	for name, blk := range binTestBlocks() {
+		t.Run(name, func(t *testing.T) {
+			raw := EncodeBlockBinary(blk)
+			if !IsBinaryBlock(raw) {
+				t.Fatal("IsBinaryBlock=false")
+			}
+			got, err := DecodeBlockBinary(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := json.Marshal(blk)
+			have, _ := json.Marshal(got)
+			if !bytes.Equal(want, have) {
+				t.Fatalf("round trip differs:\n want %s\n have %s", want, have)
+			}
+
+			// and through the content-type codec, which is what the pipeline uses
+			enc, err := EncodeBlockAs(ContentTypeBinary, blk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sniffed, err := DecodeBlockAs("", enc) // records without a content-type header
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have, _ := json.Marshal(sniffed); !bytes.Equal(want, have) {
+				t.Fatalf("DecodeBlockAs differs:\n want %s\n have %s", want, have)
+			}
+			if num, h, err := BlockID(ContentTypeBinary, enc); err != nil || num != blk.Header.Number || h != blk.Hash {
+				t.Fatalf("BlockID=%d %s err=%v", num, h.Hex(), err)
+			}
+		})
+	}
+}
+
+// Every cut of a valid block is an error: ErrBinTruncated once the magic/version is intact.
+func TestBinaryTruncated(t *testing.T) {
+	for name, blk := range binTestBlocks() {
+		raw := EncodeBlockBinary(blk)
+		for n := 0; n < len(raw); n++ {
+			_, err := DecodeBlockBinary(raw[:n])
+			if err == nil {
+				t.Fatalf("%s: prefix %d/%d decoded", name, n, len(raw))
+			}
+			if n >= binHeaderLen && !errors.Is(err, ErrBinTruncated) {
+				t.Fatalf("%s: prefix %d/%d: err=%v want ErrBinTruncated", name, n, len(raw), err)
+			}
+		}
+	}
+}
+
+func TestBinaryGarbage(t *testing.T) {
+	blk := binTestBlocks()["empty tx list"]
+	raw := EncodeBlockBinary(blk)
+	body := raw[:len(raw)-1] // everything up to the tx count (1 byte for 0)
+
+	// a huge tx count must fail before allocating
+	huge := binary.AppendUvarint(append([]byte(nil), body...), math.MaxUint64)
+	if _, err := DecodeBlockBinary(huge); !errors.Is(err, ErrBinTruncated) {
+		t.Fatalf("huge count: err=%v", err)
+	}
+	// a count larger than what follows
+	some := binary.AppendUvarint(append([]byte(nil), body...), 3)
+	some = append(some, make([]byte, 40)...)
+	if _, err := DecodeBlockBinary(some); !errors.Is(err, ErrBinTruncated) {
+		t.Fatalf("short txs: err=%v", err)
+	}
+	// an overlong varint
+	bad := append(append([]byte(nil), body...), bytes.Repeat([]byte{0xff}, 11)...)
+	if _, err := DecodeBlockBinary(bad); !errors.Is(err, ErrBinTruncated) {
+		t.Fatalf("overlong varint: err=%v", err)
+	}
+
+	if _, err := DecodeBlockBinary(append(raw, 0)); err == nil {
+		t.Fatal("trailing byte accepted")
+	}
+	v2 := append([]byte(nil), raw...)
+	v2[len(binMagic)] = 2
+	if _, err := DecodeBlockBinary(v2); !errors.Is(err, ErrBinVersion) {
+		t.Fatalf("version 2: err=%v", err)
+	}
+	if _, err := DecodeBlockBinary([]byte(`{"header":{}}`)); err == nil {
+		t.Fatal("JSON accepted as binary")
+	}
+
+	one := EncodeBlockBinary(binTestBlocks()["canonical addrs"])
+	// first tx's from tag sits after the fixed header and the tx hash / block_num delta
+	i := bytes.Index(one, []byte{addrRaw, 0x00, 0x11, 0x22})
+	if i < 0 {
+		t.Fatal("addr not found")
+	}
+	one[i] = 0x07
+	if _, err := DecodeBlockBinary(one); err == nil || errors.Is(err, ErrBinTruncated) {
+		t.Fatalf("bad addr tag: err=%v", err)
+	}
+}
+
+func FuzzDecodeBlockBinary(f *testing.F) {
+	for _, blk := range binTestBlocks() {
+		f.Add(EncodeBlockBinary(blk))
+	}
+	f.Add([]byte(binMagic))
+	f.Add([]byte{'L', 'P', 'B', 1, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		blk, err := DecodeBlockBinary(raw)
+		if err != nil {
+			return
+		}
+		// whatever decodes survives a re-encode (varints may come back shorter, so compare blocks)
+		again, err := DecodeBlockBinary(EncodeBlockBinary(blk))
+		if err != nil {
+			t.Fatalf("re-encoded block fails: %v", err)
+		}
+		want, _ := json.Marshal(blk)
+		if have, _ := json.Marshal(again); !bytes.Equal(want, have) {
+			t.Fatalf("re-encode differs:\n want %s\n have %s", want, have)
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+)
+
+func TestHasNonces(t *testing.T) {
+	tx := BuildTx(TxBody{From: "0xa", To: "0xb", Token: "MOCK", Amount: 1, Timestamp: 10, Nonce: 0}, 1)
+	blk := BuildBlock("", 1, hash.Hash32{}, []Tx{tx, tx}, 10, 0)
+	raw, err := EncodeBlock(blk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := string(raw)
+	// drop every "nonce":0 field, as blocks encoded before the nonces were serialized
+	legacy := strings.NewReplacer(`,"nonce":0}`, `}`).Replace(js)
+	if strings.Contains(legacy, `"nonce"`) {
+		t.Fatalf("legacy fixture still has a nonce: %s", legacy)
+	}
+	noTxNonce := strings.Replace(js, `,"nonce":0},"block_num"`, `},"block_num"`, 1) // first tx only
+	noHeaderNonce := strings.Replace(js, `,"nonce":0},"hash"`, `},"hash"`, 1)
+	if noTxNonce == js || noHeaderNonce == js {
+		t.Fatal("fixture replacements did not apply")
+	}
+	inString := strings.NewReplacer(`"from":"0xa"`, `"from":"\"nonce\":1"`).Replace(legacy)
+
+	for _, c := range []struct {
+		name string
+		raw  string
+		want bool
+	}{
+		{"json with nonces (zero)", js, true},
+		{"binary", string(EncodeBlockBinary(blk)), true},
+		{"legacy json", legacy, false},
+		{"header nonce only", noTxNonce, false},
+		{"tx nonces only", noHeaderNonce, false},
+		{"nonce text inside a string", inString, false},
+		{"capitalised field", strings.ReplaceAll(js, `"nonce"`, `"Nonce"`), true},
+		{"null nonce", strings.ReplaceAll(js, `"nonce":0`, `"nonce":null`), false},
+		{"no txs", `{"header":{"number":1,"nonce":5},"hash":"` + blk.Hash.Hex() + `"}`, true},
+		{"garbage", `{"header":`, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := HasNonces([]byte(c.raw)); got != c.want {
+				t.Fatalf("HasNonces=%v want %v: %s", got, c.want, c.raw)
+			}
+		})
+	}
+}
@@ -10,6 +10,7 @@ import (
 )
 
 func BuildBlock(
+	chainID string,
 	number int64,
 	parentHash hash.Hash32,
 	txs []Tx,
@@ -24,6 +25,7 @@ func BuildBlock(
 	txRoot := TxRoot(txHashes)
 
 	header := BlockHeader{
+		ChainID:    chainID,
 		Number:     number,
 		ParentHash: parentHash,
 		Timestamp:  timestamp,
@@ -50,6 +52,10 @@ func HashHeader(header BlockHeader) hash.Hash32 {
 	buf.Write(header.ParentHash[:])
 	buf.Write(header.TxRoot[:])
 	_ = binary.Write(&buf, binary.BigEndian, header.Nonce)
+	if header.ChainID != "" {
+		_ = binary.Write(&buf, binary.BigEndian, uint64(len(header.ChainID)))
+		buf.WriteString(header.ChainID)
+	}
 	return sha256.Sum256(buf.Bytes())
 }
 
@@ -0,0 +1,58 @@
+package rpc
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ChainRoute binds a chain ID to the Server of that chain.
+type ChainRoute struct {
+	ID     string
+	Server *Server
+}
+
+// NewChainsHandler serves several chains from one listener:
+//   - /chains                  -> list of chain IDs with head/tail
+//   - /chains/{id}/{endpoint}  -> that chain's Server (same endpoints as single-chain)
+//   - /{endpoint}              -> routes[0], so single-chain clients keep working
+//
+// Per-chain clients just use http://host/chains/{id} as their RPC base URL.
+func NewChainsHandler(routes []ChainRoute) http.Handler {
+	byID := make(map[string]http.Handler, len(routes))
+	for _, rt := range routes {
+		byID[rt.ID] = rt.Server.Handler()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chains", func(w http.ResponseWriter, r *http.Request) {
+		out := make([]map[string]any, 0, len(routes))
+		for _, rt := range routes {
+			item := map[string]any{"id": rt.ID}
+			if n, ok, err := rt.Server.st.HeadNum(); err == nil && ok {
+				item["head_num"] = n
+			}
+			if n, ok, err := rt.Server.st.TailNum(); err == nil && ok {
+				item["tail_num"] = n
+			}
+			out = append(out, item)
+		}
+		writeJSON(w, 200, map[string]any{"chains": out})
+	})
+	mux.HandleFunc("/chains/", func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, "/chains/")
+		id, sub, _ := strings.Cut(rest, "/")
+		h, ok := byID[id]
+		if !ok {
+			http.Error(w, "unknown chain: "+id, 404)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/" + sub
+		r2.URL.RawPath = ""
+		h.ServeHTTP(w, r2)
+	})
+	if len(routes) > 0 {
+		mux.Handle("/", byID[routes[0].ID])
+	}
+	return mux
+}
@@ -2,9 +2,12 @@ package rpc
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
 	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/store"
@@ -12,10 +15,15 @@ import (
 )
 
 type Server struct {
-	st *store.RocksStore
+	st store.ChainStore
+
+	// snapshotDir is where /admin/snapshot puts checkpoints; empty disables it.
+	snapshotDir string
 }
 
-func NewServer(st *store.RocksStore) *Server { return &Server{st: st} }
+func NewServer(st store.ChainStore, snapshotDir string) *Server {
+	return &Server{st: st, snapshotDir: snapshotDir}
+}
 
 func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
@@ -29,6 +37,11 @@ func (s *Server) Handler() http.Handler {
 	mux.HandleFunc("/block/at-or-after", s.handleBlockAtOrAfter)
 	mux.HandleFunc("/blocks/range", s.handleBlocksRange)
 
+	// admin
+	mux.HandleFunc("/admin/verify", s.handleAdminVerify)
+	mux.HandleFunc("/admin/snapshot", s.handleAdminSnapshot)
+	mux.HandleFunc("/admin/snapshots", s.handleAdminSnapshots)
+
 	return mux
 }
 
@@ -92,7 +105,7 @@ func (s *Server) handleBlockByHash(w http.ResponseWriter, r *http.Request) {
 
 // -------------------- new handlers --------------------
 
-// /chain/head returns head {num, hash, timestamp}.
+// /chain/head returns head {num, hash, timestamp} and tail_num (lowest retained height after retention pruning).
 func (s *Server) handleChainHead(w http.ResponseWriter, r *http.Request) {
 	headNum, okN, err := s.st.HeadNum()
 	if err != nil {
@@ -120,10 +133,17 @@ func (s *Server) handleChainHead(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tailNum, _, err := s.st.TailNum()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
 	writeJSON(w, 200, map[string]any{
 		"head_num":       headNum,
 		"head_hash":      headHash.Hex(),
 		"head_timestamp": blk.Header.Timestamp,
+		"tail_num":       tailNum,
 	})
 }
 
@@ -203,6 +223,17 @@ func (s *Server) handleBlocksRange(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// pruned by retention: say so instead of returning an empty partial range
+	tailNum, _, err := s.st.TailNum()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if from < tailNum {
+		http.Error(w, fmt.Sprintf("pruned: from=%d < tail_num=%d", from, tailNum), http.StatusGone)
+		return
+	}
+
 	// clamp to head
 	usedTo := to
 	if usedTo > headNum {
@@ -258,3 +289,101 @@ func (s *Server) handleBlocksRange(w http.ResponseWriter, r *http.Request) {
 		"last_ok": lastOK, // 0 means none
 	})
 }
+
+// -------------------- admin handlers --------------------
+
+// /admin/verify?from=1&to=0&max_issues=1000
+// runs store.Verify under a snapshot; the miner keeps running. Always 200 with the report, check "issue_count".
+func (s *Server) handleAdminVerify(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var opts store.VerifyOptions
+	for name, dst := range map[string]*int64{"from": &opts.FromHeight, "to": &opts.ToHeight} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				badRequest(w, "bad "+name)
+				return
+			}
+			*dst = n
+		}
+	}
+	if v := q.Get("max_issues"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			badRequest(w, "bad max_issues")
+			return
+		}
+		opts.MaxIssues = n
+	}
+
+	rep, err := s.st.Verify(opts)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, 200, map[string]any{
+		"ok":     rep.OK(),
+		"report": rep,
+	})
+}
+
+// POST /admin/snapshot?name=before-exp1
+// cuts an online RocksDB checkpoint into {snapshotDir}/{name}; name defaults to the current time.
+func (s *Server) handleAdminSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	sn, ok := s.st.(store.Snapshotter)
+	if !ok || s.snapshotDir == "" {
+		http.Error(w, "snapshots not supported by this store", http.StatusNotImplemented)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = time.Now().Format("20060102-150405")
+	}
+	if !validSnapshotName(name) {
+		badRequest(w, "bad name: use [A-Za-z0-9._-]")
+		return
+	}
+
+	info, err := sn.Checkpoint(filepath.Join(s.snapshotDir, name))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, 200, info)
+}
+
+// GET /admin/snapshots lists finished checkpoints under snapshotDir.
+func (s *Server) handleAdminSnapshots(w http.ResponseWriter, r *http.Request) {
+	if s.snapshotDir == "" {
+		http.Error(w, "snapshots not configured", http.StatusNotImplemented)
+		return
+	}
+	list, err := store.ListSnapshots(s.snapshotDir)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, 200, map[string]any{
+		"dir":       s.snapshotDir,
+		"snapshots": list,
+	})
+}
+
+func validSnapshotName(name string) bool {
+	if name == "." || name == ".." || len(name) > 128 {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
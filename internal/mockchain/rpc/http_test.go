@@ -0,0 +1,218 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/store"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/store/storetest"
+)
+
+// newTestServer serves 10 blocks at ts 1000..1009.
+func newTestServer(t *testing.T) (*httptest.Server, []model.Block) {
+	t.Helper()
+	st := store.NewMemStore(3)
+	blocks := storetest.AppendChain(t, st, 10, 1000, 1)
+	srv := httptest.NewServer(NewServer(st, "").Handler())
+	t.Cleanup(srv.Close)
+	return srv, blocks
+}
+
+func getJSON(t *testing.T, url string, out any) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 200 && out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestChainHead(t *testing.T) {
+	srv, blocks := newTestServer(t)
+	var head struct {
+		HeadNum       int64  `json:"head_num"`
+		HeadHash      string `json:"head_hash"`
+		HeadTimestamp int64  `json:"head_timestamp"`
+		TailNum       int64  `json:"tail_num"`
+	}
+	if code := getJSON(t, srv.URL+"/chain/head", &head); code != 200 {
+		t.Fatalf("code=%d", code)
+	}
+	if head.HeadNum != 10 || head.HeadHash != blocks[9].Hash.Hex() || head.HeadTimestamp != 1009 || head.TailNum != 1 {
+		t.Fatalf("head=%+v", head)
+	}
+
+	empty := httptest.NewServer(NewServer(store.NewMemStore(3), "").Handler())
+	defer empty.Close()
+	var e map[string]any
+	if getJSON(t, empty.URL+"/chain/head", &e); e["empty"] != true {
+		t.Fatalf("empty chain head=%v", e)
+	}
+}
+
+func TestBlockLookups(t *testing.T) {
+	srv, blocks := newTestServer(t)
+
+	var blk model.Block
+	if code := getJSON(t, srv.URL+"/block/by-number/4", &blk); code != 200 || blk.Hash != blocks[3].Hash {
+		t.Fatalf("by-number: code=%d hash=%s", code, blk.Hash.Hex())
+	}
+	if code := getJSON(t, srv.URL+"/block/by-hash/"+blocks[6].Hash.Hex(), &blk); code != 200 || blk.Header.Number != 7 {
+		t.Fatalf("by-hash: code=%d n=%d", code, blk.Header.Number)
+	}
+
+	var at struct {
+		BlockNum int64 `json:"block_num"`
+	}
+	if code := getJSON(t, srv.URL+"/block/at-or-after?ts=1004", &at); code != 200 || at.BlockNum != 5 {
+		t.Fatalf("at-or-after: code=%d n=%d", code, at.BlockNum)
+	}
+
+	for url, want := range map[string]int{
+		"/block/by-number/0":          400,
+		"/block/by-number/11":         404,
+		"/block/by-hash/zz":           400,
+		"/block/at-or-after":          400,
+		"/block/at-or-after?ts=-1":    400,
+		"/block/at-or-after?ts=99999": 404,
+	} {
+		if code := getJSON(t, srv.URL+url, nil); code != want {
+			t.Errorf("%s: code=%d want %d", url, code, want)
+		}
+	}
+}
+
+func TestBlocksRange(t *testing.T) {
+	srv, blocks := newTestServer(t)
+
+	type rangeResp struct {
+		From    int64         `json:"from"`
+		To      int64         `json:"to"`
+		Blocks  []model.Block `json:"blocks"`
+		Partial bool          `json:"partial"`
+		LastOK  int64         `json:"last_ok"`
+	}
+	cases := []struct {
+		from, to int64
+		wantTo   int64
+		wantN    int
+	}{
+		{1, 10, 10, 10},
+		{3, 5, 5, 3},
+		{8, 50, 10, 3}, // clamped to head
+		{11, 20, 10, 0},
+	}
+	for _, c := range cases {
+		var r rangeResp
+		url := srv.URL + "/blocks/range?from=" + strconv.FormatInt(c.from, 10) + "&to=" + strconv.FormatInt(c.to, 10)
+		if code := getJSON(t, url, &r); code != 200 {
+			t.Fatalf("%d..%d: code=%d", c.from, c.to, code)
+		}
+		if r.To != c.wantTo || len(r.Blocks) != c.wantN || r.Partial {
+			t.Fatalf("%d..%d: to=%d n=%d partial=%v", c.from, c.to, r.To, len(r.Blocks), r.Partial)
+		}
+		for i, b := range r.Blocks {
+			if b.Hash != blocks[c.from-1+int64(i)].Hash {
+				t.Fatalf("%d..%d: block %d out of order", c.from, c.to, i)
+			}
+		}
+	}
+
+	for _, q := range []string{"", "?from=1", "?from=0&to=5", "?from=5&to=4", "?from=1&to=3000"} {
+		if code := getJSON(t, srv.URL+"/blocks/range"+q, nil); code != 400 {
+			t.Errorf("range%s: code=%d want 400", q, code)
+		}
+	}
+}
+
+func TestAdminVerifyAndSnapshots(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	var v struct {
+		OK     bool                `json:"ok"`
+		Report *store.VerifyReport `json:"report"`
+	}
+	if code := getJSON(t, srv.URL+"/admin/verify?from=2&to=6", &v); code != 200 || !v.OK || v.Report.CheckedBlocks != 5 {
+		t.Fatalf("verify: code=%d %+v", code, v.Report)
+	}
+	if code := getJSON(t, srv.URL+"/admin/verify?from=x", nil); code != 400 {
+		t.Fatalf("verify bad from: code=%d", code)
+	}
+
+	// no snapshot dir (and MemStore can't checkpoint anyway)
+	resp, err := http.Post(srv.URL+"/admin/snapshot", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("snapshot: code=%d", resp.StatusCode)
+	}
+	if code := getJSON(t, srv.URL+"/admin/snapshot", nil); code != http.StatusMethodNotAllowed {
+		t.Fatalf("snapshot GET: code=%d", code)
+	}
+	if code := getJSON(t, srv.URL+"/admin/snapshots", nil); code != http.StatusNotImplemented {
+		t.Fatalf("snapshots: code=%d", code)
+	}
+}
+
+func TestValidSnapshotName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"before-exp1":     true,
+		"20250101-120000": true,
+		"a.b_c":           true,
+		"":                true, // handler substitutes a timestamp first
+		".":               false,
+		"..":              false,
+		"../x":            false,
+		"a/b":             false,
+		"a b":             false,
+	} {
+		if got := validSnapshotName(name); got != want {
+			t.Errorf("validSnapshotName(%q)=%v want %v", name, got, want)
+		}
+	}
+}
+
+func TestChainsHandler(t *testing.T) {
+	a, b := store.NewMemStore(3), store.NewMemStore(3)
+	storetest.AppendChain(t, a, 4, 1000, 1)
+	storetest.AppendChain(t, b, 7, 1000, 1)
+	srv := httptest.NewServer(NewChainsHandler([]ChainRoute{
+		{ID: "a", Server: NewServer(a, "")},
+		{ID: "b", Server: NewServer(b, "")},
+	}))
+	defer srv.Close()
+
+	var list struct {
+		Chains []struct {
+			ID      string `json:"id"`
+			HeadNum int64  `json:"head_num"`
+		} `json:"chains"`
+	}
+	if getJSON(t, srv.URL+"/chains", &list); len(list.Chains) != 2 || list.Chains[1].ID != "b" || list.Chains[1].HeadNum != 7 {
+		t.Fatalf("chains=%+v", list)
+	}
+
+	var head struct {
+		HeadNum int64 `json:"head_num"`
+	}
+	for url, want := range map[string]int64{"/chains/a/chain/head": 4, "/chains/b/chain/head": 7, "/chain/head": 4} {
+		if code := getJSON(t, srv.URL+url, &head); code != 200 || head.HeadNum != want {
+			t.Fatalf("%s: code=%d head=%d want %d", url, code, head.HeadNum, want)
+		}
+	}
+	if code := getJSON(t, srv.URL+"/chains/c/chain/head", nil); code != 404 {
+		t.Fatalf("unknown chain: code=%d", code)
+	}
+}
@@ -0,0 +1,255 @@
+package store
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+)
+
+// MemStore is an in-memory ChainStore for tests and throwaway runs.
+// It keeps the same canonical/head/timestamp semantics as rocksstore.Store but has no gap index:
+// DecideTailAction always takes the sequential-scan path, which yields the same decisions.
+type MemStore struct {
+	mu         sync.RWMutex
+	gapRuleSec int64
+
+	blocks  map[hash.Hash32][]byte
+	canon   map[int64]hash.Hash32
+	canonTs map[int64]int64
+
+	headNum  int64
+	headHash hash.Hash32
+	hasHead  bool
+}
+
+func NewMemStore(gapRuleSec int64) *MemStore {
+	return &MemStore{
+		gapRuleSec: gapRuleSec,
+		blocks:     make(map[hash.Hash32][]byte),
+		canon:      make(map[int64]hash.Hash32),
+		canonTs:    make(map[int64]int64),
+	}
+}
+
+func (s *MemStore) GapRuleSec() int64 { return s.gapRuleSec }
+
+func (s *MemStore) Close() {}
+
+func (s *MemStore) HeadHash() (hash.Hash32, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.headHash, s.hasHead, nil
+}
+
+func (s *MemStore) HeadNum() (int64, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.headNum, s.hasHead, nil
+}
+
+// TailNum is always 1: MemStore has no retention.
+func (s *MemStore) TailNum() (int64, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.hasHead {
+		return 0, false, nil
+	}
+	return 1, true, nil
+}
+
+func (s *MemStore) GetBlockByHashRaw(h hash.Hash32) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	raw, ok := s.blocks[h]
+	if !ok {
+		return nil, errors.New("block not found")
+	}
+	return append([]byte(nil), raw...), nil
+}
+
+func (s *MemStore) GetCanonicalBlockRaw(n int64) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	h, ok := s.canon[n]
+	if !ok {
+		return nil, errors.New("canonical block not found")
+	}
+	raw, ok := s.blocks[h]
+	if !ok {
+		return nil, errors.New("block not found")
+	}
+	return append([]byte(nil), raw...), nil
+}
+
+func (s *MemStore) GetCanonicalHash(n int64) (hash.Hash32, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	h, ok := s.canon[n]
+	return h, ok, nil
+}
+
+func (s *MemStore) GetCanonicalTimestamp(n int64) (int64, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ts, ok := s.canonTs[n]
+	return ts, ok, nil
+}
+
+func (s *MemStore) AppendCanonicalBlock(b model.Block, raw []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blocks[b.Hash] = append([]byte(nil), raw...)
+	s.canon[b.Header.Number] = b.Hash
+	s.canonTs[b.Header.Number] = b.Header.Timestamp
+	s.headHash = b.Hash
+	s.headNum = b.Header.Number
+	s.hasHead = true
+	return nil
+}
+
+func (s *MemStore) LowerBoundByTimestamp(targetTs int64) (int64, int64, bool, error) {
+	headNum, okHead, _ := s.HeadNum()
+	if !okHead || headNum <= 0 {
+		return 0, 0, false, nil
+	}
+	return LowerBoundByTimestamp(1, headNum, targetTs, s.GetCanonicalTimestamp)
+}
+
+// DecideTailAction follows rocksstore.Store.DecideTailAction minus the gap index fast path.
+func (s *MemStore) DecideTailAction(curTs int64, backfillSec int64) (TailAction, int64, error) {
+	start := time.Now()
+	gapSec := s.gapRuleSec
+	if backfillSec <= 0 {
+		return TailKeepAllCatchUp, 0, nil
+	}
+
+	target := curTs - backfillSec
+	windowEnd := target + gapSec
+
+	headNum, okHead, _ := s.HeadNum()
+	if !okHead || headNum <= 0 {
+		log.Printf("[tail][mem] no head => REBUILD")
+		return TailRebuild, 0, nil
+	}
+	headTs, okTs, _ := s.GetCanonicalTimestamp(headNum)
+	if !okTs {
+		log.Printf("[tail][mem] no head timestamp => REBUILD (headNum=%d)", headNum)
+		return TailRebuild, 0, nil
+	}
+	if headTs < target {
+		log.Printf("[tail][mem] headTs<target => KEEP_ALL_CATCH_UP (headTs=%d target=%d headNum=%d)", headTs, target, headNum)
+		return TailKeepAllCatchUp, headNum, nil
+	}
+
+	pos, tsPos, okPos, err := s.LowerBoundByTimestamp(target)
+	if err != nil {
+		return TailRebuild, 0, err
+	}
+	if !okPos {
+		return TailKeepAllCatchUp, headNum, nil
+	}
+	if tsPos > windowEnd {
+		if pos-1 >= 1 {
+			log.Printf("[tail][mem] tsPos>windowEnd => TRIM_AFTER_KEEP keep=%d (pos=%d tsPos=%d windowEnd=%d)", pos-1, pos, tsPos, windowEnd)
+			return TailTrimAfterKeep, pos - 1, nil
+		}
+		return TailRebuild, 0, nil
+	}
+
+	s.mu.RLock()
+	prevTs, okPrev := s.canonTs[pos]
+	gapH := int64(0)
+	for n := pos + 1; okPrev && n <= headNum; n++ {
+		ts, ok := s.canonTs[n]
+		if !ok {
+			break
+		}
+		if ts-prevTs > gapSec {
+			gapH = n
+			break
+		}
+		prevTs = ts
+	}
+	s.mu.RUnlock()
+
+	if gapH > 0 {
+		if gapH-1 < 1 {
+			return TailRebuild, 0, nil
+		}
+		log.Printf("[tail][mem] scan found gap => TRIM_AFTER_KEEP keep=%d (gapHeight=%d) cost=%s", gapH-1, gapH, time.Since(start))
+		return TailTrimAfterKeep, gapH - 1, nil
+	}
+	log.Printf("[tail][mem] scan no gap => KEEP_ALL_CATCH_UP keep=%d cost=%s", headNum, time.Since(start))
+	return TailKeepAllCatchUp, headNum, nil
+}
+
+func (s *MemStore) DeleteCanonicalAfter(keepHeight int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.hasHead || s.headNum <= 0 || keepHeight >= s.headNum {
+		return nil
+	}
+	if keepHeight < 0 {
+		keepHeight = 0
+	}
+
+	for n := keepHeight + 1; n <= s.headNum; n++ {
+		if h, ok := s.canon[n]; ok {
+			delete(s.blocks, h)
+		}
+		delete(s.canon, n)
+		delete(s.canonTs, n)
+	}
+
+	newHead, ok := s.canon[keepHeight]
+	if keepHeight == 0 || !ok {
+		s.headNum, s.headHash, s.hasHead = 0, hash.Hash32{}, false
+		return nil
+	}
+	s.headNum, s.headHash = keepHeight, newHead
+	return nil
+}
+
+// Verify runs the same checks as rocksstore.Store.Verify under the read lock (appends wait for it).
+func (s *MemStore) Verify(opts VerifyOptions) (*VerifyReport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return RunVerify(memVerifySource{s}, s.gapRuleSec, opts)
+}
+
+// memVerifySource reads MemStore maps directly; the caller holds the read lock.
+type memVerifySource struct{ s *MemStore }
+
+func (m memVerifySource) HeadNum(IssueFunc) (int64, bool, error) {
+	return m.s.headNum, m.s.hasHead, nil
+}
+
+func (m memVerifySource) HeadHash(IssueFunc) (hash.Hash32, bool, error) {
+	return m.s.headHash, m.s.hasHead, nil
+}
+
+func (m memVerifySource) TailNum(IssueFunc) (int64, error) { return 1, nil }
+
+func (m memVerifySource) Canonical(IssueFunc) (map[int64]hash.Hash32, error) {
+	return m.s.canon, nil
+}
+
+func (m memVerifySource) BlockRaw(h hash.Hash32) ([]byte, bool, error) {
+	raw, ok := m.s.blocks[h]
+	return raw, ok, nil
+}
+
+func (m memVerifySource) CanonTs(n int64) (int64, bool, error) {
+	ts, ok := m.s.canonTs[n]
+	return ts, ok, nil
+}
+
+func (m memVerifySource) GapIndex(int64) ([]GapEntry, bool, error) {
+	return nil, false, nil
+}
@@ -0,0 +1,46 @@
+package rocksstore
+
+import (
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+)
+
+// Column families. "default" is required by RocksDB; it only holds legacy (pre-CF) keys until migrateLegacy moves them.
+const (
+	cfDefault = "default"
+	cfBlocks  = "blocks"   // {hash:32} -> raw block
+	cfCanon   = "canon"    // {height:8 BE} -> hash
+	cfCanonTS = "canon_ts" // {height:8 BE} -> ts (8 bytes BE)
+	cfMeta    = "meta"     // meta:* and the gap index (gap_end_ts:*)
+)
+
+var columnFamilies = []string{cfDefault, cfBlocks, cfCanon, cfCanonTS, cfMeta}
+
+const (
+	keyHeadHash = "meta:head_hash"
+	keyHeadNum  = "meta:head_num"
+	keyTailNum  = "meta:tail_num"
+
+	gapPrefix = "gap_end_ts:"
+)
+
+func KeyHeadHash() []byte { return []byte(keyHeadHash) }
+
+func KeyHeadNum() []byte { return []byte(keyHeadNum) }
+
+// KeyTailNum is the lowest retained canonical height; absent means 1 (nothing pruned yet).
+func KeyTailNum() []byte { return []byte(keyTailNum) }
+
+// KeyCanon is big-endian so canon CF iteration / range deletes follow height order.
+func KeyCanon(n int64) []byte { return encodeI64BE(n) }
+
+func KeyCanonTS(n int64) []byte { return encodeI64BE(n) }
+
+func KeyBlockHash(h hash.Hash32) []byte { return h.Bytes() }
+
+// legacy single-keyspace layout (default CF), only read by migrateLegacy.
+
+const (
+	legacyPrefixBlockHash = "block_hash:"
+	legacyPrefixCanon     = "canon:"
+	legacyPrefixCanonTS   = "canon_ts:"
+)
@@ -0,0 +1,123 @@
+package rocksstore
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+	"github.com/tecbot/gorocksdb"
+)
+
+const migrateBatchKeys = 4096
+
+// migrateLegacy moves pre-CF keys out of the default CF:
+//
+//	block_hash:0x{hex}  -> blocks   {hash:32}
+//	canon:{n}           -> canon    {n:8 BE}
+//	canon_ts:{n}        -> canon_ts {n:8 BE}
+//	meta:* / gap_end_ts:* -> meta   (same key)
+//
+// Every batch puts the new key and deletes the old one, so a crash mid-way just resumes on the next Open.
+// Unknown keys stay where they are (logged, never deleted).
+func (s *Store) migrateLegacy() error {
+	start := time.Now()
+	def := s.cfs[0]
+
+	it := s.db.NewIteratorCF(s.ro, def)
+	defer it.Close()
+
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+
+	var moved, skipped int64
+	pending := 0
+	flush := func() error {
+		if pending == 0 {
+			return nil
+		}
+		if err := s.db.Write(s.wo, wb); err != nil {
+			return err
+		}
+		wb.Clear()
+		pending = 0
+		return nil
+	}
+
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		k := it.Key()
+		v := it.Value()
+		kBytes := append([]byte(nil), k.Data()...)
+		vBytes := append([]byte(nil), v.Data()...)
+		k.Free()
+		v.Free()
+
+		cf, newKey, err := s.legacyKey(kBytes)
+		if err != nil {
+			return fmt.Errorf("migrate key %q: %w", kBytes, err)
+		}
+		if cf == nil {
+			skipped++
+			log.Printf("[store][migrate] unknown legacy key %q (kept in default CF)", kBytes)
+			continue
+		}
+
+		wb.PutCF(cf, newKey, vBytes)
+		wb.DeleteCF(def, kBytes)
+		moved++
+		pending++
+		if pending >= migrateBatchKeys {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if moved%1_000_000 == 0 {
+			log.Printf("[store][migrate] progress: moved=%d cost=%s", moved, time.Since(start))
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if moved > 0 {
+		// reclaim the default CF now that everything in it is a tombstone
+		s.db.CompactRangeCF(def, gorocksdb.Range{})
+		log.Printf("[store][migrate] done: moved=%d skipped=%d cost=%s", moved, skipped, time.Since(start))
+	}
+	return nil
+}
+
+// legacyKey maps a default-CF key to its CF and new key; cf=nil means not a legacy store key.
+func (s *Store) legacyKey(k []byte) (*gorocksdb.ColumnFamilyHandle, []byte, error) {
+	switch {
+	case bytes.HasPrefix(k, []byte(legacyPrefixBlockHash)):
+		h, err := hash.String2Hash32(string(k[len(legacyPrefixBlockHash):]))
+		if err != nil {
+			return nil, nil, err
+		}
+		return s.cfBlocks, KeyBlockHash(h), nil
+
+	case bytes.HasPrefix(k, []byte(legacyPrefixCanonTS)):
+		n, err := strconv.ParseInt(string(k[len(legacyPrefixCanonTS):]), 10, 64)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s.cfTS, KeyCanonTS(n), nil
+
+	case bytes.HasPrefix(k, []byte(legacyPrefixCanon)):
+		n, err := strconv.ParseInt(string(k[len(legacyPrefixCanon):]), 10, 64)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s.cfCanon, KeyCanon(n), nil
+
+	case bytes.HasPrefix(k, []byte("meta:")), bytes.HasPrefix(k, []byte(gapPrefix)):
+		return s.cfMeta, k, nil
+	}
+	return nil, nil, nil
+}
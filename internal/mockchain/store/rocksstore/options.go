@@ -0,0 +1,97 @@
+package rocksstore
+
+import (
+	"github.com/tecbot/gorocksdb"
+)
+
+const (
+	blockCacheBytes = 256 << 20
+
+	// blocks CF compaction after this many pruned blocks (keys are random hashes, so
+	// tombstones are spread over every SST and only a full-range compaction reclaims them).
+	blocksCompactAfterPruned = 50_000
+)
+
+// rocksOptions owns every native options object handed to OpenDbColumnFamilies.
+type rocksOptions struct {
+	db     *gorocksdb.Options
+	cf     []*gorocksdb.Options // aligned with columnFamilies
+	tables []*gorocksdb.BlockBasedTableOptions
+	cache  *gorocksdb.Cache
+}
+
+// newRocksOptions tunes each column family for its access pattern:
+//   - blocks:   large JSON values, point lookups by hash. 64KiB blocks, LZ4 (ZSTD on the bottom level),
+//     bloom filter, bigger memtables. Keys are random, so dynamic level sizing keeps space amp bounded.
+//   - canon, canon_ts: 8-byte BE height keys appended in order, point lookups + binary search.
+//     Small blocks, bloom filter, no compression (values are 8/32 bytes).
+//   - meta: a handful of keys plus the gap index; defaults with the shared cache.
+func newRocksOptions() *rocksOptions {
+	ro := &rocksOptions{cache: gorocksdb.NewLRUCache(blockCacheBytes)}
+
+	ro.db = gorocksdb.NewDefaultOptions()
+	ro.db.SetCreateIfMissing(true)
+	ro.db.SetCreateIfMissingColumnFamilies(true)
+	ro.db.IncreaseParallelism(4)
+	ro.db.SetMaxBackgroundCompactions(4)
+	ro.db.SetMaxBackgroundFlushes(2)
+	ro.db.SetBytesPerSync(1 << 20)
+	ro.db.SetMaxTotalWalSize(256 << 20)
+	ro.db.SetKeepLogFileNum(5)
+
+	for _, name := range columnFamilies {
+		opts := gorocksdb.NewDefaultOptions()
+		table := gorocksdb.NewDefaultBlockBasedTableOptions()
+		table.SetBlockCache(ro.cache)
+		table.SetCacheIndexAndFilterBlocks(true)
+		table.SetPinL0FilterAndIndexBlocksInCache(true)
+
+		switch name {
+		case cfBlocks:
+			table.SetBlockSize(64 << 10)
+			table.SetFilterPolicy(gorocksdb.NewBloomFilterFull(10))
+			opts.SetWriteBufferSize(64 << 20)
+			opts.SetMaxWriteBufferNumber(3)
+			opts.SetTargetFileSizeBase(64 << 20)
+			opts.SetLevelCompactionDynamicLevelBytes(true)
+			opts.SetCompression(gorocksdb.LZ4Compression)
+			opts.SetCompressionPerLevel([]gorocksdb.CompressionType{
+				gorocksdb.NoCompression,
+				gorocksdb.NoCompression,
+				gorocksdb.LZ4Compression,
+				gorocksdb.LZ4Compression,
+				gorocksdb.LZ4Compression,
+				gorocksdb.LZ4Compression,
+				gorocksdb.ZSTDCompression,
+			})
+		case cfCanon, cfCanonTS:
+			table.SetBlockSize(4 << 10)
+			table.SetFilterPolicy(gorocksdb.NewBloomFilterFull(10))
+			opts.SetWriteBufferSize(8 << 20)
+			opts.SetTargetFileSizeBase(16 << 20)
+			opts.SetCompression(gorocksdb.NoCompression)
+		default:
+			opts.SetWriteBufferSize(4 << 20)
+		}
+
+		opts.SetBlockBasedTableFactory(table)
+		ro.cf = append(ro.cf, opts)
+		ro.tables = append(ro.tables, table)
+	}
+	return ro
+}
+
+func (ro *rocksOptions) destroy() {
+	for _, o := range ro.cf {
+		o.Destroy()
+	}
+	for _, t := range ro.tables {
+		t.Destroy()
+	}
+	if ro.db != nil {
+		ro.db.Destroy()
+	}
+	if ro.cache != nil {
+		ro.cache.Destroy()
+	}
+}
@@ -0,0 +1,114 @@
+package rocksstore
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/store"
+	"github.com/tecbot/gorocksdb"
+)
+
+const pruneBatchBlocks = 4096
+
+// PruneBefore deletes canonical blocks with ts < beforeTs from the bottom of the chain and advances
+// meta:tail_num. The head is never pruned, so /chain/head and LowerBoundByTimestamp (which searches
+// [tail, head]) stay valid; readers asking for a pruned height get "canonical block not found".
+// Each batch deletes blocks/canon/canon_ts and moves tail_num atomically, so a crash leaves no hole.
+// It returns the number of pruned blocks.
+func (s *Store) PruneBefore(beforeTs int64) (int64, error) {
+	start := time.Now()
+
+	headNum, okHead, err := s.HeadNum()
+	if err != nil || !okHead || headNum <= 0 {
+		return 0, err
+	}
+	tailNum, _, err := s.TailNum()
+	if err != nil {
+		return 0, err
+	}
+
+	newTail, newTailTs, ok, err := store.LowerBoundByTimestamp(tailNum, headNum, beforeTs, s.GetCanonicalTimestamp)
+	if err != nil {
+		return 0, err
+	}
+	if !ok || newTail > headNum {
+		// everything is older than beforeTs: keep the head block only
+		newTail = headNum
+		if newTailTs, ok, err = s.GetCanonicalTimestamp(headNum); err != nil || !ok {
+			return 0, err
+		}
+	}
+	if newTail <= tailNum {
+		return 0, nil
+	}
+
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+
+	var pruned int64
+	for n := tailNum; n < newTail; n++ {
+		h, ok, err := s.GetCanonicalHash(n)
+		if err != nil {
+			return pruned, err
+		}
+		if ok {
+			wb.DeleteCF(s.cfBlocks, KeyBlockHash(h))
+		}
+		wb.DeleteCF(s.cfCanon, KeyCanon(n))
+		wb.DeleteCF(s.cfTS, KeyCanonTS(n))
+
+		if (n-tailNum+1)%pruneBatchBlocks == 0 || n == newTail-1 {
+			wb.PutCF(s.cfMeta, KeyTailNum(), encodeI64BE(n+1))
+			if err := s.db.Write(s.wo, wb); err != nil {
+				return pruned, err
+			}
+			wb.Clear()
+			pruned = n + 1 - tailNum
+		}
+	}
+
+	// gap entries ending at or below the new tail can never be a valid trim point again
+	if gapSec := s.gapRuleSec; gapSec > 0 {
+		wb.DeleteRangeCF(s.cfMeta, GapPrefix(gapSec), KeyGapEndTS(gapSec, newTailTs+1))
+		if err := s.db.Write(s.wo, wb); err != nil {
+			return pruned, err
+		}
+	}
+
+	// canon / canon_ts keys are ordered heights: compacting the pruned range drops the tombstones cheaply.
+	r := gorocksdb.Range{Start: KeyCanon(tailNum), Limit: KeyCanon(newTail)}
+	s.db.CompactRangeCF(s.cfCanon, r)
+	s.db.CompactRangeCF(s.cfTS, r)
+
+	s.prunedSinceCompact += pruned
+	if s.prunedSinceCompact >= blocksCompactAfterPruned {
+		cStart := time.Now()
+		s.db.CompactRangeCF(s.cfBlocks, gorocksdb.Range{})
+		log.Printf("[retention] blocks compaction: pruned_since=%d cost=%s", s.prunedSinceCompact, time.Since(cStart))
+		s.prunedSinceCompact = 0
+	}
+
+	log.Printf("[retention] pruned=%d tail=%d->%d head=%d beforeTs=%d cost=%s",
+		pruned, tailNum, newTail, headNum, beforeTs, time.Since(start))
+	return pruned, nil
+}
+
+// RunRetention prunes blocks older than now-retentionSec every interval until ctx is done.
+// Prune errors are logged and retried on the next tick.
+func (s *Store) RunRetention(ctx context.Context, retentionSec int64, every time.Duration) error {
+	t := time.NewTicker(every)
+	defer t.Stop()
+
+	for {
+		if _, err := s.PruneBefore(time.Now().Unix() - retentionSec); err != nil {
+			log.Printf("[retention] prune failed: err=%v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -1,90 +1,121 @@
-package store
+package rocksstore
 
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"log"
 	"strconv"
 	"time"
 
 	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/store"
 	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
 	"github.com/tecbot/gorocksdb"
 )
 
-type RocksStore struct {
+var _ store.ChainStore = (*Store)(nil)
+
+type Store struct {
 	db         *gorocksdb.DB
 	ro         *gorocksdb.ReadOptions
 	wo         *gorocksdb.WriteOptions
+	opts       *rocksOptions
 	gapRuleSec int64
 
+	cfs      []*gorocksdb.ColumnFamilyHandle // aligned with columnFamilies
+	cfBlocks *gorocksdb.ColumnFamilyHandle
+	cfCanon  *gorocksdb.ColumnFamilyHandle
+	cfTS     *gorocksdb.ColumnFamilyHandle
+	cfMeta   *gorocksdb.ColumnFamilyHandle
+
 	lastCanonHeight int64
 	lastCanonTs     int64
 	lastCanonValid  bool
-}
-
-type TailAction int
 
-const (
-	// TailRebuild means: delete all canonical blocks (or treat as empty) and rebuild from target time.
-	TailRebuild TailAction = iota
-
-	// TailKeepAllCatchUp means: keep all existing blocks, just mine forward to catch up to now.
-	TailKeepAllCatchUp
-
-	// TailTrimAfterKeep means: keep blocks up to keepHeight, delete blocks after that, then mine forward to catch up.
-	TailTrimAfterKeep
-)
+	prunedSinceCompact int64
+}
 
-func (s *RocksStore) GapRuleSec() int64 {
+func (s *Store) GapRuleSec() int64 {
 	return s.gapRuleSec
 }
 
-func (a TailAction) String() string {
-	switch a {
-	case TailRebuild:
-		return "REBUILD"
-	case TailKeepAllCatchUp:
-		return "KEEP_ALL_CATCH_UP"
-	case TailTrimAfterKeep:
-		return "TRIM_AFTER_KEEP"
-	default:
-		return "UNKNOWN"
+func Open(path string, gapRuleSec int64) (*Store, error) {
+	opts := newRocksOptions()
+
+	db, cfs, err := gorocksdb.OpenDbColumnFamilies(opts.db, path, columnFamilies, opts.cf)
+	if err != nil {
+		opts.destroy()
+		return nil, err
 	}
+
+	s := newStore(db, cfs, opts, gapRuleSec)
+	if err := s.migrateLegacy(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
 }
 
-func Open(path string, gapRuleSec int64) (*RocksStore, error) {
-	opts := gorocksdb.NewDefaultOptions()
-	opts.SetCreateIfMissing(true)
+// OpenReadOnly opens the DB without taking the LOCK file, so it can inspect a store that a running
+// mockchain holds open. Writes (including the canon_ts self-heal) fail on this handle.
+func OpenReadOnly(path string, gapRuleSec int64) (*Store, error) {
+	opts := newRocksOptions()
 
-	db, err := gorocksdb.OpenDb(opts, path)
+	existing, err := gorocksdb.ListColumnFamilies(opts.db, path)
 	if err != nil {
+		opts.destroy()
 		return nil, err
 	}
+	if len(existing) < len(columnFamilies) {
+		opts.destroy()
+		return nil, fmt.Errorf("%s has column families %v: legacy layout, open it read-write once to migrate", path, existing)
+	}
 
-	return &RocksStore{
+	db, cfs, err := gorocksdb.OpenDbForReadOnlyColumnFamilies(opts.db, path, columnFamilies, opts.cf, false)
+	if err != nil {
+		opts.destroy()
+		return nil, err
+	}
+	return newStore(db, cfs, opts, gapRuleSec), nil
+}
+
+func newStore(db *gorocksdb.DB, cfs []*gorocksdb.ColumnFamilyHandle, opts *rocksOptions, gapRuleSec int64) *Store {
+	return &Store{
 		db:         db,
 		ro:         gorocksdb.NewDefaultReadOptions(),
 		wo:         gorocksdb.NewDefaultWriteOptions(),
+		opts:       opts,
 		gapRuleSec: gapRuleSec,
-	}, nil
+		cfs:        cfs,
+		cfBlocks:   cfs[1],
+		cfCanon:    cfs[2],
+		cfTS:       cfs[3],
+		cfMeta:     cfs[4],
+	}
 }
 
-func (s *RocksStore) Close() {
+func (s *Store) Close() {
 	if s.ro != nil {
 		s.ro.Destroy()
 	}
 	if s.wo != nil {
 		s.wo.Destroy()
 	}
+	for _, cf := range s.cfs {
+		cf.Destroy()
+	}
 	if s.db != nil {
 		s.db.Close()
 	}
+	if s.opts != nil {
+		s.opts.destroy()
+	}
 }
 
 // HeadHash returns head hash. ok=false means empty DB.
-func (s *RocksStore) HeadHash() (hash.Hash32, bool, error) {
-	val, err := s.db.Get(s.ro, KeyHeadHash())
+func (s *Store) HeadHash() (hash.Hash32, bool, error) {
+	val, err := s.db.GetCF(s.ro, s.cfMeta, KeyHeadHash())
 	if err != nil {
 		return hash.Hash32{}, false, err
 	}
@@ -101,8 +132,8 @@ func (s *RocksStore) HeadHash() (hash.Hash32, bool, error) {
 }
 
 // HeadNum returns head num. ok=false means empty DB.
-func (s *RocksStore) HeadNum() (int64, bool, error) {
-	val, err := s.db.Get(s.ro, KeyHeadNum())
+func (s *Store) HeadNum() (int64, bool, error) {
+	val, err := s.db.GetCF(s.ro, s.cfMeta, KeyHeadNum())
 	if err != nil {
 		return 0, false, err
 	}
@@ -118,9 +149,31 @@ func (s *RocksStore) HeadNum() (int64, bool, error) {
 	return n, true, nil
 }
 
+// TailNum returns the lowest retained canonical height (1 until retention prunes). ok=false means empty DB.
+func (s *Store) TailNum() (int64, bool, error) {
+	_, okHead, err := s.HeadNum()
+	if err != nil || !okHead {
+		return 0, false, err
+	}
+	val, err := s.db.GetCF(s.ro, s.cfMeta, KeyTailNum())
+	if err != nil {
+		return 0, false, err
+	}
+	defer val.Free()
+
+	if !val.Exists() {
+		return 1, true, nil
+	}
+	n, ok := decodeI64BE(val.Data())
+	if !ok {
+		return 0, false, fmt.Errorf("bad %s value: % x", keyTailNum, val.Data())
+	}
+	return n, true, nil
+}
+
 // GetBlockByHashRaw gets the block bytes by block hash.
-func (s *RocksStore) GetBlockByHashRaw(h hash.Hash32) ([]byte, error) {
-	val, err := s.db.Get(s.ro, KeyBlockHash(h))
+func (s *Store) GetBlockByHashRaw(h hash.Hash32) ([]byte, error) {
+	val, err := s.db.GetCF(s.ro, s.cfBlocks, KeyBlockHash(h))
 	if err != nil {
 		return nil, err
 	}
@@ -133,8 +186,8 @@ func (s *RocksStore) GetBlockByHashRaw(h hash.Hash32) ([]byte, error) {
 }
 
 // GetCanonicalBlockRaw gets canonical block at height n.
-func (s *RocksStore) GetCanonicalBlockRaw(n int64) ([]byte, error) {
-	val, err := s.db.Get(s.ro, KeyCanon(n))
+func (s *Store) GetCanonicalBlockRaw(n int64) ([]byte, error) {
+	val, err := s.db.GetCF(s.ro, s.cfCanon, KeyCanon(n))
 	if err != nil {
 		return nil, err
 	}
@@ -151,24 +204,24 @@ func (s *RocksStore) GetCanonicalBlockRaw(n int64) ([]byte, error) {
 }
 
 // AppendCanonicalBlock writes block by hash and updates canonical + head.
-func (s *RocksStore) AppendCanonicalBlock(b model.Block, raw []byte) error {
+func (s *Store) AppendCanonicalBlock(b model.Block, raw []byte) error {
 	wb := gorocksdb.NewWriteBatch()
 	defer wb.Destroy()
 
 	// 1) blockhash:{hash} -> raw
-	wb.Put(KeyBlockHash(b.Hash), raw)
+	wb.PutCF(s.cfBlocks, KeyBlockHash(b.Hash), raw)
 
 	// 2) canon:{number} -> hash
-	wb.Put(KeyCanon(b.Header.Number), b.Hash.Bytes())
+	wb.PutCF(s.cfCanon, KeyCanon(b.Header.Number), b.Hash.Bytes())
 
 	// 2.1) canon_ts:{number} -> ts (8 bytes BE)
-	wb.Put(KeyCanonTS(b.Header.Number), encodeI64BE(b.Header.Timestamp))
+	wb.PutCF(s.cfTS, KeyCanonTS(b.Header.Number), encodeI64BE(b.Header.Timestamp))
 
 	// 3) meta:head_hash -> hash
-	wb.Put(KeyHeadHash(), b.Hash.Bytes())
+	wb.PutCF(s.cfMeta, KeyHeadHash(), b.Hash.Bytes())
 
 	// 4) meta:head_num -> number
-	wb.Put(KeyHeadNum(), []byte(strconv.FormatInt(b.Header.Number, 10)))
+	wb.PutCF(s.cfMeta, KeyHeadNum(), []byte(strconv.FormatInt(b.Header.Number, 10)))
 
 	// 5) gap event index (shape-2): gap_end_ts:{gapSec}:{endTs} -> height
 	// only if gap rule is set for this run
@@ -192,7 +245,7 @@ func (s *RocksStore) AppendCanonicalBlock(b model.Block, raw []byte) error {
 		}
 
 		if ok && b.Header.Timestamp-prevTs > gapSec {
-			wb.Put(KeyGapEndTS(gapSec, b.Header.Timestamp), encodeI64BE(b.Header.Number))
+			wb.PutCF(s.cfMeta, KeyGapEndTS(gapSec, b.Header.Timestamp), encodeI64BE(b.Header.Number))
 		}
 	}
 
@@ -222,14 +275,14 @@ func (s *RocksStore) AppendCanonicalBlock(b model.Block, raw []byte) error {
 //   - REBUILD: delete all and rebuild from target
 //
 // - keepHeight: only meaningful when action == TRIM_AFTER_KEEP (or keep-all uses headNum)
-func (s *RocksStore) DecideTailAction(curTs int64, backfillSec int64) (action TailAction, keepHeight int64, err error) {
+func (s *Store) DecideTailAction(curTs int64, backfillSec int64) (action store.TailAction, keepHeight int64, err error) {
 	start := time.Now()
 	gapSec := s.gapRuleSec
 	// --- sanitize ---
 	if backfillSec <= 0 {
 		log.Printf("[tail] decide: backfillSec<=0 => KEEP_ALL_CATCH_UP (curTs=%d backfillSec=%d gapSec=%d) cost=%s",
 			curTs, backfillSec, gapSec, time.Since(start))
-		return TailKeepAllCatchUp, 0, nil
+		return store.TailKeepAllCatchUp, 0, nil
 	}
 
 	target := curTs - backfillSec
@@ -240,62 +293,67 @@ func (s *RocksStore) DecideTailAction(curTs int64, backfillSec int64) (action Ta
 	headNum, okHead, err := s.HeadNum()
 	if err != nil {
 		log.Printf("[tail] headnum failed: err=%v cost=%s", err, time.Since(start))
-		return TailRebuild, 0, err
+		return store.TailRebuild, 0, err
 	}
 	if !okHead || headNum <= 0 {
 		log.Printf("[tail] no head => REBUILD (okHead=%v headNum=%d) cost=%s", okHead, headNum, time.Since(start))
-		return TailRebuild, 0, nil
+		return store.TailRebuild, 0, nil
 	}
 
 	headTs, okTs, err := s.GetCanonicalTimestamp(headNum)
 	if err != nil {
 		log.Printf("[tail] head timestamp failed: headNum=%d err=%v cost=%s", headNum, err, time.Since(start))
-		return TailRebuild, 0, err
+		return store.TailRebuild, 0, err
 	}
 	if !okTs {
 		log.Printf("[tail] no head timestamp => REBUILD (headNum=%d) cost=%s", headNum, time.Since(start))
-		return TailRebuild, 0, nil
+		return store.TailRebuild, 0, nil
 	}
-	log.Printf("[tail] head: headNum=%d headTs=%d target=%d", headNum, headTs, target)
+	tailNum, _, err := s.TailNum()
+	if err != nil {
+		log.Printf("[tail] tailnum failed: err=%v cost=%s", err, time.Since(start))
+		return store.TailRebuild, 0, err
+	}
+	log.Printf("[tail] head: headNum=%d headTs=%d tailNum=%d target=%d", headNum, headTs, tailNum, target)
 
 	// If head hasn't reached target time -> keep all and just mine forward.
 	if headTs < target {
 		log.Printf("[tail] headTs<target => KEEP_ALL_CATCH_UP (headTs=%d target=%d headNum=%d) cost=%s",
 			headTs, target, headNum, time.Since(start))
-		return TailKeepAllCatchUp, headNum, nil
+		return store.TailKeepAllCatchUp, headNum, nil
 	}
 
 	// --- lower_bound(target) to get starting position near target ---
 	pos, tsPos, okPos, err := s.LowerBoundByTimestamp(target)
 	if err != nil {
 		log.Printf("[tail] lower_bound failed: target=%d err=%v cost=%s", target, err, time.Since(start))
-		return TailRebuild, 0, err
+		return store.TailRebuild, 0, err
 	}
 	if !okPos {
 		log.Printf("[tail] lower_bound not found => KEEP_ALL_CATCH_UP (target=%d headNum=%d) cost=%s",
 			target, headNum, time.Since(start))
-		return TailKeepAllCatchUp, headNum, nil
+		return store.TailKeepAllCatchUp, headNum, nil
 	}
 	log.Printf("[tail] lower_bound: pos=%d tsPos=%d (target=%d windowEnd=%d)", pos, tsPos, target, windowEnd)
 
 	// Quick hole detection around target:
 	// If first ts>=target is already beyond windowEnd, there is a hole near target -> trim to pos-1 (or rebuild)
 	if tsPos > windowEnd {
-		if pos-1 >= 1 {
+		if pos-1 >= tailNum {
 			log.Printf("[tail] tsPos>windowEnd => TRIM_AFTER_KEEP keep=%d (pos=%d tsPos=%d windowEnd=%d) cost=%s",
 				pos-1, pos, tsPos, windowEnd, time.Since(start))
-			return TailTrimAfterKeep, pos - 1, nil
+			return store.TailTrimAfterKeep, pos - 1, nil
 		}
-		log.Printf("[tail] tsPos>windowEnd but pos-1<1 => REBUILD (pos=%d tsPos=%d windowEnd=%d) cost=%s",
-			pos, tsPos, windowEnd, time.Since(start))
-		return TailRebuild, 0, nil
+		log.Printf("[tail] tsPos>windowEnd but pos-1<tail => REBUILD (pos=%d tsPos=%d tailNum=%d windowEnd=%d) cost=%s",
+			pos, tsPos, tailNum, windowEnd, time.Since(start))
+		return store.TailRebuild, 0, nil
 	}
 
 	// --- gap index fast path (shape-2) ---
 	storedGap, hasStored, err := s.getStoredGapRuleSec()
 	if err != nil {
 		log.Printf("[tail] gap_rule meta read failed: err=%v cost=%s", err, time.Since(start))
-		return TailRebuild, 0, err
+		return store.TailRebuild, 0, err
 	}
 
 	log.Printf(
@@ -313,7 +371,7 @@ func (s *RocksStore) DecideTailAction(curTs int64, backfillSec int64) (action Ta
 	if hasStored && storedGap == gapSec {
 		log.Printf("[tail] gap_index fastpath: storedGap=%d matches gapSec=%d (target=%d)", storedGap, gapSec, target)
 
-		it := s.db.NewIterator(s.ro)
+		it := s.db.NewIteratorCF(s.ro, s.cfMeta)
 		defer it.Close()
 
 		prefix := GapPrefix(gapSec)
@@ -340,10 +398,10 @@ func (s *RocksStore) DecideTailAction(curTs int64, backfillSec int64) (action Ta
 				continue
 			}
 
-			valid, err := s.validateGapAtHeight(h, headNum, gapSec)
+			valid, err := s.validateGapAtHeight(h, tailNum, headNum, gapSec)
 			if err != nil {
 				log.Printf("[tail] gap_index validate failed: h=%d err=%v cost=%s", h, err, time.Since(start))
-				return TailRebuild, 0, err
+				return store.TailRebuild, 0, err
 			}
 			if !valid {
 				log.Printf("[tail] gap_index stale/invalid: h=%d (skip) seen=%d", h, seen)
@@ -351,18 +409,18 @@ func (s *RocksStore) DecideTailAction(curTs int64, backfillSec int64) (action Ta
 			}
 
 			keep := h - 1
-			if keep < 1 {
+			if keep < tailNum {
 				log.Printf("[tail] gap_index hit => REBUILD (h=%d keep=%d) cost=%s", h, keep, time.Since(start))
-				return TailRebuild, 0, nil
+				return store.TailRebuild, 0, nil
 			}
 
 			log.Printf("[tail] gap_index hit => TRIM_AFTER_KEEP keep=%d (gapHeight=%d) seen=%d cost=%s",
 				keep, h, seen, time.Since(start))
-			return TailTrimAfterKeep, keep, nil
+			return store.TailTrimAfterKeep, keep, nil
 		}
 
 		log.Printf("[tail] gap_index miss => KEEP_ALL_CATCH_UP (seen=%d) cost=%s", seen, time.Since(start))
-		return TailKeepAllCatchUp, headNum, nil
+		return store.TailKeepAllCatchUp, headNum, nil
 	}
 
 	// --- fallback: sequential scan after pos (fast because canon_ts is 8 bytes) ---
@@ -372,10 +430,10 @@ func (s *RocksStore) DecideTailAction(curTs int64, backfillSec int64) (action Ta
 		log.Printf("[tail] gap_index fallback: no stored meta (gapSec=%d target=%d) => scan", gapSec, target)
 	}
 
-	gapH, gapEndTs, okGap, err := s.findFirstGapAfterPos(pos, headNum, gapSec, target)
+	gapH, gapEndTs, okGap, err := s.findFirstGapAfterPos(pos, tailNum, headNum, gapSec)
 	if err != nil {
 		log.Printf("[tail] fallback scan failed: pos=%d headNum=%d err=%v cost=%s", pos, headNum, err, time.Since(start))
-		return TailRebuild, 0, err
+		return store.TailRebuild, 0, err
 	}
 
 	// we completed fallback for this rule -> store meta for future fast path
@@ -388,24 +446,25 @@ func (s *RocksStore) DecideTailAction(curTs int64, backfillSec int64) (action Ta
 
 	if okGap {
 		keep := gapH - 1
-		if keep < 1 {
+		if keep < tailNum {
 			log.Printf("[tail] fallback found gap => REBUILD (gapHeight=%d gapEndTs=%d keep=%d) cost=%s",
 				gapH, gapEndTs, keep, time.Since(start))
-			return TailRebuild, 0, nil
+			return store.TailRebuild, 0, nil
 		}
 		log.Printf("[tail] fallback found gap => TRIM_AFTER_KEEP keep=%d (gapHeight=%d gapEndTs=%d) cost=%s",
 			keep, gapH, gapEndTs, time.Since(start))
-		return TailTrimAfterKeep, keep, nil
+		return store.TailTrimAfterKeep, keep, nil
 	}
 
 	log.Printf("[tail] fallback scan no gap => KEEP_ALL_CATCH_UP keep=%d cost=%s", headNum, time.Since(start))
-	return TailKeepAllCatchUp, headNum, nil
+	return store.TailKeepAllCatchUp, headNum, nil
 }
 
 // DeleteCanonicalAfter deletes canonical blocks with height > keepHeight, deletes their raw blocks,
 // and updates meta head to keepHeight.
-// If keepHeight == 0, it deletes the entire canonical chain and clears head metadata.
-func (s *RocksStore) DeleteCanonicalAfter(keepHeight int64) error {
+// If keepHeight == 0 (or below the retention tail), it deletes the entire canonical chain and clears
+// head/tail metadata.
+func (s *Store) DeleteCanonicalAfter(keepHeight int64) error {
 	headNum, okHead, err := s.HeadNum()
 	if err != nil {
 		return err
@@ -416,31 +475,36 @@ func (s *RocksStore) DeleteCanonicalAfter(keepHeight int64) error {
 	if keepHeight >= headNum {
 		return nil
 	}
-	if keepHeight < 0 {
+	tailNum, _, err := s.TailNum()
+	if err != nil {
+		return err
+	}
+	if keepHeight < tailNum {
 		keepHeight = 0
 	}
 
 	wb := gorocksdb.NewWriteBatch()
 	defer wb.Destroy()
 
-	// delete (keepHeight+1 .. headNum)
-	for n := keepHeight + 1; n <= headNum; n++ {
+	// delete (max(keepHeight+1, tailNum) .. headNum); nothing below tail exists any more
+	for n := max(keepHeight+1, tailNum); n <= headNum; n++ {
 		h, ok, err := s.GetCanonicalHash(n)
 		if err != nil {
 			return err
 		}
 		if ok {
-			wb.Delete(KeyBlockHash(h))
+			wb.DeleteCF(s.cfBlocks, KeyBlockHash(h))
 		}
-		wb.Delete(KeyCanon(n))
+		wb.DeleteCF(s.cfCanon, KeyCanon(n))
 		// NEW: delete timestamp index for canonical height
-		wb.Delete(KeyCanonTS(n))
+		wb.DeleteCF(s.cfTS, KeyCanonTS(n))
 	}
 
 	// update head metadata
 	if keepHeight == 0 {
-		wb.Delete(KeyHeadHash())
-		wb.Delete(KeyHeadNum())
+		wb.DeleteCF(s.cfMeta, KeyHeadHash())
+		wb.DeleteCF(s.cfMeta, KeyHeadNum())
+		wb.DeleteCF(s.cfMeta, KeyTailNum())
 	} else {
 		newHeadHash, ok, err := s.GetCanonicalHash(keepHeight)
 		if err != nil {
@@ -448,18 +512,18 @@ func (s *RocksStore) DeleteCanonicalAfter(keepHeight int64) error {
 		}
 		if !ok {
 			// broken canonical mapping; safest is to clear head
-			wb.Delete(KeyHeadHash())
-			wb.Delete(KeyHeadNum())
+			wb.DeleteCF(s.cfMeta, KeyHeadHash())
+			wb.DeleteCF(s.cfMeta, KeyHeadNum())
 		} else {
-			wb.Put(KeyHeadHash(), newHeadHash.Bytes())
-			wb.Put(KeyHeadNum(), []byte(strconv.FormatInt(keepHeight, 10)))
+			wb.PutCF(s.cfMeta, KeyHeadHash(), newHeadHash.Bytes())
+			wb.PutCF(s.cfMeta, KeyHeadNum(), []byte(strconv.FormatInt(keepHeight, 10)))
 		}
 	}
 
 	// OPTIONAL NEW: if you later add meta:gap_head, keep it consistent.
 	// Conservative approach: if trim happens, clear gap_head to avoid stale pointers.
 	// (If you want "recompute gap_head up to keepHeight", that would require scanning; don't do it here.)
-	wb.Delete(KeyGapHead())
+	wb.DeleteCF(s.cfMeta, KeyGapHead())
 
 	if err := s.db.Write(s.wo, wb); err != nil {
 		return err
@@ -474,8 +538,8 @@ func (s *RocksStore) DeleteCanonicalAfter(keepHeight int64) error {
 }
 
 // GetCanonicalHash gets canonical block hash at height n.
-func (s *RocksStore) GetCanonicalHash(n int64) (hash.Hash32, bool, error) {
-	val, err := s.db.Get(s.ro, KeyCanon(n))
+func (s *Store) GetCanonicalHash(n int64) (hash.Hash32, bool, error) {
+	val, err := s.db.GetCF(s.ro, s.cfCanon, KeyCanon(n))
 	if err != nil {
 		return hash.Hash32{}, false, err
 	}
@@ -492,9 +556,9 @@ func (s *RocksStore) GetCanonicalHash(n int64) (hash.Hash32, bool, error) {
 }
 
 // GetCanonicalTimestamp gets canonical block timestamp at height n (decode raw).
-func (s *RocksStore) GetCanonicalTimestamp(n int64) (int64, bool, error) {
+func (s *Store) GetCanonicalTimestamp(n int64) (int64, bool, error) {
 	// FAST PATH: canon_ts:{n} -> 8 bytes BE int64
-	v, err := s.db.Get(s.ro, KeyCanonTS(n))
+	v, err := s.db.GetCF(s.ro, s.cfTS, KeyCanonTS(n))
 	if err != nil {
 		return 0, false, err
 	}
@@ -519,7 +583,7 @@ func (s *RocksStore) GetCanonicalTimestamp(n int64) (int64, bool, error) {
 
 	// Best-effort self-heal: write back canon_ts for next time.
 	// 不要把错误上抛影响主流程；写失败就算了。
-	_ = s.db.Put(s.wo, KeyCanonTS(n), encodeI64BE(blk.Header.Timestamp))
+	_ = s.db.PutCF(s.wo, s.cfTS, KeyCanonTS(n), encodeI64BE(blk.Header.Timestamp))
 
 	return blk.Header.Timestamp, true, nil
 }
@@ -527,7 +591,7 @@ func (s *RocksStore) GetCanonicalTimestamp(n int64) (int64, bool, error) {
 // LowerBoundByTimestamp returns the smallest canonical height n such that ts(n) >= targetTs,
 // along with ts(n).
 // ok=false means: empty chain, broken canonical, or all ts < targetTs.
-func (s *RocksStore) LowerBoundByTimestamp(targetTs int64) (n int64, nTs int64, ok bool, err error) {
+func (s *Store) LowerBoundByTimestamp(targetTs int64) (n int64, nTs int64, ok bool, err error) {
 	headNum, okHead, err := s.HeadNum()
 	if err != nil {
 		return 0, 0, false, err
@@ -536,38 +600,11 @@ func (s *RocksStore) LowerBoundByTimestamp(targetTs int64) (n int64, nTs int64,
 		return 0, 0, false, nil
 	}
 
-	lo, hi := int64(1), headNum
-	pos := int64(-1)
-	posTs := int64(0)
-
-	for lo <= hi {
-		mid := lo + (hi-lo)/2
-		midTs, okTs, err := s.GetCanonicalTimestamp(mid)
-		if err != nil {
-			return 0, 0, false, err
-		}
-		if !okTs {
-			// canonical broken
-			return 0, 0, false, nil
-		}
-
-		if midTs >= targetTs {
-			pos = mid
-			posTs = midTs
-			hi = mid - 1
-		} else {
-			lo = mid + 1
-		}
-	}
-
-	if pos == -1 {
-		return 0, 0, false, nil
+	tailNum, _, err := s.TailNum()
+	if err != nil {
+		return 0, 0, false, err
 	}
-	return pos, posTs, true, nil
-}
-
-func KeyCanonTS(height int64) []byte {
-	return []byte("canon_ts:" + strconv.FormatInt(height, 10))
+	return store.LowerBoundByTimestamp(tailNum, headNum, targetTs, s.GetCanonicalTimestamp)
 }
 
 func KeyGapHead() []byte {
@@ -610,7 +647,7 @@ func KeyGapRuleSec() []byte {
 // gap_end_ts:{gapSecBE}:{endTsBE} -> heightBE
 func KeyGapEndTS(gapSec int64, endTs int64) []byte {
 	// prefix keeps lexicographic order: group by gapSec then by endTs
-	p := []byte("gap_end_ts:")
+	p := []byte(gapPrefix)
 	p = append(p, encodeI64BE(gapSec)...)
 	p = append(p, ':')
 	p = append(p, encodeI64BE(endTs)...)
@@ -618,14 +655,14 @@ func KeyGapEndTS(gapSec int64, endTs int64) []byte {
 }
 
 func GapPrefix(gapSec int64) []byte {
-	p := []byte("gap_end_ts:")
+	p := []byte(gapPrefix)
 	p = append(p, encodeI64BE(gapSec)...)
 	p = append(p, ':')
 	return p
 }
 
-func (s *RocksStore) getStoredGapRuleSec() (int64, bool, error) {
-	v, err := s.db.Get(s.ro, KeyGapRuleSec())
+func (s *Store) getStoredGapRuleSec() (int64, bool, error) {
+	v, err := s.db.GetCF(s.ro, s.cfMeta, KeyGapRuleSec())
 	if err != nil {
 		return 0, false, err
 	}
@@ -640,12 +677,13 @@ func (s *RocksStore) getStoredGapRuleSec() (int64, bool, error) {
 	return sec, true, nil
 }
 
-func (s *RocksStore) setStoredGapRuleSec(sec int64) error {
-	return s.db.Put(s.wo, KeyGapRuleSec(), encodeI64BE(sec))
+func (s *Store) setStoredGapRuleSec(sec int64) error {
+	return s.db.PutCF(s.wo, s.cfMeta, KeyGapRuleSec(), encodeI64BE(sec))
 }
 
-func (s *RocksStore) validateGapAtHeight(h int64, headNum int64, gapSec int64) (bool, error) {
-	if h <= 1 || h > headNum {
+func (s *Store) validateGapAtHeight(h int64, tailNum int64, headNum int64, gapSec int64) (bool, error) {
+	// h-1 must be retained too, so a gap ending at the tail (or pruned away) is stale
+	if h <= tailNum || h > headNum {
 		return false, nil
 	}
 	// canonical must exist at h
@@ -668,9 +706,9 @@ func (s *RocksStore) validateGapAtHeight(h int64, headNum int64, gapSec int64) (
 	return (ts2 - ts1) > gapSec, nil
 }
 
-func (s *RocksStore) findFirstGapAfterPos(pos int64, headNum int64, gapSec int64, targetTs int64) (gapHeight int64, gapEndTs int64, ok bool, err error) {
-	if pos < 1 {
-		pos = 1
+func (s *Store) findFirstGapAfterPos(pos int64, tailNum int64, headNum int64, gapSec int64) (gapHeight int64, gapEndTs int64, ok bool, err error) {
+	if pos < tailNum {
+		pos = tailNum
 	}
 	if pos >= headNum {
 		return 0, 0, false, nil
@@ -694,7 +732,7 @@ func (s *RocksStore) findFirstGapAfterPos(pos int64, headNum int64, gapSec int64
 		}
 		if ts-prevTs > gapSec {
 			// lazy index rebuild: record this gap event for current rule
-			_ = s.db.Put(s.wo, KeyGapEndTS(gapSec, ts), encodeI64BE(n))
+			_ = s.db.PutCF(s.wo, s.cfMeta, KeyGapEndTS(gapSec, ts), encodeI64BE(n))
 			return n, ts, true, nil
 		}
 		prevTs = ts
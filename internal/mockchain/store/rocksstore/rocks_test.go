@@ -0,0 +1,85 @@
+//go:build cgo && rocksdb
+
+// Needs librocksdb at link time: go test -tags rocksdb ./internal/mockchain/store/rocksstore
+
+package rocksstore
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/store"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/store/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, gapRuleSec int64) store.ChainStore {
+		st, err := Open(filepath.Join(t.TempDir(), "db"), gapRuleSec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st
+	})
+}
+
+func TestReopenKeepsChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	st, err := Open(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := storetest.AppendChain(t, st, 20, 1000, 1)
+	st.Close()
+
+	st, err = OpenReadOnly(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	h, ok, err := st.HeadHash()
+	if err != nil || !ok || h != blocks[19].Hash {
+		t.Fatalf("head after reopen: %s ok=%v err=%v", h.Hex(), ok, err)
+	}
+	rep, err := st.Verify(store.VerifyOptions{})
+	if err != nil || !rep.OK() || rep.CheckedBlocks != 20 {
+		t.Fatalf("verify: %+v err=%v", rep, err)
+	}
+}
+
+func TestCheckpointRestore(t *testing.T) {
+	dir := t.TempDir()
+	st, err := Open(filepath.Join(dir, "db"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := storetest.AppendChain(t, st, 10, time.Now().Unix()-100, 1)
+
+	info, err := st.Checkpoint(filepath.Join(dir, "snaps", "s1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.HeadNum != 10 || info.HeadHash != blocks[9].Hash.Hex() || info.TailNum != 1 {
+		t.Fatalf("info=%+v", info)
+	}
+	storetest.AppendChain(t, st, 5, blocks[9].Header.Timestamp+1, 1) // past the checkpoint
+	st.Close()
+
+	list, err := store.ListSnapshots(filepath.Join(dir, "snaps"))
+	if err != nil || len(list) != 1 || list[0].Name != "s1" {
+		t.Fatalf("list=%+v err=%v", list, err)
+	}
+	backup, err := store.RestoreSnapshot(list[0].Dir, filepath.Join(dir, "db"))
+	if err != nil || backup == "" {
+		t.Fatalf("restore: backup=%q err=%v", backup, err)
+	}
+
+	st, err = Open(filepath.Join(dir, "db"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if n, _, _ := st.HeadNum(); n != 10 {
+		t.Fatalf("restored head=%d want 10", n)
+	}
+}
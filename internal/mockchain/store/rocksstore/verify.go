@@ -0,0 +1,164 @@
+package rocksstore
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/store"
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+	"github.com/tecbot/gorocksdb"
+)
+
+// Verify walks the canon CF under a RocksDB snapshot and checks that the chain is self-consistent:
+// - canonical contiguity over tail..head and head meta pointing at the tip
+// - block_hash:{hash} present, decodable, number/hash matching canon:{n}
+// - parent-hash linkage, HashHeader, TxRoot and per-tx HashTxCanonical recomputation
+// - canon_ts:{n} equal to the header timestamp and strictly increasing
+// - gap_end_ts index for the runtime gap rule against the gaps actually present
+//
+// It never writes, so it is safe to run against a live store (the miner keeps appending past the snapshot)
+// or a read-only handle. Blocks written before nonces were serialized are counted as LegacyBlocks
+// (their header / tx hashes are not recomputed) instead of failing the report.
+func (s *Store) Verify(opts store.VerifyOptions) (*store.VerifyReport, error) {
+	snap := s.db.NewSnapshot()
+	defer s.db.ReleaseSnapshot(snap)
+	ro := gorocksdb.NewDefaultReadOptions()
+	defer ro.Destroy()
+	ro.SetSnapshot(snap)
+
+	return store.RunVerify(&verifySource{s: s, ro: ro}, s.gapRuleSec, opts)
+}
+
+type verifySource struct {
+	s  *Store
+	ro *gorocksdb.ReadOptions // snapshot-bound
+}
+
+func (r *verifySource) get(cf *gorocksdb.ColumnFamilyHandle, key []byte) ([]byte, bool, error) {
+	val, err := r.s.db.GetCF(r.ro, cf, key)
+	if err != nil {
+		return nil, false, err
+	}
+	defer val.Free()
+	if !val.Exists() {
+		return nil, false, nil
+	}
+	return append([]byte(nil), val.Data()...), true, nil
+}
+
+func (r *verifySource) HeadNum(report store.IssueFunc) (int64, bool, error) {
+	b, ok, err := r.get(r.s.cfMeta, KeyHeadNum())
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		report(0, store.IssueHeadMeta, "head_num: "+err.Error())
+		return 0, false, nil
+	}
+	return n, true, nil
+}
+
+func (r *verifySource) HeadHash(report store.IssueFunc) (hash.Hash32, bool, error) {
+	b, ok, err := r.get(r.s.cfMeta, KeyHeadHash())
+	if err != nil || !ok {
+		return hash.Hash32{}, false, err
+	}
+	h, err := hash.ByteSlice2Hash32(b)
+	if err != nil {
+		report(0, store.IssueHeadMeta, "head_hash: "+err.Error())
+		return hash.Hash32{}, false, nil
+	}
+	return h, true, nil
+}
+
+func (r *verifySource) TailNum(report store.IssueFunc) (int64, error) {
+	b, ok, err := r.get(r.s.cfMeta, KeyTailNum())
+	if err != nil || !ok {
+		return 1, err
+	}
+	n, okDec := decodeI64BE(b)
+	if !okDec || n < 1 {
+		report(0, store.IssueHeadMeta, fmt.Sprintf("bad tail_num % x", b))
+		return 1, nil
+	}
+	return n, nil
+}
+
+func (r *verifySource) BlockRaw(h hash.Hash32) ([]byte, bool, error) {
+	return r.get(r.s.cfBlocks, KeyBlockHash(h))
+}
+
+func (r *verifySource) CanonTs(n int64) (int64, bool, error) {
+	b, ok, err := r.get(r.s.cfTS, KeyCanonTS(n))
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	ts, ok := decodeI64BE(b)
+	return ts, ok, nil
+}
+
+// canonical scans the canon CF (8-byte BE height keys, so iteration is in height order).
+func (r *verifySource) Canonical(report store.IssueFunc) (map[int64]hash.Hash32, error) {
+	it := r.s.db.NewIteratorCF(r.ro, r.s.cfCanon)
+	defer it.Close()
+
+	out := make(map[int64]hash.Hash32)
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		k := it.Key()
+		val := it.Value()
+		kBytes := append([]byte(nil), k.Data()...)
+		vBytes := append([]byte(nil), val.Data()...)
+		k.Free()
+		val.Free()
+
+		n, ok := decodeI64BE(kBytes)
+		if !ok || n <= 0 {
+			report(0, store.IssueBadCanonValue, fmt.Sprintf("bad canon key % x", kBytes))
+			continue
+		}
+		h, err := hash.ByteSlice2Hash32(vBytes)
+		if err != nil {
+			report(n, store.IssueBadCanonValue, err.Error())
+			continue
+		}
+		out[n] = h
+	}
+	return out, it.Err()
+}
+
+func (r *verifySource) GapIndex(gapSec int64) ([]store.GapEntry, bool, error) {
+	trusted := false
+	b, ok, err := r.get(r.s.cfMeta, KeyGapRuleSec())
+	if err != nil {
+		return nil, false, err
+	}
+	if ok {
+		if stored, okDec := decodeI64BE(b); okDec && stored == gapSec {
+			trusted = true
+		}
+	}
+
+	it := r.s.db.NewIteratorCF(r.ro, r.s.cfMeta)
+	defer it.Close()
+
+	prefix := GapPrefix(gapSec)
+	out := make([]store.GapEntry, 0)
+	for it.Seek(prefix); it.Valid(); it.Next() {
+		k := it.Key()
+		val := it.Value()
+		kBytes := append([]byte(nil), k.Data()...)
+		vBytes := append([]byte(nil), val.Data()...)
+		k.Free()
+		val.Free()
+
+		if !bytes.HasPrefix(kBytes, prefix) {
+			break
+		}
+		endTs, okTs := decodeI64BE(kBytes[len(prefix):])
+		h, okH := decodeI64BE(vBytes)
+		out = append(out, store.GapEntry{EndTs: endTs, Height: h, OK: okTs && okH})
+	}
+	return out, trusted, it.Err()
+}
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SnapshotManifest sits next to the RocksDB files of a checkpoint; RocksDB ignores it.
+const SnapshotManifest = "SNAPSHOT.json"
+
+// Snapshotter is implemented by stores that can take an online, point-in-time copy of themselves.
+type Snapshotter interface {
+	Checkpoint(dir string) (*SnapshotInfo, error)
+}
+
+// SnapshotInfo describes a checkpoint. Head/tail are read back from the checkpoint itself,
+// not from the live store (the miner keeps appending while the checkpoint is cut).
+type SnapshotInfo struct {
+	Name      string `json:"name"`
+	Dir       string `json:"dir"`
+	CreatedAt int64  `json:"created_at"`
+
+	HeadNum       int64  `json:"head_num"`
+	HeadHash      string `json:"head_hash"`
+	HeadTimestamp int64  `json:"head_timestamp"`
+	TailNum       int64  `json:"tail_num"`
+	GapRuleSec    int64  `json:"gap_rule_sec"`
+
+	Cost string `json:"cost,omitempty"`
+}
+
+// WriteSnapshotManifest marks dir as a finished checkpoint (written last, atomically).
+func WriteSnapshotManifest(dir string, info *SnapshotInfo) error {
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, SnapshotManifest+".tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, SnapshotManifest))
+}
+
+// ReadSnapshotInfo loads the manifest of a finished checkpoint.
+func ReadSnapshotInfo(dir string) (*SnapshotInfo, error) {
+	b, err := os.ReadFile(filepath.Join(dir, SnapshotManifest))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%s is not a mockchain snapshot (no %s)", dir, SnapshotManifest)
+		}
+		return nil, err
+	}
+	var info SnapshotInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, fmt.Errorf("%s: %w", SnapshotManifest, err)
+	}
+	info.Dir = dir
+	return &info, nil
+}
+
+// ListSnapshots returns the finished checkpoints under root, oldest first. Missing root = none.
+func ListSnapshots(root string) ([]SnapshotInfo, error) {
+	ents, err := os.ReadDir(root)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	out := make([]SnapshotInfo, 0, len(ents))
+	for _, e := range ents {
+		if !e.IsDir() {
+			continue
+		}
+		info, err := ReadSnapshotInfo(filepath.Join(root, e.Name()))
+		if err != nil {
+			continue // unfinished or foreign dir
+		}
+		out = append(out, *info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt < out[j].CreatedAt })
+	return out, nil
+}
+
+// RestoreSnapshot replaces the DB at dbPath with a copy of the checkpoint in snapDir.
+// The checkpoint itself is left untouched (SST files are immutable, so they are hard-linked;
+// everything else is copied), and can be restored again. An existing DB is moved aside to
+// {dbPath}.pre-restore-{unix}, whose path is returned. Must run before the DB is opened.
+func RestoreSnapshot(snapDir string, dbPath string) (backup string, err error) {
+	start := time.Now()
+	info, err := ReadSnapshotInfo(snapDir)
+	if err != nil {
+		return "", err
+	}
+
+	tmp := dbPath + ".restoring"
+	if err := os.RemoveAll(tmp); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(tmp, 0o755); err != nil {
+		return "", err
+	}
+
+	ents, err := os.ReadDir(snapDir)
+	if err != nil {
+		return "", err
+	}
+	var linked, copied int
+	for _, e := range ents {
+		if e.IsDir() || strings.HasPrefix(e.Name(), SnapshotManifest) {
+			continue
+		}
+		src, dst := filepath.Join(snapDir, e.Name()), filepath.Join(tmp, e.Name())
+		if strings.HasSuffix(e.Name(), ".sst") && os.Link(src, dst) == nil {
+			linked++
+			continue
+		}
+		if err := copyFile(src, dst); err != nil {
+			_ = os.RemoveAll(tmp)
+			return "", err
+		}
+		copied++
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		backup = fmt.Sprintf("%s.pre-restore-%d", dbPath, time.Now().Unix())
+		if err := os.Rename(dbPath, backup); err != nil {
+			_ = os.RemoveAll(tmp)
+			return "", err
+		}
+	}
+	if err := os.Rename(tmp, dbPath); err != nil {
+		return backup, err
+	}
+
+	log.Printf("[snapshot] restored %s -> %s head=%d tail=%d linked=%d copied=%d backup=%q cost=%s",
+		snapDir, dbPath, info.HeadNum, info.TailNum, linked, copied, backup, time.Since(start))
+	return backup, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
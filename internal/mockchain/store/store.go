@@ -0,0 +1,99 @@
+package store
+
+import (
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+)
+
+// ChainStore is what the miner and the RPC server need from a chain store.
+// rocksstore.Store is the durable implementation; MemStore has identical semantics without cgo,
+// and storetest.Run holds both to the same contract.
+type ChainStore interface {
+	GapRuleSec() int64
+
+	// HeadHash / HeadNum / TailNum: ok=false means empty chain.
+	// TailNum is the lowest retained canonical height; heights below it were pruned by retention.
+	HeadHash() (hash.Hash32, bool, error)
+	HeadNum() (int64, bool, error)
+	TailNum() (int64, bool, error)
+
+	GetBlockByHashRaw(h hash.Hash32) ([]byte, error)
+	GetCanonicalBlockRaw(n int64) ([]byte, error)
+	GetCanonicalHash(n int64) (hash.Hash32, bool, error)
+	GetCanonicalTimestamp(n int64) (int64, bool, error)
+
+	// AppendCanonicalBlock writes block by hash and updates canonical + timestamp index + head.
+	AppendCanonicalBlock(b model.Block, raw []byte) error
+
+	// LowerBoundByTimestamp returns the smallest canonical height n with ts(n) >= targetTs.
+	LowerBoundByTimestamp(targetTs int64) (n int64, nTs int64, ok bool, err error)
+
+	// DecideTailAction / DeleteCanonicalAfter implement warmup trimming (see rocksstore.Store.DecideTailAction).
+	DecideTailAction(curTs int64, backfillSec int64) (action TailAction, keepHeight int64, err error)
+	DeleteCanonicalAfter(keepHeight int64) error
+
+	Verify(opts VerifyOptions) (*VerifyReport, error)
+
+	Close()
+}
+
+var _ ChainStore = (*MemStore)(nil)
+
+type TailAction int
+
+const (
+	// TailRebuild means: delete all canonical blocks (or treat as empty) and rebuild from target time.
+	TailRebuild TailAction = iota
+
+	// TailKeepAllCatchUp means: keep all existing blocks, just mine forward to catch up to now.
+	TailKeepAllCatchUp
+
+	// TailTrimAfterKeep means: keep blocks up to keepHeight, delete blocks after that, then mine forward to catch up.
+	TailTrimAfterKeep
+)
+
+func (a TailAction) String() string {
+	switch a {
+	case TailRebuild:
+		return "REBUILD"
+	case TailKeepAllCatchUp:
+		return "KEEP_ALL_CATCH_UP"
+	case TailTrimAfterKeep:
+		return "TRIM_AFTER_KEEP"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// LowerBoundByTimestamp is the binary search over [tailNum, headNum] shared by the stores;
+// tsAt(n) ok=false means canonical broken.
+func LowerBoundByTimestamp(tailNum, headNum int64, targetTs int64, tsAt func(n int64) (int64, bool, error)) (int64, int64, bool, error) {
+	lo, hi := tailNum, headNum
+	pos := int64(-1)
+	posTs := int64(0)
+
+	for lo <= hi {
+		mid := lo + (hi-lo)/2
+		midTs, okTs, err := tsAt(mid)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		if !okTs {
+			// canonical broken
+			return 0, 0, false, nil
+		}
+
+		if midTs >= targetTs {
+			pos = mid
+			posTs = midTs
+			hi = mid - 1
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	if pos == -1 {
+		return 0, 0, false, nil
+	}
+	return pos, posTs, true, nil
+}
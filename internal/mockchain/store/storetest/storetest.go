@@ -0,0 +1,314 @@
+// Package storetest is the conformance suite every store.ChainStore implementation must pass.
+//
+//	func TestMemStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T, gapRuleSec int64) store.ChainStore {
+//			return store.NewMemStore(gapRuleSec)
+//		})
+//	}
+//
+// Open must return an empty store; the suite closes it.
+package storetest
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/store"
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+)
+
+type Open func(t *testing.T, gapRuleSec int64) store.ChainStore
+
+const gapSec = 3
+
+func Run(t *testing.T, open Open) {
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, st store.ChainStore)
+	}{
+		{"Empty", testEmpty},
+		{"AppendAndRead", testAppendAndRead},
+		{"LowerBoundByTimestamp", testLowerBound},
+		{"DeleteCanonicalAfter", testDeleteCanonicalAfter},
+		{"AppendAfterTrim", testAppendAfterTrim},
+		{"DecideTailAction", testDecideTailAction},
+		{"Verify", testVerify},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			st := open(t, gapSec)
+			defer st.Close()
+			c.fn(t, st)
+		})
+	}
+}
+
+// AppendChain mines n blocks on top of the current head with timestamps ts, ts+step, ...
+// Exported for the miner / RPC tests, which need a known chain without running the miner.
+func AppendChain(t *testing.T, st store.ChainStore, n int, ts int64, step int64) []model.Block {
+	t.Helper()
+	parent := hash.Hash32{}
+	next := int64(1)
+	if h, ok, err := st.HeadHash(); err != nil {
+		t.Fatalf("HeadHash: %v", err)
+	} else if ok {
+		num, _, err := st.HeadNum()
+		if err != nil {
+			t.Fatalf("HeadNum: %v", err)
+		}
+		parent, next = h, num+1
+	}
+
+	out := make([]model.Block, 0, n)
+	for i := 0; i < n; i++ {
+		bn := next + int64(i)
+		txs := make([]model.Tx, 0, 3)
+		for j := 0; j < 3; j++ {
+			txs = append(txs, model.BuildTx(model.TxBody{
+				From:      fmt.Sprintf("0x%040x", j+1),
+				To:        fmt.Sprintf("0x%040x", j+2),
+				Token:     "MOCK",
+				Amount:    int64(j + 1),
+				Timestamp: ts,
+				Nonce:     uint64(bn*10 + int64(j)),
+			}, bn))
+		}
+		blk := model.BuildBlock("", bn, parent, txs, ts, uint64(bn))
+		raw, err := model.EncodeBlock(blk)
+		if err != nil {
+			t.Fatalf("EncodeBlock: %v", err)
+		}
+		if err := st.AppendCanonicalBlock(blk, raw); err != nil {
+			t.Fatalf("AppendCanonicalBlock(%d): %v", bn, err)
+		}
+		out = append(out, blk)
+		parent = blk.Hash
+		ts += step
+	}
+	return out
+}
+
+func mustHead(t *testing.T, st store.ChainStore) (int64, hash.Hash32, bool) {
+	t.Helper()
+	n, okN, err := st.HeadNum()
+	if err != nil {
+		t.Fatalf("HeadNum: %v", err)
+	}
+	h, okH, err := st.HeadHash()
+	if err != nil {
+		t.Fatalf("HeadHash: %v", err)
+	}
+	if okN != okH {
+		t.Fatalf("head meta disagree: num ok=%v hash ok=%v", okN, okH)
+	}
+	return n, h, okN
+}
+
+func testEmpty(t *testing.T, st store.ChainStore) {
+	if _, _, ok := mustHead(t, st); ok {
+		t.Fatalf("empty store reports a head")
+	}
+	if _, err := st.GetCanonicalBlockRaw(1); err == nil {
+		t.Fatalf("GetCanonicalBlockRaw(1) on empty store: want error")
+	}
+	if _, err := st.GetBlockByHashRaw(hash.Hash32{1}); err == nil {
+		t.Fatalf("GetBlockByHashRaw on empty store: want error")
+	}
+	if _, ok, err := st.GetCanonicalHash(1); err != nil || ok {
+		t.Fatalf("GetCanonicalHash(1) = ok=%v err=%v, want ok=false", ok, err)
+	}
+	if _, ok, err := st.TailNum(); err != nil || ok {
+		t.Fatalf("TailNum = ok=%v err=%v, want ok=false", ok, err)
+	}
+	if _, _, ok, err := st.LowerBoundByTimestamp(0); err != nil || ok {
+		t.Fatalf("LowerBoundByTimestamp = ok=%v err=%v, want ok=false", ok, err)
+	}
+	if act, _, err := st.DecideTailAction(1000, 100); err != nil || act != store.TailRebuild {
+		t.Fatalf("DecideTailAction = %s err=%v, want REBUILD", act, err)
+	}
+	if err := st.DeleteCanonicalAfter(0); err != nil {
+		t.Fatalf("DeleteCanonicalAfter(0): %v", err)
+	}
+	rep, err := st.Verify(store.VerifyOptions{})
+	if err != nil || !rep.OK() {
+		t.Fatalf("Verify empty: rep=%+v err=%v", rep, err)
+	}
+}
+
+func testAppendAndRead(t *testing.T, st store.ChainStore) {
+	blocks := AppendChain(t, st, 10, 1000, 1)
+
+	n, h, ok := mustHead(t, st)
+	if !ok || n != 10 || h != blocks[9].Hash {
+		t.Fatalf("head = (%d, %s, %v), want (10, %s, true)", n, h.Hex(), ok, blocks[9].Hash.Hex())
+	}
+	if tail, ok, err := st.TailNum(); err != nil || !ok || tail != 1 {
+		t.Fatalf("TailNum = %d ok=%v err=%v, want 1", tail, ok, err)
+	}
+	for _, b := range blocks {
+		want, _ := model.EncodeBlock(b)
+
+		raw, err := st.GetCanonicalBlockRaw(b.Header.Number)
+		if err != nil || !bytes.Equal(raw, want) {
+			t.Fatalf("GetCanonicalBlockRaw(%d) err=%v equal=%v", b.Header.Number, err, bytes.Equal(raw, want))
+		}
+		raw, err = st.GetBlockByHashRaw(b.Hash)
+		if err != nil || !bytes.Equal(raw, want) {
+			t.Fatalf("GetBlockByHashRaw(%d) err=%v", b.Header.Number, err)
+		}
+		ch, ok, err := st.GetCanonicalHash(b.Header.Number)
+		if err != nil || !ok || ch != b.Hash {
+			t.Fatalf("GetCanonicalHash(%d) = %s ok=%v err=%v", b.Header.Number, ch.Hex(), ok, err)
+		}
+		ts, ok, err := st.GetCanonicalTimestamp(b.Header.Number)
+		if err != nil || !ok || ts != b.Header.Timestamp {
+			t.Fatalf("GetCanonicalTimestamp(%d) = %d ok=%v err=%v", b.Header.Number, ts, ok, err)
+		}
+
+		// returned bytes must be a copy
+		raw[0] ^= 0xff
+		again, _ := st.GetBlockByHashRaw(b.Hash)
+		if !bytes.Equal(again, want) {
+			t.Fatalf("GetBlockByHashRaw(%d) returned aliased storage", b.Header.Number)
+		}
+	}
+	if _, err := st.GetCanonicalBlockRaw(11); err == nil {
+		t.Fatalf("GetCanonicalBlockRaw(11) beyond head: want error")
+	}
+}
+
+func testLowerBound(t *testing.T, st store.ChainStore) {
+	// ts: 1000, 1002, ..., 1018
+	AppendChain(t, st, 10, 1000, 2)
+
+	for _, c := range []struct {
+		target int64
+		n, ts  int64
+		ok     bool
+	}{
+		{0, 1, 1000, true},
+		{1000, 1, 1000, true},
+		{1001, 2, 1002, true},
+		{1010, 6, 1010, true},
+		{1018, 10, 1018, true},
+		{1019, 0, 0, false},
+	} {
+		n, ts, ok, err := st.LowerBoundByTimestamp(c.target)
+		if err != nil || ok != c.ok || n != c.n || ts != c.ts {
+			t.Fatalf("LowerBoundByTimestamp(%d) = (%d, %d, %v, %v), want (%d, %d, %v)",
+				c.target, n, ts, ok, err, c.n, c.ts, c.ok)
+		}
+	}
+}
+
+func testDeleteCanonicalAfter(t *testing.T, st store.ChainStore) {
+	blocks := AppendChain(t, st, 10, 1000, 1)
+
+	if err := st.DeleteCanonicalAfter(10); err != nil {
+		t.Fatalf("DeleteCanonicalAfter(head): %v", err)
+	}
+	if n, _, _ := mustHead(t, st); n != 10 {
+		t.Fatalf("keep>=head must be a no-op, head=%d", n)
+	}
+
+	if err := st.DeleteCanonicalAfter(6); err != nil {
+		t.Fatalf("DeleteCanonicalAfter(6): %v", err)
+	}
+	n, h, ok := mustHead(t, st)
+	if !ok || n != 6 || h != blocks[5].Hash {
+		t.Fatalf("head after trim = (%d, %s, %v), want (6, %s)", n, h.Hex(), ok, blocks[5].Hash.Hex())
+	}
+	for _, b := range blocks[6:] {
+		if _, err := st.GetCanonicalBlockRaw(b.Header.Number); err == nil {
+			t.Fatalf("canonical %d survived trim", b.Header.Number)
+		}
+		if _, err := st.GetBlockByHashRaw(b.Hash); err == nil {
+			t.Fatalf("block %d survived trim", b.Header.Number)
+		}
+		if _, ok, _ := st.GetCanonicalTimestamp(b.Header.Number); ok {
+			t.Fatalf("timestamp %d survived trim", b.Header.Number)
+		}
+	}
+	if _, _, ok, _ := st.LowerBoundByTimestamp(1007); ok {
+		t.Fatalf("LowerBoundByTimestamp past trimmed head: want ok=false")
+	}
+
+	if err := st.DeleteCanonicalAfter(0); err != nil {
+		t.Fatalf("DeleteCanonicalAfter(0): %v", err)
+	}
+	if _, _, ok := mustHead(t, st); ok {
+		t.Fatalf("DeleteCanonicalAfter(0) must clear head")
+	}
+	if _, err := st.GetCanonicalBlockRaw(1); err == nil {
+		t.Fatalf("canonical 1 survived full delete")
+	}
+}
+
+func testAppendAfterTrim(t *testing.T, st store.ChainStore) {
+	AppendChain(t, st, 10, 1000, 1)
+	if err := st.DeleteCanonicalAfter(4); err != nil {
+		t.Fatalf("DeleteCanonicalAfter(4): %v", err)
+	}
+	more := AppendChain(t, st, 3, 2000, 1)
+	if more[0].Header.Number != 5 {
+		t.Fatalf("append after trim starts at %d, want 5", more[0].Header.Number)
+	}
+	n, h, _ := mustHead(t, st)
+	if n != 7 || h != more[2].Hash {
+		t.Fatalf("head = (%d, %s), want (7, %s)", n, h.Hex(), more[2].Hash.Hex())
+	}
+	rep, err := st.Verify(store.VerifyOptions{})
+	if err != nil || !rep.OK() {
+		t.Fatalf("Verify after trim+append: issues=%+v err=%v", rep.Issues, err)
+	}
+}
+
+func testDecideTailAction(t *testing.T, st store.ChainStore) {
+	// contiguous 1000..1009, gap, 1020..1029 (gap at height 11)
+	AppendChain(t, st, 10, 1000, 1)
+	AppendChain(t, st, 10, 1020, 1)
+
+	for _, c := range []struct {
+		name        string
+		curTs, back int64
+		act         store.TailAction
+		keep        int64
+	}{
+		{"backfill disabled", 1029, 0, store.TailKeepAllCatchUp, 0},
+		{"head before target", 2000, 10, store.TailKeepAllCatchUp, 20},
+		{"window after gap", 1029, 5, store.TailKeepAllCatchUp, 20},
+		{"window spans gap", 1029, 25, store.TailTrimAfterKeep, 10},
+		{"target inside gap", 1029, 14, store.TailTrimAfterKeep, 10},
+		// and once more: stores with a gap index must agree with their own scan
+		{"window spans gap (again)", 1029, 25, store.TailTrimAfterKeep, 10},
+	} {
+		act, keep, err := st.DecideTailAction(c.curTs, c.back)
+		if err != nil || act != c.act || keep != c.keep {
+			t.Fatalf("%s: DecideTailAction(%d, %d) = (%s, %d, %v), want (%s, %d)",
+				c.name, c.curTs, c.back, act, keep, err, c.act, c.keep)
+		}
+	}
+}
+
+func testVerify(t *testing.T, st store.ChainStore) {
+	AppendChain(t, st, 10, 1000, 1)
+	AppendChain(t, st, 5, 1100, 1)
+
+	rep, err := st.Verify(store.VerifyOptions{})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !rep.OK() {
+		t.Fatalf("Verify: issues=%+v", rep.Issues)
+	}
+	if rep.HeadNum != 15 || rep.CheckedBlocks != 15 || rep.CheckedTxs != 45 || rep.Gaps != 1 {
+		t.Fatalf("Verify: report=%+v", rep)
+	}
+
+	rep, err = st.Verify(store.VerifyOptions{FromHeight: 5, ToHeight: 8})
+	if err != nil || !rep.OK() || rep.CheckedBlocks != 4 {
+		t.Fatalf("Verify range: report=%+v err=%v", rep, err)
+	}
+}
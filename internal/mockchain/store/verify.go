@@ -0,0 +1,370 @@
+package store
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+)
+
+type VerifyIssueKind string
+
+const (
+	IssueHeadMeta       VerifyIssueKind = "HEAD_META"        // meta:head_* missing, unreadable or not pointing at the canonical tip
+	IssueMissingCanon   VerifyIssueKind = "MISSING_CANON"    // hole in canon:{n} for tail..head
+	IssueCanonAboveHead VerifyIssueKind = "CANON_ABOVE_HEAD" // canon:{n} with n > head (trim leftover)
+	IssueBadCanonValue  VerifyIssueKind = "BAD_CANON_VALUE"  // canon:{n} value is not a 32-byte hash
+	IssueMissingBlock   VerifyIssueKind = "MISSING_BLOCK"    // block_hash:{hash} absent for a canonical hash
+	IssueDecodeBlock    VerifyIssueKind = "DECODE_BLOCK"     // raw block bytes do not decode
+	IssueBlockNumber    VerifyIssueKind = "BLOCK_NUMBER"     // header number != canonical height
+	IssueBlockHash      VerifyIssueKind = "BLOCK_HASH"       // stored block hash != canon:{n}
+	IssueHeaderHash     VerifyIssueKind = "HEADER_HASH"      // HashHeader(header) != block hash
+	IssueParentHash     VerifyIssueKind = "PARENT_HASH"      // parent_hash != canon:{n-1}
+	IssueTxRoot         VerifyIssueKind = "TX_ROOT"          // TxRoot(tx hashes) != header tx_root
+	IssueTxHash         VerifyIssueKind = "TX_HASH"          // HashTxCanonical(body) != tx hash
+	IssueTxBlockNum     VerifyIssueKind = "TX_BLOCK_NUM"     // tx.block_num != canonical height
+	IssueTimestampIndex VerifyIssueKind = "TIMESTAMP_INDEX"  // canon_ts:{n} missing/corrupt or != header timestamp
+	IssueTimestampOrder VerifyIssueKind = "TIMESTAMP_ORDER"  // ts(n) <= ts(n-1)
+	IssueGapIndexMiss   VerifyIssueKind = "GAP_INDEX_MISS"   // real gap without gap_end_ts entry while the index is trusted
+
+	// IssueLegacyNonce is a warning, not an issue: the block was stored as JSON before nonces were
+	// serialized (model.HasNonces), so HEADER_HASH / TX_HASH can't be checked for it.
+	IssueLegacyNonce VerifyIssueKind = "LEGACY_NONCE"
+)
+
+type VerifyIssue struct {
+	Height int64           `json:"height"`
+	Kind   VerifyIssueKind `json:"kind"`
+	Detail string          `json:"detail"`
+}
+
+type VerifyOptions struct {
+	// FromHeight/ToHeight bound the block walk; <=0 means tail / head. Heights below tail were pruned and are skipped.
+	FromHeight int64
+	ToHeight   int64
+
+	// MaxIssues caps VerifyReport.Issues (IssueCount keeps counting); <=0 means 1000.
+	MaxIssues int
+}
+
+type VerifyReport struct {
+	HeadNum  int64  `json:"head_num"`
+	HeadHash string `json:"head_hash"`
+	TailNum  int64  `json:"tail_num"`
+	From     int64  `json:"from"`
+	To       int64  `json:"to"`
+
+	CanonKeys     int64 `json:"canon_keys"`
+	CheckedBlocks int64 `json:"checked_blocks"`
+	CheckedTxs    int64 `json:"checked_txs"`
+
+	GapRuleSec      int64 `json:"gap_rule_sec"`
+	GapIndexTrusted bool  `json:"gap_index_trusted"` // meta:gap_rule_sec == gapRuleSec, DecideTailAction takes the fast path
+	Gaps            int64 `json:"gaps"`
+	GapEntries      int64 `json:"gap_entries"`
+	GapEntriesStale int64 `json:"gap_entries_stale"` // tolerated by DecideTailAction (left behind by trims), reported for visibility
+
+	IssueCount int           `json:"issue_count"`
+	Issues     []VerifyIssue `json:"issues"`
+	Truncated  bool          `json:"truncated"`
+
+	// LegacyBlocks: blocks without nonces (IssueLegacyNonce); listed in Warnings up to MaxIssues.
+	// They don't make the report fail: re-mine or re-encode them to get hash coverage back.
+	LegacyBlocks int64         `json:"legacy_blocks"`
+	Warnings     []VerifyIssue `json:"warnings,omitempty"`
+
+	Cost string `json:"cost"`
+}
+
+func (r *VerifyReport) OK() bool { return r.IssueCount == 0 }
+
+// VerifySource is the read-only view RunVerify walks; each ChainStore implements Verify with one.
+// Sources report backend-specific corruption (unparseable keys/values) through report and keep going.
+type VerifySource interface {
+	HeadNum(report IssueFunc) (int64, bool, error)
+	HeadHash(report IssueFunc) (hash.Hash32, bool, error)
+	TailNum(report IssueFunc) (int64, error)
+	Canonical(report IssueFunc) (map[int64]hash.Hash32, error)
+	BlockRaw(h hash.Hash32) ([]byte, bool, error)
+	CanonTs(n int64) (int64, bool, error)
+
+	// GapIndex lists the gap entries for gapSec and whether the index claims to be complete for it.
+	GapIndex(gapSec int64) (entries []GapEntry, trusted bool, err error)
+}
+
+// IssueFunc records an issue at height n (0 when no height applies).
+type IssueFunc func(n int64, kind VerifyIssueKind, detail string)
+
+type GapEntry struct {
+	EndTs  int64
+	Height int64
+	OK     bool // false: entry does not decode
+}
+
+// RunVerify checks the chain behind src:
+// - canonical contiguity over tail..head and head meta pointing at the tip
+// - stored block present, decodable, number/hash matching the canonical hash
+// - parent-hash linkage, HashHeader, TxRoot and per-tx HashTxCanonical recomputation
+// - canonical timestamps equal to the header timestamp and strictly increasing
+// - the gap index for the runtime gap rule against the gaps actually present
+//
+// Blocks written before nonces were serialized are counted as LegacyBlocks (their header / tx
+// hashes are not recomputed) instead of failing the report.
+func RunVerify(src VerifySource, gapRuleSec int64, opts VerifyOptions) (*VerifyReport, error) {
+	start := time.Now()
+	if opts.MaxIssues <= 0 {
+		opts.MaxIssues = 1000
+	}
+
+	v := &verifier{rep: &VerifyReport{GapRuleSec: gapRuleSec}, max: opts.MaxIssues}
+	rep := v.rep
+
+	// --- head meta ---
+	headNum, okHead, err := src.HeadNum(v.add)
+	if err != nil {
+		return nil, err
+	}
+	headHash, okHash, err := src.HeadHash(v.add)
+	if err != nil {
+		return nil, err
+	}
+	if !okHead && !okHash && rep.IssueCount == 0 {
+		log.Printf("[verify] empty chain cost=%s", time.Since(start))
+		rep.Cost = time.Since(start).String()
+		return rep, nil
+	}
+	if !okHead || !okHash {
+		v.add(0, IssueHeadMeta, fmt.Sprintf("partial head meta: head_num=%v head_hash=%v", okHead, okHash))
+	}
+	rep.HeadNum = headNum
+	if okHash {
+		rep.HeadHash = headHash.Hex()
+	}
+	tailNum, err := src.TailNum(v.add)
+	if err != nil {
+		return nil, err
+	}
+	rep.TailNum = tailNum
+
+	// --- canonical mapping ---
+	canon, err := src.Canonical(v.add)
+	if err != nil {
+		return nil, err
+	}
+	var maxCanon int64
+	above := make([]int64, 0)
+	for n := range canon {
+		maxCanon = max(maxCanon, n)
+		if n > headNum {
+			above = append(above, n)
+		}
+	}
+	rep.CanonKeys = int64(len(canon))
+	if maxCanon != headNum {
+		v.add(headNum, IssueHeadMeta, fmt.Sprintf("head_num=%d but highest canon key=%d", headNum, maxCanon))
+	}
+	if h, ok := canon[headNum]; ok && okHash && h != headHash {
+		v.add(headNum, IssueHeadMeta, fmt.Sprintf("head_hash=%s but canon:%d=%s", rep.HeadHash, headNum, h.Hex()))
+	}
+
+	from, to := opts.FromHeight, opts.ToHeight
+	if from < tailNum {
+		from = tailNum
+	}
+	if to <= 0 || to > headNum {
+		to = headNum
+	}
+	rep.From, rep.To = from, to
+	log.Printf("[verify] begin: head=%d tail=%d from=%d to=%d canon_keys=%d gapSec=%d", headNum, tailNum, from, to, len(canon), gapRuleSec)
+
+	slices.Sort(above)
+	for _, n := range above {
+		v.add(n, IssueCanonAboveHead, fmt.Sprintf("canon:%d exists above head=%d", n, headNum))
+	}
+
+	// --- block walk ---
+	var (
+		prevHash  hash.Hash32
+		prevTs    int64
+		prevValid bool
+		gaps      = make(map[int64]int64) // height -> endTs
+		lastLog   = time.Now()
+	)
+	if from > tailNum {
+		if h, ok := canon[from-1]; ok {
+			if ts, ok, err := src.CanonTs(from - 1); err != nil {
+				return nil, err
+			} else if ok {
+				prevHash, prevTs, prevValid = h, ts, true
+			}
+		}
+	}
+
+	for n := from; n <= to; n++ {
+		h, ok := canon[n]
+		if !ok {
+			v.add(n, IssueMissingCanon, "canon key missing")
+			prevValid = false
+			continue
+		}
+
+		raw, ok, err := src.BlockRaw(h)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			v.add(n, IssueMissingBlock, "block_hash:"+h.Hex()+" missing")
+			prevValid = false
+			continue
+		}
+		blk, err := model.DecodeBlock(raw)
+		if err != nil {
+			v.add(n, IssueDecodeBlock, err.Error())
+			prevValid = false
+			continue
+		}
+		rep.CheckedBlocks++
+		rep.CheckedTxs += int64(len(blk.Txs))
+		v.checkBlock(n, h, blk, model.HasNonces(raw))
+
+		if n == 1 && !blk.Header.ParentHash.IsZero() {
+			v.add(n, IssueParentHash, fmt.Sprintf("genesis parent=%s want zero", blk.Header.ParentHash.Hex()))
+		}
+		if n > 1 && prevValid && blk.Header.ParentHash != prevHash {
+			v.add(n, IssueParentHash, fmt.Sprintf("parent=%s canon:%d=%s", blk.Header.ParentHash.Hex(), n-1, prevHash.Hex()))
+		}
+
+		ts, okTs, err := src.CanonTs(n)
+		if err != nil {
+			return nil, err
+		}
+		if !okTs {
+			v.add(n, IssueTimestampIndex, "canon_ts missing or not 8 bytes")
+		} else if ts != blk.Header.Timestamp {
+			v.add(n, IssueTimestampIndex, fmt.Sprintf("canon_ts=%d header=%d", ts, blk.Header.Timestamp))
+		}
+		if prevValid {
+			if blk.Header.Timestamp <= prevTs {
+				v.add(n, IssueTimestampOrder, fmt.Sprintf("ts=%d prev=%d", blk.Header.Timestamp, prevTs))
+			}
+			if gapRuleSec > 0 && blk.Header.Timestamp-prevTs > gapRuleSec {
+				gaps[n] = blk.Header.Timestamp
+			}
+		}
+
+		prevHash, prevTs, prevValid = blk.Hash, blk.Header.Timestamp, true
+
+		if time.Since(lastLog) >= 1*time.Second {
+			log.Printf("[verify] progress: n=%d to=%d issues=%d cost=%s", n, to, rep.IssueCount, time.Since(start))
+			lastLog = time.Now()
+		}
+	}
+	rep.Gaps = int64(len(gaps))
+
+	// --- gap index (runtime rule only; other rules are never consulted by DecideTailAction) ---
+	if gapRuleSec > 0 {
+		entries, trusted, err := src.GapIndex(gapRuleSec)
+		if err != nil {
+			return nil, err
+		}
+		v.checkGapIndex(entries, trusted, headNum, gaps)
+	}
+
+	rep.Cost = time.Since(start).String()
+	log.Printf("[verify] done: head=%d checked=%d txs=%d gaps=%d gap_entries=%d stale=%d issues=%d legacy=%d cost=%s",
+		headNum, rep.CheckedBlocks, rep.CheckedTxs, rep.Gaps, rep.GapEntries, rep.GapEntriesStale, rep.IssueCount, rep.LegacyBlocks, rep.Cost)
+	return rep, nil
+}
+
+type verifier struct {
+	rep *VerifyReport
+	max int
+}
+
+func (v *verifier) add(n int64, kind VerifyIssueKind, detail string) {
+	v.rep.IssueCount++
+	if len(v.rep.Issues) >= v.max {
+		v.rep.Truncated = true
+		return
+	}
+	v.rep.Issues = append(v.rep.Issues, VerifyIssue{Height: n, Kind: kind, Detail: detail})
+}
+
+func (v *verifier) warn(n int64, kind VerifyIssueKind, detail string) {
+	if len(v.rep.Warnings) < v.max {
+		v.rep.Warnings = append(v.rep.Warnings, VerifyIssue{Height: n, Kind: kind, Detail: detail})
+	}
+}
+
+// checkBlock recomputes everything derivable from the block itself; hashes only when the stored
+// encoding has the nonces they cover.
+func (v *verifier) checkBlock(n int64, canonHash hash.Hash32, blk model.Block, hasNonces bool) {
+	if blk.Header.Number != n {
+		v.add(n, IssueBlockNumber, fmt.Sprintf("header number=%d", blk.Header.Number))
+	}
+	if blk.Hash != canonHash {
+		v.add(n, IssueBlockHash, fmt.Sprintf("block hash=%s canon=%s", blk.Hash.Hex(), canonHash.Hex()))
+	}
+	if !hasNonces {
+		v.rep.LegacyBlocks++
+		v.warn(n, IssueLegacyNonce, "stored without nonces: header/tx hashes not recomputed")
+	} else if got := model.HashHeader(blk.Header); got != blk.Hash {
+		v.add(n, IssueHeaderHash, fmt.Sprintf("recomputed=%s stored=%s", got.Hex(), blk.Hash.Hex()))
+	}
+
+	txHashes := make([]hash.Hash32, 0, len(blk.Txs))
+	for i, tx := range blk.Txs {
+		if got := model.HashTxCanonical(tx.TxBody); hasNonces && got != tx.Hash {
+			v.add(n, IssueTxHash, fmt.Sprintf("tx[%d] recomputed=%s stored=%s", i, got.Hex(), tx.Hash.Hex()))
+		}
+		if tx.BlockNum != n {
+			v.add(n, IssueTxBlockNum, fmt.Sprintf("tx[%d] block_num=%d", i, tx.BlockNum))
+		}
+		txHashes = append(txHashes, tx.Hash)
+	}
+	if got := model.TxRoot(txHashes); got != blk.Header.TxRoot {
+		v.add(n, IssueTxRoot, fmt.Sprintf("recomputed=%s stored=%s", got.Hex(), blk.Header.TxRoot.Hex()))
+	}
+}
+
+// checkGapIndex compares the gap entries for the runtime rule with the gaps seen during the walk.
+// Entries that no longer describe the chain are counted as stale (DecideTailAction skips them);
+// gaps without an entry are only issues once the index claims to be complete for the rule.
+func (v *verifier) checkGapIndex(entries []GapEntry, trusted bool, headNum int64, gaps map[int64]int64) {
+	rep := v.rep
+	rep.GapIndexTrusted = trusted
+
+	indexed := make(map[int64]bool)
+	for _, e := range entries {
+		rep.GapEntries++
+		if !e.OK || e.Height <= rep.TailNum || e.Height > headNum {
+			rep.GapEntriesStale++
+			continue
+		}
+		if e.Height < rep.From || e.Height > rep.To {
+			continue
+		}
+		if gapEnd, isGap := gaps[e.Height]; isGap && gapEnd == e.EndTs {
+			indexed[e.Height] = true
+			continue
+		}
+		rep.GapEntriesStale++
+	}
+
+	if !trusted {
+		return
+	}
+	missing := make([]int64, 0)
+	for h := range gaps {
+		if !indexed[h] {
+			missing = append(missing, h)
+		}
+	}
+	slices.Sort(missing)
+	for _, h := range missing {
+		v.add(h, IssueGapIndexMiss, fmt.Sprintf("gap ending ts=%d has no gap index entry for gapSec=%d", gaps[h], rep.GapRuleSec))
+	}
+}
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/chenzhangda16/web3-logpipe/internal/mockchain/model"
+	"github.com/chenzhangda16/web3-logpipe/pkg/hash"
+)
+
+// verifyChain: 10 blocks of 2 txs, ts 1000..1009.
+func verifyChain(t *testing.T) (*MemStore, []model.Block) {
+	t.Helper()
+	st := NewMemStore(3)
+	var (
+		parent hash.Hash32
+		blocks []model.Block
+	)
+	for n := int64(1); n <= 10; n++ {
+		ts := 999 + n
+		txs := []model.Tx{
+			model.BuildTx(model.TxBody{From: "0xa", To: "0xb", Token: "MOCK", Amount: n, Timestamp: ts, Nonce: uint64(n)}, n),
+			model.BuildTx(model.TxBody{From: "0xb", To: "0xc", Token: "MOCK", Amount: 2 * n, Timestamp: ts, Nonce: uint64(n + 100)}, n),
+		}
+		blk := model.BuildBlock("", n, parent, txs, ts, uint64(n)*7)
+		raw, err := model.EncodeBlock(blk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := st.AppendCanonicalBlock(blk, raw); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, blk)
+		parent = blk.Hash
+	}
+	return st, blocks
+}
+
+// putBlock replaces the stored bytes of a canonical block (hash key unchanged).
+func putBlock(t *testing.T, st *MemStore, key hash.Hash32, blk model.Block) {
+	t.Helper()
+	raw, err := model.EncodeBlock(blk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.blocks[key] = raw
+}
+
+func TestVerifyGoodChain(t *testing.T) {
+	st, _ := verifyChain(t)
+	rep, err := st.Verify(VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.OK() || rep.CheckedBlocks != 10 || rep.CheckedTxs != 20 || rep.LegacyBlocks != 0 {
+		t.Fatalf("report=%+v", rep)
+	}
+}
+
+func TestVerifyCatchesCorruption(t *testing.T) {
+	cases := []struct {
+		kind    VerifyIssueKind
+		height  int64
+		corrupt func(t *testing.T, st *MemStore, b []model.Block)
+	}{
+		{IssueMissingCanon, 4, func(t *testing.T, st *MemStore, b []model.Block) {
+			delete(st.canon, 4)
+		}},
+		{IssueCanonAboveHead, 11, func(t *testing.T, st *MemStore, b []model.Block) {
+			st.canon[11] = b[9].Hash
+		}},
+		{IssueHeadMeta, 10, func(t *testing.T, st *MemStore, b []model.Block) {
+			st.headHash = b[8].Hash
+		}},
+		{IssueMissingBlock, 5, func(t *testing.T, st *MemStore, b []model.Block) {
+			delete(st.blocks, b[4].Hash)
+		}},
+		{IssueDecodeBlock, 5, func(t *testing.T, st *MemStore, b []model.Block) {
+			st.blocks[b[4].Hash] = []byte("{not json")
+		}},
+		{IssueBlockNumber, 5, func(t *testing.T, st *MemStore, b []model.Block) {
+			blk := b[4]
+			blk.Header.Number = 50
+			blk.Hash = model.HashHeader(blk.Header)
+			st.canon[5] = blk.Hash
+			putBlock(t, st, blk.Hash, blk)
+		}},
+		{IssueBlockHash, 5, func(t *testing.T, st *MemStore, b []model.Block) {
+			putBlock(t, st, b[4].Hash, b[5])
+		}},
+		{IssueHeaderHash, 5, func(t *testing.T, st *MemStore, b []model.Block) {
+			blk := b[4]
+			blk.Header.Nonce++
+			putBlock(t, st, b[4].Hash, blk)
+		}},
+		{IssueParentHash, 5, func(t *testing.T, st *MemStore, b []model.Block) {
+			blk := b[4]
+			blk.Header.ParentHash = hash.Hash32{1}
+			blk.Hash = model.HashHeader(blk.Header)
+			delete(st.blocks, b[4].Hash)
+			st.canon[5] = blk.Hash
+			putBlock(t, st, blk.Hash, blk)
+		}},
+		{IssueTxRoot, 5, func(t *testing.T, st *MemStore, b []model.Block) {
+			blk := b[4]
+			blk.Txs = blk.Txs[:1]
+			putBlock(t, st, b[4].Hash, blk)
+		}},
+		{IssueTxHash, 5, func(t *testing.T, st *MemStore, b []model.Block) {
+			blk := b[4]
+			blk.Txs = append([]model.Tx(nil), blk.Txs...)
+			blk.Txs[0].TxBody.Amount++
+			putBlock(t, st, b[4].Hash, blk)
+		}},
+		{IssueTxBlockNum, 5, func(t *testing.T, st *MemStore, b []model.Block) {
+			blk := b[4]
+			blk.Txs = append([]model.Tx(nil), blk.Txs...)
+			blk.Txs[1].BlockNum = 4
+			putBlock(t, st, b[4].Hash, blk)
+		}},
+		{IssueTimestampIndex, 5, func(t *testing.T, st *MemStore, b []model.Block) {
+			st.canonTs[5]++
+		}},
+		{IssueTimestampOrder, 5, func(t *testing.T, st *MemStore, b []model.Block) {
+			blk := b[4]
+			blk.Header.Timestamp = 1000
+			blk.Hash = model.HashHeader(blk.Header)
+			delete(st.blocks, b[4].Hash)
+			st.canon[5], st.canonTs[5] = blk.Hash, 1000
+			putBlock(t, st, blk.Hash, blk)
+		}},
+	}
+	for _, c := range cases {
+		t.Run(string(c.kind), func(t *testing.T) {
+			st, blocks := verifyChain(t)
+			c.corrupt(t, st, blocks)
+			rep, err := st.Verify(VerifyOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rep.OK() {
+				t.Fatalf("corruption not reported: %+v", rep)
+			}
+			for _, is := range rep.Issues {
+				if is.Kind == c.kind && is.Height == c.height {
+					return
+				}
+			}
+			t.Fatalf("want %s at %d, got %+v", c.kind, c.height, rep.Issues)
+		})
+	}
+}
+
+// Blocks stored before nonces were serialized are a warning, not corruption: every store older
+// than the format change has them.
+func TestVerifyLegacyNonces(t *testing.T) {
+	st, blocks := verifyChain(t)
+	for _, b := range blocks[:4] {
+		raw := stripNonces(t, st.blocks[b.Hash])
+		if model.HasNonces(raw) {
+			t.Fatalf("stripNonces left a nonce: %s", raw)
+		}
+		st.blocks[b.Hash] = raw
+	}
+
+	rep, err := st.Verify(VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.OK() || rep.LegacyBlocks != 4 || len(rep.Warnings) != 4 || rep.Warnings[0].Kind != IssueLegacyNonce {
+		t.Fatalf("report=%+v", rep)
+	}
+
+	// everything else is still checked on legacy blocks
+	blk := blocks[1]
+	blk.Txs = blk.Txs[:1]
+	raw, _ := model.EncodeBlock(blk)
+	st.blocks[blk.Hash] = stripNonces(t, raw)
+	rep, _ = st.Verify(VerifyOptions{})
+	if rep.IssueCount != 1 || rep.Issues[0].Kind != IssueTxRoot {
+		t.Fatalf("issues=%+v", rep.Issues)
+	}
+}
+
+// stripNonces re-encodes raw the way blocks were written with json:"-" nonces.
+func stripNonces(t *testing.T, raw []byte) []byte {
+	t.Helper()
+	var m map[string]any
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	if err := d.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	delete(m["header"].(map[string]any), "nonce")
+	for _, tx := range m["txs"].([]any) {
+		delete(tx.(map[string]any)["tx_body"].(map[string]any), "nonce")
+	}
+	out, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
@@ -37,19 +37,21 @@ export PG_DSN="postgres://${PG_DB_USER:-web3}:${PG_DB_PASS:-web3}@${PG_HOST:-127
 : "${MOCK_SEED:=1}"
 : "${MOCK_BACKFILL_SEC:=86400}"
 : "${MOCK_GAP_SEC:=0}"
+: "${MOCK_RETENTION_SEC:=0}"
 
 : "${KAFKA_BROKERS:=127.0.0.1:9092}"
 : "${KAFKA_TOPIC:=mockchain.blocks}"
 
 : "${FETCH_BACKFILL_SEC:=86400}"
 : "${FETCH_PAGE:=200}"
+: "${FETCH_PARALLEL:=4}"
 : "${FETCH_POLL_HEAD:=2s}"
 : "${FETCH_IDLE_SLEEP:=300ms}"
 : "${FETCH_CKPT:=./data/fetcher.ckpt}"
 : "${RPC_BASE:=http://$MOCK_RPC}"
 
 : "${PROC_GROUP:=logpipe-processor}"
-: "${PROC_SPOOL:=./data/spool.wal}"
+: "${PROC_SPOOL:=./data/spool}"
 : "${PROC_DECODE_WORKER:=4}"
 : "${PROC_DECODE_QUEUE:=8192}"
 : "${PROC_CKPT:=./data/processor.ckpt}"
@@ -427,7 +429,8 @@ start() {
       -det="$MOCK_DET" \
       -seed "$MOCK_SEED" \
       -backfill-sec "$MOCK_BACKFILL_SEC" \
-      -gap-sec "$MOCK_GAP_SEC"
+      -gap-sec "$MOCK_GAP_SEC" \
+      -retention-sec "$MOCK_RETENTION_SEC"
   append_pid "$pid_mock"
   log "mockchain pid=$pid_mock log=$mock_log latest=$LOG_DIR/mockchain.latest.log"
 
@@ -498,6 +501,7 @@ start() {
         -topic "$KAFKA_TOPIC" \
         -backfill-sec "$FETCH_BACKFILL_SEC" \
         -page "$FETCH_PAGE" \
+        -parallel "$FETCH_PARALLEL" \
         -poll-head "$FETCH_POLL_HEAD" \
         -idle-sleep "$FETCH_IDLE_SLEEP" \
         -ckpt "$FETCH_CKPT"